@@ -0,0 +1,95 @@
+package objects
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RequestIDHeader carries a random ID generated for every batch request.
+const RequestIDHeader = "X-Request-Id"
+
+// requestSeq counts the request IDs generated without randomness.
+var requestSeq uint32
+
+// newRequestID returns a random ID. Should the system fail to provide
+// randomness, it falls back to an ID unique to the process, led by its pid
+// so prefixes still tell running processes apart.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		binary.BigEndian.PutUint32(b, uint32(os.Getpid()))
+		binary.BigEndian.PutUint32(b[4:], atomic.AddUint32(&requestSeq, 1))
+		binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// AuditLog appends a JSON line for every batch delivery attempt to a file.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Collection string    `json:"collection"`
+	IDs        []string  `json:"ids"`
+	Seq        uint64    `json:"seq"`
+	Created    time.Time `json:"created"`
+	Bytes      int       `json:"bytes"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	RequestID  string    `json:"request_id"`
+	Checksum   string    `json:"checksum,omitempty"`
+}
+
+// OpenAuditLog opens the audit log at path, creating it if needed. Entries
+// are always appended.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: f}, nil
+}
+
+// Record appends d to the log.
+func (a *AuditLog) Record(d Delivery) error {
+	entry := &auditEntry{
+		Time:       time.Now().UTC(),
+		Collection: d.Collection,
+		IDs:        d.IDs,
+		Seq:        d.Seq,
+		Created:    d.Created.UTC(),
+		Bytes:      d.Bytes,
+		Status:     "ok",
+		RequestID:  d.RequestID,
+		Checksum:   d.Checksum,
+	}
+	if d.Err != nil {
+		entry.Status = "error"
+		entry.Error = d.Err.Error()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(line)
+	return err
+}
+
+// Close closes the log file.
+func (a *AuditLog) Close() error {
+	return a.file.Close()
+}
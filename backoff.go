@@ -0,0 +1,164 @@
+package objects
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// Backoff chooses when failed requests are retried. Every request gets its
+// own schedule from NewBackOff, whose NextBackOff returns the delay before
+// each retry, or backoff.Stop to give up.
+//
+// The default is exponential, from 500ms up to 1 minute between retries,
+// giving up after 10 seconds. Short-lived processes, e.g. serverless
+// functions, may want to give up sooner, and long-running daemons to keep
+// retrying longer.
+type Backoff interface {
+	NewBackOff() backoff.BackOff
+}
+
+// BackoffFunc adapts a function returning a cenkalti/backoff policy, e.g.
+// backoff.NewExponentialBackOff, to a Backoff.
+type BackoffFunc func() backoff.BackOff
+
+func (f BackoffFunc) NewBackOff() backoff.BackOff {
+	return f()
+}
+
+// DefaultBackoff is the Backoff used when Client.Backoff is nil.
+var DefaultBackoff Backoff = BackoffFunc(func() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 10 * time.Second
+	return b
+})
+
+// ConstantBackoff waits Interval between retries.
+type ConstantBackoff struct {
+	Interval time.Duration
+
+	// MaxElapsedTime stops retrying once that long passed since the first
+	// attempt. 0 retries forever.
+	MaxElapsedTime time.Duration
+}
+
+func (b *ConstantBackoff) NewBackOff() backoff.BackOff {
+	return &schedule{maxElapsed: b.MaxElapsedTime, delay: func(n int, prev time.Duration) time.Duration {
+		return b.Interval
+	}}
+}
+
+// DecorrelatedJitter waits a random delay between Base and three times the
+// previous delay, up to Cap, or without limit if Cap is 0. Clients
+// retrying together quickly spread out, see
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.
+type DecorrelatedJitter struct {
+	Base time.Duration
+	Cap  time.Duration
+
+	// MaxElapsedTime stops retrying once that long passed since the first
+	// attempt. 0 retries forever.
+	MaxElapsedTime time.Duration
+}
+
+func (b *DecorrelatedJitter) NewBackOff() backoff.BackOff {
+	return &schedule{maxElapsed: b.MaxElapsedTime, delay: func(n int, prev time.Duration) time.Duration {
+		if prev < b.Base {
+			prev = b.Base
+		}
+		if prev > math.MaxInt64/3 {
+			prev = math.MaxInt64 / 3
+		}
+		d := b.Base + time.Duration(rand.Int63n(int64(3*prev-b.Base)+1))
+		if b.Cap > 0 && d > b.Cap {
+			d = b.Cap
+		}
+		return d
+	}}
+}
+
+// FibonacciBackoff waits Initial times the Fibonacci numbers between
+// retries, 1, 1, 2, 3, 5..., up to Max, or without limit if Max is 0.
+// Delays grow slower than exponential backoff's.
+type FibonacciBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	// MaxElapsedTime stops retrying once that long passed since the first
+	// attempt. 0 retries forever.
+	MaxElapsedTime time.Duration
+}
+
+func (b *FibonacciBackoff) NewBackOff() backoff.BackOff {
+	return &schedule{maxElapsed: b.MaxElapsedTime, delay: func(n int, prev time.Duration) time.Duration {
+		x, y := b.Initial, b.Initial
+		for i := 1; i < n && (b.Max == 0 || x < b.Max) && y <= math.MaxInt64-x; i++ {
+			x, y = y, x+y
+		}
+		if b.Max > 0 && x > b.Max {
+			x = b.Max
+		}
+		return x
+	}}
+}
+
+// schedule implements backoff.BackOff for the built-in Backoffs. delay
+// returns the delay before retry n, counting from 1, given the previous one.
+type schedule struct {
+	delay      func(n int, prev time.Duration) time.Duration
+	maxElapsed time.Duration
+
+	n     int
+	prev  time.Duration
+	start time.Time
+}
+
+func (s *schedule) Reset() {
+	s.n, s.prev, s.start = 0, 0, time.Now()
+}
+
+func (s *schedule) NextBackOff() time.Duration {
+	if s.maxElapsed > 0 && time.Since(s.start) > s.maxElapsed {
+		return backoff.Stop
+	}
+	s.n++
+	s.prev = s.delay(s.n, s.prev)
+	return s.prev
+}
+
+// backOff returns the retry schedule of a request: the client's Backoff,
+// resumed after the attempts already made in ctx's retryState, subject to
+// the RetryBudget.
+func (c *Client) backOff(ctx context.Context) backoff.BackOff {
+	strategy := c.Backoff
+	if strategy == nil {
+		strategy = DefaultBackoff
+	}
+	b := strategy.NewBackOff()
+	if st := retryStateFrom(ctx); st != nil && st.attempts > 0 {
+		b = &resumedBackOff{BackOff: b, skip: st.attempts}
+	}
+	if c.RetryBudget != nil {
+		c.RetryBudget.deposit()
+	}
+	return &budgetBackOff{BackOff: b, client: c}
+}
+
+// resumedBackOff skips the delays of attempts made before a batch was
+// spooled, so its replay continues the backoff schedule.
+type resumedBackOff struct {
+	backoff.BackOff
+	skip int
+}
+
+func (b *resumedBackOff) Reset() {
+	b.BackOff.Reset()
+	for i := 0; i < b.skip; i++ {
+		if b.BackOff.NextBackOff() == backoff.Stop {
+			return
+		}
+	}
+}
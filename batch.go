@@ -1,9 +1,177 @@
 package objects
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
 
 type batch struct {
 	Collection string          `json:"collection"`
 	WriteKey   string          `json:"write_key"`
 	Objects    json.RawMessage `json:"objects"`
+
+	count int
+
+	// seq numbers the batches of a client from 1, in the order they are
+	// created, i.e. cut from their buffer.
+	seq     uint64
+	created time.Time
+
+	// enqueued is when the oldest object of the batch was queued.
+	enqueued time.Time
+
+	// ids are the object IDs, only tracked for OnDeliver and AuditLog.
+	ids []string
+
+	// meta are the objects' Meta, tracked along with ids.
+	meta []map[string]interface{}
+
+	// expiring are the objects with a Deadline, and expiringPos their
+	// positions in the batch.
+	expiring    []*Object
+	expiringPos []int
+
+	// expires are the expiries of the objects with an ExpiresAt, scheduled
+	// once the batch is delivered, and expiresPos their positions.
+	expires    []expiry
+	expiresPos []int
+
+	// dedupe are the DuplicateFilter entries of the objects, recorded once
+	// the batch is delivered.
+	dedupe []duplicateEntry
+
+	// attempts and retryAt are the delivery attempts made when the batch is
+	// spooled after failing, and when the next one is due, see retryState.
+	attempts int
+	retryAt  time.Time
+
+	// spilled is the size of the single object of a batch encoded to disk
+	// instead of into Objects, see Client.SpillThreshold.
+	spilled int
+
+	// spooled is set for batches read back from Spool, whose objects are
+	// counted in Stats already.
+	spooled bool
+}
+
+// nextSeq returns the sequence number of a new batch.
+func (c *Client) nextSeq() uint64 {
+	return atomic.AddUint64(&c.batches, 1)
+}
+
+// size returns the encoded size of the batch's objects.
+func (b *batch) size() int {
+	if b.spilled > 0 {
+		return b.spilled
+	}
+	return len(b.Objects)
+}
+
+// deadline returns the latest Deadline of the batch's objects, or the zero
+// time if some have none: a request canceled at it fails no object that could
+// still be delivered in time.
+func (b *batch) deadline() time.Time {
+	if len(b.expiring) < b.count {
+		return time.Time{}
+	}
+	var t time.Time
+	for _, v := range b.expiring {
+		if v.Deadline.After(t) {
+			t = v.Deadline
+		}
+	}
+	return t
+}
+
+// remove re-encodes the batch without the objects at the given positions,
+// which must have a Deadline. Objects are matched by position rather than
+// ID, so other objects with the same ID are kept.
+func (b *batch) remove(positions []int) error {
+	removed := make(map[int]bool, len(positions))
+	for _, i := range positions {
+		removed[i] = true
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b.Objects, &raw); err != nil {
+		return err
+	}
+	if len(raw) != b.count {
+		return fmt.Errorf("Batch holds %d objects, expected %d", len(raw), b.count)
+	}
+
+	// shift maps the positions of the objects kept to their new ones.
+	shift := make([]int, len(raw))
+	kept := raw[:0]
+	var ids []string
+	var meta []map[string]interface{}
+	var dedupe []duplicateEntry
+	for i, x := range raw {
+		if removed[i] {
+			continue
+		}
+		shift[i] = len(kept)
+		kept = append(kept, x)
+		if b.ids != nil {
+			ids = append(ids, b.ids[i])
+			meta = append(meta, b.meta[i])
+		}
+		if b.dedupe != nil {
+			dedupe = append(dedupe, b.dedupe[i])
+		}
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+
+	var expiring []*Object
+	var expiringPos []int
+	for k, i := range b.expiringPos {
+		if !removed[i] {
+			expiring = append(expiring, b.expiring[k])
+			expiringPos = append(expiringPos, shift[i])
+		}
+	}
+	var expires []expiry
+	var expiresPos []int
+	for k, i := range b.expiresPos {
+		if !removed[i] {
+			expires = append(expires, b.expires[k])
+			expiresPos = append(expiresPos, shift[i])
+		}
+	}
+	b.Objects, b.count, b.ids, b.meta, b.dedupe = data, len(kept), ids, meta, dedupe
+	b.expiring, b.expiringPos, b.expires, b.expiresPos = expiring, expiringPos, expires, expiresPos
+	return nil
+}
+
+// Batch is a group of objects sent in a single request with SendBatch, for
+// callers who want exact control over grouping instead of the client's
+// background batching.
+type Batch struct {
+	Collection string
+	Objects    []*Object
+
+	// Bytes is the encoded size of the objects, set by SendBatch.
+	Bytes int
+}
+
+// NewBatch returns an empty batch for collection.
+func NewBatch(collection string) *Batch {
+	return &Batch{Collection: collection}
+}
+
+// Add appends objects to the batch. Objects without a Collection are assigned
+// the batch's collection.
+func (b *Batch) Add(objects ...*Object) *Batch {
+	for _, o := range objects {
+		if o.Collection == "" {
+			o.Collection = b.Collection
+		}
+		b.Objects = append(b.Objects, o)
+	}
+	return b
 }
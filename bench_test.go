@@ -0,0 +1,268 @@
+package objects
+
+import (
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/validator.v2"
+)
+
+func benchObject() *Object {
+	return &Object{
+		ID:         "a1b2c3",
+		Collection: "users",
+		Properties: map[string]interface{}{
+			"name":    "Jane Doe",
+			"email":   "jane@example.com",
+			"age":     42,
+			"premium": true,
+			"address": map[string]interface{}{
+				"city": "San Francisco",
+				"zip":  "94107",
+			},
+		},
+	}
+}
+
+// drain consumes the buffer queue so Set never blocks.
+func drain(b *buffer) {
+	for {
+		select {
+		case <-b.Queue.ready:
+			b.pending, b.pendingAt = b.Queue.drain(b.pending[:0], b.pendingAt[:0])
+		case <-b.Queue.done:
+			return
+		}
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	c := New("writekey")
+	buf := newBuffer("users", 100)
+	c.cmap.Set("users", buf)
+	go drain(buf)
+	defer buf.Queue.close()
+
+	v := benchObject()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Set(v)
+	}
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	c := New("writekey")
+	buf := newBuffer("users", 100)
+	c.cmap.Set("users", buf)
+	go drain(buf)
+	defer buf.Queue.close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		v := benchObject()
+		for pb.Next() {
+			c.Set(v)
+		}
+	})
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	c := New("writekey")
+	c.Logger = log.New(ioutil.Discard, "", 0)
+	v := benchObject()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.marshal(v)
+	}
+}
+
+// BenchmarkQueueContention and BenchmarkChannelContention compare a
+// collection queue with the buffered channel it replaced, with many
+// goroutines pushing objects and a single consumer, as when producers Set
+// into the same hot collection.
+func BenchmarkQueueContention(b *testing.B) {
+	q := newQueue(100)
+	go func() {
+		var pending []*Object
+		var at []time.Time
+		for {
+			select {
+			case <-q.ready:
+				pending, at = q.drain(pending[:0], at[:0])
+			case <-q.done:
+				return
+			}
+		}
+	}()
+	defer q.close()
+
+	v := benchObject()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.push(v)
+		}
+	})
+}
+
+func BenchmarkChannelContention(b *testing.B) {
+	c := make(chan *Object, 100)
+	go func() {
+		for range c {
+		}
+	}()
+	defer close(c)
+
+	v := benchObject()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c <- v
+		}
+	})
+}
+
+// BenchmarkDequeue measures the buffer goroutine adding queued objects, with
+// producers keeping the queue full.
+func BenchmarkDequeue(b *testing.B) {
+	c := New("writekey")
+	c.MaxBatchCount = 1 << 30
+	c.MaxBatchBytes = 1 << 30
+	buf := newBuffer("users", 100)
+	v := benchObject()
+	go func() {
+		for buf.Queue.push(v) {
+		}
+	}()
+	defer buf.Queue.close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		<-buf.Queue.ready
+		c.dequeue(buf)
+		n += len(buf.pending)
+		buf.buf = buf.buf[:0]
+		buf.n = 0
+		buf.currentByteSize = 0
+	}
+}
+
+// sent keeps benchmarked batches on the heap, as they are once sent.
+var sent *batch
+
+// BenchmarkSingle and BenchmarkBuffered compare building a one object batch
+// with and without the MaxBatchCount=1 fast path.
+func BenchmarkSingle(b *testing.B) {
+	c := New("writekey")
+	buf := newBuffer("users", 0)
+	v := benchObject()
+	x, _ := c.marshal(v)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sent = c.single(buf, v, x)
+		putBytes(sent.Objects)
+	}
+}
+
+func BenchmarkBuffered(b *testing.B) {
+	c := New("writekey")
+	buf := newBuffer("users", 0)
+	v := benchObject()
+	x, _ := c.marshal(v)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.add(x)
+		buf.ids = append(buf.ids, v.ID)
+		sent = &batch{
+			Collection: buf.collection,
+			WriteKey:   c.writeKey,
+			count:      buf.count(),
+			enqueued:   buf.first,
+			ids:        buf.ids,
+			Objects:    buf.marshalArray(),
+		}
+		buf.ids = nil
+		putBytes(sent.Objects)
+	}
+}
+
+func TestSetAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	c := New("writekey")
+	buf := newBuffer("users", 100)
+	c.cmap.Set("users", buf)
+
+	// Nothing consumes the queue: keep the runs within its capacity.
+	v := benchObject()
+	allocs := testing.AllocsPerRun(buf.Queue.cap()/2-1, func() {
+		if err := c.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("Set allocates %v times per call, want 0", allocs)
+	}
+}
+
+// maxMarshalAllocs bounds the allocations of encoding benchObject.
+const maxMarshalAllocs = 29
+
+func TestMarshalAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	c := New("writekey")
+	v := benchObject()
+	marshal := testing.AllocsPerRun(100, func() {
+		if _, err := c.marshal(v); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if marshal > maxMarshalAllocs {
+		t.Errorf("marshal allocates %v times per object, want at most %d", marshal, maxMarshalAllocs)
+	}
+
+	// Buffering the encoded object allocates nothing more.
+	buf := newBuffer("users", 100)
+	add := testing.AllocsPerRun(100, func() {
+		buf.pending = append(buf.pending[:0], v)
+		buf.pendingAt = append(buf.pendingAt[:0], time.Now())
+		c.addPending(buf)
+		buf.buf = buf.buf[:0]
+		buf.n = 0
+		buf.currentByteSize = 0
+	})
+	if add > marshal {
+		t.Errorf("adding an object allocates %v times, %v more than encoding it", add, add-marshal)
+	}
+}
+
+// TestValidateTags checks that Object.validate, which avoids reflection,
+// agrees with the validator tags of Object.
+func TestValidateTags(t *testing.T) {
+	for _, collection := range []string{"", "c"} {
+		for _, id := range []string{"", "1"} {
+			for _, props := range []map[string]interface{}{nil, {}, {"p": "1"}} {
+				v := &Object{Collection: collection, ID: id, Properties: props}
+				want := validator.Validate(v)
+				got := v.validate()
+				if !reflect.DeepEqual(want, got) {
+					t.Errorf("validate(%+v) = %v, want %v", v, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package objects
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen fails requests while the client's Breaker is open.
+var ErrBreakerOpen = errors.New("Circuit breaker is open")
+
+// Breaker fails requests at once, without sending them, after Failures
+// consecutive requests failed, so batches are spooled or reported to
+// callbacks instead of piling up in memory while the API is down. Once
+// Cooldown passed, a single request is let through: the breaker closes if it
+// succeeds, and stays open for another Cooldown otherwise.
+type Breaker struct {
+	// Failures is the number of consecutive failed requests opening the
+	// breaker.
+	Failures int
+
+	// Cooldown is how long the breaker stays open before a request is let
+	// through.
+	Cooldown time.Duration
+
+	// SlowResponse optionally counts responses slower than it as failures,
+	// even successful ones, so an endpoint degraded but not down, e.g.
+	// accepting connections slowly, opens the breaker too.
+	SlowResponse time.Duration
+
+	// OnStateChange is optionally called when the breaker opens or closes.
+	OnStateChange func(open bool)
+
+	mu       sync.Mutex
+	failures int
+	opened   time.Time
+	probing  bool
+}
+
+// NewBreaker returns a Breaker opening after failures consecutive failed
+// requests, for cooldown at a time.
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{Failures: failures, Cooldown: cooldown}
+}
+
+// Open reports whether requests currently fail at once.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Failures
+}
+
+// allow reports whether a request may be sent, and whether it is the single
+// request let through while the breaker is open. If so, record or cancel
+// must be called once it is done.
+func (b *Breaker) allow() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.Failures {
+		return true, false
+	}
+	if b.probing || time.Since(b.opened) < b.Cooldown {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// record counts a request that was sent, and lets another one through once
+// the breaker is open if it was the probe.
+func (b *Breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	if probe {
+		b.probing = false
+	}
+	open := b.failures >= b.Failures
+	if failed {
+		b.failures++
+		if b.failures >= b.Failures {
+			b.opened = time.Now()
+		}
+	} else {
+		b.failures = 0
+	}
+	changed := open != (b.failures >= b.Failures)
+	b.mu.Unlock()
+
+	if changed && b.OnStateChange != nil {
+		b.OnStateChange(!open)
+	}
+}
+
+// cancel releases a request that was let through without counting it, e.g.
+// when its context was canceled.
+func (b *Breaker) cancel(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// observe records a request to endpoint that started at start, for endpoint
+// selection and the Breaker. probe is whether the Breaker let it through as
+// its probe, see gate.
+func (c *Client) observe(ctx context.Context, endpoint string, start time.Time, probe bool, resp *http.Response, err error) {
+	if ctx.Err() != nil {
+		if c.Breaker != nil {
+			c.Breaker.cancel(probe)
+		}
+		return
+	}
+
+	d := time.Since(start)
+	failed := err != nil || resp.StatusCode >= 500
+	c.observeEndpoint(endpoint, d, failed)
+	if c.Breaker == nil {
+		return
+	}
+	if slow := c.Breaker.SlowResponse; slow > 0 && d > slow {
+		failed = true
+		if c.Metrics != nil {
+			c.Metrics.Count("objects.slow_responses", 1, nil)
+		}
+	}
+	c.Breaker.record(probe, failed)
+}
@@ -1,31 +1,149 @@
 package objects
 
 import (
-	"bytes"
 	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type buffer struct {
-	Channel         chan *Object
+	Queue           *queue
 	Exit            chan struct{}
+	flushes         chan chan struct{}
+	dumps           chan chan bufferDump
 	collection      string
-	buf             [][]byte
+	buf             []byte
+	n               int
 	currentByteSize int
+	highWatermark   int64
+
+	// pending holds the objects taken from Queue, reused across dequeues,
+	// and pendingAt when they were queued. adding is the one being added,
+	// queued at addingAt, until it is buffered or dropped, and encoding is
+	// set while it is encoded. flushing is the batch being flushed, until it
+	// is held or sent. They tell restart what a panic affected.
+	pending   []*Object
+	pendingAt []time.Time
+	adding    *Object
+	addingAt  time.Time
+	encoding  bool
+	flushing  *batch
+
+	// lastBytes and lastCount are the size of the previous batch, which the
+	// next one is preallocated for.
+	lastBytes int
+	lastCount int
+
+	// first is when the oldest buffered object was queued, see addingAt, or
+	// added.
+	first time.Time
+
+	// ids are the buffered object IDs, see Client.tracksIDs.
+	ids []string
+
+	// meta are the buffered objects' Meta, tracked along with ids.
+	meta []map[string]interface{}
+
+	// expiring are the buffered objects with a Deadline, and expiringPos
+	// their positions in the batch.
+	expiring    []*Object
+	expiringPos []int
+
+	// expires are the expiries of the buffered objects with an ExpiresAt,
+	// and expiresPos their positions.
+	expires    []expiry
+	expiresPos []int
+
+	// dedupe are the DuplicateFilter entries of the buffered objects.
+	dedupe []duplicateEntry
+
+	// created, buffered, inflight and stuck are used by the watchdog.
+	created  time.Time
+	buffered int64
+	inflight int64
+	stuck    int32
+
+	// busy is when the buffer goroutine started its current work, in unix
+	// nanoseconds, or 0 while it waits. busy and stalled are used to detect
+	// stalls, see Client.StallAfter.
+	busy    int64
+	stalled int32
+
+	// shards are the buffers of a sharded collection, b included, see
+	// Client.Shards. Only set on the buffer stored in the collection map.
+	shards []*buffer
 }
 
-func newBuffer(collection string) *buffer {
+func newBuffer(collection string, capacity int) *buffer {
 	return &buffer{
 		collection:      collection,
-		Channel:         make(chan *Object, 100),
+		Queue:           newQueue(capacity),
 		Exit:            make(chan struct{}),
-		buf:             [][]byte{},
+		flushes:         make(chan chan struct{}),
+		dumps:           make(chan chan bufferDump),
 		currentByteSize: 0,
+		created:         time.Now(),
 	}
 }
 
+// add appends x to the JSON array being built in b.buf. The closing bracket
+// is only written by marshalArray.
 func (b *buffer) add(x []byte) {
-	b.buf = append(b.buf, x)
+	if b.n == 0 {
+		b.buf = append(b.buf, '[')
+		b.first = b.addingAt
+		if b.first.IsZero() {
+			b.first = time.Now()
+		}
+	} else {
+		b.buf = append(b.buf, ',')
+	}
+	b.buf = append(b.buf, x...)
+	b.n++
 	b.currentByteSize += len(x)
+	atomic.StoreInt64(&b.buffered, int64(b.n))
+}
+
+// observe records the queue's current length if it is the highest seen.
+func (b *buffer) observe() {
+	n := int64(b.Queue.len())
+	for {
+		max := atomic.LoadInt64(&b.highWatermark)
+		if n <= max || atomic.CompareAndSwapInt64(&b.highWatermark, max, n) {
+			return
+		}
+	}
+}
+
+// all returns the buffers of b's collection.
+func (b *buffer) all() []*buffer {
+	if len(b.shards) == 0 {
+		return []*buffer{b}
+	}
+	return b.shards
+}
+
+// shard returns the buffer objects with the given ID are queued in, so
+// updates of the same object are always delivered in order.
+func (b *buffer) shard(id string) *buffer {
+	if len(b.shards) == 0 {
+		return b
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return b.shards[h.Sum32()%uint32(len(b.shards))]
+}
+
+// reserve makes room for n more bytes in b.buf.
+func (b *buffer) reserve(n int) {
+	if cap(b.buf)-len(b.buf) >= n {
+		return
+	}
+	buf := make([]byte, len(b.buf), len(b.buf)+n)
+	copy(buf, b.buf)
+	b.buf = buf
 }
 
 func (b *buffer) size() int {
@@ -33,17 +151,42 @@ func (b *buffer) size() int {
 }
 
 func (b *buffer) count() int {
-	return len(b.buf)
+	return b.n
 }
 
 func (b *buffer) reset() {
-	b.buf = [][]byte{}
+	b.buf = b.buf[:0]
+	b.n = 0
 	b.currentByteSize = 0
+	atomic.StoreInt64(&b.buffered, 0)
 }
 
+// marshalArray returns the buffered objects as a JSON array and resets the
+// buffer. The array's memory is handed over rather than copied: b continues
+// with a fresh slice from the pool, and the caller should return the array
+// with putBytes once it is sent.
 func (b *buffer) marshalArray() json.RawMessage {
-	rm := bytes.Join(b.buf, []byte{','})
-	rm = append([]byte{'['}, rm...)
-	rm = append(rm, ']')
+	if b.n == 0 {
+		return json.RawMessage("[]")
+	}
+
+	rm := append(b.buf, ']')
+	b.lastBytes, b.lastCount = len(rm), b.n
+	b.buf = getBytes()
+	b.n = 0
+	b.currentByteSize = 0
+	atomic.StoreInt64(&b.buffered, 0)
 	return json.RawMessage(rm)
 }
+
+var bytesPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+func getBytes() []byte {
+	return (*bytesPool.Get().(*[]byte))[:0]
+}
+
+func putBytes(b []byte) {
+	bytesPool.Put(&b)
+}
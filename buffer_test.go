@@ -16,7 +16,7 @@ type BufferTestSuite struct {
 }
 
 func (b *BufferTestSuite) TestNewBuffer() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 
 	b.Equal("collection", buf.collection)
@@ -24,12 +24,12 @@ func (b *BufferTestSuite) TestNewBuffer() {
 	b.Equal(0, buf.size())
 	b.Equal(0, buf.currentByteSize)
 	b.Len(buf.buf, 0)
-	b.NotNil(buf.Channel)
+	b.NotNil(buf.Queue)
 	b.NotNil(buf.Exit)
 }
 
 func (b *BufferTestSuite) TestAddNew() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 	json1 := []byte(`{"string": "test", "int": 1}`)
 	buf.add(json1)
@@ -39,7 +39,7 @@ func (b *BufferTestSuite) TestAddNew() {
 }
 
 func (b *BufferTestSuite) TestMarshalEmptyArray() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 	res := buf.marshalArray()
 	b.Equal("[]", string(res))
@@ -50,7 +50,7 @@ func (b *BufferTestSuite) TestMarshalEmptyArray() {
 }
 
 func (b *BufferTestSuite) TestMarshalSingleArray() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 	json1 := []byte(`{"string": "test", "int": 1}`)
 	buf.add(json1)
@@ -66,7 +66,7 @@ func (b *BufferTestSuite) TestMarshalSingleArray() {
 }
 
 func (b *BufferTestSuite) TestAddMultiple() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 	json1 := []byte(`{"string": "test", "int": 1}`)
 	buf.add(json1)
@@ -91,7 +91,7 @@ func (b *BufferTestSuite) TestAddMultiple() {
 }
 
 func (b *BufferTestSuite) TestAddMultipleReset() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 	json1 := []byte(`{"string": "test", "int": 1}`)
 	buf.add(json1)
@@ -118,7 +118,7 @@ func (b *BufferTestSuite) TestAddMultipleReset() {
 }
 
 func (b *BufferTestSuite) TestAddMultipleMarshalReset() {
-	buf := newBuffer("collection")
+	buf := newBuffer("collection", 100)
 	b.NotNil(buf)
 	json1 := []byte(`{"string": "test", "int": 1}`)
 	buf.add(json1)
@@ -155,3 +155,58 @@ func (b *BufferTestSuite) TestAddMultipleMarshalReset() {
 	b.Equal(0, buf.size())
 	b.Equal(0, buf.currentByteSize)
 }
+
+func (b *BufferTestSuite) TestMarshalArrayHandsOff() {
+	buf := newBuffer("collection", 100)
+	json1 := []byte(`{"int": 1}`)
+	buf.add(json1)
+
+	res := buf.marshalArray()
+	b.Equal(0, buf.count())
+	b.Equal(0, buf.size())
+
+	buf.add([]byte(`{"int": 2}`))
+	b.Equal(`[{"int": 1}]`, string(res))
+	b.Equal(`[{"int": 2}]`, string(buf.marshalArray()))
+}
+
+func (b *BufferTestSuite) TestReserve() {
+	c := New("writeKey")
+	c.MaxBatchCount = 10
+	buf := newBuffer("collection", 100)
+	x := []byte(`{"id":"1","properties":{"int":1}}`)
+
+	// The first batch is sized for MaxBatchCount objects like the first one.
+	c.reserve(buf, len(x))
+	buf.add(x)
+	start := &buf.buf[0]
+	for i := 1; i < 10; i++ {
+		buf.add(x)
+	}
+	b.True(start == &buf.buf[0])
+	buf.marshalArray()
+
+	// The next ones as large as the previous one.
+	c.reserve(buf, len(x))
+	b.True(cap(buf.buf) >= 10*(len(x)+1)+1)
+
+	c.MaxBatchBytes = MinBatchBytes
+	c.MaxBatchCount = 1 << 30
+	buf = newBuffer("collection", 100)
+	c.reserve(buf, len(x))
+	b.Equal(MinBatchBytes, cap(buf.buf))
+}
+
+func (b *BufferTestSuite) TestEncodeRequest() {
+	req := &batch{
+		Collection: "rooms",
+		WriteKey:   "key\"",
+		Objects:    json.RawMessage(`[{"id":"1","properties":{"a":"b"}}]`),
+	}
+	expected, err := json.Marshal(req)
+	b.NoError(err)
+
+	payload, err := encodeRequest(req)
+	b.NoError(err)
+	b.Equal(string(expected), string(payload))
+}
@@ -0,0 +1,139 @@
+package objects
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Builder builds an Object property by property. Setters record the first
+// error, which Build returns, so calls can be chained:
+//
+//	v, err := objects.NewObject("products", id).
+//		SetString("name", name).
+//		SetTime("launched_at", t).
+//		Build()
+type Builder struct {
+	v   *Object
+	err error
+}
+
+// NewObject starts building an object of collection with the given ID.
+func NewObject(collection, id string) *Builder {
+	return &Builder{v: &Object{
+		Collection: collection,
+		ID:         id,
+		Properties: map[string]interface{}{},
+	}}
+}
+
+func (b *Builder) set(key string, value interface{}) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if key == "" {
+		b.err = fmt.Errorf("Object `%s` has a property with an empty name", b.v.ID)
+		return b
+	}
+	if _, ok := b.v.Properties[key]; ok {
+		b.err = fmt.Errorf("Object `%s` property `%s` is set twice", b.v.ID, key)
+		return b
+	}
+	b.v.Properties[key] = value
+	return b
+}
+
+// Set sets a property of any type the client can encode. Channels,
+// functions and complex numbers are rejected.
+func (b *Builder) Set(key string, value interface{}) *Builder {
+	if value != nil {
+		switch reflect.TypeOf(value).Kind() {
+		case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+			if b.err == nil {
+				b.err = fmt.Errorf("Object `%s` property `%s` has unsupported type %T", b.v.ID, key, value)
+			}
+			return b
+		}
+	}
+	return b.set(key, value)
+}
+
+// SetString sets a string property.
+func (b *Builder) SetString(key, value string) *Builder {
+	return b.set(key, value)
+}
+
+// SetInt sets an integer property.
+func (b *Builder) SetInt(key string, value int64) *Builder {
+	return b.set(key, value)
+}
+
+// SetFloat sets a number property. NaN and infinities, which JSON can't
+// represent, are rejected by Build.
+func (b *Builder) SetFloat(key string, value float64) *Builder {
+	return b.set(key, value)
+}
+
+// SetBool sets a boolean property.
+func (b *Builder) SetBool(key string, value bool) *Builder {
+	return b.set(key, value)
+}
+
+// SetTime sets a timestamp property, sent in RFC 3339 format unless an
+// Encoder is registered for time.Time.
+func (b *Builder) SetTime(key string, value time.Time) *Builder {
+	return b.set(key, value)
+}
+
+// SetMap sets a nested property, flattened like any other map.
+func (b *Builder) SetMap(key string, value map[string]interface{}) *Builder {
+	return b.set(key, value)
+}
+
+// ExpiresAt sets the object's ExpiresAt.
+func (b *Builder) ExpiresAt(t time.Time) *Builder {
+	b.v.ExpiresAt = t
+	return b
+}
+
+// Build returns the object, or the first error of the setters or of its
+// validation. Numbers that are NaN or infinite, including in maps, are
+// rejected since they would fail to encode.
+func (b *Builder) Build() (*Object, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := b.v.validate(); err != nil {
+		return nil, err
+	}
+	if err := finite(b.v.ID, "", b.v.Properties); err != nil {
+		return nil, err
+	}
+	return b.v, nil
+}
+
+// finite returns an error for the first NaN or infinite number in props,
+// whose keys are prefixed with prefix in the error.
+func finite(id, prefix string, props map[string]interface{}) error {
+	for key, val := range props {
+		var f float64
+		switch v := val.(type) {
+		case float64:
+			f = v
+		case float32:
+			f = float64(v)
+		case map[string]interface{}:
+			if err := finite(id, prefix+key+".", v); err != nil {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("Object `%s` property `%s` is %v, which JSON can't represent", id, prefix+key, f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package objects
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBuilder(t *testing.T) {
+	suite.Run(t, &BuilderTestSuite{})
+}
+
+type BuilderTestSuite struct {
+	suite.Suite
+}
+
+func (b *BuilderTestSuite) TestBuild() {
+	launched := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	v, err := NewObject("products", "p1").
+		SetString("name", "Lamp").
+		SetInt("stock", 3).
+		SetFloat("price", 9.5).
+		SetBool("active", true).
+		SetTime("launched_at", launched).
+		SetMap("size", map[string]interface{}{"height": 10}).
+		Set("tags", []string{"a"}).
+		Build()
+	b.NoError(err)
+
+	b.Equal("products", v.Collection)
+	b.Equal("p1", v.ID)
+	b.Equal(map[string]interface{}{
+		"name":        "Lamp",
+		"stock":       int64(3),
+		"price":       9.5,
+		"active":      true,
+		"launched_at": launched,
+		"size":        map[string]interface{}{"height": 10},
+		"tags":        []string{"a"},
+	}, v.Properties)
+
+	flat := New("writeKey").Flatten(v)
+	b.Equal("2016-01-02T03:04:05Z", flat["launched_at"])
+	b.Equal(10, flat["size_height"])
+}
+
+func (b *BuilderTestSuite) TestErrors() {
+	_, err := NewObject("products", "p1").Build()
+	b.Error(err)
+
+	_, err = NewObject("", "p1").SetString("name", "Lamp").Build()
+	b.Error(err)
+
+	_, err = NewObject("products", "p1").SetString("", "Lamp").Build()
+	b.EqualError(err, "Object `p1` has a property with an empty name")
+
+	_, err = NewObject("products", "p1").SetString("name", "a").SetString("name", "b").Build()
+	b.EqualError(err, "Object `p1` property `name` is set twice")
+
+	_, err = NewObject("products", "p1").Set("ch", make(chan int)).SetString("name", "a").Build()
+	b.EqualError(err, "Object `p1` property `ch` has unsupported type chan int")
+
+	_, err = NewObject("products", "p1").SetFloat("price", math.NaN()).Build()
+	b.EqualError(err, "Object `p1` property `price` is NaN, which JSON can't represent")
+
+	_, err = NewObject("products", "p1").SetMap("size", map[string]interface{}{"height": math.Inf(1)}).Build()
+	b.EqualError(err, "Object `p1` property `size.height` is +Inf, which JSON can't represent")
+}
@@ -0,0 +1,15 @@
+package objects
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ChecksumHeader carries the hex SHA-256 of the request body when
+// Client.Checksum is enabled.
+const ChecksumHeader = "X-Content-SHA256"
+
+func checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
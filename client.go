@@ -2,19 +2,19 @@ package objects
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"reflect"
+	"runtime/pprof"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"gopkg.in/validator.v2"
-
-	"github.com/cenkalti/backoff"
 	"github.com/segmentio/go-tableize"
 	"github.com/tj/go-sync/semaphore"
 )
@@ -28,7 +28,8 @@ const (
 )
 
 var (
-	ErrClientClosed = errors.New("Client is closed")
+	ErrClientClosed         = errors.New("Client is closed")
+	ErrInvalidSerialization = errors.New("Serializer returned invalid JSON")
 )
 
 type Client struct {
@@ -36,18 +37,252 @@ type Client struct {
 	Logger       *log.Logger
 	Client       *http.Client
 
-	MaxBatchBytes    int
-	MaxBatchCount    int
+	// Endpoints optionally lists equivalent endpoints used instead of
+	// BaseEndpoint, e.g. one per region. Requests go to the fastest healthy
+	// one, measured from deliveries and from requests probing every
+	// endpoint each ProbeInterval. Another endpoint must be at least 20%
+	// faster to be preferred, so close endpoints don't flap.
+	Endpoints     []string
+	ProbeInterval time.Duration
+
+	MaxBatchBytes int
+
+	// MaxBatchCount is the most objects sent per batch. Setting it to 1 is a
+	// low-latency mode: each object is sent in its own request as soon as it
+	// is encoded, skipping the batch buffer entirely.
+	MaxBatchCount int
+
+	// MaxBatchInterval is the longest an object is buffered before its batch
+	// is flushed. Zero flushes as soon as Set is called, still asynchronously:
+	// only objects queued while a collection is busy are batched together,
+	// which suits low-volume applications wanting minimal latency.
 	MaxBatchInterval time.Duration
 
+	// ChannelBuffer is the number of objects a collection queues before Set
+	// blocks, at least 2. ChannelBuffers overrides it for individual
+	// collections. Both only apply to collections not yet used.
+	ChannelBuffer  int
+	ChannelBuffers map[string]int
+
+	// Shards splits the named collections over several buffers, each
+	// batching and flushing on its own, for collections too hot for a single
+	// goroutine. Objects are assigned to a shard by ID, so updates of the same
+	// object keep their order. Each shard queues ChannelBuffer objects.
+	Shards map[string]int
+
+	// AlignFlushInterval flushes every MaxBatchInterval on a fixed schedule.
+	// By default the interval restarts whenever a batch is flushed because it
+	// is full, so it is never followed by a nearly empty one.
+	AlignFlushInterval bool
+
+	// DeletedField and DeletedAtField are the properties set by SetDeleted.
+	DeletedField   string
+	DeletedAtField string
+
+	// ArrayStrategy controls how array property values are flattened.
+	ArrayStrategy ArrayStrategy
+
+	// Nested sends the properties of the listed collections without
+	// flattening, for warehouses storing semi-structured columns. Nested maps
+	// are kept as-is and keys are not snake cased, so `{"Address": {"City":
+	// "SF"}}` is sent unchanged instead of as `address_city`. Encoders and
+	// ArrayStrategy still apply.
+	Nested map[string]bool
+
+	// IDGenerator optionally sets the ID of objects passed to Set, SetAt or
+	// SendBatch without one, e.g. UUIDv7. The ID is set on the object itself.
+	IDGenerator IDGenerator
+
+	// KeySeparator and KeyEncoding control how Object.Keys are joined into
+	// an ID. Keys take precedence over IDGenerator.
+	KeySeparator string
+	KeyEncoding  KeyEncoding
+
+	// SpillThreshold optionally sets the size from which objects are encoded
+	// to a temporary file in SpillDir, or the default temporary directory,
+	// and sent alone in a request streamed from the file, so multi-MB
+	// objects don't stay in memory while they are buffered and delivered.
+	// Sizes are estimated from the string and byte properties, which are
+	// streamed to the file. Spilled objects are not deduplicated or passed
+	// to Sinks, and objects are never spilled when Signer, Checksum,
+	// PayloadEncoder, Middleware or a Serializer applies, since they need
+	// the whole request body, or while DeliveryWindows hold batches.
+	SpillThreshold int
+	SpillDir       string
+
+	// Serializers replace the encoding of objects of the listed collections,
+	// see Serializer.
+	Serializers map[string]Serializer
+
+	// PayloadEncoder optionally encodes batch requests in another format
+	// than JSON, when BaseEndpoint supports it, or with other field names,
+	// see Envelope.
+	PayloadEncoder PayloadEncoder
+
+	// DeliveryWindows restricts when batches are sent. Batches flushed
+	// outside every window are held in memory until the next one opens, or
+	// until Close. When empty, batches are sent at any time.
+	DeliveryWindows []Window
+
+	// MaxHeldBytes bounds the size of the batches DeliveryWindows hold in
+	// memory. Batches beyond it are written to Spool, to be sent by the
+	// Replay run once the next window opens, or dropped with DropHoldFull
+	// without a Spool.
+	MaxHeldBytes int
+
+	// Coalesce packs batches smaller than half of MaxBatchBytes, from any
+	// collection, into multi-collection requests sent to MultiPath, saving
+	// requests when many collections see little traffic. It requires an
+	// endpoint supporting multi-collection requests. Coalesced requests do
+	// not go through Middleware or PayloadEncoder.
+	Coalesce bool
+
+	// Hedge optionally sends a second request when one is slower than most,
+	// see HedgePolicy.
+	Hedge *HedgePolicy
+
+	// RetryBudget optionally caps the requests retried across collections.
+	RetryBudget *RetryBudget
+
+	// Backoff chooses when failed requests are retried. When nil,
+	// DefaultBackoff is used.
+	Backoff Backoff
+
+	// Breaker optionally fails requests at once while the API is failing.
+	Breaker *Breaker
+
+	// RequestLimiter optionally bounds the rate of requests sent, retries
+	// included. Share a golang.org/x/time/rate.Limiter between clients, or
+	// with other SDKs sending to the same API, to share an egress budget.
+	RequestLimiter Limiter
+
+	// RetryNetworkErrorsOnly only retries requests that got no response,
+	// e.g. after connection errors and timeouts. Any response, even a 5xx,
+	// fails the batch at once, for applications retrying batches themselves,
+	// e.g. from an outbox, that want no duplicates from hidden retries.
+	RetryNetworkErrorsOnly bool
+
+	// Middleware wraps the delivery of every batch, the first one outermost.
+	Middleware []Middleware
+
+	// Sinks receive a copy of every delivered batch.
+	Sinks []Sink
+
+	// Signer optionally signs every request sent to BaseEndpoint.
+	Signer Signer
+
+	// Metrics optionally receives delivery metrics, e.g. statsd.New(addr).
+	Metrics Metrics
+
+	// Checksum sends the hex SHA-256 of every request body, as sent, i.e.
+	// after compression, in the ChecksumHeader, so proxies and gateways can
+	// verify its integrity.
+	Checksum bool
+
+	// CompressionLevel optionally gzips request bodies at that level, from
+	// 1 for the fastest to 9 for the smallest, for endpoints accepting a
+	// gzip Content-Encoding. Spilled objects are sent uncompressed.
+	CompressionLevel int
+
+	// OnDeliver is called after every batch delivery attempt, successful or
+	// not, e.g. to keep an audit trail.
+	OnDeliver func(Delivery)
+
+	// AuditLog optionally records every batch delivery attempt.
+	AuditLog *AuditLog
+
+	// Receipts optionally persists a receipt for every delivered object.
+	// Objects of spooled batches get one once replayed.
+	Receipts ReceiptStore
+
+	// Spool optionally persists batches whose delivery failed, see Replay.
+	Spool *Spool
+
+	// SpoolOnClose makes Close write the batches not delivered yet to Spool
+	// and return without waiting for the network, for shutdowns with a hard
+	// deadline. Deliveries in progress are aborted and spooled as well.
+	// Replay delivers them, e.g. on the next start.
+	SpoolOnClose bool
+
+	// OnDrop is called with every object dropped without being delivered,
+	// e.g. to write it to a dead letter queue, with the reason it was. Drops
+	// are logged when nil. It is called from the collection's buffer
+	// goroutine or, for batches failing delivery, from their sender, and
+	// should not block.
+	OnDrop func(Drop)
+
+	// StuckAfter enables a watchdog reporting collections with objects
+	// pending and no successful delivery for longer than StuckAfter. Stuck
+	// collections are passed to OnStuck, or logged when it is nil, once until
+	// they recover.
+	StuckAfter time.Duration
+	OnStuck    func(StuckReport)
+
+	// StallAfter enables reporting buffer goroutines busy with the same
+	// objects for longer than StallAfter, e.g. blocked in a callback or
+	// waiting for a delivery slot. Buffer goroutines that panic are always
+	// restarted. Both are passed to OnWorkerEvent, or logged when it is nil.
+	StallAfter    time.Duration
+	OnWorkerEvent func(WorkerEvent)
+
+	// Duplicates optionally detects objects set twice in a short time.
+	Duplicates *DuplicateFilter
+
+	// Quotas optionally caps the objects set per collection, see Quota.
+	Quotas map[string]*Quota
+
+	// OnShutdown is called as every phase of Close starts, see
+	// ShutdownPhase.
+	OnShutdown func(ShutdownPhase)
+
+	// ShutdownGrace bounds how long Drain waits for buffered objects to be
+	// delivered. Zero waits until they are.
+	ShutdownGrace time.Duration
+
+	// Debug logs every request sent to BaseEndpoint with its status, timing,
+	// sizes and headers, credentials redacted. DebugBodies also logs the
+	// bodies of failed requests, pretty-printed.
+	Debug       bool
+	DebugBodies bool
+
+	// TraceRegions wraps the encoding of every object and the delivery of
+	// every batch in the runtime/trace regions "objects.marshal" and
+	// "objects.send", shown in `go tool trace`.
+	TraceRegions bool
+
 	writeKey  string
 	wg        sync.WaitGroup
 	semaphore semaphore.Semaphore
 	closed    int64
 	cmap      concurrentMap
+	encoders  map[reflect.Type]Encoder
+	scheduler *scheduler
+	delayed   sync.Map // scheduler key => *Object held by SetAt
+	setting   sync.WaitGroup
+	batches   uint64
+	ctx       context.Context
+	cancel    context.CancelFunc
+	held      []*batch
+	heldBytes int
+	heldSpool bool
+	heldMutex sync.Mutex
+	health    health
+	endpoints endpoints
+	quotas    quotas
+	counters  counters
+	latencies latencies
+	watchOnce sync.Once
+	healOnce  sync.Once
+	hedger    hedger
+	coalescer coalescer
+	drainOnce sync.Once
+	drained   chan struct{}
+	closing   chan struct{}
+	newTicker func(time.Duration) ticker // time.NewTicker unless set by tests
 }
 
 func New(writeKey string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
 		BaseEndpoint:     DefaultBaseEndpoint,
 		Logger:           log.New(os.Stderr, "segment ", log.LstdFlags),
@@ -57,141 +292,830 @@ func New(writeKey string) *Client {
 		MaxBatchBytes:    500 << 10,
 		MaxBatchCount:    100,
 		MaxBatchInterval: 10 * time.Second,
+		MaxHeldBytes:     64 << 20,
+		ChannelBuffer:    100,
+		ShutdownGrace:    20 * time.Second,
+		DeletedField:     "deleted",
+		DeletedAtField:   "deleted_at",
+		KeySeparator:     ":",
 		semaphore:        make(semaphore.Semaphore, 10),
+		scheduler:        newScheduler(),
+		ctx:              ctx,
+		cancel:           cancel,
+		closing:          make(chan struct{}),
+		drained:          make(chan struct{}),
 	}
 }
 
 func (c *Client) fetchFunction(key string) *buffer {
-	b := newBuffer(key)
-	c.wg.Add(1)
-	go c.buffer(b)
+	size := c.ChannelBuffer
+	if n, ok := c.ChannelBuffers[key]; ok {
+		size = n
+	}
+
+	b := newBuffer(key, size)
+	if n := c.Shards[key]; n > 1 {
+		b.shards = []*buffer{b}
+		for len(b.shards) < n {
+			b.shards = append(b.shards, newBuffer(key, size))
+		}
+	}
+	for _, s := range b.all() {
+		c.wg.Add(1)
+		go c.buffer(s)
+	}
+	c.watch()
+	c.heal()
 	return b
 }
 
-func (c *Client) flush(b *buffer) {
+// flush sends the buffered objects, if any, and reports whether it did.
+func (c *Client) flush(b *buffer) bool {
 	if b.count() == 0 {
+		return false
+	}
+
+	batchRequest := &batch{
+		Collection:  b.collection,
+		WriteKey:    c.writeKey,
+		seq:         c.nextSeq(),
+		created:     time.Now(),
+		count:       b.count(),
+		enqueued:    b.first,
+		ids:         b.ids,
+		meta:        b.meta,
+		expiring:    b.expiring,
+		expiringPos: b.expiringPos,
+		expires:     b.expires,
+		expiresPos:  b.expiresPos,
+		dedupe:      b.dedupe,
+		Objects:     b.marshalArray(),
+	}
+	b.ids = nil
+	b.meta = nil
+	b.expiring, b.expiringPos = nil, nil
+	b.expires, b.expiresPos = nil, nil
+	b.dedupe = nil
+
+	b.flushing = batchRequest
+	if !c.hold(batchRequest, time.Now()) {
+		c.send(batchRequest)
+	}
+	b.flushing = nil
+	return true
+}
+
+// single returns a batch holding only req, encoded as x, without going
+// through the buffer.
+func (c *Client) single(b *buffer, req *Object, x []byte) *batch {
+	objects := append(getBytes(), '[')
+	objects = append(objects, x...)
+	enqueued := b.addingAt
+	if enqueued.IsZero() {
+		enqueued = time.Now()
+	}
+	request := &batch{
+		Collection: b.collection,
+		WriteKey:   c.writeKey,
+		seq:        c.nextSeq(),
+		created:    time.Now(),
+		count:      1,
+		enqueued:   enqueued,
+		Objects:    append(objects, ']'),
+	}
+	if c.tracksIDs() {
+		request.ids = []string{req.ID}
+		request.meta = []map[string]interface{}{req.Meta}
+	}
+	if !req.Deadline.IsZero() {
+		request.expiring, request.expiringPos = []*Object{req}, []int{0}
+	}
+	if !req.ExpiresAt.IsZero() {
+		request.expires = []expiry{{Collection: req.Collection, ID: req.ID, At: req.ExpiresAt}}
+		request.expiresPos = []int{0}
+	}
+	return request
+}
+
+func (c *Client) send(batchRequest *batch) {
+	if !c.dropExpired(batchRequest) {
+		putBytes(batchRequest.Objects)
+		return
+	}
+	if c.spoolingOnClose() {
+		if !c.spool(batchRequest) {
+			c.dropBatch(batchRequest, DropClosed, ErrClientClosed)
+		}
+		putBytes(batchRequest.Objects)
+		return
+	}
+	if c.Coalesce && len(batchRequest.expiring) == 0 && len(batchRequest.Objects) < c.MaxBatchBytes/2 {
+		c.coalesce(batchRequest)
 		return
 	}
 
-	rm := b.marshalArray()
 	c.semaphore.Run(func() {
-		batchRequest := &batch{
-			Collection: b.collection,
-			WriteKey:   c.writeKey,
-			Objects:    rm,
+		pprof.Do(c.ctx, labels(batchRequest.Collection, "send"), func(ctx context.Context) {
+			c.sendAndSpool(ctx, batchRequest)
+		})
+	})
+}
+
+// sendAndSpool delivers batchRequest, spooling it if that fails.
+func (c *Client) sendAndSpool(ctx context.Context, batchRequest *batch) {
+	if t := batchRequest.deadline(); !t.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, t)
+		defer cancel()
+	}
+
+	st := &retryState{}
+	r := c.region(ctx, "objects.send")
+	err := c.deliver(withRetryState(ctx, st), batchRequest)
+	r.End()
+	if err != nil {
+		batchRequest.attempts, batchRequest.retryAt = st.attempts, st.retryAt
+		c.Logger.Printf("[Error] %v", err)
+		pooled := batchRequest.Objects
+		defer putBytes(pooled)
+
+		// Objects with a Deadline would be stale once replayed: only the
+		// others are spooled. Rejected batches would be rejected again.
+		expiring := batchRequest.expiring
+		for _, v := range expiring {
+			c.drop(v, DropDeadline, err)
+		}
+		if st.rejected() {
+			c.dropBatch(batchRequest, DropRejected, err)
+			return
 		}
+		if len(expiring) == batchRequest.count {
+			return
+		}
+		if len(expiring) > 0 {
+			if rmErr := batchRequest.remove(batchRequest.expiringPos); rmErr != nil {
+				c.Logger.Printf("[Error] Batch for collection `%s` failed to spool: %v", batchRequest.Collection, rmErr)
+				c.dropBatch(batchRequest, DropRetriesExhausted, err)
+				return
+			}
+		}
+		if !c.spool(batchRequest) {
+			c.dropBatch(batchRequest, DropRetriesExhausted, err)
+		}
+		return
+	}
+	putBytes(batchRequest.Objects)
+}
 
-		c.makeRequest(batchRequest)
-	})
-	b.reset()
+// dropExpired removes the objects of request whose Deadline passed, e.g.
+// while it was held or queued for a sender, dropping them with DropDeadline.
+// It reports whether objects are left to send.
+func (c *Client) dropExpired(request *batch) bool {
+	now := time.Now()
+	var expired []*Object
+	var positions []int
+	for k, v := range request.expiring {
+		if !now.Before(v.Deadline) {
+			expired = append(expired, v)
+			positions = append(positions, request.expiringPos[k])
+		}
+	}
+	if len(expired) == 0 {
+		return true
+	}
+	if len(expired) < request.count {
+		if err := request.remove(positions); err != nil {
+			// The stale objects are sent along rather than losing the others.
+			c.Logger.Printf("[Error] Expired objects failed to be removed from batch for collection `%s`: %v", request.Collection, err)
+			return true
+		}
+	}
+	for _, v := range expired {
+		c.drop(v, DropDeadline, context.DeadlineExceeded)
+	}
+	return len(expired) < request.count
+}
+
+// spool writes request to Spool, if any, and reports whether it did.
+func (c *Client) spool(request *batch) bool {
+	if c.Spool == nil {
+		return false
+	}
+	evicted, err := c.Spool.put(request)
+	for _, v := range evicted {
+		c.dropSpooled(v, DropEvicted, ErrSpoolFull)
+	}
+	if err != nil {
+		c.Logger.Printf("[Error] Batch for collection `%s` failed to spool: %v", request.Collection, err)
+		return false
+	}
+	atomic.AddInt64(&c.counters.failed, int64(request.count))
+	return true
 }
 
 func (c *Client) buffer(b *buffer) {
 	defer c.wg.Done()
+	pprof.SetGoroutineLabels(pprof.WithLabels(c.ctx, labels(b.collection, "buffer")))
 
-	tick := time.NewTicker(c.MaxBatchInterval)
+	for !c.loop(b) {
+	}
+}
 
-	for {
-		select {
-		case req := <-b.Channel:
-			req.Properties = tableize.Tableize(&tableize.Input{
-				Value: req.Properties,
-			})
-			x, err := json.Marshal(req)
-			if err != nil {
-				log.Printf("[Error] Message `%s` excluded from batch: %v", req.ID, err)
-				continue
-			}
-			if b.size()+len(x) >= c.MaxBatchBytes || b.count()+1 >= c.MaxBatchCount {
-				c.flush(b)
-			}
-			b.add(x)
-		case <-tick.C:
-			c.flush(b)
-		case <-b.Exit:
-			for req := range b.Channel {
-				req.Properties = tableize.Tableize(&tableize.Input{
-					Value: req.Properties,
-				})
-				x, err := json.Marshal(req)
-				if err != nil {
-					log.Printf("[Error] Message `%s` excluded from batch: %v", req.ID, err)
-					continue
-				}
-				if b.size()+len(x) >= c.MaxBatchBytes || b.count()+1 >= c.MaxBatchCount {
-					c.flush(b)
-				}
-				b.add(x)
-			}
-			c.flush(b)
-			return
+// dequeue adds every object queued in b, taking them all at once rather than
+// one per loop iteration, and reports whether a batch was flushed.
+func (c *Client) dequeue(b *buffer) bool {
+	b.pending, b.pendingAt = b.Queue.drain(b.pending[:0], b.pendingAt[:0])
+	return c.addPending(b)
+}
+
+// addPending adds the objects taken from b's queue and not added yet, and
+// reports whether a batch was flushed.
+func (c *Client) addPending(b *buffer) bool {
+	flushed := false
+	for i, req := range b.pending {
+		if req == nil {
+			continue
+		}
+		b.pending[i] = nil
+		b.adding, b.addingAt = req, b.pendingAt[i]
+		flushed = c.add(b, req) || flushed
+		b.adding, b.addingAt = nil, time.Time{}
+	}
+	return flushed
+}
+
+// add encodes req into b. A batch is flushed as soon as it reaches
+// MaxBatchCount objects or MaxBatchBytes, and before an object that would take
+// it over MaxBatchBytes. It reports whether a batch was flushed.
+func (c *Client) add(b *buffer, req *Object) bool {
+	if !req.Deadline.IsZero() && !time.Now().Before(req.Deadline) {
+		b.adding = nil
+		c.drop(req, DropDeadline, context.DeadlineExceeded)
+		return false
+	}
+
+	b.encoding = true
+	if c.spillable(req) && c.spill(req) {
+		b.adding, b.encoding = nil, false
+		return false
+	}
+
+	r := c.region(c.ctx, "objects.marshal")
+	x, err := c.marshal(req)
+	r.End()
+	b.encoding = false
+	if err != nil {
+		b.adding = nil
+		c.drop(req, DropMarshal, err)
+		return false
+	}
+
+	// Objects they drop must not be dropped again if OnDrop panics.
+	b.adding = nil
+	dedupe, dup := c.duplicate(req, x)
+	if dup || !c.admit(req, len(x)) {
+		return false
+	}
+	b.adding = req
+
+	if c.MaxBatchCount == 1 && b.count() == 0 {
+		request := c.single(b, req, x)
+		if c.Duplicates != nil {
+			request.dedupe = []duplicateEntry{dedupe}
+		}
+		b.adding, b.flushing = nil, request
+		if !c.hold(request, time.Now()) {
+			c.send(request)
+		}
+		b.flushing = nil
+		return true
+	}
+
+	flushed := false
+	if b.count() > 0 && b.size()+len(x) > c.MaxBatchBytes {
+		flushed = c.flush(b)
+	}
+	if b.count() == 0 {
+		c.reserve(b, len(x))
+	}
+	pos := b.count()
+	b.add(x)
+	b.adding = nil
+	if c.tracksIDs() {
+		b.ids = append(b.ids, req.ID)
+		b.meta = append(b.meta, req.Meta)
+	}
+	if !req.Deadline.IsZero() {
+		b.expiring = append(b.expiring, req)
+		b.expiringPos = append(b.expiringPos, pos)
+	}
+	if !req.ExpiresAt.IsZero() {
+		b.expires = append(b.expires, expiry{Collection: req.Collection, ID: req.ID, At: req.ExpiresAt})
+		b.expiresPos = append(b.expiresPos, pos)
+	}
+	if c.Duplicates != nil {
+		b.dedupe = append(b.dedupe, dedupe)
+	}
+	if b.count() >= c.MaxBatchCount || b.size() >= c.MaxBatchBytes {
+		flushed = c.flush(b) || flushed
+	}
+	return flushed
+}
+
+// maxReserve bounds the memory preallocated for a batch.
+const maxReserve = 4 << 20
+
+// reserve preallocates b for a batch starting with an object of n bytes, so
+// it isn't grown object by object. Batches are expected to be as large as the
+// previous one or, for the first one, to hold MaxBatchCount objects of n
+// bytes, within MaxBatchBytes.
+func (c *Client) reserve(b *buffer, n int) {
+	size, count := b.lastBytes, b.lastCount
+	if count == 0 {
+		count = c.MaxBatchCount
+		size = n*count + count + 1
+	}
+	if size > c.MaxBatchBytes {
+		size = c.MaxBatchBytes
+	}
+	if size > maxReserve {
+		size = maxReserve
+	}
+	if count > size/(n+1) {
+		count = size / (n + 1)
+	}
+
+	b.reserve(size)
+	if c.tracksIDs() && cap(b.ids) < count {
+		b.ids = make([]string, 0, count)
+		b.meta = make([]map[string]interface{}, 0, count)
+	}
+}
+
+func (c *Client) marshal(req *Object) ([]byte, error) {
+	if fn, ok := c.Serializers[req.Collection]; ok {
+		x, err := fn(req)
+		if err == nil && !json.Valid(x) {
+			err = ErrInvalidSerialization
 		}
+		return x, err
 	}
 
+	return json.Marshal(&Object{
+		ID:         req.ID,
+		Properties: c.Flatten(req),
+	})
+}
+
+// Serializer encodes an object as the JSON element sent in its batch's
+// objects array, e.g. `{"id":"1","properties":{...}}`, bypassing encoders,
+// ArrayStrategy and flattening. Objects it fails to encode are dropped.
+type Serializer func(*Object) ([]byte, error)
+
+// Flatten returns the properties of v as they will be sent, after encoders,
+// ArrayStrategy and tableize are applied. Properties of Nested collections
+// are not tableized. v is not modified.
+func (c *Client) Flatten(v *Object) map[string]interface{} {
+	props := c.normalize(v.ID, v.Properties)
+	if c.Nested[v.Collection] {
+		return props
+	}
+	return tableize.Tableize(&tableize.Input{Value: props})
 }
 
+// Close stops accepting objects, flushes every buffer and waits for all
+// batches to be delivered, in the phases described by ShutdownPhase.
 func (c *Client) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but once ctx is done it aborts in-flight
+// requests and retries, dropping the batches not yet delivered, and returns
+// ctx.Err().
+func (c *Client) CloseContext(ctx context.Context) error {
 	if !atomic.CompareAndSwapInt64(&c.closed, 0, 1) {
 		return ErrClientClosed
 	}
+	close(c.closing)
 
-	for t := range c.cmap.Iter() {
-		t.Val.Exit <- struct{}{}
-		close(t.Val.Exit)
-		close(t.Val.Channel)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.cancel()
+		case <-done:
+		}
+	}()
+
+	c.scheduler.cancel("window")
+	c.scheduler.cancel("watchdog")
+	c.scheduler.cancel("stalls")
+	c.scheduler.cancel("coalesce")
+	c.scheduler.cancel("probe")
+	if n := c.scheduler.stop(); n > 0 {
+		c.Logger.Printf("[Error] %d scheduled operations discarded on close", n)
 	}
+	// Sets waiting for a quota return once closing is closed.
+	c.setting.Wait()
+	c.delayed.Range(func(key, v interface{}) bool {
+		// With a Spool, held objects are left in it for the next Replay.
+		if _, ok := c.delayed.LoadAndDelete(key); ok && c.Spool == nil {
+			c.drop(v.(*Object), DropClosed, ErrClientClosed)
+		}
+		return true
+	})
 
-	c.wg.Wait()
-	c.semaphore.Wait()
+	c.shutdown()
+	return ctx.Err()
+}
 
+// Flush sends every object queued so far, without waiting for batches to
+// fill up, and waits for their delivery, e.g. before a serverless runtime
+// freezes the process. Batches held by DeliveryWindows stay held. Delivery
+// failures are handled as usual, Flush only returns ctx.Err() if ctx is done
+// first, or ErrClientClosed.
+func (c *Client) Flush(ctx context.Context) error {
+	if atomic.LoadInt64(&c.closed) == 1 {
+		return ErrClientClosed
+	}
+
+	var buffers []*buffer
+	for t := range c.cmap.Iter() {
+		buffers = append(buffers, t.Val.all()...)
+	}
+	for _, b := range buffers {
+		done := make(chan struct{})
+		select {
+		case b.flushes <- done:
+		case <-c.closing:
+			return ErrClientClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c.flushCoalesced()
+
+	// Deliveries are over once every slot of the semaphore is free. The
+	// slots taken are released as soon as ctx is done, rather than holding
+	// up later deliveries.
+	acquired := 0
+	defer func() {
+		for ; acquired > 0; acquired-- {
+			c.semaphore.Release()
+		}
+	}()
+	for acquired < cap(c.semaphore) {
+		select {
+		case c.semaphore <- struct{}{}:
+			acquired++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	return nil
 }
 
+// ValidateObject returns the error Set returns if v is invalid, without queuing
+// it, e.g. to check every object of a request before queuing any. Like Set,
+// it sets the ID of v from its Keys or IDGenerator if it has none.
+func (c *Client) ValidateObject(v *Object) error {
+	c.identify(v)
+	return v.validate()
+}
+
+// Set validates v and queues it on its collection's buffer. It does not
+// allocate once the collection's buffer exists; encoding happens in the
+// buffer goroutine.
 func (c *Client) Set(v *Object) error {
 	if atomic.LoadInt64(&c.closed) == 1 {
 		return ErrClientClosed
 	}
 
-	if err := validator.Validate(v); err != nil {
+	if err := c.ValidateObject(v); err != nil {
+		return err
+	}
+	if err := c.waitQuota(context.Background(), v.Collection); err != nil {
 		return err
 	}
 
-	c.cmap.Fetch(v.Collection, c.fetchFunction).Channel <- v
+	b, ok := c.cmap.Get(v.Collection)
+	if !ok {
+		b = c.cmap.Fetch(v.Collection, c.fetchFunction)
+	}
+	b = b.shard(v.ID)
+	if !b.Queue.push(v) {
+		return ErrClientClosed
+	}
+	b.observe()
+	atomic.AddInt64(&c.counters.queued, 1)
 	return nil
 }
 
-func (c *Client) makeRequest(request *batch) {
-	payload, err := json.Marshal(request)
-	if err != nil {
-		log.Printf("[Error] Batch failed to marshal: %v - %v", request, err)
+// SetAt validates v and holds it until t, when it is passed to Set.
+// Objects still held when the client is closed are dropped, see OnDrop,
+// unless the client has a Spool: SetAt persists them to it, and the Replay
+// of a later process holds them again.
+func (c *Client) SetAt(t time.Time, v *Object) error {
+	if atomic.LoadInt64(&c.closed) == 1 {
+		return ErrClientClosed
+	}
+
+	c.identify(v)
+	if err := v.validate(); err != nil {
+		return err
+	}
+
+	e := expiry{Collection: v.Collection, ID: v.ID, At: t, Held: &heldObject{Key: newRequestID()}}
+	if c.Spool != nil {
+		props, err := json.Marshal(v.Properties)
+		if err != nil {
+			return err
+		}
+		e.Held.Properties = props
+		e.Held.ExpiresAt = v.ExpiresAt
+		e.Held.Deadline = v.Deadline
+		if err := c.Spool.putExpiry(e); err != nil {
+			return err
+		}
+	}
+	c.holdObject(e, v)
+	return nil
+}
+
+// holdObject schedules the Set of v, held by SetAt until e.At, and records
+// it as done in Spool once v is queued. Set runs on its own goroutine, as it
+// may wait for a QuotaBlock quota, which would hold up every other scheduled
+// task.
+func (c *Client) holdObject(e expiry, v *Object) {
+	key := e.key()
+	c.delayed.Store(key, v)
+	c.scheduler.schedule(key, e.At, func() {
+		if _, ok := c.delayed.LoadAndDelete(key); !ok {
+			return
+		}
+		c.setting.Add(1)
+		go func() {
+			defer c.setting.Done()
+			c.setHeld(e, v)
+		}()
+	})
+}
+
+// setHeld passes v, held by SetAt, to Set.
+func (c *Client) setHeld(e expiry, v *Object) {
+	err := c.Set(v)
+	switch {
+	case err == ErrClientClosed && c.Spool != nil:
+		// Left in the spool for the next Replay.
 		return
+	case err == ErrClientClosed:
+		c.drop(v, DropClosed, err)
+	case err != nil:
+		c.Logger.Printf("[Error] Scheduled object `%s` failed to set: %v", v.ID, err)
+	}
+	if c.Spool != nil {
+		done := expiry{Collection: e.Collection, ID: e.ID, At: e.At, Held: &heldObject{Key: e.Held.Key}, Done: true}
+		if err := c.Spool.putExpiry(done); err != nil {
+			c.Logger.Printf("[Error] Scheduled object `%s` failed to spool: %v", v.ID, err)
+		}
+	}
+}
+
+// SendBatch validates, encodes and sends b synchronously, bypassing the
+// background buffers. It returns once the batch is delivered or retries are
+// exhausted.
+func (c *Client) SendBatch(ctx context.Context, b *Batch) error {
+	if atomic.LoadInt64(&c.closed) == 1 {
+		return ErrClientClosed
+	}
+
+	n, err := c.sendObjects(ctx, b.Collection, b.Objects, false)
+	b.Bytes = n
+	return err
+}
+
+// sendObjects validates, encodes and delivers objs of collection, in a
+// single batch, or in batches of at most MaxBatchCount objects and
+// MaxBatchBytes if split is set. Nothing is sent if an object is invalid.
+// It returns the encoded size of the objects.
+func (c *Client) sendObjects(ctx context.Context, collection string, objs []*Object, split bool) (int, error) {
+	type chunk struct {
+		objects json.RawMessage
+		count   int
+		ids     []string
+		meta    []map[string]interface{}
+	}
+
+	buf := newBuffer(collection, 0)
+	var chunks []*chunk
+	var ids []string
+	var meta []map[string]interface{}
+	size := 0
+	cut := func() {
+		chunks = append(chunks, &chunk{count: buf.count(), ids: ids, meta: meta, objects: buf.marshalArray()})
+		ids, meta = nil, nil
 	}
 
+	encoded := make([][]byte, len(objs))
+	for i, v := range objs {
+		c.identify(v)
+		if err := v.validate(); err != nil {
+			return 0, err
+		}
+		if v.Collection != collection {
+			return 0, fmt.Errorf("Object `%s` belongs to collection `%s`, not `%s`", v.ID, v.Collection, collection)
+		}
+		x, err := c.marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		encoded[i] = x
+	}
+
+	// Quotas only count objects once all of them are known to be valid.
+	if err := c.waitQuota(ctx, collection); err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for i, v := range objs {
+		x := encoded[i]
+		if !c.admit(v, len(x)) {
+			dropped++
+			continue
+		}
+
+		if split && buf.count() > 0 && (buf.count() >= c.MaxBatchCount || buf.size()+len(x) > c.MaxBatchBytes) {
+			cut()
+		}
+		buf.add(x)
+		size += len(x)
+		if c.tracksIDs() {
+			ids = append(ids, v.ID)
+			meta = append(meta, v.Meta)
+		}
+	}
+	if buf.count() > 0 || dropped == 0 {
+		cut()
+	}
+	atomic.AddInt64(&c.counters.queued, int64(dropped))
+
+	for i, ch := range chunks {
+		// Objects are queued once sent, so those of the batches not sent
+		// after a failure don't stay pending.
+		atomic.AddInt64(&c.counters.queued, int64(ch.count))
+		request := &batch{
+			Collection: collection,
+			WriteKey:   c.writeKey,
+			seq:        c.nextSeq(),
+			created:    time.Now(),
+			count:      ch.count,
+			enqueued:   time.Now(),
+			ids:        ch.ids,
+			meta:       ch.meta,
+			Objects:    ch.objects,
+		}
+		err := c.deliver(ctx, request)
+		putBytes(request.Objects)
+		if err != nil {
+			atomic.AddInt64(&c.counters.failed, int64(ch.count))
+			for _, ch := range chunks[i+1:] {
+				putBytes(ch.objects)
+			}
+			return size, err
+		}
+	}
+	return size, nil
+}
+
+func (c *Client) makeRequest(ctx context.Context, path string, request interface{}) error {
+	payload, err := encodeRequest(request)
+	if err != nil {
+		return c.redactError(fmt.Errorf("Request failed to marshal: %v", err))
+	}
+
+	return c.post(ctx, path, payload, http.Header{})
+}
 
-	b := backoff.NewExponentialBackOff()
-	b.MaxElapsedTime = 10 * time.Second
-	err = backoff.Retry(func() error {
-		bodyReader := bytes.NewReader(payload)
-		resp, err := http.Post(c.BaseEndpoint+"/v1/set", "application/json", bodyReader)
+// post sends payload to path with the given extra headers, retrying
+// failures. With Checksum, the checksum of the body as sent is also set in
+// header, so deliveries report it.
+func (c *Client) post(ctx context.Context, path string, payload []byte, header http.Header) error {
+	raw := payload
+	if c.CompressionLevel > 0 {
+		compressed, err := compress(payload, c.CompressionLevel)
 		if err != nil {
 			return err
 		}
+		payload = compressed
+	}
+	if c.Checksum {
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set(ChecksumHeader, checksum(payload))
+	}
+	if c.CompressionLevel > 0 {
+		header = cloneHeader(header)
+		header.Set("Content-Encoding", "gzip")
+	}
+
+	err := retry(ctx, c.backOff(ctx), func() error {
+		endpoint := c.endpoint()
+		req, err := http.NewRequest("POST", endpoint+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if c.Signer != nil {
+			if err := c.Signer.Sign(req, payload); err != nil {
+				return err
+			}
+		}
+
+		probe, err := c.gate(ctx)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		resp, err := c.attempt(ctx, req, payload)
+		c.observe(ctx, endpoint, start, probe, resp, err)
+		if err != nil {
+			if c.Metrics != nil && IsDNSError(err) {
+				c.Metrics.Count("objects.dns_errors", 1, nil)
+			}
+			return err
+		}
 		defer resp.Body.Close()
+		if st := retryStateFrom(ctx); st != nil {
+			st.status = resp.StatusCode
+		}
 
 		response := map[string]interface{}{}
 		dec := json.NewDecoder(resp.Body)
 		dec.Decode(&response)
 
 		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("HTTP Post Request Failed, Status Code %d. \nResponse: %v \nRequest payload: %v",
-					resp.StatusCode, response, string(payload))
+			return c.responseError(fmt.Errorf("HTTP Post Request Failed, Status Code %d. \nResponse: %v \nRequest payload: %v",
+				resp.StatusCode, response, string(raw)))
 		}
 
 		return nil
-	}, b)
+	})
+	return c.redactError(err)
+}
 
+// gate waits for the RequestLimiter, then checks the Breaker, before a
+// request is sent. It reports whether the request is the Breaker's probe.
+func (c *Client) gate(ctx context.Context) (probe bool, err error) {
+	if c.RequestLimiter != nil {
+		if err := c.RequestLimiter.WaitN(ctx, 1); err != nil {
+			return false, err
+		}
+	}
+	if c.Breaker == nil {
+		return false, nil
+	}
+	ok, probe := c.Breaker.allow()
+	if !ok {
+		return false, &permanentError{ErrBreakerOpen}
+	}
+	return probe, nil
+}
+
+// encodeRequest marshals request. Batches are assembled around their already
+// encoded objects instead of being re-encoded by encoding/json.
+func encodeRequest(request interface{}) ([]byte, error) {
+	b, ok := request.(*batch)
+	if !ok || len(b.Objects) == 0 {
+		return json.Marshal(request)
+	}
+
+	collection, err := json.Marshal(b.Collection)
 	if err != nil {
-		log.Printf("[Error] %v", err)
-		return
+		return nil, err
+	}
+	writeKey, err := json.Marshal(b.WriteKey)
+	if err != nil {
+		return nil, err
 	}
+
+	payload := make([]byte, 0, len(collection)+len(writeKey)+len(b.Objects)+40)
+	payload = append(payload, `{"collection":`...)
+	payload = append(payload, collection...)
+	payload = append(payload, `,"write_key":`...)
+	payload = append(payload, writeKey...)
+	payload = append(payload, `,"objects":`...)
+	payload = append(payload, b.Objects...)
+	payload = append(payload, '}')
+	return payload, nil
 }
@@ -1,13 +1,32 @@
 package objects
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/cenkalti/backoff"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/suite"
 )
@@ -21,6 +40,8 @@ type ClientTestSuite struct {
 
 	httpRequestsMutex sync.Mutex
 	httpRequests      []*batch
+	httpDeletes       []*deleteRequest
+	httpDeleted       chan struct{}
 	httpSuccess       int64
 	httpErrors        int64
 }
@@ -48,6 +69,33 @@ func (c *ClientTestSuite) SetupSuite() {
 	}
 
 	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", responder)
+
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/delete", func(req *http.Request) (*http.Response, error) {
+		defer req.Body.Close()
+
+		v := &deleteRequest{}
+		if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+			return httpmock.NewStringResponse(500, ""), nil
+		}
+
+		c.httpRequestsMutex.Lock()
+		c.httpDeletes = append(c.httpDeletes, v)
+		select {
+		case c.httpDeleted <- struct{}{}:
+		default:
+		}
+		c.httpRequestsMutex.Unlock()
+
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (c *ClientTestSuite) SetupTest() {
+	c.httpRequestsMutex.Lock()
+	c.httpRequests = nil
+	c.httpDeletes = nil
+	c.httpDeleted = make(chan struct{}, 10)
+	c.httpRequestsMutex.Unlock()
 }
 
 func (c *ClientTestSuite) TestNewClient() {
@@ -94,6 +142,202 @@ func (c *ClientTestSuite) TestSetFull() {
 	c.Equal("id2", received[1].ID)
 }
 
+// batchSizes returns the number of objects in every request received, sorted.
+func (c *ClientTestSuite) batchSizes() []int {
+	c.httpRequestsMutex.Lock()
+	defer c.httpRequestsMutex.Unlock()
+
+	sizes := []int{}
+	for _, r := range c.httpRequests {
+		received := []*Object{}
+		c.NoError(json.Unmarshal(r.Objects, &received))
+		sizes = append(sizes, len(received))
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+func (c *ClientTestSuite) TestMaxBatchCount() {
+	client := New("writeKey")
+	client.MaxBatchCount = 3
+
+	for i := 0; i < 7; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	c.Equal([]int{1, 3, 3}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) TestSingleObjectBatches() {
+	var mu sync.Mutex
+	var ids []string
+	client := New("writeKey")
+	client.MaxBatchCount = 1
+	client.OnDeliver = func(d Delivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, d.IDs...)
+	}
+
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	c.Equal([]int{1, 1, 1}, c.batchSizes())
+	c.Len(ids, 3)
+}
+
+func (c *ClientTestSuite) TestMeta() {
+	var deliveries []Delivery
+	client := New("writeKey")
+	client.OnDeliver = func(d Delivery) { deliveries = append(deliveries, d) }
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}, Meta: map[string]interface{}{"offset": 7}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Close())
+
+	c.Len(deliveries, 1)
+	c.Equal([]string{"1", "2"}, deliveries[0].IDs)
+	c.Equal([]map[string]interface{}{{"offset": 7}, nil}, deliveries[0].Meta)
+
+	c.httpRequestsMutex.Lock()
+	defer c.httpRequestsMutex.Unlock()
+	c.JSONEq(`[{"id":"1","properties":{"p":"1"}},{"id":"2","properties":{"p":"1"}}]`, string(c.httpRequests[0].Objects))
+}
+
+func (c *ClientTestSuite) TestReceipts() {
+	var receipts []Receipt
+	var deliveries []Delivery
+	client := New("writeKey")
+	client.OnDeliver = func(d Delivery) { deliveries = append(deliveries, d) }
+	client.Receipts = ReceiptStoreFunc(func(ctx context.Context, r []Receipt) error {
+		receipts = append(receipts, r...)
+		return nil
+	})
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Close())
+
+	c.Len(receipts, 2)
+	for i, r := range receipts {
+		c.Equal("c", r.Collection)
+		c.Equal(strconv.Itoa(i+1), r.ID)
+		c.Equal(deliveries[0].RequestID, r.BatchID)
+		c.False(r.Time.IsZero())
+	}
+}
+
+func (c *ClientTestSuite) TestReceiptsFailed() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+
+	var receipts []Receipt
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Receipts = ReceiptStoreFunc(func(ctx context.Context, r []Receipt) error {
+		receipts = append(receipts, r...)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c.Error(client.SendBatch(ctx, NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+	c.Empty(receipts)
+}
+
+func (c *ClientTestSuite) TestMaxBatchBytes() {
+	client := New("writeKey")
+	v := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	x, err := client.marshal(v)
+	c.NoError(err)
+
+	// Exactly two objects fit.
+	client.MaxBatchBytes = 2 * len(x)
+	for i := 0; i < 5; i++ {
+		c.NoError(client.Set(v))
+	}
+	c.NoError(client.Close())
+	c.Equal([]int{1, 2, 2}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) TestMaxBatchBytesOverflow() {
+	client := New("writeKey")
+	v := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	x, err := client.marshal(v)
+	c.NoError(err)
+
+	// The second object would overflow the batch, so it starts the next one.
+	client.MaxBatchBytes = 2*len(x) - 1
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(v))
+	}
+	c.NoError(client.Close())
+	c.Equal([]int{1, 1, 1}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) requestCount() int {
+	c.httpRequestsMutex.Lock()
+	defer c.httpRequestsMutex.Unlock()
+	return len(c.httpRequests)
+}
+
+// fakeTicker never ticks, and reports its resets.
+type fakeTicker struct {
+	resets chan time.Duration
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time { return nil }
+func (t *fakeTicker) Reset(d time.Duration)  { t.resets <- d }
+func (t *fakeTicker) Stop()                  {}
+
+// flushAfterFullBatch queues a full batch and one more object, and returns
+// the number of times the flush interval was restarted.
+func (c *ClientTestSuite) flushAfterFullBatch(align bool) int {
+	tick := &fakeTicker{resets: make(chan time.Duration, 10)}
+	delivered := make(chan struct{}, 10)
+	client := New("writeKey")
+	client.MaxBatchCount = 2
+	client.MaxBatchInterval = time.Hour
+	client.AlignFlushInterval = align
+	client.OnDeliver = func(Delivery) { delivered <- struct{}{} }
+	client.newTicker = func(time.Duration) ticker { return tick }
+	defer client.Close()
+
+	v := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(v))
+	}
+	<-delivered
+
+	// Flush is handled by the buffer once it is done with the full batch.
+	c.NoError(client.Flush(context.Background()))
+	return len(tick.resets)
+}
+
+func (c *ClientTestSuite) TestIntervalResetsAfterFlush() {
+	c.Equal(1, c.flushAfterFullBatch(false))
+}
+
+func (c *ClientTestSuite) TestAlignFlushInterval() {
+	c.Equal(0, c.flushAfterFullBatch(true))
+}
+
+func (c *ClientTestSuite) TestZeroIntervalFlushesImmediately() {
+	client := New("writeKey")
+	client.MaxBatchInterval = 0
+	defer client.Close()
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	deadline := time.Now().Add(time.Second)
+	for c.requestCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Equal(1, c.requestCount())
+}
+
 func (c *ClientTestSuite) TestChannelFlow() {
 	client := New("writeKey")
 	c.NotNil(client)
@@ -101,10 +345,10 @@ func (c *ClientTestSuite) TestChannelFlow() {
 	v := &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
 
 	buf := client.cmap.Fetch("c", client.fetchFunction)
-	buf.Channel <- v
+	c.True(buf.Queue.push(v))
 
 	// TODO(vince): Find a better solution to test this
-	// Wait for the channel to add to buffer
+	// Wait for the queue to add to buffer
 	time.Sleep(250 * time.Millisecond)
 	c.Equal(1, buf.count())
 
@@ -114,6 +358,84 @@ func (c *ClientTestSuite) TestChannelFlow() {
 	c.Equal(len(bt), buf.size())
 }
 
+func (c *ClientTestSuite) TestCloseLeaksNoGoroutines() {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		client := New("writeKey")
+		client.MaxBatchInterval = time.Millisecond
+		c.NoError(client.Set(&Object{ID: "id", Collection: "a", Properties: map[string]interface{}{"p": "1"}}))
+		c.NoError(client.Set(&Object{ID: "id", Collection: "b", Properties: map[string]interface{}{"p": "1"}}))
+		c.NoError(client.SetAt(time.Now().Add(time.Hour), &Object{ID: "id", Collection: "a", Properties: map[string]interface{}{"p": "1"}}))
+		c.NoError(client.Close())
+	}
+
+	// Goroutines may take a moment to be reaped after they return.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.True(runtime.NumGoroutine() <= before, "%d goroutines leaked", runtime.NumGoroutine()-before)
+}
+
+func (c *ClientTestSuite) TestCloseContext() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	c.NoError(client.Set(&Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.Equal(context.DeadlineExceeded, client.CloseContext(ctx))
+	c.True(time.Since(start) < time.Second)
+}
+
+func (c *ClientTestSuite) TestSendBatchCanceled() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.Error(client.SendBatch(ctx, NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+	c.True(time.Since(start) < time.Second)
+}
+
+func (c *ClientTestSuite) TestWriteKeyRedacted() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+
+	out := &bytes.Buffer{}
+	client := New("s3cr3t-write-key")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Logger = log.New(out, "", 0)
+	client.Debug = true
+	client.DebugBodies = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := client.SendBatch(ctx, NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}}))
+	c.Error(err)
+	c.NotContains(err.Error(), "s3cr3t-write-key")
+	c.Contains(err.Error(), `"write_key":"[redacted]"`)
+
+	c.Contains(out.String(), "[Debug] Request body")
+	c.NotContains(out.String(), "s3cr3t-write-key")
+}
+
+func (c *ClientTestSuite) TestRedactedErrorWraps() {
+	client := New("s3cr3t-write-key")
+	err := client.redactError(fmt.Errorf("write_key s3cr3t-write-key: %w", context.Canceled))
+	c.Equal("write_key [redacted]: context canceled", err.Error())
+	c.True(errors.Is(err, context.Canceled))
+}
+
 func (c *ClientTestSuite) TestSetErrors() {
 	client := New("writeKey")
 	c.NotNil(client)
@@ -139,3 +461,1339 @@ func (c *ClientTestSuite) TestClose() {
 	// Error already closed
 	c.Error(client.Set(&Object{ID: "id", Collection: "collection", Properties: map[string]interface{}{"prop1": "1"}}))
 }
+
+func (c *ClientTestSuite) TestSendBatch() {
+	client := New("writeKey")
+	c.NotNil(client)
+
+	b := NewBatch("c").Add(
+		&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}},
+		&Object{ID: "id2", Properties: map[string]interface{}{"p": "2"}},
+	)
+	c.NoError(client.SendBatch(context.Background(), b))
+	c.Equal(0, client.cmap.Count())
+
+	c.httpRequestsMutex.Lock()
+	requests := c.httpRequests
+	c.httpRequestsMutex.Unlock()
+	c.Require().Len(requests, 1)
+	c.Equal("c", requests[0].Collection)
+	c.Equal("writeKey", requests[0].WriteKey)
+
+	received := []*Object{}
+	c.NoError(json.Unmarshal(requests[0].Objects, &received))
+	c.Len(received, 2)
+	c.Equal("id", received[0].ID)
+	c.Equal("id2", received[1].ID)
+}
+
+func (c *ClientTestSuite) TestSendBatchErrors() {
+	client := New("writeKey")
+	c.NotNil(client)
+
+	// Error without properties
+	c.Error(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id"})))
+
+	// Error with object from another collection
+	c.Error(client.SendBatch(context.Background(), NewBatch("c").Add(
+		&Object{ID: "id", Collection: "other", Properties: map[string]interface{}{"p": "1"}},
+	)))
+
+	c.Equal(0, c.requestCount())
+}
+
+func (c *ClientTestSuite) TestDelete() {
+	client := New("writeKey")
+	c.NotNil(client)
+
+	c.NoError(client.Delete(context.Background(), "c", "id"))
+	c.Len(c.httpDeletes, 1)
+	c.Equal("c", c.httpDeletes[0].Collection)
+	c.Equal("writeKey", c.httpDeletes[0].WriteKey)
+	c.Equal([]string{"id"}, c.httpDeletes[0].IDs)
+}
+
+func (c *ClientTestSuite) TestDeleteBatch() {
+	client := New("writeKey")
+
+	ids := make([]string, MaxDeleteIDs+5)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+	n, err := client.DeleteBatch(context.Background(), "c", ids)
+	c.NoError(err)
+	c.Equal(len(ids), n)
+	c.Len(c.httpDeletes, 2)
+	c.Len(c.httpDeletes[0].IDs, MaxDeleteIDs)
+	c.Equal([]string{"1000", "1001", "1002", "1003", "1004"}, c.httpDeletes[1].IDs)
+
+	// MaxBatchBytes also bounds requests.
+	c.httpDeletes = nil
+	client.MaxBatchBytes = 12
+	n, err = client.DeleteBatch(context.Background(), "c", []string{"abc", "def", "ghi"})
+	c.NoError(err)
+	c.Equal(3, n)
+	c.Len(c.httpDeletes, 2)
+	c.Equal([]string{"abc", "def"}, c.httpDeletes[0].IDs)
+}
+
+func (c *ClientTestSuite) TestSetDeleted() {
+	client := New("writeKey")
+	client.DeletedField = "is_deleted"
+
+	at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.NoError(client.SetDeleted("c", "id", at))
+	c.NoError(client.Close())
+
+	c.Len(c.httpRequests, 1)
+	c.JSONEq(`[{"id":"id","properties":{"is_deleted":true,"deleted_at":"2016-01-02T03:04:05Z"}}]`, string(c.httpRequests[0].Objects))
+}
+
+func (c *ClientTestSuite) TestExpiresAt() {
+	client := New("writeKey")
+	c.NotNil(client)
+
+	// The Delete is scheduled once the Set is delivered, and so follows it
+	// even for an object already expired.
+	v := &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "0"}, ExpiresAt: time.Now().Add(-time.Second)}
+	c.NoError(client.Set(v))
+	c.Equal(0, client.scheduler.pending())
+	c.NoError(client.Flush(context.Background()))
+	<-c.httpDeleted
+	c.httpRequestsMutex.Lock()
+	c.Len(c.httpRequests, 1)
+	c.Len(c.httpDeletes, 1)
+	c.httpRequestsMutex.Unlock()
+
+	v = &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}, ExpiresAt: time.Now().Add(time.Hour)}
+	c.NoError(client.Set(v))
+	c.NoError(client.Flush(context.Background()))
+	c.Equal(1, client.scheduler.pending())
+
+	// A later Set reschedules the same object
+	v = &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "2"}, ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+	c.NoError(client.Set(v))
+	c.NoError(client.Flush(context.Background()))
+	c.Equal(1, client.scheduler.pending())
+
+	<-c.httpDeleted
+	c.Equal(0, client.scheduler.pending())
+
+	c.httpRequestsMutex.Lock()
+	c.Len(c.httpDeletes, 2)
+	c.Equal([]string{"id"}, c.httpDeletes[1].IDs)
+	c.httpRequestsMutex.Unlock()
+
+	c.NoError(client.Close())
+}
+
+func (c *ClientTestSuite) TestExpiresAtDiscardedOnClose() {
+	client := New("writeKey")
+	c.NotNil(client)
+
+	v := &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}, ExpiresAt: time.Now().Add(time.Hour)}
+	c.NoError(client.Set(v))
+	c.NoError(client.Close())
+	c.Len(c.httpDeletes, 0)
+}
+
+func (c *ClientTestSuite) TestSetAt() {
+	client := New("writeKey")
+	client.MaxBatchInterval = 50 * time.Millisecond
+	c.NotNil(client)
+
+	v := &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	c.NoError(client.SetAt(time.Now().Add(100*time.Millisecond), v))
+	c.Equal(0, client.cmap.Count())
+	c.Equal(1, client.scheduler.pending())
+
+	time.Sleep(250 * time.Millisecond)
+	c.Equal(1, client.cmap.Count())
+	c.Equal(0, client.scheduler.pending())
+	c.NoError(client.Close())
+
+	c.Len(c.httpRequests, 1)
+}
+
+func (c *ClientTestSuite) TestSetAtQuotaBlock() {
+	delivered := make(chan string, 10)
+	var mu sync.Mutex
+	var drops []Drop
+	client := New("writeKey")
+	client.MaxBatchCount = 1
+	client.Quotas = map[string]*Quota{"c": {MaxObjects: 1, Mode: QuotaBlock}}
+	client.OnDeliver = func(d Delivery) { delivered <- d.Collection }
+	client.OnDrop = func(d Drop) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops = append(drops, d)
+	}
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.Equal("c", <-delivered)
+
+	// An object waiting for its quota doesn't hold up the ones after it.
+	c.NoError(client.SetAt(time.Now(), &Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.SetAt(time.Now().Add(time.Millisecond), &Object{ID: "3", Collection: "d", Properties: map[string]interface{}{"p": "1"}}))
+	c.Equal("d", <-delivered)
+
+	c.NoError(client.Close())
+	mu.Lock()
+	defer mu.Unlock()
+	c.Len(drops, 1)
+	c.Equal("2", drops[0].Object.ID)
+	c.Equal(DropClosed, drops[0].Reason)
+}
+
+func (c *ClientTestSuite) TestSetAtErrors() {
+	client := New("writeKey")
+	c.NotNil(client)
+
+	// Error without properties
+	c.Error(client.SetAt(time.Now(), &Object{ID: "id", Collection: "collection"}))
+
+	v := &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	c.NoError(client.SetAt(time.Now().Add(time.Hour), v))
+	c.NoError(client.Close())
+	c.Len(c.httpRequests, 0)
+
+	// Error already closed
+	c.Equal(ErrClientClosed, client.SetAt(time.Now(), v))
+}
+
+type testSink struct {
+	mu      sync.Mutex
+	batches map[string]json.RawMessage
+}
+
+func (s *testSink) Send(ctx context.Context, collection string, objects json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[collection] = append(json.RawMessage(nil), objects...)
+	return nil
+}
+
+func (c *ClientTestSuite) TestSinks() {
+	sink := &testSink{batches: map[string]json.RawMessage{}}
+	client := New("writeKey")
+	client.Sinks = []Sink{sink}
+	c.NotNil(client)
+
+	v := &Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	c.NoError(client.Set(v))
+	c.NoError(client.Close())
+
+	c.Len(c.httpRequests, 1)
+	c.Len(sink.batches, 1)
+	c.Equal(string(c.httpRequests[0].Objects), string(sink.batches["c"]))
+}
+
+func (c *ClientTestSuite) TestSinksSkipFailedBatches() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+	sink := &testSink{batches: map[string]json.RawMessage{}}
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.RetryNetworkErrorsOnly = true
+	client.Sinks = []Sink{sink}
+
+	c.Error(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+	c.Len(sink.batches, 0)
+}
+
+func (c *ClientTestSuite) TestSigner() {
+	signatures := make(chan string, 1)
+	httpmock.RegisterResponder("POST", "https://signed.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		signatures <- req.Header.Get(DefaultSignatureHeader)
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://signed.segment.com"
+	client.Signer = NewHMACSigner([]byte("secret"))
+
+	b := NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})
+	c.NoError(client.SendBatch(context.Background(), b))
+
+	payload, err := json.Marshal(&batch{
+		Collection: "c",
+		WriteKey:   "writeKey",
+		Objects:    json.RawMessage(`[{"id":"id","properties":{"p":"1"}}]`),
+	})
+	c.NoError(err)
+	c.Equal(NewHMACSigner([]byte("secret")).Signature(payload), <-signatures)
+}
+
+func (c *ClientTestSuite) TestChecksum() {
+	checksums := make(chan string, 1)
+	httpmock.RegisterResponder("POST", "https://checksum.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		sum := sha256.Sum256(body)
+		c.Equal(hex.EncodeToString(sum[:]), req.Header.Get(ChecksumHeader))
+		checksums <- req.Header.Get(ChecksumHeader)
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	var deliveries []Delivery
+	client := New("writeKey")
+	client.BaseEndpoint = "https://checksum.segment.com"
+	client.Checksum = true
+	client.OnDeliver = func(d Delivery) { deliveries = append(deliveries, d) }
+
+	c.NoError(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+
+	c.Len(deliveries, 1)
+	c.Equal("c", deliveries[0].Collection)
+	c.Equal(1, deliveries[0].Objects)
+	c.NoError(deliveries[0].Err)
+	c.Equal(<-checksums, deliveries[0].Checksum)
+}
+
+func (c *ClientTestSuite) TestChecksumCompressed() {
+	checksums := make(chan string, 1)
+	httpmock.RegisterResponder("POST", "https://checksumgzip.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		sum := sha256.Sum256(body)
+		c.Equal("gzip", req.Header.Get("Content-Encoding"))
+		c.Equal(hex.EncodeToString(sum[:]), req.Header.Get(ChecksumHeader))
+		checksums <- req.Header.Get(ChecksumHeader)
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	var deliveries []Delivery
+	client := New("writeKey")
+	client.BaseEndpoint = "https://checksumgzip.segment.com"
+	client.Checksum = true
+	client.CompressionLevel = gzip.BestSpeed
+	client.OnDeliver = func(d Delivery) { deliveries = append(deliveries, d) }
+
+	c.NoError(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+
+	c.Len(deliveries, 1)
+	c.Equal(<-checksums, deliveries[0].Checksum)
+}
+
+type testPayloadEncoder struct{}
+
+func (testPayloadEncoder) ContentType() string {
+	return "text/plain"
+}
+
+func (testPayloadEncoder) Encode(collection, writeKey string, objects json.RawMessage) ([]byte, error) {
+	return []byte(collection + " " + string(objects)), nil
+}
+
+func (c *ClientTestSuite) TestPayloadEncoder() {
+	bodies := make(chan string, 1)
+	httpmock.RegisterResponder("POST", "https://encoder.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		c.Equal("text/plain", req.Header.Get("Content-Type"))
+		bodies <- string(body)
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://encoder.segment.com"
+	client.PayloadEncoder = testPayloadEncoder{}
+
+	c.NoError(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+	c.Equal(`c [{"id":"id","properties":{"p":"1"}}]`, <-bodies)
+}
+
+func (c *ClientTestSuite) TestMiddleware() {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next BatchSender) BatchSender {
+			return BatchSenderFunc(func(ctx context.Context, r *BatchRequest) error {
+				calls = append(calls, name+":"+r.Collection+":"+strconv.Itoa(r.Count))
+				r.Header.Set("X-Trace", name)
+				return next.Send(ctx, r)
+			})
+		}
+	}
+	chaos := errors.New("chaos")
+	traces := make(chan string, 1)
+	httpmock.RegisterResponder("POST", "https://middleware.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		traces <- req.Header.Get("X-Trace")
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://middleware.segment.com"
+	client.Middleware = []Middleware{trace("outer"), trace("inner")}
+	b := NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})
+	c.NoError(client.SendBatch(context.Background(), b))
+	c.Equal([]string{"outer:c:1", "inner:c:1"}, calls)
+	c.Equal("inner", <-traces)
+
+	client.Middleware = []Middleware{func(next BatchSender) BatchSender {
+		return BatchSenderFunc(func(ctx context.Context, r *BatchRequest) error {
+			return chaos
+		})
+	}}
+	c.Equal(chaos, client.SendBatch(context.Background(), b))
+	c.Equal(int64(1), client.Stats().Failed)
+}
+
+func (c *ClientTestSuite) TestBackoff() {
+	delays := func(b Backoff, n int) []time.Duration {
+		bo := b.NewBackOff()
+		bo.Reset()
+		var ret []time.Duration
+		for i := 0; i < n; i++ {
+			ret = append(ret, bo.NextBackOff())
+		}
+		return ret
+	}
+
+	c.Equal([]time.Duration{time.Second, time.Second, time.Second},
+		delays(&ConstantBackoff{Interval: time.Second}, 3))
+	c.Equal([]time.Duration{1, 1, 2, 3, 5, 8, 10},
+		delays(&FibonacciBackoff{Initial: 1, Max: 10}, 7))
+	for _, d := range delays(&DecorrelatedJitter{Base: 10, Cap: 100}, 20) {
+		c.True(d >= 10 && d <= 100, "%v", d)
+	}
+
+	// A zero Cap or Max leaves delays uncapped.
+	c.Equal([]time.Duration{1, 1, 2, 3, 5, 8, 13},
+		delays(&FibonacciBackoff{Initial: 1}, 7))
+	for _, d := range delays(&DecorrelatedJitter{Base: 10}, 20) {
+		c.True(d >= 10, "%v", d)
+	}
+
+	// The client's Backoff schedules retries.
+	calls := 0
+	httpmock.RegisterResponder("POST", "https://backoff.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		calls++
+		return httpmock.NewStringResponse(500, ""), nil
+	})
+	client := New("writeKey")
+	client.BaseEndpoint = "https://backoff.segment.com"
+	client.Backoff = &ConstantBackoff{Interval: time.Millisecond, MaxElapsedTime: 50 * time.Millisecond}
+	c.Error(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.True(calls > 10, "%d", calls)
+
+	client.Backoff = BackoffFunc(func() backoff.BackOff { return &backoff.StopBackOff{} })
+	calls = 0
+	c.Error(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.Equal(1, calls)
+}
+
+func (c *ClientTestSuite) TestRetryNetworkErrorsOnly() {
+	calls := 0
+	status := 0
+	httpmock.RegisterResponder("POST", "https://network.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		calls++
+		if status == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return httpmock.NewStringResponse(status, ""), nil
+	})
+	client := New("writeKey")
+	client.BaseEndpoint = "https://network.segment.com"
+	client.Backoff = &ConstantBackoff{Interval: time.Millisecond, MaxElapsedTime: 20 * time.Millisecond}
+	client.RetryNetworkErrorsOnly = true
+
+	// Responses are not retried, even 5xx.
+	status = 503
+	err := client.post(context.Background(), "/v1/set", []byte("{}"), nil)
+	c.Error(err)
+	c.Contains(err.Error(), "Status Code 503")
+	c.Equal(1, calls)
+
+	calls, status = 0, 0
+	c.Error(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.True(calls > 1, "%d", calls)
+}
+
+func (c *ClientTestSuite) TestBreaker() {
+	calls := 0
+	delay := 20 * time.Millisecond
+	httpmock.RegisterResponder("POST", "https://slow.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		calls++
+		time.Sleep(delay)
+		return httpmock.NewStringResponse(200, ""), nil
+	})
+	var states []bool
+	client := New("writeKey")
+	client.BaseEndpoint = "https://slow.segment.com"
+	client.Breaker = NewBreaker(2, 50*time.Millisecond)
+	client.Breaker.SlowResponse = 10 * time.Millisecond
+	client.Breaker.OnStateChange = func(open bool) { states = append(states, open) }
+	c.NoError(client.Validate())
+
+	// Slow responses open the breaker, which then fails requests at once.
+	c.NoError(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.NoError(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.True(client.Breaker.Open())
+	c.Equal(ErrBreakerOpen, client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.Equal(2, calls)
+
+	// After the cooldown, a fast response closes it.
+	delay = 0
+	time.Sleep(50 * time.Millisecond)
+	c.NoError(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.False(client.Breaker.Open())
+	c.Equal(3, calls)
+	c.Equal([]bool{true, false}, states)
+}
+
+func (c *ClientTestSuite) TestBreakerSingleProbe() {
+	b := NewBreaker(1, 0)
+	ok, probe := b.allow()
+	c.True(ok)
+	c.False(probe)
+	b.record(probe, true)
+
+	// Only one request is let through while open, whatever other requests
+	// in flight do.
+	ok, probe = b.allow()
+	c.True(ok)
+	c.True(probe)
+	b.cancel(false)
+	b.record(false, true)
+	ok, _ = b.allow()
+	c.False(ok)
+
+	b.cancel(probe)
+	ok, probe = b.allow()
+	c.True(ok)
+	c.True(probe)
+}
+
+func (c *ClientTestSuite) TestRequestLimiter() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+	limiter := &countingLimiter{}
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Backoff = &ConstantBackoff{Interval: time.Millisecond, MaxElapsedTime: 20 * time.Millisecond}
+	client.RequestLimiter = limiter
+	c.Error(client.post(context.Background(), "/v1/set", []byte("{}"), nil))
+	c.True(limiter.n > 1, "%d", limiter.n)
+}
+
+func (c *ClientTestSuite) TestRetryBudget() {
+	var attempts int64
+	httpmock.RegisterResponder("POST", "https://budget.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt64(&attempts, 1)
+		return httpmock.NewStringResponse(500, ""), nil
+	})
+
+	exhausted := 0
+	metrics := &testMetrics{counts: map[string]int64{}}
+	client := New("writeKey")
+	client.BaseEndpoint = "https://budget.segment.com"
+	client.Metrics = metrics
+	client.RetryBudget = NewRetryBudget(0, 1)
+	client.RetryBudget.OnExhausted = func() { exhausted++ }
+
+	b := NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		c.Error(client.SendBatch(ctx, b))
+		cancel()
+	}
+
+	// One retry, then none.
+	c.Equal(int64(3), atomic.LoadInt64(&attempts))
+	c.Equal(0, client.RetryBudget.Remaining())
+	c.Equal(1, exhausted)
+	c.Equal(int64(1), metrics.counts["objects.retries..ok"])
+	c.Equal(int64(2), metrics.counts["objects.retries..exhausted"])
+}
+
+func (c *ClientTestSuite) TestHedge() {
+	var attempts int64
+	httpmock.RegisterResponder("POST", "https://hedge.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://hedge.segment.com"
+	client.Hedge = &HedgePolicy{MinSamples: 1, MaxRatio: 1}
+	client.hedger.observe(50 * time.Millisecond)
+
+	start := time.Now()
+	b := NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})
+	c.NoError(client.SendBatch(context.Background(), b))
+	c.True(time.Since(start) < time.Second)
+	c.Equal(int64(2), atomic.LoadInt64(&attempts))
+
+	// Hedges are capped to MaxRatio of the requests.
+	client.Hedge.MaxRatio = 0.01
+	atomic.StoreInt64(&attempts, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	c.Error(client.SendBatch(ctx, b))
+	c.Equal(int64(1), atomic.LoadInt64(&attempts))
+}
+
+func (c *ClientTestSuite) TestCoalesce() {
+	requests := make(chan *multiRequest, 10)
+	httpmock.RegisterResponder("POST", "https://multi.segment.com/v1/multi", func(req *http.Request) (*http.Response, error) {
+		v := &multiRequest{}
+		if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		requests <- v
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://multi.segment.com"
+	client.Coalesce = true
+	for _, coll := range []string{"a", "b", "c"} {
+		c.NoError(client.Set(&Object{ID: "1", Collection: coll, Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	c.Len(requests, 1)
+	v := <-requests
+	c.Equal("writeKey", v.WriteKey)
+	c.Len(v.Batches, 3)
+	c.Equal(Stats{Queued: 3, Sent: 3}, client.Stats())
+}
+
+func (c *ClientTestSuite) TestCoalesceRejected() {
+	httpmock.RegisterResponder("POST", "https://multi-reject.segment.com/v1/multi", httpmock.NewStringResponder(400, ""))
+	dir, err := ioutil.TempDir("", "objects")
+	c.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	spool, err := OpenSpool(dir)
+	c.Require().NoError(err)
+	defer spool.Close()
+
+	var mu sync.Mutex
+	var drops []Drop
+	client := New("writeKey")
+	client.BaseEndpoint = "https://multi-reject.segment.com"
+	client.Coalesce = true
+	client.Spool = spool
+	client.Backoff = BackoffFunc(func() backoff.BackOff { return &backoff.StopBackOff{} })
+	client.OnDrop = func(d Drop) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops = append(drops, d)
+	}
+	for _, coll := range []string{"a", "b"} {
+		c.NoError(client.Set(&Object{ID: "1", Collection: coll, Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	// Rejected batches are dropped rather than spooled to be rejected again.
+	mu.Lock()
+	defer mu.Unlock()
+	c.Len(drops, 2)
+	c.Equal(DropRejected, drops[0].Reason)
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	c.NoError(err)
+	for _, path := range segments {
+		records, err := spool.readRecords(path)
+		c.NoError(err)
+		c.Empty(records)
+	}
+}
+
+func (c *ClientTestSuite) TestAuditLog() {
+	dir, err := ioutil.TempDir("", "objects")
+	c.NoError(err)
+	defer os.RemoveAll(dir)
+
+	audit, err := OpenAuditLog(filepath.Join(dir, "audit.jsonl"))
+	c.NoError(err)
+
+	client := New("writeKey")
+	client.AuditLog = audit
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Close())
+	c.NoError(audit.Close())
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "audit.jsonl"))
+	c.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	c.Len(lines, 1)
+
+	entry := map[string]interface{}{}
+	c.NoError(json.Unmarshal([]byte(lines[0]), &entry))
+	c.Equal("c", entry["collection"])
+	c.Equal([]interface{}{"1", "2"}, entry["ids"])
+	c.Equal("ok", entry["status"])
+	c.Len(entry["request_id"], 32)
+	c.Equal(float64(1), entry["seq"])
+	c.NotEmpty(entry["created"])
+	c.NotContains(string(b), "writeKey")
+}
+
+func (c *ClientTestSuite) TestDeliverySeq() {
+	var mu sync.Mutex
+	var seqs []int
+	client := New("writeKey")
+	client.MaxBatchCount = 1
+	client.OnDeliver = func(d Delivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		c.False(d.Created.IsZero())
+		seqs = append(seqs, int(d.Seq))
+	}
+
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	sort.Ints(seqs)
+	c.Equal([]int{1, 2, 3}, seqs)
+}
+
+func (c *ClientTestSuite) TestUserAgent() {
+	agents := make(chan string, 1)
+	httpmock.RegisterResponder("POST", "https://agent.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		agents <- req.Header.Get("User-Agent")
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://agent.segment.com"
+	c.NoError(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+
+	agent := <-agents
+	c.True(strings.HasPrefix(agent, "objects-go/"+Version+" ("), agent)
+	c.Contains(agent, runtime.Version())
+}
+
+func (c *ClientTestSuite) TestVersionInfo() {
+	info := VersionInfo()
+	c.Equal(Version, info.Version)
+	c.Equal(runtime.Version(), info.GoVersion)
+}
+
+func (c *ClientTestSuite) TestStats() {
+	client := New("writeKey")
+	client.PublishExpvar("objects_test_stats")
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "3", Collection: "c", Properties: map[string]interface{}{"p": make(chan int)}}))
+	c.NoError(client.Close())
+
+	c.Equal(Stats{Queued: 3, Sent: 2, Dropped: 1}, client.Stats())
+	c.JSONEq(`{"queued": 3, "sent": 2, "failed": 0, "dropped": 1, "inflight": 0}`, expvar.Get("objects_test_stats").String())
+}
+
+func (c *ClientTestSuite) TestStatsSendBatch() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+	client := New("writeKey")
+	b := NewBatch("c").
+		Add(&Object{ID: "1", Properties: map[string]interface{}{"p": "1"}}).
+		Add(&Object{ID: "2", Properties: map[string]interface{}{"p": "1"}})
+	c.NoError(client.SendBatch(context.Background(), b))
+	c.Equal(Stats{Queued: 2, Sent: 2}, client.Stats())
+
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.RetryNetworkErrorsOnly = true
+	c.Error(client.SendBatch(context.Background(), b))
+	c.Equal(Stats{Queued: 4, Sent: 2, Failed: 2}, client.Stats())
+}
+
+// checkStats checks that every queued object is in exactly one bucket.
+func (c *ClientTestSuite) checkStats(s Stats, pending int64) {
+	c.Equal(s.Queued, s.Sent+s.Failed+s.Dropped+pending, "%+v", s)
+}
+
+func (c *ClientTestSuite) TestStatsFailedOnce() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+	v := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+
+	// Batches that fail and are dropped only count as Dropped.
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.RetryNetworkErrorsOnly = true
+	c.NoError(client.Set(v))
+	c.NoError(client.Close())
+	c.Equal(Stats{Queued: 1, Dropped: 1}, client.Stats())
+	c.checkStats(client.Stats(), 0)
+
+	// Spooled ones only count as Failed, even if dropped later.
+	dir, err := ioutil.TempDir("", "stats")
+	c.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	spool, err := OpenSpool(dir)
+	c.Require().NoError(err)
+	defer spool.Close()
+
+	client = New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.RetryNetworkErrorsOnly = true
+	client.Spool = spool
+	c.NoError(client.Set(v))
+	c.NoError(client.Flush(context.Background()))
+	c.Equal(Stats{Queued: 1, Failed: 1}, client.Stats())
+	c.checkStats(client.Stats(), 0)
+
+	client.dropSpooled(&spooledBatch{Collection: "c", Objects: json.RawMessage(`[{"id":"1"}]`)}, DropEvicted, ErrSpoolFull)
+	c.Equal(Stats{Queued: 1, Failed: 1}, client.Stats())
+	c.NoError(client.Close())
+}
+
+type testMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	timed  int
+}
+
+func (m *testMetrics) Count(name string, value int64, tags map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name+"."+tags["collection"]+"."+tags["status"]+tags["budget"]] += value
+}
+
+func (m *testMetrics) Timing(name string, value time.Duration, tags map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timed++
+}
+
+func (c *ClientTestSuite) TestMetrics() {
+	metrics := &testMetrics{counts: map[string]int64{}}
+	client := New("writeKey")
+	client.Metrics = metrics
+
+	c.NoError(client.SendBatch(context.Background(), NewBatch("c").Add(
+		&Object{ID: "1", Properties: map[string]interface{}{"p": "1"}},
+		&Object{ID: "2", Properties: map[string]interface{}{"p": "1"}},
+	)))
+
+	c.Equal(int64(1), metrics.counts["objects.batches.c.ok"])
+	c.Equal(int64(2), metrics.counts["objects.objects.c.ok"])
+	c.NotZero(metrics.counts["objects.bytes.c.ok"])
+	c.Equal(2, metrics.timed)
+}
+
+func (c *ClientTestSuite) TestOnDrop() {
+	var drops []Drop
+	metrics := &testMetrics{counts: map[string]int64{}}
+	client := New("writeKey")
+	client.Metrics = metrics
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+
+	v := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": make(chan int)}}
+	c.NoError(client.Set(v))
+	c.NoError(client.Close())
+
+	c.Len(drops, 1)
+	c.True(drops[0].Object == v)
+	c.Equal(DropMarshal, drops[0].Reason)
+	c.Error(drops[0].Err)
+	c.Equal(int64(1), client.Stats().Dropped)
+	c.Equal(int64(1), metrics.counts["objects.dropped.c."])
+}
+
+func (c *ClientTestSuite) TestDropReasons() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+
+	var mu sync.Mutex
+	drops := map[string]DropReason{}
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.OnDrop = func(d Drop) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops[d.Object.ID] = d.Reason
+	}
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.SetAt(time.Now().Add(time.Hour), &Object{ID: "3", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.CloseContext(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Equal(map[string]DropReason{"1": DropRetriesExhausted, "2": DropRetriesExhausted, "3": DropClosed}, drops)
+	c.Equal(int64(3), client.Stats().Dropped)
+}
+
+func (c *ClientTestSuite) TestDumpState() {
+	client := New("writeKey")
+	client.MaxBatchInterval = time.Hour
+	client.DebugBodies = true
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "2"}}))
+
+	type dump struct {
+		Buffers []struct {
+			Collection    string
+			Queued        int
+			Buffered      int
+			BufferedBytes int `json:"buffered_bytes"`
+			Oldest        *time.Time
+			Objects       json.RawMessage
+			Responsive    bool
+		}
+	}
+	var d dump
+	for i := 0; i < 100; i++ {
+		buf := &bytes.Buffer{}
+		c.NoError(client.DumpState(buf))
+		d = dump{}
+		c.NoError(json.Unmarshal(buf.Bytes(), &d))
+		if d.Buffers[0].Queued == 0 && d.Buffers[0].Buffered == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b := d.Buffers[0]
+	c.Equal("c", b.Collection)
+	c.Equal(0, b.Queued)
+	c.Equal(2, b.Buffered)
+	c.True(b.BufferedBytes > 0)
+	c.NotNil(b.Oldest)
+	c.True(b.Responsive)
+	c.JSONEq(`[{"id":"1","properties":{"p":"1"}},{"id":"2","properties":{"p":"2"}}]`, string(b.Objects))
+	c.NoError(client.Close())
+}
+
+func (c *ClientTestSuite) TestDumpStalledState() {
+	unblock := make(chan struct{})
+	client := New("writeKey")
+	client.OnDrop = func(Drop) { <-unblock }
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Deadline: time.Now(), Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	time.Sleep(10 * time.Millisecond)
+
+	// The buffer goroutine is blocked: the dump says so without waiting.
+	buf := &bytes.Buffer{}
+	start := time.Now()
+	c.NoError(client.DumpState(buf))
+	c.True(time.Since(start) < time.Second)
+	c.Contains(buf.String(), `"responsive": false`)
+	c.Contains(buf.String(), `"busy_since"`)
+	c.NotContains(buf.String(), `"objects"`)
+
+	close(unblock)
+	c.NoError(client.Close())
+}
+
+func (c *ClientTestSuite) TestDeadline() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+
+	var mu sync.Mutex
+	var drops []Drop
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.OnDrop = func(d Drop) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops = append(drops, d)
+	}
+
+	stale := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}, Deadline: time.Now().Add(-time.Second)}
+	c.NoError(client.Set(stale))
+	fresh := &Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}, Deadline: time.Now().Add(100 * time.Millisecond)}
+	c.NoError(client.Set(fresh))
+
+	start := time.Now()
+	c.NoError(client.Close())
+	c.True(time.Since(start) < time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Len(drops, 2)
+	c.True(drops[0].Object == stale)
+	c.Equal(DropDeadline, drops[0].Reason)
+	c.Equal(context.DeadlineExceeded, drops[0].Err)
+	c.True(drops[1].Object == fresh)
+	c.Equal(DropDeadline, drops[1].Reason)
+}
+
+func (c *ClientTestSuite) TestRemoveByPosition() {
+	stale := &Object{ID: "1", Deadline: time.Now()}
+	b := &batch{
+		Collection:  "c",
+		Objects:     json.RawMessage(`[{"id":"1","properties":{"p":"stale"}},{"id":"1","properties":{"p":"kept"}},{"key":"2"}]`),
+		count:       3,
+		ids:         []string{"1", "1", "2"},
+		meta:        make([]map[string]interface{}, 3),
+		expiring:    []*Object{stale},
+		expiringPos: []int{0},
+		expires:     []expiry{{ID: "2"}},
+		expiresPos:  []int{2},
+	}
+
+	// Only the stale copy goes, and objects without an id are kept.
+	c.NoError(b.remove(b.expiringPos))
+	c.JSONEq(`[{"id":"1","properties":{"p":"kept"}},{"key":"2"}]`, string(b.Objects))
+	c.Equal(2, b.count)
+	c.Equal([]string{"1", "2"}, b.ids)
+	c.Empty(b.expiring)
+	c.Equal([]int{1}, b.expiresPos)
+}
+
+func (c *ClientTestSuite) TestChannelBuffers() {
+	client := New("writeKey")
+	client.ChannelBuffer = 10
+	client.ChannelBuffers = map[string]int{"big": 1000}
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "small", Properties: map[string]interface{}{"a": "b"}}))
+	c.NoError(client.Set(&Object{ID: "1", Collection: "big", Properties: map[string]interface{}{"a": "b"}}))
+
+	stats := client.BufferStats()
+	c.Equal(10, stats["small"].Capacity)
+	c.Equal(1000, stats["big"].Capacity)
+	c.NoError(client.Close())
+}
+
+func (c *ClientTestSuite) TestShards() {
+	client := New("writeKey")
+	client.ChannelBuffer = 10
+	client.MaxBatchCount = 1000
+	client.Shards = map[string]int{"hot": 4}
+
+	for i := 0; i < 100; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "hot", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Set(&Object{ID: "1", Collection: "cold", Properties: map[string]interface{}{"p": "1"}}))
+
+	b, _ := client.cmap.Get("hot")
+	c.Len(b.shards, 4)
+	c.True(b.shard("42") == b.shard("42"))
+	stats := client.BufferStats()
+	c.Equal(40, stats["hot"].Capacity)
+	c.Equal(10, stats["cold"].Capacity)
+	c.NoError(client.Close())
+
+	sizes := c.batchSizes()
+	c.Len(sizes, 5)
+	total := 0
+	for _, n := range sizes {
+		total += n
+	}
+	c.Equal(101, total)
+}
+
+func (c *ClientTestSuite) TestTraceRegions() {
+	out := &bytes.Buffer{}
+	c.NoError(trace.Start(out))
+	client := New("writeKey")
+	client.TraceRegions = true
+	c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Close())
+	trace.Stop()
+
+	c.Contains(out.String(), "objects.marshal")
+	c.Contains(out.String(), "objects.send")
+}
+
+func (c *ClientTestSuite) TestShutdownPhases() {
+	var phases []ShutdownPhase
+	client := New("writeKey")
+	client.OnShutdown = func(p ShutdownPhase) {
+		if p == ShutdownDrain {
+			c.Equal(ErrClientClosed, client.Set(&Object{ID: "3", Collection: "b", Properties: map[string]interface{}{"p": "1"}}))
+		}
+		phases = append(phases, p)
+	}
+
+	c.NoError(client.Set(&Object{ID: "1", Collection: "a", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Set(&Object{ID: "2", Collection: "b", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Close())
+
+	c.Equal([]ShutdownPhase{ShutdownIntake, ShutdownDrain, ShutdownFlush, ShutdownSenders, ShutdownDone}, phases)
+	c.Equal([]int{1, 1}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) TestHighWatermark() {
+	client := New("writeKey")
+	buf := newBuffer("c", 10)
+	client.cmap.Set("c", buf)
+
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"a": "b"}}))
+	}
+	buf.Queue.pop()
+	c.Equal(BufferStats{Capacity: 10, Length: 2, HighWatermark: 3}, client.BufferStats()["c"])
+}
+
+func (c *ClientTestSuite) TestQuotaWarn() {
+	out := &bytes.Buffer{}
+	client := New("writeKey")
+	client.Logger = log.New(out, "", 0)
+	client.Quotas = map[string]*Quota{"c": {MaxObjects: 1}}
+
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	c.Equal([]int{3}, c.batchSizes())
+	c.Equal(1, bytes.Count(out.Bytes(), []byte("Quota exceeded")))
+}
+
+func (c *ClientTestSuite) TestQuotaDrop() {
+	var drops []Drop
+	client := New("writeKey")
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	client.Quotas = map[string]*Quota{"c": {MaxBytes: 1, Mode: QuotaDrop}}
+
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "other", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Close())
+
+	c.Len(drops, 2)
+	c.Equal(DropQuota, drops[0].Reason)
+	c.Equal(ErrQuotaExceeded, drops[0].Err)
+	c.Equal([]int{1, 3}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) TestQuotaSendBatch() {
+	var drops []Drop
+	client := New("writeKey")
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	client.Quotas = map[string]*Quota{"c": {MaxObjects: 1, Mode: QuotaDrop}}
+
+	b := &Batch{Collection: "c"}
+	for i := 0; i < 3; i++ {
+		b.Objects = append(b.Objects, &Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}})
+	}
+	c.NoError(client.SendBatch(context.Background(), b))
+	c.Len(drops, 2)
+	c.Equal(DropQuota, drops[0].Reason)
+	c.Equal([]int{1}, c.batchSizes())
+
+	// Nothing is sent once every object is dropped.
+	c.NoError(client.SendBatch(context.Background(), b))
+	c.Len(drops, 5)
+	c.Equal([]int{1}, c.batchSizes())
+
+	// A blocked SendBatch returns once its context is done.
+	client.Quotas["c"].Mode = QuotaBlock
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Equal(context.DeadlineExceeded, client.SendBatch(ctx, b))
+	c.NoError(client.Close())
+}
+
+func (c *ClientTestSuite) TestQuotaBlock() {
+	client := New("writeKey")
+	client.MaxBatchCount = 1
+	client.Quotas = map[string]*Quota{"c": {MaxObjects: 1, Period: 300 * time.Millisecond, Mode: QuotaBlock}}
+
+	// Wait for the start of a period so the first objects fit in it.
+	time.Sleep(time.Until(client.Quotas["c"].next(time.Now())))
+	v := &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}
+	c.NoError(client.Set(v))
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	c.NoError(client.Set(v))
+	c.True(time.Since(start) > 100*time.Millisecond)
+
+	// Close wakes up blocked calls.
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan error)
+	go func() { done <- client.Set(v) }()
+	time.Sleep(50 * time.Millisecond)
+	c.NoError(client.Close())
+	c.Equal(ErrClientClosed, <-done)
+}
+
+func (c *ClientTestSuite) TestDuplicates() {
+	var dups []*Object
+	client := New("writeKey")
+	client.Duplicates = NewDuplicateFilter(time.Minute, 10)
+	client.Duplicates.OnDuplicate = func(v *Object) { dups = append(dups, v) }
+
+	set := func(id, p string) {
+		c.NoError(client.Set(&Object{ID: id, Collection: "c", Properties: map[string]interface{}{"p": p}}))
+	}
+	set("1", "a")
+	c.NoError(client.Flush(context.Background()))
+	set("1", "a")
+	set("1", "b")
+	set("2", "b")
+	c.NoError(client.Close())
+
+	c.Len(dups, 1)
+	c.Equal("1", dups[0].ID)
+	c.Equal([]int{1, 3}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) TestDuplicateFilterExpiry() {
+	f := NewDuplicateFilter(time.Minute, 0)
+	e := f.entry(&Object{ID: "1", Collection: "c"}, []byte(`{}`))
+	now := time.Now()
+
+	// Only delivered objects are recorded.
+	c.False(f.seen(e, now))
+	f.record([]duplicateEntry{e}, now)
+	c.True(f.seen(e, now.Add(30*time.Second)))
+
+	// Entries expire after the window even without MaxEntries.
+	c.False(f.seen(e, now.Add(time.Minute)))
+	c.Equal(0, f.lru.Len())
+}
+
+func (c *ClientTestSuite) TestDuplicatesSuppressed() {
+	var drops []Drop
+	client := New("writeKey")
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	client.Duplicates = NewDuplicateFilter(time.Minute, 1)
+	client.Duplicates.Suppress = true
+
+	set := func(id string) {
+		c.NoError(client.Set(&Object{ID: id, Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	set("1")
+	c.NoError(client.Flush(context.Background()))
+	set("1")
+	set("2")
+	c.NoError(client.Flush(context.Background()))
+	// 1 was evicted by 2.
+	set("1")
+	c.NoError(client.Close())
+
+	c.Len(drops, 1)
+	c.Equal(DropDuplicate, drops[0].Reason)
+	c.Equal(ErrDuplicate, drops[0].Err)
+	c.Equal([]int{1, 1, 1}, c.batchSizes())
+}
+
+func (c *ClientTestSuite) TestSimpleClient() {
+	before := runtime.NumGoroutine()
+	simple := NewSimpleClient("writeKey")
+	simple.Client.MaxBatchCount = 2
+
+	var objs []*Object
+	for i := 0; i < 5; i++ {
+		objs = append(objs, &Object{ID: strconv.Itoa(i), Collection: "a", Properties: map[string]interface{}{"p": "1"}})
+	}
+	objs = append(objs, &Object{ID: "1", Collection: "b", Properties: map[string]interface{}{"p": "1"}})
+	c.NoError(simple.Send(context.Background(), objs))
+	c.Equal([]int{1, 1, 2, 2}, c.batchSizes())
+	c.True(runtime.NumGoroutine() <= before)
+
+	// Invalid objects fail the whole call.
+	objs = append(objs, &Object{ID: "2", Collection: "b"})
+	c.Error(simple.Send(context.Background(), objs))
+	c.Len(c.httpRequests, 4)
+}
+
+func (c *ClientTestSuite) TestFlush() {
+	client := New("writeKey")
+	for i := 0; i < 3; i++ {
+		c.NoError(client.Set(&Object{ID: strconv.Itoa(i), Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	c.NoError(client.Flush(context.Background()))
+	c.Equal([]int{3}, c.batchSizes())
+
+	c.NoError(client.Close())
+	c.Equal(ErrClientClosed, client.Flush(context.Background()))
+}
+
+func (c *ClientTestSuite) TestFlushTimeout() {
+	client := New("writeKey")
+
+	// A delivery still in flight when ctx is done: Flush returns, releasing
+	// the slots it took.
+	client.semaphore.Acquire()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Equal(context.Canceled, client.Flush(ctx))
+	c.Equal(1, len(client.semaphore))
+
+	client.semaphore.Release()
+	c.NoError(client.Close())
+}
+
+func (c *ClientTestSuite) TestSpill() {
+	dir, err := ioutil.TempDir("", "objects")
+	c.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var deliveries []Delivery
+	client := New("writeKey")
+	client.SpillThreshold = 1000
+	client.SpillDir = dir
+	client.OnDeliver = func(d Delivery) {
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries = append(deliveries, d)
+	}
+
+	big := strings.Repeat("x", 2000)
+	c.NoError(client.Set(&Object{ID: "big", Collection: "c", Properties: map[string]interface{}{"Blob": big}}))
+	c.NoError(client.Set(&Object{ID: "small", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	c.NoError(client.Close())
+
+	c.Equal([]int{1, 1}, c.batchSizes())
+	c.httpRequestsMutex.Lock()
+	defer c.httpRequestsMutex.Unlock()
+	var spilled []*Object
+	for _, r := range c.httpRequests {
+		received := []*Object{}
+		c.NoError(json.Unmarshal(r.Objects, &received))
+		if received[0].ID == "big" {
+			spilled = received
+		}
+	}
+	c.Len(spilled, 1)
+	c.Equal(big, spilled[0].Properties["blob"])
+
+	files, err := ioutil.ReadDir(dir)
+	c.NoError(err)
+	c.Empty(files)
+	c.Len(deliveries, 2)
+}
+
+func (c *ClientTestSuite) TestSpillDeadline() {
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+	dir, err := ioutil.TempDir("", "objects")
+	c.NoError(err)
+	defer os.RemoveAll(dir)
+
+	var drops []Drop
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.RetryNetworkErrorsOnly = true
+	client.SpillThreshold = 1000
+	client.SpillDir = dir
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+
+	v := &Object{ID: "big", Collection: "c", Deadline: time.Now().Add(time.Hour), Properties: map[string]interface{}{"blob": strings.Repeat("x", 2000)}}
+	c.NoError(client.Set(v))
+	c.NoError(client.Close())
+
+	c.Len(drops, 1)
+	c.True(drops[0].Object == v)
+	c.Equal(DropDeadline, drops[0].Reason)
+}
+
+func (c *ClientTestSuite) TestEncodeSpilled() {
+	client := New("writeKey")
+	v := &Object{ID: "big", Collection: "c", Properties: map[string]interface{}{
+		"Blob":  strings.Repeat("é\"<", 3*spillChunk),
+		"Bytes": []byte("bytes"),
+		"Raw":   json.RawMessage(`{"a": 1}`),
+		"User":  map[string]interface{}{"FirstName": "a"},
+		"n":     1,
+	}}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	c.NoError(client.encodeSpilled(w, v))
+	c.NoError(w.Flush())
+	marshaled, err := client.marshal(v)
+	c.NoError(err)
+	c.JSONEq(string(marshaled), buf.String())
+}
@@ -5,7 +5,6 @@ package objects
 
 import (
 	"encoding/json"
-	"hash/fnv"
 	"sync"
 )
 
@@ -30,9 +29,18 @@ func newConcurrentMap() concurrentMap {
 
 // Returns shard under given key
 func (m concurrentMap) GetShard(key string) *concurrentMapShared {
-	hasher := fnv.New32()
-	hasher.Write([]byte(key))
-	return m[int(hasher.Sum32())%shardCount]
+	return m[uint(fnv32(key))%uint(shardCount)]
+}
+
+// FNV-1 hash of key, computed inline to avoid allocating a hasher.
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	const prime32 = uint32(16777619)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
 }
 
 // Sets the given value under the specified key.
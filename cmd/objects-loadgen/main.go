@@ -0,0 +1,251 @@
+// Command objects-loadgen sends synthetic objects at a steady rate and
+// reports throughput, delivery latency and drops, to size a client before
+// rolling it out. Without -endpoint, objects are sent to an in-process fake
+// Objects API whose latency and error rate are set with -fake-latency and
+// -fake-errors.
+//
+//	objects-loadgen -rate 50000 -size 512 -collections 4 -duration 5m
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/objects-go"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", "", "Objects API endpoint, empty for an in-process fake")
+	writeKey := flag.String("write-key", os.Getenv("SEGMENT_WRITE_KEY"), "write key sent to -endpoint")
+	rate := flag.Int("rate", 1000, "objects generated per second")
+	size := flag.Int("size", 256, "approximate size of every object's properties, in bytes")
+	collections := flag.Int("collections", 1, "number of collections objects are spread over")
+	ids := flag.Int("ids", 100000, "number of distinct object IDs per collection")
+	producers := flag.Int("producers", 4, "goroutines calling Set")
+	duration := flag.Duration("duration", time.Minute, "how long to generate objects, 0 until interrupted")
+	interval := flag.Duration("report", 10*time.Second, "interval between reports")
+	batchCount := flag.Int("batch-count", 100, "MaxBatchCount")
+	batchInterval := flag.Duration("batch-interval", 10*time.Second, "MaxBatchInterval")
+	fakeLatency := flag.Duration("fake-latency", 20*time.Millisecond, "latency of the fake endpoint")
+	fakeErrors := flag.Float64("fake-errors", 0, "ratio of requests the fake endpoint fails, 0 to 1")
+	flag.Parse()
+
+	if *endpoint == "" {
+		srv := httptest.NewServer(fake(*fakeLatency, *fakeErrors))
+		defer srv.Close()
+		*endpoint = srv.URL
+		if *writeKey == "" {
+			*writeKey = "loadgen"
+		}
+	}
+
+	r := &recorder{}
+	client := objects.New(*writeKey)
+	client.BaseEndpoint = *endpoint
+	client.MaxBatchCount = *batchCount
+	client.MaxBatchInterval = *batchInterval
+	client.Logger = log.New(ioutil.Discard, "", 0)
+	client.OnDeliver = r.delivered
+	if err := client.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	g := &generator{
+		client:      client,
+		recorder:    r,
+		rate:        *rate,
+		size:        *size,
+		collections: *collections,
+		ids:         *ids,
+	}
+	done := make(chan struct{})
+	go func() {
+		g.run(ctx, *producers)
+		close(done)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.report(os.Stdout, client, time.Since(start))
+			continue
+		case <-done:
+		}
+		break
+	}
+
+	if err := client.Close(); err != nil {
+		log.Printf("[Error] %v", err)
+	}
+	fmt.Fprintln(os.Stdout, "final:")
+	r.report(os.Stdout, client, time.Since(start))
+}
+
+// generator calls Set at a steady rate, spread over producers.
+type generator struct {
+	client      *objects.Client
+	recorder    *recorder
+	rate        int
+	size        int
+	collections int
+	ids         int
+}
+
+func (g *generator) run(ctx context.Context, producers int) {
+	wg := sync.WaitGroup{}
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			g.produce(ctx, p, float64(g.rate)/float64(producers))
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (g *generator) produce(ctx context.Context, p int, rate float64) {
+	rnd := rand.New(rand.NewSource(int64(p)))
+	value := make([]byte, g.size)
+	for i := range value {
+		value[i] = 'a' + byte(rnd.Intn(26))
+	}
+
+	// Objects are generated in small bursts so high rates don't depend on
+	// the timer resolution.
+	const tick = 10 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	owed := 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for owed += rate * tick.Seconds(); owed >= 1; owed-- {
+			v := &objects.Object{
+				ID:         strconv.Itoa(rnd.Intn(g.ids)),
+				Collection: "loadgen_" + strconv.Itoa(rnd.Intn(g.collections)),
+				Properties: map[string]interface{}{
+					"value":      string(value),
+					"producer":   p,
+					"created_at": time.Now(),
+				},
+			}
+			start := time.Now()
+			if err := g.client.Set(v); err != nil {
+				g.recorder.failed(err)
+				return
+			}
+			g.recorder.set(time.Since(start))
+		}
+	}
+}
+
+// recorder aggregates Set and delivery latencies.
+type recorder struct {
+	sync.Mutex
+	sets       []time.Duration
+	deliveries []time.Duration
+	batches    int
+	failures   int
+	bytes      int
+	setErr     error
+}
+
+func (r *recorder) set(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.sets = append(r.sets, d)
+}
+
+func (r *recorder) failed(err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.setErr = err
+}
+
+func (r *recorder) delivered(d objects.Delivery) {
+	r.Lock()
+	defer r.Unlock()
+	r.batches++
+	r.bytes += d.Bytes
+	if d.Err != nil {
+		r.failures++
+		return
+	}
+	r.deliveries = append(r.deliveries, d.Duration)
+}
+
+// report prints the stats since the previous report, and the client's
+// counters since it started.
+func (r *recorder) report(w io.Writer, client *objects.Client, elapsed time.Duration) {
+	r.Lock()
+	sets, deliveries := r.sets, r.deliveries
+	batches, failures, bytes, setErr := r.batches, r.failures, r.bytes, r.setErr
+	r.sets, r.deliveries = nil, nil
+	r.batches, r.failures, r.bytes = 0, 0, 0
+	r.Unlock()
+
+	s := client.Stats()
+	fmt.Fprintf(w, "%v: queued=%d sent=%d failed=%d dropped=%d inflight=%d\n",
+		elapsed.Truncate(time.Second), s.Queued, s.Sent, s.Failed, s.Dropped, s.Inflight)
+	fmt.Fprintf(w, "  set: n=%d p50=%v p99=%v max=%v\n",
+		len(sets), percentile(sets, 0.5), percentile(sets, 0.99), percentile(sets, 1))
+	fmt.Fprintf(w, "  delivery: batches=%d failures=%d bytes=%d p50=%v p99=%v max=%v\n",
+		batches, failures, bytes, percentile(deliveries, 0.5), percentile(deliveries, 0.99), percentile(deliveries, 1))
+	for name, b := range client.BufferStats() {
+		fmt.Fprintf(w, "  %s: queue=%d/%d high=%d\n", name, b.Length, b.Capacity, b.HighWatermark)
+	}
+	if setErr != nil {
+		fmt.Fprintf(w, "  set failed: %v\n", setErr)
+	}
+}
+
+func percentile(d []time.Duration, p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	i := int(p * float64(len(d)-1))
+	return d[i]
+}
+
+// fake is an Objects API accepting every request after latency, except a
+// ratio of errors failing with a 500.
+func fake(latency time.Duration, errors float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		time.Sleep(latency)
+		if rand.Float64() < errors {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,102 @@
+// Command objectsd is a local daemon accepting objects from the
+// applications of a host, over HTTP or a unix socket, and owning their
+// batching, spooling and delivery to Segment, see package daemon.
+//
+//	SEGMENT_WRITE_KEY=... objectsd -socket /var/run/objectsd.sock -spool /var/lib/objectsd
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/daemon"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:4040", "TCP address to listen on, empty to disable")
+	socket := flag.String("socket", "", "unix socket to listen on")
+	endpoint := flag.String("endpoint", objects.DefaultBaseEndpoint, "Objects API endpoint")
+	spool := flag.String("spool", "", "directory spooling failed batches, replayed every -replay")
+	replay := flag.Duration("replay", time.Minute, "interval between replays of the spool")
+	flag.Parse()
+
+	client := objects.New(os.Getenv("SEGMENT_WRITE_KEY"))
+	client.BaseEndpoint = *endpoint
+	if *spool != "" {
+		s, err := objects.OpenSpool(*spool)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		client.Spool = s
+	}
+	if err := client.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: daemon.New(client)}
+	var listeners []net.Listener
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if *socket != "" {
+		os.Remove(*socket)
+		l, err := net.Listen("unix", *socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		log.Fatal("Nothing to listen on: set -listen or -socket")
+	}
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}(l)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if client.Spool != nil {
+		go replayLoop(ctx, client, *replay)
+	}
+	<-ctx.Done()
+
+	// Stop accepting objects before delivering the buffered ones.
+	shutdown, cancel := context.WithTimeout(context.Background(), client.ShutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(shutdown); err != nil {
+		log.Printf("[Error] %v", err)
+	}
+	<-client.Drain()
+}
+
+func replayLoop(ctx context.Context, client *objects.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := client.Replay(ctx); err != nil {
+			log.Printf("[Error] Replay failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MultiPath is the path multi-collection requests are sent to when
+// Client.Coalesce is enabled.
+const MultiPath = "/v1/multi"
+
+// coalesceDelay is the longest a small batch waits for others to be
+// coalesced with.
+const coalesceDelay = time.Second
+
+type coalescer struct {
+	sync.Mutex
+	parts []*batch
+	size  int
+}
+
+type multiRequest struct {
+	WriteKey string       `json:"write_key"`
+	Batches  []multiBatch `json:"batches"`
+}
+
+type multiBatch struct {
+	Collection string          `json:"collection"`
+	Objects    json.RawMessage `json:"objects"`
+}
+
+// coalesce queues a small batch to be sent with others in a single
+// multi-collection request, once they add up to MaxBatchBytes or after
+// coalesceDelay.
+func (c *Client) coalesce(request *batch) {
+	c.coalescer.Lock()
+	c.coalescer.parts = append(c.coalescer.parts, request)
+	c.coalescer.size += len(request.Objects)
+	first := len(c.coalescer.parts) == 1
+	full := c.coalescer.size >= c.MaxBatchBytes
+	c.coalescer.Unlock()
+
+	switch {
+	case full:
+		c.scheduler.cancel("coalesce")
+		c.flushCoalesced()
+	case first:
+		c.scheduler.schedule("coalesce", time.Now().Add(coalesceDelay), c.flushCoalesced)
+	}
+}
+
+// flushCoalesced sends the queued batches in one request. Batches of a failed
+// request are spooled individually.
+func (c *Client) flushCoalesced() {
+	c.coalescer.Lock()
+	parts := c.coalescer.parts
+	c.coalescer.parts = nil
+	c.coalescer.size = 0
+	c.coalescer.Unlock()
+
+	if len(parts) == 0 {
+		return
+	}
+	if c.spoolingOnClose() {
+		for _, request := range parts {
+			if !c.spool(request) {
+				c.dropBatch(request, DropClosed, ErrClientClosed)
+			}
+			putBytes(request.Objects)
+		}
+		return
+	}
+
+	c.semaphore.Run(func() {
+		st := &retryState{}
+		if err := c.deliverMulti(withRetryState(c.ctx, st), parts); err != nil {
+			c.Logger.Printf("[Error] %v", err)
+			for _, request := range parts {
+				request.attempts, request.retryAt = st.attempts, st.retryAt
+				if st.rejected() {
+					// Rejected batches would be rejected again.
+					c.dropBatch(request, DropRejected, err)
+				} else if !c.spool(request) {
+					c.dropBatch(request, DropRetriesExhausted, err)
+				}
+			}
+		}
+		for _, request := range parts {
+			putBytes(request.Objects)
+		}
+	})
+}
+
+// deliverMulti sends parts in one request to MultiPath. Each part is then
+// accounted for like a batch delivered on its own.
+func (c *Client) deliverMulti(ctx context.Context, parts []*batch) error {
+	start := time.Now()
+	v := &multiRequest{WriteKey: c.writeKey}
+	for _, request := range parts {
+		c.begin(request)
+		v.Batches = append(v.Batches, multiBatch{Collection: request.Collection, Objects: request.Objects})
+	}
+
+	header := http.Header{}
+	header.Set(RequestIDHeader, newRequestID())
+	payload, err := json.Marshal(v)
+	if err != nil {
+		err = c.redactError(fmt.Errorf("Request failed to marshal: %v", err))
+	} else {
+		err = c.post(ctx, MultiPath, payload, header)
+	}
+
+	for _, request := range parts {
+		c.end(request)
+		c.complete(ctx, request, start, header, err)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package objects
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+type collectionsResponse struct {
+	Collections []string `json:"collections"`
+}
+
+// Collections returns the names of the collections known for the write key.
+func (c *Client) Collections(ctx context.Context) ([]string, error) {
+	resp := &collectionsResponse{}
+	if err := c.call(ctx, "GET", "/v1/collections", url.Values{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Collections, nil
+}
+
+// Ping checks that BaseEndpoint is reachable and accepts the write key, with
+// a single request and no retries, so misconfigurations are caught at
+// startup instead of once batches fail. It also opens a connection reused by
+// the first deliveries. Any HTTP response counts as reachable, even for an
+// API without the collections endpoint; Ping returns ErrUnauthorized if the
+// write key is rejected.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.request(ctx, "GET", "/v1/collections", url.Values{})
+	if err != nil {
+		return c.redactError(err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// DeleteCollection removes the collection and all of its objects.
+// It returns ErrNotSupported if the API does not allow deleting collections.
+func (c *Client) DeleteCollection(ctx context.Context, name string) error {
+	return c.call(ctx, "DELETE", "/v1/collections", url.Values{"collection": {name}}, nil)
+}
@@ -0,0 +1,107 @@
+package objects
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+var (
+	ErrColumnsID = errors.New("Property `id` collides with the ID column")
+)
+
+// Columns is a batch of objects sharing the same properties, encoded as a
+// schema and rows rather than repeating every property name in every object.
+// The first column is the object ID, followed by the property names in
+// order.
+type Columns struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// ColumnarSink is implemented by sinks accepting Columns, e.g. to write
+// compact files. Batches whose objects all share the same properties, other
+// than `id`, are passed to SendColumns; others to Send.
+type ColumnarSink interface {
+	Sink
+	SendColumns(ctx context.Context, collection string, columns *Columns) error
+}
+
+// NewColumns converts objects, a JSON array as passed to sinks, to Columns.
+// It returns nil if the objects do not all have the same properties, and
+// ErrColumnsID if they have an `id` property.
+func NewColumns(objects json.RawMessage) (*Columns, error) {
+	var values []struct {
+		ID         string                 `json:"id"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(objects))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(values[0].Properties))
+	for k := range values[0].Properties {
+		if k == "id" {
+			return nil, ErrColumnsID
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	cols := &Columns{
+		Columns: append([]string{"id"}, names...),
+		Rows:    make([][]interface{}, 0, len(values)),
+	}
+	for _, v := range values {
+		if len(v.Properties) != len(names) {
+			return nil, nil
+		}
+		row := make([]interface{}, 0, len(cols.Columns))
+		row = append(row, v.ID)
+		for _, k := range names {
+			p, ok := v.Properties[k]
+			if !ok {
+				return nil, nil
+			}
+			row = append(row, p)
+		}
+		cols.Rows = append(cols.Rows, row)
+	}
+	return cols, nil
+}
+
+// sendSinks passes request to every sink, as Columns to the ColumnarSinks
+// when possible. Sink errors are logged.
+func (c *Client) sendSinks(ctx context.Context, request *batch) {
+	var cols *Columns
+	converted := false
+
+	for _, s := range c.Sinks {
+		var err error
+		if cs, ok := s.(ColumnarSink); ok {
+			if !converted {
+				converted = true
+				if cols, err = NewColumns(request.Objects); err != nil {
+					c.Logger.Printf("[Warn] Batch for collection `%s` not converted to columns: %v", request.Collection, err)
+				}
+			}
+			if cols != nil {
+				err = cs.SendColumns(ctx, request.Collection, cols)
+			} else {
+				err = s.Send(ctx, request.Collection, request.Objects)
+			}
+		} else {
+			err = s.Send(ctx, request.Collection, request.Objects)
+		}
+		if err != nil {
+			c.Logger.Printf("[Error] Sink failed for collection `%s`: %v", request.Collection, err)
+		}
+	}
+}
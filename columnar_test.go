@@ -0,0 +1,47 @@
+package objects
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestColumnar(t *testing.T) {
+	suite.Run(t, &ColumnarTestSuite{})
+}
+
+type ColumnarTestSuite struct {
+	suite.Suite
+}
+
+func (s *ColumnarTestSuite) TestNewColumns() {
+	cols, err := NewColumns(json.RawMessage(`[{"id":"1","properties":{"b":true,"a":1.5}},{"id":"2","properties":{"a":2,"b":false}}]`))
+	s.NoError(err)
+	s.Equal(&Columns{
+		Columns: []string{"id", "a", "b"},
+		Rows: [][]interface{}{
+			{"1", json.Number("1.5"), true},
+			{"2", json.Number("2"), false},
+		},
+	}, cols)
+}
+
+func (s *ColumnarTestSuite) TestMixedSchemas() {
+	cols, err := NewColumns(json.RawMessage(`[{"id":"1","properties":{"a":1}},{"id":"2","properties":{"b":2}}]`))
+	s.NoError(err)
+	s.Nil(cols)
+
+	cols, err = NewColumns(json.RawMessage(`[{"id":"1","properties":{"a":1}},{"id":"2","properties":{"a":1,"b":2}}]`))
+	s.NoError(err)
+	s.Nil(cols)
+
+	_, err = NewColumns(json.RawMessage(`[`))
+	s.Error(err)
+}
+
+func (s *ColumnarTestSuite) TestIDProperty() {
+	cols, err := NewColumns(json.RawMessage(`[{"id":"1","properties":{"a":1,"id":"x"}}]`))
+	s.Equal(ErrColumnsID, err)
+	s.Nil(cols)
+}
@@ -0,0 +1,32 @@
+package objects
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+)
+
+// compress gzips payload at level.
+func compress(payload []byte, level int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(payload)/4))
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cloneHeader returns a copy of header that can be modified, even if header
+// is nil.
+func cloneHeader(header http.Header) http.Header {
+	if header == nil {
+		return http.Header{}
+	}
+	return header.Clone()
+}
@@ -0,0 +1,138 @@
+package objects
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MinBatchBytes is the smallest MaxBatchBytes accepted by Validate. Smaller
+// batches would mostly hold a single object and waste requests.
+const MinBatchBytes = 1 << 10
+
+// ConfigError lists every problem found by Validate, each with a suggested
+// fix.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "Invalid client configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks the client's configuration, returning a *ConfigError
+// listing every problem found, or nil. Call it after configuring the client
+// and before the first Set, e.g. at startup:
+//
+//	client := objects.New(os.Getenv("SEGMENT_WRITE_KEY"))
+//	client.MaxBatchCount = 500
+//	if err := client.Validate(); err != nil {
+//		log.Fatal(err)
+//	}
+func (c *Client) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(strings.TrimSpace(c.writeKey) != "",
+		"Write key is empty: pass the source's write key to New")
+
+	u, err := url.Parse(c.BaseEndpoint)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"BaseEndpoint %q is not an absolute URL: use e.g. %q", c.BaseEndpoint, DefaultBaseEndpoint)
+	check(!strings.HasSuffix(c.BaseEndpoint, "/"),
+		"BaseEndpoint %q ends with a slash: remove it", c.BaseEndpoint)
+	for _, endpoint := range c.Endpoints {
+		u, err := url.Parse(endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && !strings.HasSuffix(endpoint, "/"),
+			"Endpoints entry %q is not an absolute URL without a trailing slash: use e.g. %q", endpoint, DefaultBaseEndpoint)
+	}
+	check(c.ProbeInterval >= 0,
+		"ProbeInterval is %v: set it to a positive duration, or 0 for the default of %v", c.ProbeInterval, DefaultProbeInterval)
+
+	check(c.Logger != nil, "Logger is nil: set it to a *log.Logger, e.g. writing to ioutil.Discard")
+	check(c.Client != nil, "Client is nil: set it to an *http.Client, e.g. http.DefaultClient")
+
+	check(c.MaxBatchBytes >= MinBatchBytes,
+		"MaxBatchBytes is %d: set it to at least %d, the default is %d", c.MaxBatchBytes, MinBatchBytes, 500<<10)
+	check(c.MaxBatchCount >= 1,
+		"MaxBatchCount is %d: set it to at least 1, the default is 100", c.MaxBatchCount)
+	check(c.MaxBatchInterval >= 0,
+		"MaxBatchInterval is %v: set it to a positive duration, or 0 to flush immediately, the default is 10s", c.MaxBatchInterval)
+
+	check(c.ChannelBuffer >= 0,
+		"ChannelBuffer is %d: set it to 0 or more, the default is 100", c.ChannelBuffer)
+	for name, n := range c.ChannelBuffers {
+		check(n >= 0, "ChannelBuffers[%q] is %d: set it to 0 or more", name, n)
+	}
+	for name, n := range c.Shards {
+		check(n >= 0, "Shards[%q] is %d: set it to 0 or more, 0 and 1 disable sharding", name, n)
+	}
+
+	check(c.DeletedField != "" && c.DeletedAtField != "",
+		"DeletedField or DeletedAtField is empty: name the properties set by SetDeleted, the defaults are \"deleted\" and \"deleted_at\"")
+
+	check(!c.SpoolOnClose || c.Spool != nil,
+		"SpoolOnClose is set without a Spool: open one with OpenSpool")
+
+	check(c.SpillThreshold >= 0,
+		"SpillThreshold is %d: set it to 0 or more, 0 disables spilling", c.SpillThreshold)
+
+	check(c.KeySeparator != "",
+		"KeySeparator is empty: set the separator of composite keys, the default is \":\"")
+
+	if c.Duplicates != nil {
+		check(c.Duplicates.Window > 0 && c.Duplicates.MaxEntries >= 0,
+			"Duplicates is invalid: set Window to a positive duration and MaxEntries to 0 or more")
+	}
+
+	for name, q := range c.Quotas {
+		check(q != nil && q.MaxObjects >= 0 && q.MaxBytes >= 0 && q.Period >= 0,
+			"Quotas[%q] is invalid: set MaxObjects, MaxBytes and Period to 0 or more", name)
+	}
+
+	check(c.StuckAfter >= 0,
+		"StuckAfter is %v: set it to a positive duration, or 0 to disable the watchdog", c.StuckAfter)
+	check(c.StallAfter >= 0,
+		"StallAfter is %v: set it to a positive duration, or 0 to disable stall reports", c.StallAfter)
+
+	check(c.ShutdownGrace >= 0,
+		"ShutdownGrace is %v: set it to a positive duration, or 0 to wait for every delivery", c.ShutdownGrace)
+
+	switch b := c.Backoff.(type) {
+	case *ConstantBackoff:
+		check(b.Interval >= 0 && b.MaxElapsedTime >= 0,
+			"ConstantBackoff waits %v up to %v: set both to 0 or more", b.Interval, b.MaxElapsedTime)
+	case *DecorrelatedJitter:
+		check(b.Base > 0 && b.Cap >= b.Base && b.MaxElapsedTime >= 0,
+			"DecorrelatedJitter waits %v to %v up to %v: set Base above 0, Cap to at least Base and MaxElapsedTime to 0 or more", b.Base, b.Cap, b.MaxElapsedTime)
+	case *FibonacciBackoff:
+		check(b.Initial > 0 && b.Max >= b.Initial && b.MaxElapsedTime >= 0,
+			"FibonacciBackoff waits %v to %v up to %v: set Initial above 0, Max to at least Initial and MaxElapsedTime to 0 or more", b.Initial, b.Max, b.MaxElapsedTime)
+	}
+	check(c.CompressionLevel >= 0 && c.CompressionLevel <= 9,
+		"CompressionLevel is %d: set it from 1 to 9, or 0 to send requests uncompressed", c.CompressionLevel)
+	if c.Breaker != nil {
+		check(c.Breaker.Failures >= 1 && c.Breaker.Cooldown > 0 && c.Breaker.SlowResponse >= 0,
+			"Breaker opens after %d failures for %v, counting responses slower than %v: set Failures to 1 or more, Cooldown above 0 and SlowResponse to 0 or more",
+			c.Breaker.Failures, c.Breaker.Cooldown, c.Breaker.SlowResponse)
+	}
+	if c.RetryBudget != nil {
+		check(c.RetryBudget.Ratio >= 0 && c.RetryBudget.Burst >= 0,
+			"RetryBudget allows %v retries per request up to %d: set both to 0 or more", c.RetryBudget.Ratio, c.RetryBudget.Burst)
+	}
+
+	if c.Spool != nil {
+		n := len(c.Spool.Key)
+		check(n == 0 || n == 16 || n == 24 || n == 32,
+			"Spool.Key is %d bytes: use a 16, 24 or 32 byte AES key", n)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
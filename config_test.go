@@ -0,0 +1,38 @@
+package objects
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConfig(t *testing.T) {
+	suite.Run(t, &ConfigTestSuite{})
+}
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func (c *ConfigTestSuite) TestDefaults() {
+	c.NoError(New("writeKey").Validate())
+}
+
+func (c *ConfigTestSuite) TestProblems() {
+	client := New(" ")
+	client.BaseEndpoint = "objects.segment.com/"
+	client.MaxBatchBytes = 10
+	client.MaxBatchInterval = -time.Second
+	client.ChannelBuffers = map[string]int{"c": -1}
+	client.Spool = &Spool{Key: []byte("short")}
+
+	err := client.Validate()
+	c.Require().IsType(&ConfigError{}, err)
+
+	problems := err.(*ConfigError).Problems
+	c.Len(problems, 7)
+	c.Contains(problems[0], "Write key is empty")
+	c.Contains(problems[3], "set it to at least 1024")
+	c.Contains(err.Error(), "Spool.Key is 5 bytes")
+}
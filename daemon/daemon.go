@@ -0,0 +1,135 @@
+// Package daemon implements the ingestion API served by objectsd, a local
+// daemon owning batching, spooling and delivery for the applications of a
+// host. The API mirrors the Objects API, so applications reach the daemon
+// with an objects.Client whose BaseEndpoint points to it, and never hold the
+// write key:
+//
+//	client := objects.New("unused")
+//	client.BaseEndpoint = "http://localhost"
+//	client.Client = objects.UnixSocketClient("/var/run/objectsd.sock")
+//	client.MaxBatchInterval = 0
+//
+// Objects posted to /v1/set are buffered by the daemon's client and
+// acknowledged before delivery. Deletes posted to /v1/delete are delivered
+// before being acknowledged. GET /v1/health serves the client's
+// HealthReport, with a 503 status once deliveries are failing.
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/segmentio/objects-go"
+)
+
+// MaxRequestBytes bounds the size of request bodies.
+const MaxRequestBytes = 16 << 20
+
+type Server struct {
+	Client *objects.Client
+}
+
+func New(client *objects.Client) *Server {
+	return &Server{Client: client}
+}
+
+type setRequest struct {
+	Collection string            `json:"collection"`
+	Objects    []*objects.Object `json:"objects"`
+}
+
+type deleteRequest struct {
+	Collection string   `json:"collection"`
+	IDs        []string `json:"ids"`
+}
+
+type response struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v1/set":
+		s.set(w, r)
+	case r.Method == "POST" && r.URL.Path == "/v1/delete":
+		s.delete(w, r)
+	case r.Method == "GET" && r.URL.Path == "/v1/health":
+		s.health(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) set(w http.ResponseWriter, r *http.Request) {
+	req := &setRequest{}
+	if err := decode(w, r, req); err != nil {
+		reply(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Nothing is queued if any object is invalid, so retries of the request
+	// don't queue the others twice.
+	for _, v := range req.Objects {
+		v.Collection = req.Collection
+		if err := s.Client.ValidateObject(v); err != nil {
+			reply(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	for _, v := range req.Objects {
+		if err := s.Client.Set(v); err == objects.ErrClientClosed {
+			reply(w, http.StatusServiceUnavailable, err)
+			return
+		} else if err != nil {
+			reply(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	reply(w, http.StatusOK, nil)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	req := &deleteRequest{}
+	if err := decode(w, r, req); err != nil {
+		reply(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := s.Client.DeleteBatch(r.Context(), req.Collection, req.IDs); err != nil {
+		reply(w, http.StatusBadGateway, err)
+		return
+	}
+	reply(w, http.StatusOK, nil)
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	report := s.Client.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBytes))
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func reply(w http.ResponseWriter, status int, err error) {
+	resp := &response{Success: err == nil}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
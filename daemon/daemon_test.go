@@ -0,0 +1,123 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDaemon(t *testing.T) {
+	suite.Run(t, &DaemonTestSuite{})
+}
+
+type DaemonTestSuite struct {
+	suite.Suite
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func (d *DaemonTestSuite) SetupSuite() {
+	httpmock.Activate()
+
+	record := func(op string) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			v := struct {
+				Collection string            `json:"collection"`
+				WriteKey   string            `json:"write_key"`
+				Objects    []*objects.Object `json:"objects"`
+				IDs        []string          `json:"ids"`
+			}{}
+			json.NewDecoder(req.Body).Decode(&v)
+
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			for _, o := range v.Objects {
+				d.ops = append(d.ops, op+" "+v.WriteKey+" "+v.Collection+"/"+o.ID)
+			}
+			for _, id := range v.IDs {
+				d.ops = append(d.ops, op+" "+v.WriteKey+" "+v.Collection+"/"+id)
+			}
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		}
+	}
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", record("set"))
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/delete", record("delete"))
+}
+
+func (d *DaemonTestSuite) TearDownSuite() {
+	httpmock.DeactivateAndReset()
+}
+
+func (d *DaemonTestSuite) SetupTest() {
+	d.mu.Lock()
+	d.ops = nil
+	d.mu.Unlock()
+}
+
+func (d *DaemonTestSuite) serve(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func (d *DaemonTestSuite) TestSetAndDelete() {
+	client := objects.New("daemonKey")
+	s := New(client)
+
+	w := d.serve(s, "POST", "/v1/set", `{"collection": "c", "write_key": "ignored", "objects": [{"id": "1", "properties": {"a": 1}}, {"id": "2", "properties": {"a": 2}}]}`)
+	d.Equal(200, w.Code)
+	d.JSONEq(`{"success": true}`, w.Body.String())
+
+	w = d.serve(s, "POST", "/v1/delete", `{"collection": "c", "ids": ["3"]}`)
+	d.Equal(200, w.Code)
+	d.NoError(client.Close())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Equal([]string{"delete daemonKey c/3", "set daemonKey c/1", "set daemonKey c/2"}, d.ops)
+}
+
+func (d *DaemonTestSuite) TestInvalid() {
+	client := objects.New("daemonKey")
+	s := New(client)
+
+	d.Equal(400, d.serve(s, "POST", "/v1/set", `{`).Code)
+	d.Equal(400, d.serve(s, "POST", "/v1/set", `{"collection": "c", "objects": [{"properties": {"a": 1}}]}`).Code)
+	d.Equal(404, d.serve(s, "GET", "/v1/set", ``).Code)
+
+	d.NoError(client.Close())
+	w := d.serve(s, "POST", "/v1/set", `{"collection": "c", "objects": [{"id": "1", "properties": {"a": 1}}]}`)
+	d.Equal(503, w.Code)
+	d.Contains(w.Body.String(), objects.ErrClientClosed.Error())
+}
+
+func (d *DaemonTestSuite) TestInvalidQueuesNothing() {
+	client := objects.New("daemonKey")
+	s := New(client)
+
+	w := d.serve(s, "POST", "/v1/set", `{"collection": "c", "objects": [{"id": "1", "properties": {"a": 1}}, {"properties": {"a": 2}}]}`)
+	d.Equal(400, w.Code)
+	d.NoError(client.Close())
+	d.Equal(int64(0), client.Stats().Queued)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Empty(d.ops)
+}
+
+func (d *DaemonTestSuite) TestHealth() {
+	client := objects.New("daemonKey")
+	defer client.Close()
+
+	w := d.serve(New(client), "GET", "/v1/health", ``)
+	d.Equal(200, w.Code)
+	d.Contains(w.Body.String(), `"State":"ok"`)
+}
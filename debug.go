@@ -0,0 +1,83 @@
+package objects
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redactedHeaders are never logged in debug mode.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// do sends req, which carries body, and logs the exchange when Debug is set.
+func (c *Client) do(req *http.Request, body []byte) (*http.Response, error) {
+	if !c.Debug {
+		return c.Client.Do(req)
+	}
+
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		c.Logger.Printf("[Debug] %s %s failed after %v: %v", req.Method, req.URL, time.Since(start), err)
+		c.Logger.Printf("[Debug] Request headers: %s", debugHeaders(req.Header))
+		if c.DebugBodies {
+			c.debugBody("Request", body)
+		}
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	c.Logger.Printf("[Debug] %s %s %d in %v, %d bytes sent, %d bytes received",
+		req.Method, req.URL, resp.StatusCode, time.Since(start), len(body), len(respBody))
+	c.Logger.Printf("[Debug] Request headers: %s", debugHeaders(req.Header))
+	if c.DebugBodies && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		c.debugBody("Request", body)
+		c.debugBody("Response", respBody)
+	}
+	return resp, nil
+}
+
+func (c *Client) debugBody(name string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, body, "", "  "); err != nil {
+		buf.Reset()
+		buf.Write(body)
+	}
+	c.Logger.Printf("[Debug] %s body:\n%s", name, c.redact(buf.String()))
+}
+
+// debugHeaders formats h sorted by name, redacting credentials.
+func debugHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if redactedHeaders[k] {
+			v = redacted
+		}
+		parts = append(parts, k+": "+v)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,70 @@
+package objects
+
+import (
+	"context"
+	"time"
+)
+
+type deleteRequest struct {
+	Collection string   `json:"collection"`
+	WriteKey   string   `json:"write_key"`
+	IDs        []string `json:"ids"`
+}
+
+// Delete removes a single object from the Objects store.
+func (c *Client) Delete(ctx context.Context, collection, id string) error {
+	return c.makeRequest(ctx, "/v1/delete", &deleteRequest{
+		Collection: collection,
+		WriteKey:   c.writeKey,
+		IDs:        []string{id},
+	})
+}
+
+// MaxDeleteIDs is the most IDs sent per request by DeleteBatch.
+const MaxDeleteIDs = 1000
+
+// DeleteBatch removes objects from the Objects store, sending up to
+// MaxDeleteIDs IDs, and at most MaxBatchBytes of them, per request. Requests
+// are sent in order and DeleteBatch stops at the first failure, returning
+// the number of IDs deleted until then.
+func (c *Client) DeleteBatch(ctx context.Context, collection string, ids []string) (int, error) {
+	deleted := 0
+	for len(ids) > 0 {
+		n, size := 0, 0
+		for n < len(ids) && n < MaxDeleteIDs {
+			// Quotes and comma.
+			size += len(ids[n]) + 3
+			if n > 0 && size > c.MaxBatchBytes {
+				break
+			}
+			n++
+		}
+
+		err := c.makeRequest(ctx, "/v1/delete", &deleteRequest{
+			Collection: collection,
+			WriteKey:   c.writeKey,
+			IDs:        ids[:n],
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		ids = ids[n:]
+	}
+	return deleted, nil
+}
+
+// SetDeleted marks an object as deleted without removing it, for warehouses
+// modeling deletions as soft-delete columns. It queues a tombstone setting
+// the DeletedField property to true and the DeletedAtField property to
+// deletedAt, leaving the object's other properties as they are.
+func (c *Client) SetDeleted(collection, id string, deletedAt time.Time) error {
+	return c.Set(&Object{
+		ID:         id,
+		Collection: collection,
+		Properties: map[string]interface{}{
+			c.DeletedField:   true,
+			c.DeletedAtField: deletedAt.UTC(),
+		},
+	})
+}
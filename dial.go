@@ -0,0 +1,39 @@
+package objects
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// DialFunc dials connections for an *http.Client, see DialerClient.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialerClient returns an *http.Client, to use as Client.Client, dialing
+// every connection with dial, e.g. through a SOCKS proxy.
+func DialerClient(dial DialFunc) *http.Client {
+	t := newTransport()
+	t.DialContext = dial
+	return &http.Client{Transport: t}
+}
+
+// UnixSocketClient returns an *http.Client, to use as Client.Client,
+// sending every request over the unix socket at path whatever its URL host,
+// e.g. to a local sidecar proxy holding credentials and handling egress.
+// BaseEndpoint still sets the scheme and Host header, e.g. "http://localhost".
+func UnixSocketClient(path string) *http.Client {
+	client := DialerClient(func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	})
+	client.Transport.(*http.Transport).Proxy = nil
+	return client
+}
+
+// newTransport returns a copy of http.DefaultTransport.
+func newTransport() *http.Transport {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		return t.Clone()
+	}
+	return &http.Transport{Proxy: http.ProxyFromEnvironment}
+}
@@ -0,0 +1,46 @@
+package objects
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDial(t *testing.T) {
+	suite.Run(t, &DialTestSuite{})
+}
+
+type DialTestSuite struct {
+	suite.Suite
+}
+
+func (s *DialTestSuite) TestUnixSocket() {
+	dir, err := ioutil.TempDir("", "objects")
+	s.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "proxy.sock")
+	l, err := net.Listen("unix", path)
+	s.NoError(err)
+	paths := make(chan string, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.Host + r.URL.Path
+		w.Write([]byte(`{"success": true}`))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := New("writeKey")
+	client.BaseEndpoint = "http://localhost"
+	client.Client = UnixSocketClient(path)
+	defer client.Close()
+
+	s.NoError(client.SendBatch(context.Background(), NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}})))
+	s.Equal("localhost/v1/set", <-paths)
+}
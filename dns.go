@@ -0,0 +1,208 @@
+package objects
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSCache resolves endpoint hosts itself and re-resolves them every
+// Refresh in the background, closing idle connections when addresses
+// change, so long-lived clients follow DNS changes instead of reusing
+// connections to stale records. Use it through Transport:
+//
+//	dns := objects.NewDNSCache(time.Minute)
+//	defer dns.Close()
+//	client.Client = &http.Client{Transport: dns.Transport()}
+type DNSCache struct {
+	Refresh time.Duration
+
+	// Fallback optionally lists addresses per host used when the host has
+	// never been resolved and resolution fails.
+	Fallback map[string][]string
+
+	// Resolver defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+
+	// OnError is called when a background refresh fails. The previous
+	// addresses are kept.
+	OnError func(host string, err error)
+
+	mu         sync.Mutex
+	hosts      map[string][]string
+	transports []*http.Transport
+	once       sync.Once
+	exit       chan struct{}
+	closed     bool
+}
+
+func NewDNSCache(refresh time.Duration) *DNSCache {
+	return &DNSCache{Refresh: refresh}
+}
+
+// Transport returns a copy of http.DefaultTransport dialing through the
+// cache.
+func (d *DNSCache) Transport() *http.Transport {
+	t := newTransport()
+	t.DialContext = d.DialContext
+
+	d.mu.Lock()
+	d.transports = append(d.transports, t)
+	d.mu.Unlock()
+	return t
+}
+
+// DialContext dials address after resolving its host through the cache,
+// trying every address in turn.
+func (d *DNSCache) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Close stops the background refreshes.
+func (d *DNSCache) Close() error {
+	d.once.Do(func() {})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.closed = true
+		if d.exit != nil {
+			close(d.exit)
+		}
+	}
+	return nil
+}
+
+// lookup returns the cached addresses of host, resolving it the first time.
+func (d *DNSCache) lookup(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	addrs, ok := d.hosts[host]
+	d.mu.Unlock()
+	if ok {
+		return addrs, nil
+	}
+
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		fallback, ok := d.Fallback[host]
+		if !ok {
+			return nil, err
+		}
+		addrs = fallback
+	}
+
+	d.mu.Lock()
+	if d.hosts == nil {
+		d.hosts = map[string][]string{}
+	}
+	d.hosts[host] = addrs
+	d.mu.Unlock()
+
+	d.once.Do(func() {
+		d.mu.Lock()
+		d.exit = make(chan struct{})
+		d.mu.Unlock()
+		go d.refresh(d.exit)
+	})
+	return addrs, nil
+}
+
+func (d *DNSCache) resolve(ctx context.Context, host string) ([]string, error) {
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// refresh re-resolves every cached host each Refresh until Close.
+func (d *DNSCache) refresh(exit chan struct{}) {
+	if d.Refresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(d.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-exit:
+			return
+		}
+
+		d.mu.Lock()
+		hosts := make([]string, 0, len(d.hosts))
+		for host := range d.hosts {
+			hosts = append(hosts, host)
+		}
+		d.mu.Unlock()
+
+		changed := false
+		for _, host := range hosts {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			addrs, err := d.resolve(ctx, host)
+			cancel()
+			if err != nil {
+				if d.OnError != nil {
+					d.OnError(host, err)
+				}
+				continue
+			}
+
+			d.mu.Lock()
+			if strings.Join(d.hosts[host], ",") != strings.Join(addrs, ",") {
+				d.hosts[host] = addrs
+				changed = true
+			}
+			d.mu.Unlock()
+		}
+
+		if changed {
+			d.mu.Lock()
+			transports := d.transports
+			d.mu.Unlock()
+			for _, t := range transports {
+				t.CloseIdleConnections()
+			}
+		}
+	}
+}
+
+// IsDNSError reports whether err is caused by a failed DNS resolution.
+func IsDNSError(err error) bool {
+	var dns *net.DNSError
+	return errors.As(err, &dns)
+}
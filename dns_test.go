@@ -0,0 +1,50 @@
+package objects
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDNS(t *testing.T) {
+	suite.Run(t, &DNSTestSuite{})
+}
+
+type DNSTestSuite struct {
+	suite.Suite
+}
+
+func (s *DNSTestSuite) TestFallback() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	s.NoError(err)
+
+	d := NewDNSCache(time.Hour)
+	defer d.Close()
+	d.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("unreachable")
+		},
+	}
+	d.Fallback = map[string][]string{"objects.invalid": {"127.0.0.1"}}
+	client := &http.Client{Transport: d.Transport()}
+
+	resp, err := client.Get("http://objects.invalid:" + u.Port())
+	s.NoError(err)
+	resp.Body.Close()
+	s.Equal(200, resp.StatusCode)
+
+	_, err = client.Get("http://other.invalid:" + u.Port())
+	s.Error(err)
+	s.True(IsDNSError(err))
+	s.False(IsDNSError(errors.New("other")))
+}
@@ -0,0 +1,83 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Drain starts closing c in the background, waiting up to ShutdownGrace for
+// buffered objects to be delivered, and returns a channel closed once it is
+// done. It may be called several times, and after Close.
+func (c *Client) Drain() <-chan struct{} {
+	c.drainOnce.Do(func() {
+		go func() {
+			defer close(c.drained)
+
+			ctx := context.Background()
+			if c.ShutdownGrace > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.ShutdownGrace)
+				defer cancel()
+			}
+			if err := c.CloseContext(ctx); err != nil && err != ErrClientClosed {
+				c.Logger.Printf("[Warn] Objects not delivered within %v were dropped", c.ShutdownGrace)
+			}
+		}()
+	})
+	return c.drained
+}
+
+// DrainProgress is served by DrainHandler.
+type DrainProgress struct {
+	// Drained is true once the client is closed.
+	Drained bool `json:"drained"`
+
+	// Pending is the number of objects queued and not yet delivered, failed
+	// or dropped.
+	Pending int64 `json:"pending"`
+
+	Stats
+}
+
+// DrainHandler returns an http.Handler triggering Drain, e.g. for a
+// Kubernetes preStop hook:
+//
+//	http.Handle("/objects/drain", client.DrainHandler())
+//
+//	lifecycle:
+//	  preStop:
+//	    httpGet:
+//	      path: /objects/drain
+//	      port: 8080
+//
+// Requests wait until the client is drained, or until they are canceled, and
+// are answered with a JSON DrainProgress: 200 once drained, 202 before.
+// Requests with `?wait=false` return the progress immediately.
+func (c *Client) DrainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		drained := c.Drain()
+		if req.URL.Query().Get("wait") != "false" {
+			select {
+			case <-drained:
+			case <-req.Context().Done():
+			}
+		}
+
+		progress := DrainProgress{Stats: c.Stats()}
+		progress.Pending = progress.Queued - progress.Sent - progress.Failed - progress.Dropped
+		select {
+		case <-drained:
+			progress.Drained = true
+		default:
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if progress.Drained {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(&progress)
+	})
+}
@@ -0,0 +1,45 @@
+package objects
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDrain(t *testing.T) {
+	suite.Run(t, &DrainTestSuite{})
+}
+
+type DrainTestSuite struct {
+	suite.Suite
+}
+
+func (d *DrainTestSuite) TestDrain() {
+	client := New("writeKey")
+	<-client.Drain()
+	<-client.Drain()
+	d.Equal(ErrClientClosed, client.Close())
+}
+
+func (d *DrainTestSuite) TestDrainAfterClose() {
+	client := New("writeKey")
+	d.NoError(client.Close())
+	<-client.Drain()
+}
+
+func (d *DrainTestSuite) TestDrainHandler() {
+	client := New("writeKey")
+
+	w := httptest.NewRecorder()
+	client.DrainHandler().ServeHTTP(w, httptest.NewRequest("GET", "/objects/drain", nil))
+	d.Equal(200, w.Code)
+	d.Equal("application/json", w.Header().Get("Content-Type"))
+
+	progress := DrainProgress{}
+	d.NoError(json.Unmarshal(w.Body.Bytes(), &progress))
+	d.True(progress.Drained)
+	d.Equal(int64(0), progress.Pending)
+	d.Equal(ErrClientClosed, client.Close())
+}
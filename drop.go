@@ -0,0 +1,135 @@
+package objects
+
+import (
+	"sync/atomic"
+)
+
+// DropReason identifies why an object was dropped.
+type DropReason string
+
+const (
+	// DropMarshal means the object's properties failed to encode to JSON.
+	DropMarshal DropReason = "marshal"
+
+	// DropDeadline means the object was not delivered by its Deadline.
+	DropDeadline DropReason = "deadline"
+
+	// DropQuota means the object's collection exceeded its QuotaDrop quota.
+	DropQuota DropReason = "quota"
+
+	// DropDuplicate means the object was suppressed by the DuplicateFilter.
+	DropDuplicate DropReason = "duplicate"
+
+	// DropPanic means the buffer goroutine panicked adding the object or
+	// flushing its batch, see WorkerEvent.
+	DropPanic DropReason = "panic"
+
+	// DropClosed means the client was closed before the object was sent,
+	// e.g. while SetAt held it, and it could not be spooled.
+	DropClosed DropReason = "closed"
+
+	// DropRetriesExhausted means the delivery of the object's batch failed
+	// and the batch could not be spooled.
+	DropRetriesExhausted DropReason = "retries_exhausted"
+
+	// DropRejected means the API rejected the object's batch with a 4xx
+	// status, e.g. as invalid, so it was not retried later.
+	DropRejected DropReason = "rejected"
+
+	// DropHoldFull means DeliveryWindows held more than MaxHeldBytes of
+	// batches, and the object's batch could not be spooled.
+	DropHoldFull DropReason = "hold_full"
+
+	// DropEvicted means the object's spooled batch was evicted to make room
+	// for a newer one, see EvictOldest.
+	DropEvicted DropReason = "evicted"
+)
+
+// Drop describes an object the client gave up on. Objects of dropped
+// batches only hold their ID, collection and Meta.
+type Drop struct {
+	Object *Object
+	Reason DropReason
+	Err    error
+}
+
+// drop accounts for an object that will never be delivered and hands it to
+// OnDrop, or logs it when OnDrop is nil.
+func (c *Client) drop(v *Object, reason DropReason, err error) {
+	atomic.AddInt64(&c.counters.dropped, 1)
+	c.reportDrop(v, reason, err)
+}
+
+// reportDrop hands a dropped object to OnDrop, or logs it, without counting
+// it in Stats.
+func (c *Client) reportDrop(v *Object, reason DropReason, err error) {
+	if c.Metrics != nil {
+		c.Metrics.Count("objects.dropped", 1, map[string]string{
+			"collection": v.Collection,
+			"reason":     string(reason),
+		})
+	}
+
+	if c.OnDrop != nil {
+		c.OnDrop(Drop{Object: v, Reason: reason, Err: err})
+		return
+	}
+	c.Logger.Printf("[Error] Message `%s` excluded from batch: %v", v.ID, err)
+}
+
+// dropBatch drops the objects of request not dropped already for expiring.
+// They are handed to OnDrop one by one, their IDs being tracked when it is
+// set, or logged together. Objects of spooled batches, counted as Failed
+// already, are not counted again.
+func (c *Client) dropBatch(request *batch, reason DropReason, err error) {
+	if c.OnDrop == nil || len(request.ids) == 0 {
+		n := request.count - len(request.expiring)
+		if n == 0 {
+			return
+		}
+		if !request.spooled {
+			atomic.AddInt64(&c.counters.dropped, int64(n))
+		}
+		if c.Metrics != nil {
+			c.Metrics.Count("objects.dropped", int64(n), map[string]string{
+				"collection": request.Collection,
+				"reason":     string(reason),
+			})
+		}
+		c.Logger.Printf("[Error] %d objects of collection `%s` dropped: %v", n, request.Collection, err)
+		return
+	}
+
+	expired := make(map[int]bool, len(request.expiringPos))
+	for _, i := range request.expiringPos {
+		expired[i] = true
+	}
+	for i, id := range request.ids {
+		if expired[i] {
+			continue
+		}
+		v := &Object{ID: id, Collection: request.Collection, Meta: request.meta[i]}
+		if request.spooled {
+			c.reportDrop(v, reason, err)
+		} else {
+			c.drop(v, reason, err)
+		}
+	}
+}
+
+// dropSpooled drops the objects of a spooled batch, whose IDs are read back
+// from its objects. Their Meta is not spooled.
+func (c *Client) dropSpooled(v *spooledBatch, reason DropReason, err error) {
+	ids, parseErr := v.ids()
+	if parseErr != nil {
+		c.Logger.Printf("[Error] Spooled batch `%s` of collection `%s` dropped: %v", v.ID, v.Collection, err)
+		return
+	}
+	c.dropBatch(&batch{
+		Collection: v.Collection,
+		count:      len(ids),
+		ids:        ids,
+		meta:       make([]map[string]interface{}, len(ids)),
+		spooled:    true,
+	}, reason, err)
+}
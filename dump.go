@@ -0,0 +1,136 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// dumpTimeout bounds how long DumpState waits for the buffer goroutines to
+// describe their batch, all together. Goroutines not answering in time, e.g.
+// stalled ones, are reported as unresponsive.
+const dumpTimeout = 100 * time.Millisecond
+
+type stateDump struct {
+	Time       time.Time    `json:"time"`
+	Closed     bool         `json:"closed"`
+	Senders    int          `json:"senders"`
+	MaxSenders int          `json:"max_senders"`
+	Held       int          `json:"held_batches"`
+	Coalesced  int          `json:"coalesced_batches"`
+	Buffers    []bufferDump `json:"buffers"`
+	Stats      Stats        `json:"stats"`
+	Health     HealthReport `json:"health"`
+}
+
+type bufferDump struct {
+	Collection string `json:"collection"`
+	Shard      int    `json:"shard"`
+
+	// Queued objects are waiting in the queue, Buffered ones in the batch
+	// being built and Inflight ones in batches being delivered.
+	Queued   int `json:"queued"`
+	Capacity int `json:"capacity"`
+	Buffered int `json:"buffered"`
+	Inflight int `json:"inflight"`
+
+	// BufferedBytes, Oldest and Objects describe the batch being built. They
+	// are only known when the buffer goroutine answered.
+	BufferedBytes int             `json:"buffered_bytes"`
+	Oldest        *time.Time      `json:"oldest,omitempty"`
+	Objects       json.RawMessage `json:"objects,omitempty"`
+
+	BusySince  *time.Time `json:"busy_since,omitempty"`
+	Stuck      bool       `json:"stuck"`
+	Stalled    bool       `json:"stalled"`
+	Responsive bool       `json:"responsive"`
+}
+
+// DumpState writes a JSON snapshot of the client's pipeline to w, to attach
+// to bug reports about objects not being delivered: per collection and
+// shard, the objects queued, buffered and in flight, the size and age of the
+// batch being built, and whether its goroutine is stuck or stalled, along
+// with Stats and Health. Object payloads are only included with
+// DebugBodies. It is safe to call at any time, including on a stalled client.
+func (c *Client) DumpState(w io.Writer) error {
+	d := &stateDump{
+		Time:       time.Now(),
+		Closed:     atomic.LoadInt64(&c.closed) == 1,
+		Senders:    len(c.semaphore),
+		MaxSenders: cap(c.semaphore),
+		Stats:      c.Stats(),
+		Health:     c.Health(),
+	}
+
+	c.heldMutex.Lock()
+	d.Held = len(c.held)
+	c.heldMutex.Unlock()
+	c.coalescer.Lock()
+	d.Coalesced = len(c.coalescer.parts)
+	c.coalescer.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dumpTimeout)
+	defer cancel()
+	for t := range c.cmap.Iter() {
+		for i, b := range t.Val.all() {
+			v := bufferDump{
+				Collection: t.Key,
+				Shard:      i,
+				Queued:     b.Queue.len(),
+				Capacity:   b.Queue.cap(),
+				Buffered:   int(atomic.LoadInt64(&b.buffered)),
+				Inflight:   int(atomic.LoadInt64(&b.inflight)),
+				Stuck:      atomic.LoadInt32(&b.stuck) == 1,
+				Stalled:    atomic.LoadInt32(&b.stalled) == 1,
+			}
+			if busy := atomic.LoadInt64(&b.busy); busy != 0 {
+				since := time.Unix(0, busy)
+				v.BusySince = &since
+			}
+			if !d.Closed {
+				c.describe(b, &v, ctx.Done())
+			}
+			d.Buffers = append(d.Buffers, v)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// describe asks b's goroutine to describe the batch it is building into v,
+// unless timeout is closed first.
+func (c *Client) describe(b *buffer, v *bufferDump, timeout <-chan struct{}) {
+	reply := make(chan bufferDump, 1)
+	select {
+	case b.dumps <- reply:
+	case <-timeout:
+		return
+	}
+	select {
+	case batch := <-reply:
+		v.Buffered = batch.Buffered
+		v.BufferedBytes = batch.BufferedBytes
+		v.Oldest = batch.Oldest
+		v.Objects = batch.Objects
+		v.Responsive = true
+	case <-timeout:
+	}
+}
+
+// dump describes the batch being built in b. It must be called from b's
+// goroutine.
+func (c *Client) dump(b *buffer) bufferDump {
+	v := bufferDump{Buffered: b.count(), BufferedBytes: b.size()}
+	if b.count() > 0 {
+		first := b.first
+		v.Oldest = &first
+		if c.DebugBodies {
+			v.Objects = append(append(json.RawMessage{}, b.buf...), ']')
+		}
+	}
+	return v
+}
@@ -0,0 +1,145 @@
+package objects
+
+import (
+	"container/list"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDuplicate = errors.New("Object already set within the duplicate window")
+)
+
+// DuplicateFilter detects objects set again with the same collection, ID
+// and encoded properties within Window of their delivery, which usually
+// means an upstream consumer processed the same message twice. Objects are
+// only recorded once delivered, so those whose delivery failed can be set
+// again. Recent deliveries are kept in memory, and expire after Window or,
+// the least recently delivered first, beyond MaxEntries.
+type DuplicateFilter struct {
+	Window     time.Duration
+	MaxEntries int
+
+	// Suppress drops duplicates with DropDuplicate instead of sending them.
+	Suppress bool
+
+	// OnDuplicate is called with every duplicate, which is logged when nil.
+	// It is called from the collection's buffer goroutine and should not
+	// block.
+	OnDuplicate func(*Object)
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[duplicateKey]*list.Element
+}
+
+type duplicateKey struct {
+	collection string
+	id         string
+}
+
+type duplicateEntry struct {
+	key  duplicateKey
+	hash uint64
+	at   time.Time
+}
+
+func NewDuplicateFilter(window time.Duration, maxEntries int) *DuplicateFilter {
+	return &DuplicateFilter{
+		Window:     window,
+		MaxEntries: maxEntries,
+	}
+}
+
+// entry returns the entry of v, encoded as x.
+func (f *DuplicateFilter) entry(v *Object, x []byte) duplicateEntry {
+	h := fnv.New64a()
+	h.Write(x)
+	return duplicateEntry{key: duplicateKey{v.Collection, v.ID}, hash: h.Sum64()}
+}
+
+// seen reports whether the object of e was delivered within the window.
+func (f *DuplicateFilter) seen(e duplicateEntry, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now)
+
+	el, ok := f.entries[e.key]
+	if !ok {
+		return false
+	}
+	last := el.Value.(*duplicateEntry)
+	return last.hash == e.hash && now.Sub(last.at) < f.Window
+}
+
+// record records the entries of delivered objects.
+func (f *DuplicateFilter) record(entries []duplicateEntry, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.entries == nil {
+		f.lru = list.New()
+		f.entries = map[duplicateKey]*list.Element{}
+	}
+
+	for _, e := range entries {
+		if el, ok := f.entries[e.key]; ok {
+			last := el.Value.(*duplicateEntry)
+			last.hash, last.at = e.hash, now
+			f.lru.MoveToFront(el)
+			continue
+		}
+		e.at = now
+		f.entries[e.key] = f.lru.PushFront(&e)
+	}
+	for f.lru.Len() > f.MaxEntries && f.MaxEntries > 0 {
+		f.remove(f.lru.Back())
+	}
+	f.expire(now)
+}
+
+// expire removes the entries older than the window, which are the least
+// recently recorded.
+func (f *DuplicateFilter) expire(now time.Time) {
+	if f.lru == nil {
+		return
+	}
+	for el := f.lru.Back(); el != nil && now.Sub(el.Value.(*duplicateEntry).at) >= f.Window; el = f.lru.Back() {
+		f.remove(el)
+	}
+}
+
+func (f *DuplicateFilter) remove(el *list.Element) {
+	f.lru.Remove(el)
+	delete(f.entries, el.Value.(*duplicateEntry).key)
+}
+
+// duplicate returns the DuplicateFilter entry of v, encoded as x, to record
+// once its batch is delivered, and reports whether v should be dropped as a
+// duplicate.
+func (c *Client) duplicate(v *Object, x []byte) (duplicateEntry, bool) {
+	f := c.Duplicates
+	if f == nil {
+		return duplicateEntry{}, false
+	}
+	e := f.entry(v, x)
+	if !f.seen(e, time.Now()) {
+		return e, false
+	}
+
+	if c.Metrics != nil {
+		c.Metrics.Count("objects.duplicates", 1, map[string]string{"collection": v.Collection})
+	}
+	if f.OnDuplicate != nil {
+		f.OnDuplicate(v)
+	} else {
+		c.Logger.Printf("[Warn] Object `%s` of collection `%s` set twice within %v", v.ID, v.Collection, f.Window)
+	}
+
+	if f.Suppress {
+		c.drop(v, DropDuplicate, ErrDuplicate)
+		return e, true
+	}
+	return e, false
+}
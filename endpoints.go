@@ -0,0 +1,167 @@
+package objects
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errEndpointFailed = errors.New("Endpoint failed")
+
+const (
+	// DefaultProbeInterval is how often Endpoints are probed when
+	// Client.ProbeInterval is zero.
+	DefaultProbeInterval = 30 * time.Second
+
+	// endpointHysteresis is how much faster than the current endpoint
+	// another one must be to be preferred, so close endpoints don't flap.
+	endpointHysteresis = 0.2
+
+	// endpointDecay is the weight of a new sample in the moving averages.
+	endpointDecay = 0.3
+)
+
+// endpointStats are moving averages of an endpoint's request latency and
+// failure rate, from 0 to 1.
+type endpointStats struct {
+	samples  int
+	latency  float64
+	failures float64
+}
+
+func (s *endpointStats) healthy() bool {
+	return s.failures < 0.5
+}
+
+type endpoints struct {
+	sync.Mutex
+	current string
+	stats   map[string]*endpointStats
+	once    sync.Once
+}
+
+// endpoint returns the endpoint requests are sent to.
+func (c *Client) endpoint() string {
+	if len(c.Endpoints) == 0 {
+		return c.BaseEndpoint
+	}
+
+	c.endpoints.once.Do(func() {
+		c.scheduler.schedule("probe", time.Now(), c.startProbe)
+	})
+
+	c.endpoints.Lock()
+	defer c.endpoints.Unlock()
+	if c.endpoints.current == "" {
+		c.endpoints.current = c.Endpoints[0]
+	}
+	return c.endpoints.current
+}
+
+// observeEndpoint records a request to endpoint that took d, then selects
+// the fastest healthy endpoint.
+func (c *Client) observeEndpoint(endpoint string, d time.Duration, failed bool) {
+	if len(c.Endpoints) == 0 {
+		return
+	}
+
+	e := &c.endpoints
+	e.Lock()
+	if e.stats == nil {
+		e.stats = map[string]*endpointStats{}
+	}
+	s, ok := e.stats[endpoint]
+	if !ok {
+		s = &endpointStats{}
+		e.stats[endpoint] = s
+	}
+	failure := 0.0
+	if failed {
+		failure = 1
+	}
+	if s.samples == 0 {
+		s.latency, s.failures = float64(d), failure
+	} else {
+		s.latency += endpointDecay * (float64(d) - s.latency)
+		s.failures += endpointDecay * (failure - s.failures)
+	}
+	s.samples++
+
+	if e.current == "" {
+		e.current = c.Endpoints[0]
+	}
+	previous := e.current
+	best, bestStats := e.current, e.stats[e.current]
+	for _, name := range c.Endpoints {
+		s, ok := e.stats[name]
+		if !ok || !s.healthy() || name == best {
+			continue
+		}
+		if bestStats == nil || !bestStats.healthy() || s.latency < bestStats.latency*(1-endpointHysteresis) {
+			best, bestStats = name, s
+		}
+	}
+	e.current = best
+	e.Unlock()
+
+	if best != previous {
+		c.Logger.Printf("[Warn] Switching endpoint from %s to %s", previous, best)
+	}
+}
+
+// startProbe probes the endpoints without blocking the scheduler.
+func (c *Client) startProbe() {
+	go c.probe()
+}
+
+// probe measures every endpoint with a lightweight request, then schedules
+// the next probe.
+func (c *Client) probe() {
+	var wg sync.WaitGroup
+	for _, endpoint := range c.Endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			start := time.Now()
+			failed := c.probeEndpoint(endpoint) != nil
+			c.observeEndpoint(endpoint, time.Since(start), failed)
+		}(endpoint)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt64(&c.closed) == 1 {
+		return
+	}
+	interval := c.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	c.scheduler.schedule("probe", time.Now().Add(interval), c.startProbe)
+}
+
+// probeEndpoint sends a single request to endpoint, failing on network
+// errors and server errors only.
+func (c *Client) probeEndpoint(endpoint string) error {
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", endpoint+"/v1/collections", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.writeKey, "")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.do(req.WithContext(ctx), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return errEndpointFailed
+	}
+	return nil
+}
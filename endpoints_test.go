@@ -0,0 +1,57 @@
+package objects
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEndpoints(t *testing.T) {
+	suite.Run(t, &EndpointsTestSuite{})
+}
+
+type EndpointsTestSuite struct {
+	suite.Suite
+}
+
+func (s *EndpointsTestSuite) current(c *Client) string {
+	c.endpoints.Lock()
+	defer c.endpoints.Unlock()
+	return c.endpoints.current
+}
+
+func (s *EndpointsTestSuite) TestSelection() {
+	c := New("writeKey")
+	c.Logger = log.New(ioutil.Discard, "", 0)
+	c.Endpoints = []string{"https://a", "https://b"}
+
+	c.observeEndpoint("https://a", 100*time.Millisecond, false)
+	s.Equal("https://a", s.current(c))
+
+	// Slightly faster is not enough to switch.
+	c.observeEndpoint("https://b", 90*time.Millisecond, false)
+	s.Equal("https://a", s.current(c))
+
+	c.observeEndpoint("https://b", 50*time.Millisecond, false)
+	s.Equal("https://b", s.current(c))
+
+	// Failures make the endpoint unhealthy, however fast.
+	c.observeEndpoint("https://b", time.Millisecond, true)
+	s.Equal("https://b", s.current(c))
+	c.observeEndpoint("https://b", time.Millisecond, true)
+	s.Equal("https://a", s.current(c))
+
+	c.observeEndpoint("https://b", time.Millisecond, false)
+	c.observeEndpoint("https://b", time.Millisecond, false)
+	s.Equal("https://b", s.current(c))
+}
+
+func (s *EndpointsTestSuite) TestBaseEndpoint() {
+	c := New("writeKey")
+	s.Equal(DefaultBaseEndpoint, c.endpoint())
+	c.observeEndpoint(DefaultBaseEndpoint, time.Second, true)
+	s.Nil(c.endpoints.stats)
+}
@@ -0,0 +1,294 @@
+package objects
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// expiryExt is the extension of the expiry logs in a spool directory.
+const expiryExt = ".exp"
+
+// expiry is the scheduled Delete of an object, see Object.ExpiresAt, or,
+// with Held, its scheduled Set, see Client.SetAt.
+type expiry struct {
+	Collection string      `json:"collection"`
+	ID         string      `json:"id"`
+	At         time.Time   `json:"at"`
+	Held       *heldObject `json:"held,omitempty"`
+
+	// Done records, in an expiry log, that the Delete scheduled at At was
+	// sent.
+	Done bool `json:"done,omitempty"`
+}
+
+// heldObject is an object held by SetAt. Its properties are kept as JSON.
+type heldObject struct {
+	Key        string          `json:"key"`
+	Properties json.RawMessage `json:"properties,omitempty"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	Deadline   time.Time       `json:"deadline"`
+}
+
+// key returns the scheduler key of the Delete, shared by every expiry of the
+// object, or of the Set of a held object.
+func (e expiry) key() string {
+	if e.Held != nil {
+		return "set\x00" + e.Held.Key
+	}
+	return "expire\x00" + e.Collection + "\x00" + e.ID
+}
+
+// object returns the object held by SetAt that e schedules.
+func (e expiry) object() (*Object, error) {
+	d := json.NewDecoder(bytes.NewReader(e.Held.Properties))
+	d.UseNumber()
+	var props map[string]interface{}
+	if err := d.Decode(&props); err != nil {
+		return nil, err
+	}
+	return &Object{
+		Collection: e.Collection,
+		ID:         e.ID,
+		Properties: props,
+		ExpiresAt:  e.Held.ExpiresAt,
+		Deadline:   e.Held.Deadline,
+	}, nil
+}
+
+// expiries are the pending expiries of an expiry log, by key.
+type expiries map[string]expiry
+
+// apply records e, replacing the pending expiry of its object, or removing
+// it if e is its Done record. It reports whether the pending expiries
+// changed.
+func (m expiries) apply(e expiry) bool {
+	if !e.Done {
+		m[e.key()] = e
+		return true
+	}
+	if cur, ok := m[e.key()]; ok && cur.At.Equal(e.At) {
+		delete(m, e.key())
+		return true
+	}
+	return false
+}
+
+// expiryLog persists the expiries and objects held by SetAt pending in a
+// process to a file of its Spool, locked while the process runs, so the next
+// Replay reschedules those left when it exits. Records are appended, and the file is rewritten once
+// most of them are done.
+type expiryLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	path    string
+	pending expiries
+	records int
+	closed  bool
+}
+
+// close closes the expiry log, which releases its lock.
+func (l *expiryLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// putExpiry durably appends e to the expiry log of the process.
+func (s *Spool) putExpiry(e expiry) error {
+	l := &s.expiries
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return os.ErrClosed
+	}
+	if l.pending == nil {
+		l.pending = expiries{}
+	}
+	if !l.pending.apply(e) {
+		return nil
+	}
+	if l.records > 2*len(l.pending)+1024 {
+		return s.compactExpiries()
+	}
+
+	record, err := s.encodeExpiry(e)
+	if err != nil {
+		return err
+	}
+	if l.file == nil {
+		l.path = filepath.Join(s.Dir, s.ownerID()+expiryExt)
+		if l.file, err = createLocked(l.path); err != nil {
+			return err
+		}
+	}
+	if _, err := l.file.Write(record); err != nil {
+		return err
+	}
+	l.records++
+	return l.file.Sync()
+}
+
+// compactExpiries replaces the expiry log with the pending expiries. The new
+// log is locked before it replaces the old one.
+func (s *Spool) compactExpiries() error {
+	l := &s.expiries
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := flock(f); err != nil {
+		return fail(err)
+	}
+
+	w := bufio.NewWriter(f)
+	for _, e := range l.pending {
+		record, err := s.encodeExpiry(e)
+		if err != nil {
+			return fail(err)
+		}
+		w.Write(record)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fail(err)
+	}
+
+	l.file.Close()
+	l.file = f
+	l.records = len(l.pending)
+	return syncDir(s.Dir)
+}
+
+func (s *Spool) encodeExpiry(e expiry) ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return s.frame(data)
+}
+
+func (s *Spool) decodeExpiry(record []byte) (expiry, error) {
+	var e expiry
+	data, err := s.unframe(record)
+	if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, ErrSpoolCorrupt
+	}
+	return e, nil
+}
+
+// restoreExpiries reschedules the expiries and held objects pending in the
+// logs of processes that exited, moving them to the log of this one.
+func (c *Client) restoreExpiries() error {
+	s := c.Spool
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*"+expiryExt))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		// Logs of running processes are locked, this one's included.
+		f, err := claim(path)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			continue
+		}
+
+		records, err := s.readRecords(path)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		pending := expiries{}
+		for _, record := range records {
+			e, err := s.decodeExpiry(record)
+			if err != nil {
+				c.Logger.Printf("[Error] Expiry in %s failed to decode: %v", path, err)
+				continue
+			}
+			pending.apply(e)
+		}
+		for _, e := range pending {
+			var v *Object
+			if e.Held != nil {
+				if v, err = e.object(); err != nil {
+					c.Logger.Printf("[Error] Scheduled object `%s` in %s failed to decode: %v", e.ID, path, err)
+					continue
+				}
+			}
+			if err := s.putExpiry(e); err != nil {
+				f.Close()
+				return err
+			}
+			if v != nil {
+				c.holdObject(e, v)
+			} else {
+				c.scheduleExpiry(e)
+			}
+		}
+
+		err = os.Remove(path)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire schedules the Delete of the object of e, whose Set was delivered,
+// persisting it to Spool, if any.
+func (c *Client) expire(e expiry) {
+	if c.Spool != nil {
+		if err := c.Spool.putExpiry(e); err != nil {
+			c.Logger.Printf("[Error] Expiry of object `%s` failed to spool: %v", e.ID, err)
+		}
+	}
+	c.scheduleExpiry(e)
+}
+
+// scheduleExpiry schedules the Delete of the object of e. A later expiry of
+// the same object replaces it. The Delete is sent by a sender, like batches,
+// and recorded as done in Spool once it succeeds.
+func (c *Client) scheduleExpiry(e expiry) {
+	c.scheduler.schedule(e.key(), e.At, func() {
+		c.semaphore.Run(func() {
+			if err := c.Delete(c.ctx, e.Collection, e.ID); err != nil {
+				c.Logger.Printf("[Error] Expired object `%s` failed to delete: %v", e.ID, err)
+				return
+			}
+			if c.Spool != nil {
+				e.Done = true
+				if err := c.Spool.putExpiry(e); err != nil {
+					c.Logger.Printf("[Error] Expiry of object `%s` failed to spool: %v", e.ID, err)
+				}
+			}
+		})
+	})
+}
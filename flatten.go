@@ -0,0 +1,159 @@
+package objects
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+// ArrayStrategy controls how array property values are written to the warehouse.
+type ArrayStrategy int
+
+const (
+	// ArrayPassthrough sends arrays as-is. This is the default.
+	ArrayPassthrough ArrayStrategy = iota
+
+	// ArrayJSONString encodes arrays as a single JSON string column.
+	ArrayJSONString
+
+	// ArrayIndexFlatten flattens arrays into one column per element,
+	// e.g. `items: [{"name": "a"}]` becomes `items_0_name`.
+	ArrayIndexFlatten
+
+	// ArrayDrop removes arrays from the properties and logs a warning.
+	ArrayDrop
+)
+
+// Encoder converts a property value of a registered type into a value the
+// Objects API accepts (string, number, boolean or a map of those).
+type Encoder func(v interface{}) (interface{}, error)
+
+// RegisterEncoder registers fn to encode every property value with the same
+// type as v. Encoders must be registered before the first call to Set.
+func (c *Client) RegisterEncoder(v interface{}, fn Encoder) {
+	if c.encoders == nil {
+		c.encoders = map[reflect.Type]Encoder{}
+	}
+	c.encoders[reflect.TypeOf(v)] = fn
+}
+
+// normalize prepares m for tableize: registered encoders, json.Marshaler and
+// encoding.TextMarshaler values are converted to plain values, and the
+// client's ArrayStrategy is applied to every array, including arrays nested
+// inside maps. m is left untouched; it is returned as-is when it only holds
+// plain values, which is the common case.
+func (c *Client) normalize(id string, m map[string]interface{}) map[string]interface{} {
+	if plain(m) {
+		return m
+	}
+
+	ret := make(map[string]interface{}, len(m))
+	for key, val := range m {
+		v, err := c.encode(val)
+		if err != nil {
+			c.Logger.Printf("[Warn] Object `%s` property `%s` dropped: %v", id, key, err)
+			continue
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			ret[key] = c.normalize(id, v)
+			continue
+		case []byte, json.RawMessage:
+			ret[key] = v
+			continue
+		}
+
+		rv := reflect.ValueOf(v)
+		if !rv.IsValid() || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+			ret[key] = v
+			continue
+		}
+
+		switch c.ArrayStrategy {
+		case ArrayJSONString:
+			b, err := json.Marshal(v)
+			if err != nil {
+				c.Logger.Printf("[Warn] Object `%s` property `%s` dropped: %v", id, key, err)
+				continue
+			}
+			ret[key] = string(b)
+		case ArrayIndexFlatten:
+			elems := make(map[string]interface{}, rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				elems[strconv.Itoa(i)] = rv.Index(i).Interface()
+			}
+			ret[key] = c.normalize(id, elems)
+		case ArrayDrop:
+			c.Logger.Printf("[Warn] Object `%s` array property `%s` dropped", id, key)
+		default:
+			ret[key] = v
+		}
+	}
+	return ret
+}
+
+// plain reports whether m only holds values tableize and encoding/json
+// already handle as the Objects API expects.
+func plain(m map[string]interface{}) bool {
+	for _, val := range m {
+		switch v := val.(type) {
+		case nil, string, bool, json.Number,
+			int, int8, int16, int32, int64,
+			uint, uint8, uint16, uint32, uint64,
+			float32, float64:
+		case map[string]interface{}:
+			if !plain(v) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// encode converts v using a registered Encoder, json.Marshaler or
+// encoding.TextMarshaler, in that order. Other values are returned as-is.
+func (c *Client) encode(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	// A nil pointer is null, like with encoding/json, rather than a
+	// Marshaler called with a nil receiver.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, nil
+	}
+
+	if fn, ok := c.encoders[reflect.TypeOf(v)]; ok {
+		return fn(v)
+	}
+
+	switch m := v.(type) {
+	case json.RawMessage:
+		return v, nil
+	case json.Marshaler:
+		b, err := m.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		// Decode into plain values so objects are flattened like any other map.
+		var ret interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&ret); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	case encoding.TextMarshaler:
+		b, err := m.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+
+	return v, nil
+}
@@ -0,0 +1,190 @@
+package objects
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFlatten(t *testing.T) {
+	suite.Run(t, &FlattenTestSuite{})
+}
+
+type FlattenTestSuite struct {
+	suite.Suite
+}
+
+func (f *FlattenTestSuite) props() map[string]interface{} {
+	return map[string]interface{}{
+		"name": "cart",
+		"items": []interface{}{
+			map[string]interface{}{"name": "a", "qty": 1},
+			map[string]interface{}{"name": "b", "qty": 2},
+		},
+		"nested": map[string]interface{}{
+			"tags": []string{"x", "y"},
+		},
+	}
+}
+
+func (f *FlattenTestSuite) TestPassthrough() {
+	client := New("writeKey")
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: f.props()})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{
+		"name":"cart",
+		"items":[{"name":"a","qty":1},{"name":"b","qty":2}],
+		"nested_tags":["x","y"]
+	}}`, string(x))
+}
+
+func (f *FlattenTestSuite) TestJSONString() {
+	client := New("writeKey")
+	client.ArrayStrategy = ArrayJSONString
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: f.props()})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{
+		"name":"cart",
+		"items":"[{\"name\":\"a\",\"qty\":1},{\"name\":\"b\",\"qty\":2}]",
+		"nested_tags":"[\"x\",\"y\"]"
+	}}`, string(x))
+}
+
+func (f *FlattenTestSuite) TestIndexFlatten() {
+	client := New("writeKey")
+	client.ArrayStrategy = ArrayIndexFlatten
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: f.props()})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{
+		"name":"cart",
+		"items_0_name":"a",
+		"items_0_qty":1,
+		"items_1_name":"b",
+		"items_1_qty":2,
+		"nested_tags_0":"x",
+		"nested_tags_1":"y"
+	}}`, string(x))
+}
+
+func (f *FlattenTestSuite) TestDrop() {
+	out := &bytes.Buffer{}
+	client := New("writeKey")
+	client.Logger = log.New(out, "", 0)
+	client.ArrayStrategy = ArrayDrop
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: f.props()})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{"name":"cart"}}`, string(x))
+	f.Contains(out.String(), "`items` dropped")
+	f.Contains(out.String(), "`tags` dropped")
+}
+
+type uuid [4]byte
+
+func (u uuid) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%x", u[:])), nil
+}
+
+type money struct {
+	cents    int
+	currency string
+}
+
+func (m money) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"amount":%d,"currency":%q}`, m.cents, m.currency)), nil
+}
+
+type celsius float64
+
+func (f *FlattenTestSuite) TestMarshalers() {
+	client := New("writeKey")
+	client.ArrayStrategy = ArrayIndexFlatten
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: map[string]interface{}{
+		"uuid":  uuid{0xde, 0xad, 0xbe, 0xef},
+		"price": money{1999, "USD"},
+	}})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{
+		"uuid":"deadbeef",
+		"price_amount":1999,
+		"price_currency":"USD"
+	}}`, string(x))
+}
+
+func (f *FlattenTestSuite) TestNilMarshaler() {
+	client := New("writeKey")
+	var price *money
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: map[string]interface{}{
+		"price": price,
+	}})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{"price":null}}`, string(x))
+}
+
+func (f *FlattenTestSuite) TestRegisterEncoder() {
+	out := &bytes.Buffer{}
+	client := New("writeKey")
+	client.Logger = log.New(out, "", 0)
+	client.RegisterEncoder(celsius(0), func(v interface{}) (interface{}, error) {
+		if v.(celsius) < -273.15 {
+			return nil, errors.New("below absolute zero")
+		}
+		return fmt.Sprintf("%.1fC", v.(celsius)), nil
+	})
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: map[string]interface{}{
+		"temp":    celsius(21.5),
+		"invalid": celsius(-300),
+	}})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{"temp":"21.5C"}}`, string(x))
+	f.Contains(out.String(), "below absolute zero")
+}
+
+func (f *FlattenTestSuite) TestNested() {
+	client := New("writeKey")
+	client.Nested = map[string]bool{"c": true}
+	props := map[string]interface{}{
+		"Name":    "cart",
+		"address": map[string]interface{}{"City": "SF"},
+		"price":   money{1999, "USD"},
+	}
+
+	x, err := client.marshal(&Object{ID: "id", Collection: "c", Properties: props})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{
+		"Name":"cart",
+		"address":{"City":"SF"},
+		"price":{"amount":1999,"currency":"USD"}
+	}}`, string(x))
+
+	x, err = client.marshal(&Object{ID: "id", Collection: "other", Properties: props})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{
+		"name":"cart",
+		"address_city":"SF",
+		"price_amount":1999,
+		"price_currency":"USD"
+	}}`, string(x))
+}
+
+func (f *FlattenTestSuite) TestSerializers() {
+	client := New("writeKey")
+	client.Serializers = map[string]Serializer{
+		"raw": func(v *Object) ([]byte, error) {
+			return []byte(`{"id":"` + v.ID + `","properties":{"Raw":true}}`), nil
+		},
+		"invalid": func(v *Object) ([]byte, error) {
+			return []byte(`{"id":`), nil
+		},
+	}
+
+	x, err := client.marshal(&Object{ID: "id", Collection: "raw", Properties: f.props()})
+	f.NoError(err)
+	f.JSONEq(`{"id":"id","properties":{"Raw":true}}`, string(x))
+
+	_, err = client.marshal(&Object{ID: "id", Collection: "invalid", Properties: f.props()})
+	f.Equal(ErrInvalidSerialization, err)
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package objects
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errLocked = errors.New("File is locked")
+
+// flock takes an exclusive advisory lock on f without blocking. It returns
+// errLocked if another open file holds it.
+func flock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// syncDir syncs the directory at path, so renames and new files in it
+// survive a crash.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	d.Close()
+	return err
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package objects
+
+import (
+	"errors"
+	"os"
+)
+
+var errLocked = errors.New("File is locked")
+
+// flock is a no-op: spools are not safe to share between processes on
+// Windows.
+func flock(f *os.File) error {
+	return nil
+}
+
+// syncDir is a no-op: directories cannot be synced on Windows.
+func syncDir(path string) error {
+	return nil
+}
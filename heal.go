@@ -0,0 +1,229 @@
+package objects
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrStalled = errors.New("Buffer stalled")
+)
+
+// WorkerEvent describes a collection's buffer goroutine that crashed and was
+// restarted, or has been busy with the same objects for longer than
+// Client.StallAfter.
+type WorkerEvent struct {
+	Collection string
+
+	// Restarted is true when the goroutine panicked and was restarted. The
+	// object being encoded is dropped with DropPanic, the other objects it
+	// had taken from the queue are added back, and its batch is kept. If it
+	// panicked elsewhere, e.g. in a callback while flushing, the batch being
+	// built or flushed is dropped with DropPanic too.
+	Restarted bool
+
+	// Requeued is the number of objects added back after a restart.
+	Requeued int
+
+	// Since is when the goroutine started the work it is stuck in, or
+	// panicked in.
+	Since time.Time
+
+	// Err is the panic, or ErrStalled.
+	Err error
+}
+
+// loop batches the objects of b until Exit, and reports whether it got
+// there rather than panicked.
+func (c *Client) loop(b *buffer) (exited bool) {
+	defer func() {
+		if exited {
+			return
+		}
+		if r := recover(); r != nil {
+			c.restart(b, r)
+		}
+	}()
+
+	var tick ticker
+	var ticks <-chan time.Time
+	if c.MaxBatchInterval > 0 {
+		tick = c.ticker(c.MaxBatchInterval)
+		defer tick.Stop()
+		ticks = tick.Chan()
+	}
+
+	// Objects left by a panic come first.
+	c.addPending(b)
+
+	for {
+		atomic.StoreInt64(&b.busy, 0)
+		select {
+		case <-b.Queue.ready:
+			atomic.StoreInt64(&b.busy, time.Now().UnixNano())
+			flushed := c.dequeue(b)
+			if (tick == nil || len(b.expiring) > 0) && b.Queue.len() == 0 {
+				flushed = c.flush(b) || flushed
+			}
+			if flushed && tick != nil && !c.AlignFlushInterval {
+				tick.Reset(c.MaxBatchInterval)
+			}
+		case <-ticks:
+			atomic.StoreInt64(&b.busy, time.Now().UnixNano())
+			// Objects already queued make it into this batch rather than the
+			// next one.
+			c.dequeue(b)
+			c.flush(b)
+		case done := <-b.flushes:
+			atomic.StoreInt64(&b.busy, time.Now().UnixNano())
+			// The queue never holds more than a dequeue takes: objects still
+			// being pushed are not queued yet.
+			c.dequeue(b)
+			c.flush(b)
+			close(done)
+		case reply := <-b.dumps:
+			reply <- c.dump(b)
+		case <-b.Exit:
+			// Exit is closed once the queue is, when no push is in progress.
+			<-b.Queue.done
+			c.dequeue(b)
+			c.flush(b)
+			return true
+		}
+	}
+}
+
+// ticker is the flush interval of a buffer, a *time.Ticker outside tests.
+type ticker interface {
+	Chan() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+type timeTicker struct {
+	*time.Ticker
+}
+
+func (t timeTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+func (c *Client) ticker(d time.Duration) ticker {
+	if c.newTicker != nil {
+		return c.newTicker(d)
+	}
+	return timeTicker{time.NewTicker(d)}
+}
+
+// restart reports the panic r of b's goroutine, which runs its loop again.
+// A panic encoding an object drops the object. Any other one, e.g. in a
+// callback while flushing, would happen again with the same batch: the batch
+// being flushed or built is dropped, along with the object being added.
+func (c *Client) restart(b *buffer, r interface{}) {
+	err := fmt.Errorf("Buffer of collection `%s` panicked: %v", b.collection, r)
+	v, encoding := b.adding, b.encoding
+	dropped := b.flushing
+	b.adding, b.encoding, b.flushing = nil, false, nil
+	if !encoding && dropped == nil && b.count() > 0 {
+		dropped = &batch{Collection: b.collection, count: b.count(), ids: b.ids, meta: b.meta}
+		b.reset()
+		b.ids, b.meta, b.dedupe = nil, nil, nil
+		b.expiring, b.expiringPos, b.expires, b.expiresPos = nil, nil, nil, nil
+	}
+
+	requeued := 0
+	for _, v := range b.pending {
+		if v != nil {
+			requeued++
+		}
+	}
+
+	// Callbacks may panic too, which must not end the goroutine.
+	safely := func(fn func()) {
+		defer func() {
+			if r := recover(); r != nil {
+				c.Logger.Printf("[Error] Buffer of collection `%s` panicked reporting a panic: %v", b.collection, r)
+			}
+		}()
+		fn()
+	}
+	if v != nil {
+		safely(func() { c.drop(v, DropPanic, err) })
+	}
+	if dropped != nil {
+		// Objects already dropped for their Deadline were removed from the
+		// batch, the others are all dropped here.
+		dropped.expiring, dropped.expiringPos = nil, nil
+		safely(func() { c.dropBatch(dropped, DropPanic, err) })
+	}
+	if c.Metrics != nil {
+		safely(func() {
+			c.Metrics.Count("objects.restarts", 1, map[string]string{"collection": b.collection})
+		})
+	}
+	safely(func() {
+		c.workerEvent(WorkerEvent{
+			Collection: b.collection,
+			Restarted:  true,
+			Requeued:   requeued,
+			Since:      time.Unix(0, atomic.LoadInt64(&b.busy)),
+			Err:        err,
+		})
+	})
+}
+
+// heal starts checking for stalled buffers if StallAfter is set.
+func (c *Client) heal() {
+	if c.StallAfter <= 0 {
+		return
+	}
+	c.healOnce.Do(func() {
+		c.scheduler.schedule("stalls", time.Now().Add(c.StallAfter/2), c.stalls)
+	})
+}
+
+// stalls reports buffers that became stalled since the last check, then
+// schedules the next check.
+func (c *Client) stalls() {
+	now := time.Now()
+	for t := range c.cmap.Iter() {
+		for _, b := range t.Val.all() {
+			busy := atomic.LoadInt64(&b.busy)
+			if busy == 0 || now.Sub(time.Unix(0, busy)) <= c.StallAfter {
+				atomic.StoreInt32(&b.stalled, 0)
+				continue
+			}
+			if !atomic.CompareAndSwapInt32(&b.stalled, 0, 1) {
+				continue
+			}
+
+			if c.Metrics != nil {
+				c.Metrics.Count("objects.stalls", 1, map[string]string{"collection": b.collection})
+			}
+			c.workerEvent(WorkerEvent{
+				Collection: b.collection,
+				Since:      time.Unix(0, busy),
+				Err:        ErrStalled,
+			})
+		}
+	}
+
+	if atomic.LoadInt64(&c.closed) == 0 {
+		c.scheduler.schedule("stalls", now.Add(c.StallAfter/2), c.stalls)
+	}
+}
+
+// workerEvent hands e to OnWorkerEvent, or logs it when it is nil.
+func (c *Client) workerEvent(e WorkerEvent) {
+	if c.OnWorkerEvent != nil {
+		c.OnWorkerEvent(e)
+		return
+	}
+	if e.Restarted {
+		c.Logger.Printf("[Error] %v, restarted with %d objects requeued", e.Err, e.Requeued)
+		return
+	}
+	c.Logger.Printf("[Warn] Buffer of collection `%s` stalled since %s", e.Collection, e.Since.Format(time.RFC3339))
+}
@@ -0,0 +1,173 @@
+package objects
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// HealthState summarizes the delivery pipeline.
+type HealthState string
+
+const (
+	// HealthOK means batches are being delivered.
+	HealthOK HealthState = "ok"
+
+	// HealthDegraded means deliveries are failing or a queue is nearly full,
+	// but the failures may still be transient.
+	HealthDegraded HealthState = "degraded"
+
+	// HealthFailing means the last FailingThreshold deliveries all failed,
+	// or the client's Breaker is open.
+	HealthFailing HealthState = "failing"
+)
+
+// FailingThreshold is the number of consecutive failed deliveries after
+// which Health reports HealthFailing.
+const FailingThreshold = 3
+
+// saturated is the fraction of a queue's capacity above which it counts as
+// saturated.
+const saturated = 0.9
+
+// HealthReport is returned by Health.
+type HealthReport struct {
+	State HealthState
+
+	// ConsecutiveFailures is the number of deliveries that failed since the
+	// last success.
+	ConsecutiveFailures int
+
+	// LastError is the error of the last failed delivery, if any.
+	LastError string
+
+	// LastFlush is the time of the last successful delivery per collection.
+	LastFlush map[string]time.Time
+
+	// BreakerOpen is whether the client's Breaker is open, failing requests
+	// without sending them.
+	BreakerOpen bool
+
+	// Saturation is the fraction of each collection's queue in use, from
+	// 0 to 1. A collection at 0.9 or more makes the client HealthDegraded.
+	Saturation map[string]float64
+}
+
+// Ready reports whether the client is fit to receive traffic, e.g. for a
+// readiness probe.
+func (r HealthReport) Ready() bool {
+	return r.State != HealthFailing
+}
+
+type health struct {
+	sync.Mutex
+	failures  int
+	lastError string
+	lastFlush map[string]time.Time
+	errors    map[string]string
+}
+
+func (h *health) record(collection string, err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.lastFlush == nil {
+		h.lastFlush = map[string]time.Time{}
+		h.errors = map[string]string{}
+	}
+
+	if err != nil {
+		h.failures++
+		h.lastError = err.Error()
+		h.errors[collection] = h.lastError
+		return
+	}
+
+	h.failures = 0
+	h.lastFlush[collection] = time.Now()
+	delete(h.errors, collection)
+}
+
+// Health summarizes the delivery pipeline: consecutive delivery failures,
+// the Breaker, the last successful flush of every collection and how full
+// their queues are. It is cheap enough to call from liveness and readiness probes.
+func (c *Client) Health() HealthReport {
+	c.health.Lock()
+	report := HealthReport{
+		State:               HealthOK,
+		ConsecutiveFailures: c.health.failures,
+		LastError:           c.health.lastError,
+		LastFlush:           make(map[string]time.Time, len(c.health.lastFlush)),
+		Saturation:          map[string]float64{},
+	}
+	for k, t := range c.health.lastFlush {
+		report.LastFlush[k] = t
+	}
+	c.health.Unlock()
+
+	for k, s := range c.BufferStats() {
+		if s.Capacity == 0 {
+			continue
+		}
+		report.Saturation[k] = float64(s.Length) / float64(s.Capacity)
+		if report.Saturation[k] >= saturated {
+			report.State = HealthDegraded
+		}
+	}
+
+	report.BreakerOpen = c.Breaker != nil && c.Breaker.Open()
+
+	switch {
+	case report.BreakerOpen, report.ConsecutiveFailures >= FailingThreshold:
+		report.State = HealthFailing
+	case report.ConsecutiveFailures > 0:
+		report.State = HealthDegraded
+	}
+	return report
+}
+
+// Saturation returns how close the client is to blocking or dropping
+// objects, from 0 to 1: the highest of the fill ratios of the collection
+// queues, of the concurrent deliveries, of the QuotaBlock and QuotaDrop
+// quotas and of the RequestLimiter's burst when it reports its tokens, as
+// *rate.Limiter does. Producers pulling from an upstream source, e.g. a
+// Kafka reader, can slow their intake as it nears 1 rather than block in
+// Set.
+func (c *Client) Saturation() float64 {
+	s := float64(len(c.semaphore)) / float64(cap(c.semaphore))
+
+	for _, b := range c.BufferStats() {
+		if b.Capacity > 0 {
+			s = math.Max(s, float64(b.Length)/float64(b.Capacity))
+		}
+	}
+
+	now := time.Now()
+	c.quotas.Lock()
+	for name, q := range c.Quotas {
+		if q.Mode == QuotaWarn {
+			continue
+		}
+		u := c.quotas.current(name, q, now)
+		if q.MaxObjects > 0 {
+			s = math.Max(s, float64(u.objects)/float64(q.MaxObjects))
+		}
+		if q.MaxBytes > 0 {
+			s = math.Max(s, float64(u.bytes)/float64(q.MaxBytes))
+		}
+	}
+	c.quotas.Unlock()
+
+	if l, ok := c.RequestLimiter.(tokenLimiter); ok && l.Burst() > 0 {
+		s = math.Max(s, 1-l.Tokens()/float64(l.Burst()))
+	}
+
+	return math.Min(s, 1)
+}
+
+// tokenLimiter is implemented by Limiters reporting their available tokens,
+// e.g. *rate.Limiter.
+type tokenLimiter interface {
+	Tokens() float64
+	Burst() int
+}
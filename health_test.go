@@ -0,0 +1,130 @@
+package objects
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestHealth(t *testing.T) {
+	suite.Run(t, &HealthTestSuite{})
+}
+
+type HealthTestSuite struct {
+	suite.Suite
+}
+
+func (h *HealthTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://ok.segment.com/v1/set", httpmock.NewStringResponder(200, `{"success": true}`))
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+}
+
+func (h *HealthTestSuite) send(client *Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	return client.SendBatch(ctx, NewBatch("c").Add(&Object{ID: "id", Properties: map[string]interface{}{"p": "1"}}))
+}
+
+func (h *HealthTestSuite) TestOK() {
+	client := New("writeKey")
+	client.BaseEndpoint = "https://ok.segment.com"
+	h.NoError(h.send(client))
+
+	report := client.Health()
+	h.Equal(HealthOK, report.State)
+	h.True(report.Ready())
+	h.WithinDuration(time.Now(), report.LastFlush["c"], time.Second)
+}
+
+func (h *HealthTestSuite) TestFailing() {
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+
+	h.Error(h.send(client))
+	report := client.Health()
+	h.Equal(HealthDegraded, report.State)
+	h.Equal(1, report.ConsecutiveFailures)
+	h.NotEmpty(report.LastError)
+
+	for i := 1; i < FailingThreshold; i++ {
+		h.Error(h.send(client))
+	}
+	h.Equal(HealthFailing, client.Health().State)
+	h.False(client.Health().Ready())
+
+	client.BaseEndpoint = "https://ok.segment.com"
+	h.NoError(h.send(client))
+	h.Equal(HealthOK, client.Health().State)
+	h.Equal(0, client.Health().ConsecutiveFailures)
+}
+
+func (h *HealthTestSuite) TestBreakerOpen() {
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Breaker = NewBreaker(1, time.Minute)
+	h.False(client.Health().BreakerOpen)
+
+	h.Error(h.send(client))
+	report := client.Health()
+	h.True(report.BreakerOpen)
+	h.Equal(HealthFailing, report.State)
+	h.False(report.Ready())
+}
+
+func (h *HealthTestSuite) TestSaturation() {
+	client := New("writeKey")
+	client.cmap.Set("c", newBuffer("c", 10))
+
+	for i := 0; i < 9; i++ {
+		h.NoError(client.Set(&Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+
+	report := client.Health()
+	h.Equal(HealthDegraded, report.State)
+	h.Equal(0.9, report.Saturation["c"])
+}
+
+func (h *HealthTestSuite) TestClientSaturation() {
+	client := New("writeKey")
+	h.Equal(0.0, client.Saturation())
+
+	client.cmap.Set("c", newBuffer("c", 10))
+	for i := 0; i < 4; i++ {
+		h.NoError(client.Set(&Object{ID: "id", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	h.Equal(0.4, client.Saturation())
+
+	client.Quotas = map[string]*Quota{"q": {MaxObjects: 2, Mode: QuotaDrop}, "w": {MaxObjects: 1}}
+	client.admit(&Object{ID: "1", Collection: "q"}, 10)
+	client.admit(&Object{ID: "1", Collection: "w"}, 10)
+	h.Equal(0.5, client.Saturation())
+
+	client.admit(&Object{ID: "2", Collection: "q"}, 10)
+	client.admit(&Object{ID: "3", Collection: "q"}, 10)
+	h.Equal(1.0, client.Saturation())
+}
+
+type tokens struct {
+	countingLimiter
+	n float64
+}
+
+func (t *tokens) Tokens() float64 { return t.n }
+func (t *tokens) Burst() int      { return 10 }
+
+func (h *HealthTestSuite) TestLimiterSaturation() {
+	client := New("writeKey")
+	client.RequestLimiter = &countingLimiter{}
+	h.Equal(0.0, client.Saturation())
+
+	client.RequestLimiter = &tokens{n: 2}
+	h.InDelta(0.8, client.Saturation(), 1e-9)
+
+	// Tokens are negative while requests wait.
+	client.RequestLimiter = &tokens{n: -5}
+	h.Equal(1.0, client.Saturation())
+}
@@ -0,0 +1,183 @@
+package objects
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HedgePolicy configures hedged requests: when a request takes longer than
+// the Quantile of recent request latencies, a second identical request is
+// sent and the first response wins, the other request being canceled. It
+// trims the tail latency caused by occasional slow connections, at the cost
+// of a few duplicate requests, which the Objects API handles as it does
+// retries.
+type HedgePolicy struct {
+	// Quantile of request latencies after which a request is hedged. It
+	// defaults to 0.95.
+	Quantile float64
+
+	// MinSamples is the number of requests measured before any is hedged.
+	// It defaults to 100.
+	MinSamples int64
+
+	// MaxRatio caps hedged requests to a fraction of requests. It defaults
+	// to 0.05.
+	MaxRatio float64
+}
+
+type hedger struct {
+	sync.Mutex
+	times    *Histogram
+	requests int64
+	hedges   int64
+}
+
+// delay returns how long to wait before hedging a request, and false while
+// too few requests were measured.
+func (h *hedger) delay(p *HedgePolicy) (time.Duration, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.requests++
+	min := p.MinSamples
+	if min == 0 {
+		min = 100
+	}
+	if h.times == nil || h.times.Count < min {
+		return 0, false
+	}
+	q := p.Quantile
+	if q == 0 {
+		q = 0.95
+	}
+	return h.times.Quantile(q), true
+}
+
+// allow reports whether a request may be hedged, counting it if so.
+func (h *hedger) allow(p *HedgePolicy) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	ratio := p.MaxRatio
+	if ratio == 0 {
+		ratio = 0.05
+	}
+	if float64(h.hedges+1) > ratio*float64(h.requests) {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+func (h *hedger) observe(d time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.times == nil {
+		h.times = newHistogram()
+	}
+	h.times.observe(d)
+}
+
+type hedgeResult struct {
+	i      int
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+}
+
+// cancelBody cancels the context of its request once closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// attempt sends req, which carries payload, hedging it according to the
+// client's HedgePolicy.
+func (c *Client) attempt(ctx context.Context, req *http.Request, payload []byte) (*http.Response, error) {
+	if c.Hedge == nil {
+		return c.do(req.WithContext(ctx), payload)
+	}
+
+	start := time.Now()
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func(r *http.Request) {
+		rctx, cancel := context.WithCancel(ctx)
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := c.do(r.WithContext(rctx), payload)
+			results <- hedgeResult{i: i, resp: resp, err: err, cancel: cancel}
+		}()
+	}
+	launch(req)
+	pending := 1
+
+	var timer <-chan time.Time
+	if delay, ok := c.hedger.delay(c.Hedge); ok {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if c.hedger.allow(c.Hedge) {
+				hedge := req.Clone(ctx)
+				hedge.Body = ioutil.NopCloser(bytes.NewReader(payload))
+				launch(hedge)
+				pending++
+			}
+
+		case r := <-results:
+			pending--
+			ok := r.err == nil && r.resp.StatusCode == http.StatusOK
+			if !ok && pending > 0 {
+				discard(r)
+				continue
+			}
+
+			if ok {
+				c.hedger.observe(time.Since(start))
+			}
+			if pending > 0 {
+				for i, cancel := range cancels {
+					if i != r.i {
+						cancel()
+					}
+				}
+				go func() {
+					discard(<-results)
+				}()
+			}
+			if r.err != nil {
+				r.cancel()
+				return nil, r.err
+			}
+			r.resp.Body = &cancelBody{ReadCloser: r.resp.Body, cancel: r.cancel}
+			return r.resp, nil
+		}
+	}
+}
+
+// discard releases the response of a request that lost the race.
+func discard(r hedgeResult) {
+	r.cancel()
+	if r.err == nil {
+		r.resp.Body.Close()
+	}
+}
@@ -0,0 +1,140 @@
+package objects
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KeyEncoding controls how Object.Keys are joined into an ID. Keys are
+// always ordered by name.
+type KeyEncoding int
+
+const (
+	// KeyValues joins the values, e.g. `42:7` for `{"order_id": "42",
+	// "line": "7"}`. This is the default.
+	KeyValues KeyEncoding = iota
+
+	// KeyPairs joins `name=value` pairs, e.g. `line=7:order_id=42`, with
+	// names and values escaped like KeyEscaped's.
+	KeyPairs
+
+	// KeyEscaped is like KeyValues with values query escaped, and the
+	// characters of the separator percent encoded, so values containing the
+	// separator can't produce the same ID as other keys.
+	KeyEscaped
+)
+
+// IDGenerator returns the ID of an object set without one, see
+// Client.IDGenerator.
+type IDGenerator func(v *Object) string
+
+// UUIDv7 is an IDGenerator returning random, time-ordered UUIDs as defined
+// by RFC 9562. Unlike content based IDs, every Set creates a new object.
+// Should the system fail to provide randomness, objects get no ID, and fail
+// validation.
+func UUIDv7(v *Object) string {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return ""
+	}
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	b[6] = 0x70 | b[6]&0x0f
+	b[8] = 0x80 | b[8]&0x3f
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// HashID returns an IDGenerator deriving the ID from a SHA-256 hash of the
+// listed properties, or of every property when none is listed, so loading
+// the same rows again updates the same objects instead of duplicating them.
+// Values are hashed in their JSON form, so 1 and 1.0 have the same ID, and
+// missing properties hash like nil. Objects whose properties fail to encode
+// get no ID, and fail validation.
+func HashID(properties ...string) IDGenerator {
+	return func(v *Object) string {
+		keys := properties
+		if len(keys) == 0 {
+			keys = make([]string, 0, len(v.Properties))
+			for k := range v.Properties {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+		}
+
+		values := make([]interface{}, 0, 2*len(keys))
+		for _, k := range keys {
+			values = append(values, k, v.Properties[k])
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return ""
+		}
+
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:16])
+	}
+}
+
+// identify sets the ID of v from its Keys, or using the client's
+// IDGenerator, if v has none.
+func (c *Client) identify(v *Object) {
+	switch {
+	case v.ID != "":
+	case len(v.Keys) > 0:
+		v.ID = c.joinKeys(v.Keys)
+	case c.IDGenerator != nil:
+		v.ID = c.IDGenerator(v)
+	}
+}
+
+func (c *Client) joinKeys(keys map[string]string) string {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		switch c.KeyEncoding {
+		case KeyPairs:
+			parts[i] = escapeKey(name, c.KeySeparator) + "=" + escapeKey(keys[name], c.KeySeparator)
+		case KeyEscaped:
+			parts[i] = escapeKey(keys[name], c.KeySeparator)
+		default:
+			parts[i] = keys[name]
+		}
+	}
+	return strings.Join(parts, c.KeySeparator)
+}
+
+// escapeKey query escapes s, and percent encodes the characters of sep left
+// unescaped, e.g. '-', '.', '_' and '~', so s never contains sep.
+func escapeKey(s, sep string) string {
+	s = url.QueryEscape(s)
+	if !strings.ContainsAny(s, sep) {
+		return s
+	}
+
+	b := &bytes.Buffer{}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(sep, s[i]) >= 0 {
+			fmt.Fprintf(b, "%%%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
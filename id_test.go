@@ -0,0 +1,92 @@
+package objects
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestID(t *testing.T) {
+	suite.Run(t, &IDTestSuite{})
+}
+
+type IDTestSuite struct {
+	suite.Suite
+}
+
+func (s *IDTestSuite) TestUUIDv7() {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := UUIDv7(nil), UUIDv7(nil)
+	s.Regexp(re, a)
+	s.Regexp(re, b)
+	s.NotEqual(a, b)
+	s.True(a[:8] <= b[:8])
+}
+
+func (s *IDTestSuite) TestIdentify() {
+	c := New("writeKey")
+	defer c.Close()
+
+	v := &Object{Collection: "c", Properties: map[string]interface{}{"a": "b"}}
+	c.identify(v)
+	s.Empty(v.ID)
+
+	c.IDGenerator = func(v *Object) string { return "generated" }
+	c.identify(v)
+	s.Equal("generated", v.ID)
+
+	v.ID = "natural"
+	c.identify(v)
+	s.Equal("natural", v.ID)
+}
+
+func (s *IDTestSuite) TestHashID() {
+	props := func(m map[string]interface{}) *Object {
+		return &Object{Collection: "c", Properties: m}
+	}
+
+	all := HashID()
+	a := all(props(map[string]interface{}{"day": "2016-01-02", "count": 3}))
+	s.Len(a, 32)
+	s.Equal(a, all(props(map[string]interface{}{"count": 3.0, "day": "2016-01-02"})))
+	s.NotEqual(a, all(props(map[string]interface{}{"day": "2016-01-02", "count": 4})))
+
+	day := HashID("day")
+	s.Equal(day(props(map[string]interface{}{"day": "2016-01-02", "count": 3})), day(props(map[string]interface{}{"day": "2016-01-02", "count": 4})))
+	s.NotEqual(day(props(map[string]interface{}{"day": "2016-01-02"})), day(props(map[string]interface{}{})))
+
+	s.Empty(all(props(map[string]interface{}{"ch": make(chan int)})))
+}
+
+func (s *IDTestSuite) TestKeys() {
+	c := New("writeKey")
+	defer c.Close()
+	c.IDGenerator = UUIDv7
+
+	keys := map[string]string{"order_id": "42", "line": "7"}
+	id := func() string {
+		v := &Object{Collection: "c", Keys: keys, Properties: map[string]interface{}{"a": "b"}}
+		c.identify(v)
+		return v.ID
+	}
+
+	s.Equal("7:42", id())
+
+	c.KeySeparator = "|"
+	c.KeyEncoding = KeyPairs
+	s.Equal("line=7|order_id=42", id())
+
+	c.KeyEncoding = KeyEscaped
+	keys["order_id"] = "4|2"
+	s.Equal("7|4%7C2", id())
+
+	// Separators query escaping leaves as is are escaped too.
+	c.KeySeparator = "-"
+	keys["order_id"] = "4-2"
+	s.Equal("7-4%2D2", id())
+
+	c.KeyEncoding = KeyPairs
+	keys = map[string]string{"a-b": "1", "c": "2-3"}
+	s.Equal("a%2Db=1-c=2%2D3", id())
+}
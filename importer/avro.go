@@ -0,0 +1,468 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotAvro = errors.New("Not an Avro object container file")
+)
+
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// maxAvroBlockSize bounds the size of a block and of the values it holds,
+// decompressed, so a corrupt length cannot allocate gigabytes.
+const maxAvroBlockSize = 64 << 20
+
+// NewAvroReader returns a Reader over an Avro object container file whose
+// schema is a record. Values are mapped using the embedded writer schema:
+// nested records and maps become nested maps, arrays become slices, enums
+// become strings, and the date, timestamp-millis, timestamp-micros and
+// decimal logical types become time.Time and json.Number. The null and
+// deflate codecs are supported.
+func NewAvroReader(r io.Reader) (Reader, error) {
+	a := &avroReader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(a.r, magic); err != nil || !bytes.Equal(magic, avroMagic) {
+		return nil, ErrNotAvro
+	}
+
+	meta, err := a.readMeta()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(a.r, a.sync[:]); err != nil {
+		return nil, err
+	}
+
+	a.codec = string(meta["avro.codec"])
+	if a.codec != "" && a.codec != "null" && a.codec != "deflate" {
+		return nil, fmt.Errorf("Avro codec `%s` is not supported", a.codec)
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(meta["avro.schema"], &raw); err != nil {
+		return nil, err
+	}
+	if a.schema, err = parseAvroSchema(raw, map[string]*avroSchema{}, ""); err != nil {
+		return nil, err
+	}
+	if a.schema.Type != "record" {
+		return nil, fmt.Errorf("Avro schema must be a record, not `%s`", a.schema.Type)
+	}
+
+	return a, nil
+}
+
+type avroReader struct {
+	r      *bufio.Reader
+	schema *avroSchema
+	codec  string
+	sync   [16]byte
+
+	block     *bytes.Reader
+	remaining int64
+}
+
+func (a *avroReader) Read() (map[string]interface{}, error) {
+	for a.remaining == 0 {
+		if err := a.nextBlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := a.schema.decode(a.block)
+	if err != nil {
+		return nil, err
+	}
+	a.remaining--
+	return v.(map[string]interface{}), nil
+}
+
+func (a *avroReader) nextBlock() error {
+	count, err := binary.ReadVarint(a.r)
+	if err != nil {
+		return err
+	}
+	size, err := binary.ReadVarint(a.r)
+	if err != nil {
+		return unexpected(err)
+	}
+	if count < 0 || size < 0 || size > maxAvroBlockSize {
+		return fmt.Errorf("Invalid Avro block of %d objects and %d bytes", count, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(a.r, data); err != nil {
+		return unexpected(err)
+	}
+
+	sync := make([]byte, 16)
+	if _, err := io.ReadFull(a.r, sync); err != nil {
+		return unexpected(err)
+	}
+	if !bytes.Equal(sync, a.sync[:]) {
+		return errors.New("Avro block sync marker mismatch")
+	}
+
+	if a.codec == "deflate" {
+		r := io.LimitReader(flate.NewReader(bytes.NewReader(data)), maxAvroBlockSize+1)
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return err
+		}
+		if len(data) > maxAvroBlockSize {
+			return fmt.Errorf("Avro block exceeds %d bytes once decompressed", maxAvroBlockSize)
+		}
+	}
+
+	a.block = bytes.NewReader(data)
+	a.remaining = count
+	return nil
+}
+
+func (a *avroReader) readMeta() (map[string][]byte, error) {
+	meta := map[string][]byte{}
+	for {
+		count, err := binary.ReadVarint(a.r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if count == 0 {
+			return meta, nil
+		}
+		if count < 0 {
+			count = -count
+			if _, err := binary.ReadVarint(a.r); err != nil {
+				return nil, unexpected(err)
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			key, err := readAvroBytes(a.r)
+			if err != nil {
+				return nil, err
+			}
+			val, err := readAvroBytes(a.r)
+			if err != nil {
+				return nil, err
+			}
+			meta[string(key)] = val
+		}
+	}
+}
+
+type avroSchema struct {
+	Type        string
+	Name        string
+	Fields      []*avroField
+	Symbols     []string
+	Items       *avroSchema
+	Values      *avroSchema
+	Union       []*avroSchema
+	Size        int
+	LogicalType string
+	Scale       int
+}
+
+type avroField struct {
+	Name   string
+	Schema *avroSchema
+}
+
+// parseAvroSchema parses the JSON form of a schema. Named types are
+// registered in names so they can be referenced later, including
+// recursively.
+func parseAvroSchema(raw interface{}, names map[string]*avroSchema, namespace string) (*avroSchema, error) {
+	switch v := raw.(type) {
+	case string:
+		switch v {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{Type: v}, nil
+		}
+		if s, ok := names[v]; ok {
+			return s, nil
+		}
+		if s, ok := names[namespace+"."+v]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("Unknown Avro type `%s`", v)
+
+	case []interface{}:
+		s := &avroSchema{Type: "union"}
+		for _, branch := range v {
+			b, err := parseAvroSchema(branch, names, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.Union = append(s.Union, b)
+		}
+		return s, nil
+
+	case map[string]interface{}:
+		typ, _ := v["type"].(string)
+		if typ == "" {
+			// {"type": {...}} wraps another schema.
+			return parseAvroSchema(v["type"], names, namespace)
+		}
+
+		s := &avroSchema{Type: typ}
+		s.LogicalType, _ = v["logicalType"].(string)
+		if scale, ok := v["scale"].(float64); ok {
+			s.Scale = int(scale)
+		}
+
+		switch typ {
+		case "record", "error", "enum", "fixed":
+			s.Type = strings.Replace(typ, "error", "record", 1)
+			name, _ := v["name"].(string)
+			if ns, ok := v["namespace"].(string); ok {
+				namespace = ns
+			}
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				namespace = name[:i]
+				name = name[i+1:]
+			}
+			s.Name = name
+			names[name] = s
+			if namespace != "" {
+				names[namespace+"."+name] = s
+			}
+		}
+
+		switch s.Type {
+		case "record":
+			fields, _ := v["fields"].([]interface{})
+			for _, f := range fields {
+				fm, ok := f.(map[string]interface{})
+				if !ok {
+					return nil, errors.New("Invalid Avro record field")
+				}
+				fs, err := parseAvroSchema(fm["type"], names, namespace)
+				if err != nil {
+					return nil, err
+				}
+				name, _ := fm["name"].(string)
+				s.Fields = append(s.Fields, &avroField{Name: name, Schema: fs})
+			}
+		case "enum":
+			symbols, _ := v["symbols"].([]interface{})
+			for _, sym := range symbols {
+				name, _ := sym.(string)
+				s.Symbols = append(s.Symbols, name)
+			}
+		case "fixed":
+			size, _ := v["size"].(float64)
+			s.Size = int(size)
+		case "array":
+			items, err := parseAvroSchema(v["items"], names, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.Items = items
+		case "map":
+			values, err := parseAvroSchema(v["values"], names, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.Values = values
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("Invalid Avro schema: %v", raw)
+}
+
+// decode reads a value of schema s from r, which must be a *bytes.Reader
+// holding a block of the file.
+func (s *avroSchema) decode(r *bytes.Reader) (interface{}, error) {
+	switch s.Type {
+	case "null":
+		return nil, nil
+
+	case "boolean":
+		b, err := r.ReadByte()
+		return b != 0, unexpected(err)
+
+	case "int", "long":
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		switch s.LogicalType {
+		case "date":
+			return time.Unix(n*86400, 0).UTC(), nil
+		case "timestamp-millis":
+			return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+		case "timestamp-micros":
+			return time.Unix(0, n*int64(time.Microsecond)).UTC(), nil
+		}
+		return n, nil
+
+	case "float":
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, unexpected(err)
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+
+	case "double":
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, unexpected(err)
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+
+	case "bytes", "fixed":
+		var b []byte
+		var err error
+		if s.Type == "fixed" {
+			b = make([]byte, s.Size)
+			_, err = io.ReadFull(r, b)
+			err = unexpected(err)
+		} else {
+			b, err = readAvroBytes(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.LogicalType == "decimal" {
+			return decimal(b, s.Scale), nil
+		}
+		return b, nil
+
+	case "string":
+		b, err := readAvroBytes(r)
+		return string(b), err
+
+	case "record":
+		m := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			v, err := f.Schema.decode(r)
+			if err != nil {
+				return nil, err
+			}
+			m[f.Name] = v
+		}
+		return m, nil
+
+	case "enum":
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if n < 0 || int(n) >= len(s.Symbols) {
+			return nil, fmt.Errorf("Avro enum index %d out of range", n)
+		}
+		return s.Symbols[n], nil
+
+	case "union":
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if n < 0 || int(n) >= len(s.Union) {
+			return nil, fmt.Errorf("Avro union index %d out of range", n)
+		}
+		return s.Union[n].decode(r)
+
+	case "array":
+		ret := []interface{}{}
+		err := readAvroBlocks(r, func() error {
+			v, err := s.Items.decode(r)
+			ret = append(ret, v)
+			return err
+		})
+		return ret, err
+
+	case "map":
+		ret := map[string]interface{}{}
+		err := readAvroBlocks(r, func() error {
+			k, err := readAvroBytes(r)
+			if err != nil {
+				return err
+			}
+			v, err := s.Values.decode(r)
+			ret[string(k)] = v
+			return err
+		})
+		return ret, err
+	}
+
+	return nil, fmt.Errorf("Unsupported Avro type `%s`", s.Type)
+}
+
+// readAvroBlocks calls fn for every item of an array or map encoding.
+func readAvroBlocks(r *bytes.Reader, fn func() error) error {
+	for {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return unexpected(err)
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			count = -count
+			if _, err := binary.ReadVarint(r); err != nil {
+				return unexpected(err)
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type avroByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readAvroBytes(r avroByteReader) ([]byte, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if n < 0 {
+		return nil, errors.New("Negative Avro length")
+	}
+	if n > maxAvroBlockSize {
+		return nil, fmt.Errorf("Avro length %d exceeds %d bytes", n, maxAvroBlockSize)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, unexpected(err)
+}
+
+// decimal converts a two's-complement big-endian unscaled value.
+func decimal(b []byte, scale int) json.Number {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	r := new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	return json.Number(r.FloatString(scale))
+}
+
+// unexpected turns io.EOF into io.ErrUnexpectedEOF, since it is only valid
+// between blocks.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestAvro(t *testing.T) {
+	suite.Run(t, &AvroTestSuite{})
+}
+
+type AvroTestSuite struct {
+	suite.Suite
+}
+
+const avroSchemaJSON = `{
+	"type": "record", "name": "Room", "namespace": "com.example",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "rating", "type": ["null", "double"]},
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["SUITE", "SINGLE"]}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "owner", "type": {"type": "record", "name": "Owner", "fields": [
+			{"name": "name", "type": "string"},
+			{"name": "verified", "type": "boolean"}
+		]}},
+		{"name": "listed_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}},
+		{"name": "extras", "type": {"type": "map", "values": "int"}}
+	]
+}`
+
+type avroWriter struct {
+	bytes.Buffer
+}
+
+func (w *avroWriter) long(n int64) *avroWriter {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.Write(b[:binary.PutVarint(b, n)])
+	return w
+}
+
+func (w *avroWriter) bytes(b []byte) *avroWriter {
+	w.long(int64(len(b)))
+	w.Write(b)
+	return w
+}
+
+func (w *avroWriter) str(s string) *avroWriter {
+	return w.bytes([]byte(s))
+}
+
+func (w *avroWriter) double(f float64) *avroWriter {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	w.Write(b)
+	return w
+}
+
+func room(id int64, name string, rating *float64) []byte {
+	w := &avroWriter{}
+	w.long(id).str(name)
+	if rating == nil {
+		w.long(0)
+	} else {
+		w.long(1).double(*rating)
+	}
+	w.long(1)                              // kind: SINGLE
+	w.long(2).str("beach").str("view")     // tags, one block of two
+	w.long(0)                              // end of tags
+	w.str("calvin").WriteByte(1)           // owner
+	w.long(1464739200000)                  // listed_at: 2016-06-01
+	w.bytes([]byte{0xfe, 0x0c})            // price: -500 unscaled, -5.00
+	w.long(-1).long(3).str("wifi").long(1) // extras, one block with byte size
+	w.long(0)                              // end of extras
+	return w.Bytes()
+}
+
+func avroFile(codec string, blocks ...[][]byte) []byte {
+	sync := []byte("0123456789abcdef")
+	w := &avroWriter{}
+	w.Write([]byte{'O', 'b', 'j', 1})
+	w.long(2).str("avro.schema").str(avroSchemaJSON).str("avro.codec").str(codec).long(0)
+	w.Write(sync)
+
+	for _, records := range blocks {
+		data := bytes.Join(records, nil)
+		if codec == "deflate" {
+			buf := &bytes.Buffer{}
+			fw, _ := flate.NewWriter(buf, flate.DefaultCompression)
+			fw.Write(data)
+			fw.Close()
+			data = buf.Bytes()
+		}
+		w.long(int64(len(records))).bytes(data)
+		w.Write(sync)
+	}
+	return w.Bytes()
+}
+
+func (a *AvroTestSuite) read(file []byte) []map[string]interface{} {
+	r, err := NewAvroReader(bytes.NewReader(file))
+	a.Require().NoError(err)
+
+	rows := []map[string]interface{}{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		a.Require().NoError(err)
+		rows = append(rows, row)
+	}
+}
+
+func (a *AvroTestSuite) TestRead() {
+	rating := 4.5
+	for _, codec := range []string{"null", "deflate"} {
+		rows := a.read(avroFile(codec, [][]byte{room(1, "a", &rating), room(2, "b", nil)}, [][]byte{room(3, "c", nil)}))
+		a.Len(rows, 3)
+
+		row := rows[0]
+		a.Equal(int64(1), row["id"])
+		a.Equal("a", row["name"])
+		a.Equal(4.5, row["rating"])
+		a.Equal("SINGLE", row["kind"])
+		a.Equal([]interface{}{"beach", "view"}, row["tags"])
+		a.Equal(map[string]interface{}{"name": "calvin", "verified": true}, row["owner"])
+		a.Equal(time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC), row["listed_at"])
+		a.Equal(json.Number("-5.00"), row["price"])
+		a.Equal(map[string]interface{}{"wifi": int64(1)}, row["extras"])
+
+		a.Nil(rows[1]["rating"])
+		a.Equal(int64(3), rows[2]["id"])
+	}
+}
+
+func (a *AvroTestSuite) TestErrors() {
+	_, err := NewAvroReader(bytes.NewReader([]byte("PAR1")))
+	a.Equal(ErrNotAvro, err)
+
+	file := avroFile("null", [][]byte{room(1, "a", nil)})
+	r, err := NewAvroReader(bytes.NewReader(file[:len(file)-20]))
+	a.NoError(err)
+	_, err = r.Read()
+	a.Equal(io.ErrUnexpectedEOF, err)
+
+	// A corrupt block size fails instead of being allocated.
+	w := &avroWriter{}
+	w.Write(avroFile("null"))
+	w.long(1).long(1 << 40)
+	r, err = NewAvroReader(bytes.NewReader(w.Bytes()))
+	a.NoError(err)
+	_, err = r.Read()
+	a.Error(err)
+}
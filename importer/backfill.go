@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/segmentio/objects-go"
+)
+
+const (
+	// BackfillBatchSize is the number of objects sent per request by a
+	// BackfillSession.
+	BackfillBatchSize = 1000
+
+	// BackfillRate is the number of objects per second sent by a
+	// BackfillSession, so a backfill doesn't starve live traffic of the
+	// workspace's rate limit.
+	BackfillRate = 5000
+)
+
+// BackfillSession imports a large backfill, e.g. a whole warehouse table,
+// with defaults favoring throughput over latency: batches of
+// BackfillBatchSize objects and BackfillRate objects per second. Use a
+// client from NewBackfillClient for the best gzip compression. Set
+// Checkpoint, e.g. to a FileCheckpoint, to resume an interrupted backfill.
+// Run returns a report of the objects that failed.
+type BackfillSession struct {
+	*Importer
+
+	// DeliveredIDs optionally receives the ID of every object delivered,
+	// one per line, e.g. to a file, rather than keeping them in memory.
+	DeliveredIDs io.Writer
+
+	mu       sync.Mutex
+	failed   map[string]string
+	writeErr error
+}
+
+// BackfillReport is returned by BackfillSession.Run.
+type BackfillReport struct {
+	// Progress holds the final counts, including rows skipped without an
+	// ID, which are not listed below.
+	Progress
+
+	// FailedIDs maps the objects whose delivery failed to the error.
+	FailedIDs map[string]string
+}
+
+// NewBackfillClient returns a client configured for backfills, which gzips
+// requests with the best compression.
+func NewBackfillClient(writeKey string) *objects.Client {
+	client := objects.New(writeKey)
+	client.CompressionLevel = gzip.BestCompression
+	return client
+}
+
+// NewBackfillSession returns a session importing collection with client,
+// whose configuration is left as is.
+func NewBackfillSession(client *objects.Client, collection string) *BackfillSession {
+	s := &BackfillSession{
+		Importer: New(client, collection),
+		failed:   map[string]string{},
+	}
+	s.BatchSize = BackfillBatchSize
+	s.Limiter = NewLimiter(BackfillRate, BackfillBatchSize)
+	s.OnBatch = s.record
+	return s
+}
+
+func (s *BackfillSession) record(b *objects.Batch, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		for _, v := range b.Objects {
+			s.failed[v.ID] = err.Error()
+		}
+		return
+	}
+	if s.DeliveredIDs == nil || s.writeErr != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, v := range b.Objects {
+		buf.WriteString(v.ID)
+		buf.WriteByte('\n')
+	}
+	_, s.writeErr = s.DeliveredIDs.Write(buf.Bytes())
+}
+
+// Run imports every partition, see ImportPartitions. The report is returned
+// even if the backfill stopped on an error. Run also returns the first error
+// writing to DeliveredIDs, which does not stop the backfill.
+func (s *BackfillSession) Run(ctx context.Context, parts ...Reader) (*BackfillReport, error) {
+	var final Progress
+	progress := s.Progress
+	s.Progress = func(p Progress) {
+		final = p
+		if progress != nil {
+			progress(p)
+		}
+	}
+	defer func() { s.Progress = progress }()
+
+	_, err := s.ImportPartitions(ctx, parts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := &BackfillReport{
+		Progress:  final,
+		FailedIDs: s.failed,
+	}
+	if err == nil {
+		err = s.writeErr
+	}
+	s.failed, s.writeErr = map[string]string{}, nil
+	return report, err
+}
+
+// FileCheckpoint is a Checkpointer persisting positions to a JSON file,
+// written atomically after every batch.
+type FileCheckpoint struct {
+	Path string
+
+	mu   sync.Mutex
+	rows map[string]int
+}
+
+func NewFileCheckpoint(path string) *FileCheckpoint {
+	return &FileCheckpoint{Path: path}
+}
+
+// Load implements Checkpointer. A missing file is an empty checkpoint.
+func (c *FileCheckpoint) Load(partition int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.read(); err != nil {
+		return 0, err
+	}
+	return c.rows[strconv.Itoa(partition)], nil
+}
+
+// Save implements Checkpointer.
+func (c *FileCheckpoint) Save(partition int, rows int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.read(); err != nil {
+		return err
+	}
+	c.rows[strconv.Itoa(partition)] = rows
+
+	data, err := json.Marshal(c.rows)
+	if err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Path)
+}
+
+func (c *FileCheckpoint) read() error {
+	if c.rows != nil {
+		return nil
+	}
+	rows := map[string]int{}
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return err
+		}
+	}
+	c.rows = rows
+	return nil
+}
@@ -0,0 +1,195 @@
+// Package importer bulk loads rows from files into an objects collection.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/segmentio/objects-go"
+)
+
+var (
+	ErrMissingID = errors.New("Row has no ID")
+)
+
+// Reader reads rows one at a time. Read returns io.EOF after the last row.
+type Reader interface {
+	Read() (map[string]interface{}, error)
+}
+
+// ReaderFunc adapts a function to a Reader, e.g. to wrap a third-party
+// reader for a format without a built-in one, such as ORC.
+type ReaderFunc func() (map[string]interface{}, error)
+
+func (f ReaderFunc) Read() (map[string]interface{}, error) {
+	return f()
+}
+
+type Importer struct {
+	Client     *objects.Client
+	Collection string
+
+	// IDField is the row field used as the object ID.
+	IDField string
+
+	// BatchSize is the number of objects sent per request.
+	BatchSize int
+
+	// TotalRows optionally sets the expected row count, used for the ETA.
+	TotalRows int
+
+	// Progress is called after every batch and once the import completes.
+	// Calls are serialized across partitions.
+	Progress func(Progress)
+
+	// Checkpoint optionally records, per partition, how many rows have been
+	// processed so an interrupted import resumes where it stopped.
+	Checkpoint Checkpointer
+
+	// Limiter optionally bounds the rate of objects sent across all
+	// partitions.
+	Limiter Limiter
+
+	// OnBatch is optionally called after every batch sent, with the error
+	// of SendBatch. Calls may be concurrent across partitions.
+	OnBatch func(*objects.Batch, error)
+}
+
+// Checkpointer persists per-partition import positions.
+type Checkpointer interface {
+	// Load returns the number of rows of partition already processed.
+	Load(partition int) (int, error)
+	Save(partition int, rows int) error
+}
+
+func New(client *objects.Client, collection string) *Importer {
+	return &Importer{
+		Client:     client,
+		Collection: collection,
+		IDField:    "id",
+		BatchSize:  100,
+	}
+}
+
+// Import reads every row of r and delivers it synchronously, in batches of
+// BatchSize. Rows without an ID are logged and skipped. It returns the
+// number of objects delivered.
+func (i *Importer) Import(ctx context.Context, r Reader) (int, error) {
+	return i.ImportPartitions(ctx, []Reader{r})
+}
+
+// ImportPartitions imports every partition concurrently, e.g. one Reader per
+// input file or byte range. Partition numbers used for checkpoints are the
+// indexes in parts. The first error stops every partition.
+func (i *Importer) ImportPartitions(ctx context.Context, parts []Reader) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t := &tracker{importer: i, start: time.Now()}
+	errs := make(chan error, len(parts))
+	for n, r := range parts {
+		go func(n int, r Reader) {
+			err := i.partition(ctx, n, r, t)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}(n, r)
+	}
+
+	var err error
+	for range parts {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if err == nil {
+		t.done()
+	}
+	return t.delivered(), err
+}
+
+func (i *Importer) partition(ctx context.Context, n int, r Reader, t *tracker) error {
+	read := 0
+	if i.Checkpoint != nil {
+		skip, err := i.Checkpoint.Load(n)
+		if err != nil {
+			return err
+		}
+		for ; read < skip; read++ {
+			if _, err := r.Read(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		t.skip(skip)
+	}
+
+	b := objects.NewBatch(i.Collection)
+	send := func() error {
+		if i.Limiter != nil && len(b.Objects) > 0 {
+			if err := i.Limiter.WaitN(ctx, len(b.Objects)); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if len(b.Objects) > 0 {
+			err = i.Client.SendBatch(ctx, b)
+			t.batch(b, err)
+			if i.OnBatch != nil {
+				i.OnBatch(b, err)
+			}
+		}
+		if err == nil && i.Checkpoint != nil {
+			err = i.Checkpoint.Save(n, read)
+		}
+		b = objects.NewBatch(i.Collection)
+		return err
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		read++
+
+		v, err := i.object(row)
+		if err != nil {
+			log.Printf("[Error] Row skipped: %v", err)
+			t.fail()
+			continue
+		}
+		b.Add(v)
+
+		if len(b.Objects) >= i.BatchSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return send()
+}
+
+func (i *Importer) object(row map[string]interface{}) (*objects.Object, error) {
+	id, ok := row[i.IDField]
+	if !ok || id == nil {
+		return nil, ErrMissingID
+	}
+	return &objects.Object{
+		ID:         fmt.Sprint(id),
+		Collection: i.Collection,
+		Properties: row,
+	}, nil
+}
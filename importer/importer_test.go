@@ -0,0 +1,246 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestImporter(t *testing.T) {
+	suite.Run(t, &ImporterTestSuite{})
+}
+
+type ImporterTestSuite struct {
+	suite.Suite
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (i *ImporterTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := struct {
+			Objects []*objects.Object `json:"objects"`
+		}{}
+		json.NewDecoder(req.Body).Decode(&v)
+
+		ids := []string{}
+		for _, o := range v.Objects {
+			ids = append(ids, o.ID)
+		}
+		i.mu.Lock()
+		i.batches = append(i.batches, ids)
+		i.mu.Unlock()
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (i *ImporterTestSuite) SetupTest() {
+	i.mu.Lock()
+	i.batches = nil
+	i.mu.Unlock()
+}
+
+func rows(rows ...map[string]interface{}) Reader {
+	return ReaderFunc(func() (map[string]interface{}, error) {
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	})
+}
+
+func (i *ImporterTestSuite) TestImport() {
+	imp := New(objects.New("writeKey"), "rooms")
+	imp.BatchSize = 2
+	imp.TotalRows = 4
+	reports := []Progress{}
+	imp.Progress = func(p Progress) {
+		reports = append(reports, p)
+	}
+
+	n, err := imp.Import(context.Background(), rows(
+		map[string]interface{}{"id": 1, "name": "a"},
+		map[string]interface{}{"name": "missing id"},
+		map[string]interface{}{"id": 2, "name": "b"},
+		map[string]interface{}{"id": 3, "name": "c"},
+	))
+	i.NoError(err)
+	i.Equal(3, n)
+	i.Equal([][]string{{"1", "2"}, {"3"}}, i.batches)
+
+	i.Len(reports, 3)
+	i.Equal(3, reports[0].RowsRead)
+	i.Equal(2, reports[0].Delivered)
+	i.Equal(1, reports[0].Failed)
+	i.True(reports[0].BytesSent > 0)
+	i.True(reports[0].ETA > 0)
+	i.False(reports[0].Done)
+
+	last := reports[2]
+	i.Equal(4, last.RowsRead)
+	i.Equal(3, last.Delivered)
+	i.Equal(1, last.Failed)
+	i.Equal(time.Duration(0), last.ETA)
+	i.True(last.Done)
+}
+
+func (i *ImporterTestSuite) TestBar() {
+	out := &bytes.Buffer{}
+	bar := Bar(out, 4)
+
+	bar(Progress{RowsRead: 2, Delivered: 2, BytesSent: 2048, ETA: 3 * time.Second})
+	i.Equal("\r[===============               ]  50% 2 rows, 2 delivered, 0 failed, 2.0KB sent, eta 3s", out.String())
+
+	out.Reset()
+	bar(Progress{RowsRead: 4, Delivered: 4, BytesSent: 10, Done: true})
+	i.Equal("\r[==============================] 100% 4 rows, 4 delivered, 0 failed, 10B sent, eta 0s\n", out.String())
+}
+
+func (i *ImporterTestSuite) TestETAAfterResume() {
+	var last Progress
+	importer := &Importer{TotalRows: 100, Progress: func(p Progress) { last = p }}
+	t := &tracker{importer: importer, start: time.Now().Add(-10 * time.Second)}
+
+	// 10 rows read in 10s after skipping 80: 10 rows remain, about 10s.
+	t.skip(80)
+	t.batch(&objects.Batch{Collection: "c", Objects: make([]*objects.Object, 10)}, nil)
+	i.Equal(90, last.RowsRead)
+	i.True(last.ETA > 9*time.Second && last.ETA < 11*time.Second, "%v", last.ETA)
+}
+
+type checkpoints struct {
+	mu   sync.Mutex
+	rows map[int]int
+}
+
+func (c *checkpoints) Load(partition int) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rows[partition], nil
+}
+
+func (c *checkpoints) Save(partition int, rows int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rows[partition] = rows
+	return nil
+}
+
+func (i *ImporterTestSuite) TestImportPartitions() {
+	imp := New(objects.New("writeKey"), "rooms")
+	imp.BatchSize = 2
+	imp.Checkpoint = &checkpoints{rows: map[int]int{1: 2}}
+	imp.Limiter = NewLimiter(1000, 10)
+
+	n, err := imp.ImportPartitions(context.Background(), []Reader{
+		rows(map[string]interface{}{"id": "a1"}, map[string]interface{}{"id": "a2"}, map[string]interface{}{"id": "a3"}),
+		rows(map[string]interface{}{"id": "b1"}, map[string]interface{}{"id": "b2"}, map[string]interface{}{"id": "b3"}),
+	})
+	i.NoError(err)
+	i.Equal(4, n)
+
+	// Partition 1 resumed after its first two rows.
+	sent := []string{}
+	for _, b := range i.batches {
+		sent = append(sent, b...)
+	}
+	sort.Strings(sent)
+	i.Equal([]string{"a1", "a2", "a3", "b3"}, sent)
+	i.Equal(map[int]int{0: 3, 1: 3}, imp.Checkpoint.(*checkpoints).rows)
+}
+
+func (i *ImporterTestSuite) TestLimiter() {
+	l := NewLimiter(100, 10)
+	start := time.Now()
+	i.NoError(l.WaitN(context.Background(), 10))
+	i.True(time.Since(start) < 10*time.Millisecond)
+
+	// The bucket is empty: 5 more objects take about 50ms.
+	i.NoError(l.WaitN(context.Background(), 5))
+	i.True(time.Since(start) >= 40*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	i.Equal(context.Canceled, l.WaitN(ctx, 100))
+}
+
+func (i *ImporterTestSuite) TestBackfillSession() {
+	var requests int
+	httpmock.RegisterResponder("POST", "https://backfill.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		requests++
+		r, err := gzip.NewReader(req.Body)
+		if err != nil || req.Header.Get("Content-Encoding") != "gzip" {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		v := struct {
+			Objects []*objects.Object `json:"objects"`
+		}{}
+		json.NewDecoder(r).Decode(&v)
+		if len(v.Objects) > 0 && v.Objects[0].ID == "bad" {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	dir, err := ioutil.TempDir("", "backfill")
+	i.NoError(err)
+	defer os.RemoveAll(dir)
+
+	client := NewBackfillClient("writeKey")
+	client.BaseEndpoint = "https://backfill.segment.com"
+	client.RetryNetworkErrorsOnly = true
+	s := NewBackfillSession(client, "rooms")
+	s.BatchSize = 2
+	s.Checkpoint = NewFileCheckpoint(filepath.Join(dir, "checkpoint.json"))
+	delivered := &bytes.Buffer{}
+	s.DeliveredIDs = delivered
+	i.NoError(client.Validate())
+
+	report, err := s.Run(context.Background(), rows(
+		map[string]interface{}{"id": "1"},
+		map[string]interface{}{"id": "2"},
+		map[string]interface{}{"id": "bad"},
+		map[string]interface{}{"id": "3"},
+	))
+	i.Error(err)
+	i.Equal("1\n2\n", delivered.String())
+	i.Len(report.FailedIDs, 2)
+	i.Contains(report.FailedIDs, "bad")
+	i.Equal(2, report.Delivered)
+
+	// A new session resumes after the rows delivered.
+	s = NewBackfillSession(client, "rooms")
+	s.BatchSize = 2
+	s.Checkpoint = NewFileCheckpoint(filepath.Join(dir, "checkpoint.json"))
+	delivered.Reset()
+	s.DeliveredIDs = delivered
+	report, err = s.Run(context.Background(), rows(
+		map[string]interface{}{"id": "1"},
+		map[string]interface{}{"id": "2"},
+		map[string]interface{}{"id": "4"},
+	))
+	i.NoError(err)
+	i.Equal("4\n", delivered.String())
+	i.Empty(report.FailedIDs)
+	i.True(report.Done)
+	i.Equal(3, report.RowsRead)
+	i.Equal(3, requests)
+}
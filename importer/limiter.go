@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter bounds the rate of objects sent. *rate.Limiter from
+// golang.org/x/time/rate satisfies it.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// NewLimiter returns a token bucket Limiter allowing perSecond objects per
+// second on average, with bursts of up to burst objects. Requests larger
+// than burst wait for the tokens they borrow.
+func NewLimiter(perSecond float64, burst int) Limiter {
+	return &limiter{
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
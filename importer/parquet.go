@@ -0,0 +1,952 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotParquet = errors.New("Not a Parquet file")
+)
+
+var parquetMagic = []byte("PAR1")
+
+const (
+	// maxParquetPageSize bounds the size of the footer, of a page and of
+	// the values it holds, decompressed, so a corrupt length cannot
+	// allocate gigabytes.
+	maxParquetPageSize = 64 << 20
+
+	// maxParquetDepth bounds the nesting of groups in a schema.
+	maxParquetDepth = 64
+)
+
+// Parquet physical types.
+const (
+	parquetBoolean = iota
+	parquetInt32
+	parquetInt64
+	parquetInt96
+	parquetFloat
+	parquetDouble
+	parquetByteArray
+	parquetFixed
+
+	// parquetGroup marks groups, which have no physical type.
+	parquetGroup = -1
+)
+
+// Parquet converted types, the legacy annotations still written along with
+// logical types.
+const (
+	convertedUTF8            = 0
+	convertedEnum            = 4
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedUint8           = 11
+	convertedUint64          = 14
+	convertedJSON            = 19
+
+	// convertedNone marks fields without a converted type.
+	convertedNone = -1
+)
+
+// Parquet repetitions.
+const (
+	repetitionOptional = 1
+	repetitionRepeated = 2
+)
+
+// Parquet page types.
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Parquet encodings.
+const (
+	encodingPlain         = 0
+	encodingPlainDict     = 2
+	encodingRLE           = 3
+	encodingRLEDictionary = 8
+)
+
+// Parquet codecs.
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+)
+
+// INT96 timestamps count days from the Julian epoch.
+const julianDayOfUnixEpoch = 2440588
+
+// NewParquetReader returns a Reader over the Parquet file r of size bytes.
+// Values are mapped using the file's schema: groups become nested maps, the
+// UTF8, ENUM and JSON annotations strings, the DATE and TIMESTAMP ones and
+// INT96 values time.Time, and decimals json.Number. Repeated fields are not
+// supported. The uncompressed, snappy and gzip codecs, the PLAIN and
+// dictionary encodings and both data page versions are supported.
+func NewParquetReader(r io.ReaderAt, size int64) (Reader, error) {
+	if size < 12 {
+		return nil, ErrNotParquet
+	}
+	head := make([]byte, 4)
+	tail := make([]byte, 8)
+	if _, err := r.ReadAt(head, 0); err != nil || !bytes.Equal(head, parquetMagic) {
+		return nil, ErrNotParquet
+	}
+	if _, err := r.ReadAt(tail, size-8); err != nil || !bytes.Equal(tail[4:], parquetMagic) {
+		return nil, ErrNotParquet
+	}
+
+	n := int64(binary.LittleEndian.Uint32(tail))
+	if n > maxParquetPageSize || n > size-12 {
+		return nil, fmt.Errorf("Invalid Parquet footer of %d bytes", n)
+	}
+	meta, err := readThriftStruct(bufio.NewReader(io.NewSectionReader(r, size-8-n, n)))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parquetReader{r: r}
+	elements := meta.list(2)
+	if len(elements) == 0 {
+		return nil, errors.New("Parquet file has no schema")
+	}
+	root, next, err := p.parseSchema(elements, 0, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if next != len(elements) || root.typ != parquetGroup {
+		return nil, errors.New("Invalid Parquet schema")
+	}
+	for _, g := range meta.list(4) {
+		if rg, ok := g.(thriftStruct); ok {
+			p.groups = append(p.groups, rg)
+		}
+	}
+	return p, nil
+}
+
+type parquetReader struct {
+	r      io.ReaderAt
+	leaves []*parquetNode
+	groups []thriftStruct
+
+	columns   []*parquetColumn
+	remaining int64
+}
+
+// parquetNode is a field of the schema. Leaves hold the path of fields from
+// the root and their maximum definition level.
+type parquetNode struct {
+	name      string
+	typ       int
+	length    int
+	optional  bool
+	converted int
+	logical   thriftStruct
+	scale     int
+
+	path   []*parquetNode
+	maxDef int
+}
+
+func (p *parquetReader) Read() (map[string]interface{}, error) {
+	for p.remaining == 0 {
+		if len(p.groups) == 0 {
+			return nil, io.EOF
+		}
+		if err := p.nextGroup(); err != nil {
+			return nil, err
+		}
+	}
+
+	row := map[string]interface{}{}
+	for _, c := range p.columns {
+		v, def, err := c.next()
+		if err != nil {
+			return nil, fmt.Errorf("Parquet column `%s`: %v", c.leaf.name, err)
+		}
+		c.leaf.set(row, v, def)
+	}
+	p.remaining--
+	return row, nil
+}
+
+// nextGroup starts reading the next row group.
+func (p *parquetReader) nextGroup() error {
+	rg := p.groups[0]
+	p.groups = p.groups[1:]
+
+	chunks := map[string]thriftStruct{}
+	for _, c := range rg.list(1) {
+		chunk, _ := c.(thriftStruct)
+		meta := chunk.st(3)
+		if meta == nil {
+			return errors.New("Parquet column chunk has no metadata")
+		}
+		if chunk.str(1) != "" {
+			return errors.New("Parquet column chunks in other files are not supported")
+		}
+		var path []string
+		for _, name := range meta.list(3) {
+			b, _ := name.([]byte)
+			path = append(path, string(b))
+		}
+		chunks[strings.Join(path, ".")] = meta
+	}
+
+	p.columns = p.columns[:0]
+	for _, leaf := range p.leaves {
+		meta, ok := chunks[leaf.key()]
+		if !ok {
+			return fmt.Errorf("Parquet column `%s` is missing from a row group", leaf.key())
+		}
+		offset := meta.int(9)
+		if meta.has(11) && meta.int(11) > 0 && meta.int(11) < offset {
+			offset = meta.int(11)
+		}
+		size := meta.int(7)
+		if offset < 0 || size < 0 {
+			return fmt.Errorf("Invalid Parquet column chunk `%s`", leaf.key())
+		}
+		p.columns = append(p.columns, &parquetColumn{
+			leaf:  leaf,
+			codec: int(meta.int(4)),
+			r:     bufio.NewReader(io.NewSectionReader(p.r, offset, size)),
+		})
+	}
+	p.remaining = rg.int(3)
+	return nil
+}
+
+// parseSchema parses the schema element at i and its children, which follow
+// it depth first. It returns the index of the next element.
+func (p *parquetReader) parseSchema(elements []interface{}, i int, path []*parquetNode, def int) (*parquetNode, int, error) {
+	e, ok := elements[i].(thriftStruct)
+	if !ok {
+		return nil, 0, errors.New("Invalid Parquet schema")
+	}
+	n := &parquetNode{
+		name:      e.str(4),
+		typ:       parquetGroup,
+		length:    int(e.int(2)),
+		converted: convertedNone,
+		logical:   e.st(10),
+		scale:     int(e.int(7)),
+	}
+	if e.has(1) {
+		n.typ = int(e.int(1))
+	}
+	if e.has(6) {
+		n.converted = int(e.int(6))
+	}
+
+	// The root is not part of the paths.
+	if i > 0 {
+		switch e.int(3) {
+		case repetitionRepeated:
+			return nil, 0, fmt.Errorf("Parquet field `%s` is repeated, which is not supported", n.name)
+		case repetitionOptional:
+			n.optional = true
+			def++
+		}
+		path = append(path[:len(path):len(path)], n)
+	}
+	if len(path) > maxParquetDepth {
+		return nil, 0, errors.New("Parquet schema is nested too deep")
+	}
+
+	next := i + 1
+	if n.typ != parquetGroup {
+		n.path, n.maxDef = path, def
+		p.leaves = append(p.leaves, n)
+		return n, next, nil
+	}
+	for c := int64(0); c < e.int(5); c++ {
+		if next >= len(elements) {
+			return nil, 0, errors.New("Invalid Parquet schema")
+		}
+		var err error
+		if _, next, err = p.parseSchema(elements, next, path, def); err != nil {
+			return nil, 0, err
+		}
+	}
+	return n, next, nil
+}
+
+// key returns the dotted path of a leaf, as in column chunks.
+func (n *parquetNode) key() string {
+	names := make([]string, len(n.path))
+	for i, f := range n.path {
+		names[i] = f.name
+	}
+	return strings.Join(names, ".")
+}
+
+// set stores v, the value of leaf n with definition level def, in row. A
+// level below the maximum means the leaf or one of its groups is null.
+func (n *parquetNode) set(row map[string]interface{}, v interface{}, def int) {
+	m := row
+	level := 0
+	for i, f := range n.path {
+		if f.optional {
+			level++
+			if def < level {
+				if _, ok := m[f.name]; !ok {
+					m[f.name] = nil
+				}
+				return
+			}
+		}
+		if i == len(n.path)-1 {
+			m[f.name] = v
+			return
+		}
+		child, ok := m[f.name].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[f.name] = child
+		}
+		m = child
+	}
+}
+
+// convert maps a physical value of leaf n to its logical type.
+func (n *parquetNode) convert(v interface{}) interface{} {
+	logical := func(id int16) bool { return n.logical.has(id) }
+
+	switch x := v.(type) {
+	case int32:
+		switch {
+		case logical(6) || n.converted == convertedDate:
+			return time.Unix(int64(x)*86400, 0).UTC()
+		case logical(5) || n.converted == convertedDecimal:
+			return decimalInt(big.NewInt(int64(x)), n.decimalScale())
+		case n.unsigned():
+			return int64(uint32(x))
+		}
+		return int64(x)
+
+	case int64:
+		switch {
+		case logical(5) || n.converted == convertedDecimal:
+			return decimalInt(big.NewInt(x), n.decimalScale())
+		case n.unsigned():
+			return uint64(x)
+		}
+		unit := int16(0)
+		if ts := n.logical.st(8); ts != nil {
+			for id := int16(1); id <= 3; id++ {
+				if ts.st(2).has(id) {
+					unit = id
+				}
+			}
+		} else if n.converted == convertedTimestampMillis {
+			unit = 1
+		} else if n.converted == convertedTimestampMicros {
+			unit = 2
+		}
+		switch unit {
+		case 1:
+			return time.Unix(0, x*int64(time.Millisecond)).UTC()
+		case 2:
+			return time.Unix(0, x*int64(time.Microsecond)).UTC()
+		case 3:
+			return time.Unix(0, x).UTC()
+		}
+		return x
+
+	case []byte:
+		switch {
+		case logical(5) || n.converted == convertedDecimal:
+			return decimal(x, n.decimalScale())
+		case logical(1) || logical(4) || logical(12) ||
+			n.converted == convertedUTF8 || n.converted == convertedEnum || n.converted == convertedJSON:
+			return string(x)
+		}
+		return x
+	}
+	return v
+}
+
+// unsigned reports whether an integer leaf is annotated as unsigned.
+func (n *parquetNode) unsigned() bool {
+	if i := n.logical.st(10); i != nil {
+		signed, ok := i[2].(bool)
+		return ok && !signed
+	}
+	return n.converted >= convertedUint8 && n.converted <= convertedUint64
+}
+
+func (n *parquetNode) decimalScale() int {
+	if d := n.logical.st(5); d != nil {
+		return int(d.int(1))
+	}
+	return n.scale
+}
+
+// decimalInt formats an unscaled integer decimal.
+func decimalInt(n *big.Int, scale int) json.Number {
+	r := new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	return json.Number(r.FloatString(scale))
+}
+
+// parquetColumn reads the values of a leaf in a row group, one page at a
+// time.
+type parquetColumn struct {
+	leaf  *parquetNode
+	codec int
+	r     *bufio.Reader
+	dict  []interface{}
+
+	// levels are the definition levels of the current page, nil if the leaf
+	// is required, and values its non-null values.
+	levels []int
+	values []interface{}
+	n      int
+	pos    int
+	vpos   int
+}
+
+// next returns the next value and its definition level.
+func (c *parquetColumn) next() (interface{}, int, error) {
+	for c.pos == c.n {
+		if err := c.readPage(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	def := c.leaf.maxDef
+	if c.levels != nil {
+		def = c.levels[c.pos]
+	}
+	c.pos++
+	if def < c.leaf.maxDef {
+		return nil, def, nil
+	}
+	if c.vpos == len(c.values) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	v := c.values[c.vpos]
+	c.vpos++
+	return v, def, nil
+}
+
+func (c *parquetColumn) readPage() error {
+	h, err := readThriftStruct(c.r)
+	if err != nil {
+		return err
+	}
+	size, rawSize := h.int(3), h.int(2)
+	if size < 0 || size > maxParquetPageSize || rawSize < 0 || rawSize > maxParquetPageSize {
+		return fmt.Errorf("Invalid Parquet page of %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return unexpected(err)
+	}
+
+	switch h.int(1) {
+	case pageDictionary:
+		if data, err = c.decompress(data); err != nil {
+			return err
+		}
+		dh := h.st(7)
+		c.dict, err = c.plain(data, int(dh.int(1)))
+		return err
+
+	case pageData:
+		dh := h.st(5)
+		if dh == nil {
+			return errors.New("Parquet data page has no header")
+		}
+		if data, err = c.decompress(data); err != nil {
+			return err
+		}
+		n := int(dh.int(1))
+		if c.leaf.maxDef > 0 {
+			if dh.int(3) != encodingRLE {
+				return fmt.Errorf("Parquet definition level encoding %d is not supported", dh.int(3))
+			}
+			if len(data) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			length := int(binary.LittleEndian.Uint32(data))
+			if length < 0 || 4+length > len(data) {
+				return io.ErrUnexpectedEOF
+			}
+			if err := c.readLevels(data[4:4+length], n); err != nil {
+				return err
+			}
+			data = data[4+length:]
+		} else {
+			c.levels = nil
+		}
+		return c.readValues(data, n, int(dh.int(2)))
+
+	case pageDataV2:
+		dh := h.st(8)
+		if dh == nil {
+			return errors.New("Parquet data page has no header")
+		}
+		n := int(dh.int(1))
+		repLen, defLen := int(dh.int(6)), int(dh.int(5))
+		if repLen < 0 || defLen < 0 || repLen+defLen > len(data) {
+			return io.ErrUnexpectedEOF
+		}
+		if c.leaf.maxDef > 0 {
+			if err := c.readLevels(data[repLen:repLen+defLen], n); err != nil {
+				return err
+			}
+		} else {
+			c.levels = nil
+		}
+		values := data[repLen+defLen:]
+		if compressed, ok := dh[7].(bool); !ok || compressed {
+			if values, err = c.decompress(values); err != nil {
+				return err
+			}
+		}
+		return c.readValues(values, n, int(dh.int(4)))
+	}
+
+	// Index pages are skipped.
+	return nil
+}
+
+// readLevels decodes the n definition levels of a page.
+func (c *parquetColumn) readLevels(data []byte, n int) error {
+	levels, err := readHybrid(data, bitWidth(c.leaf.maxDef), n)
+	if err != nil {
+		return err
+	}
+	c.levels = levels
+	return nil
+}
+
+// readValues decodes the non-null values of a page of n values.
+func (c *parquetColumn) readValues(data []byte, n, encoding int) error {
+	count := n
+	if c.levels != nil {
+		count = 0
+		for _, def := range c.levels {
+			if def == c.leaf.maxDef {
+				count++
+			}
+		}
+	}
+
+	var err error
+	switch encoding {
+	case encodingPlain:
+		c.values, err = c.plain(data, count)
+	case encodingPlainDict, encodingRLEDictionary:
+		if len(data) == 0 {
+			if count > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			c.values = nil
+			break
+		}
+		var indexes []int
+		if indexes, err = readHybrid(data[1:], int(data[0]), count); err != nil {
+			return err
+		}
+		c.values = make([]interface{}, count)
+		for i, index := range indexes {
+			if index >= len(c.dict) {
+				return fmt.Errorf("Parquet dictionary index %d out of range", index)
+			}
+			c.values[i] = c.dict[index]
+		}
+	default:
+		return fmt.Errorf("Parquet encoding %d is not supported", encoding)
+	}
+	if err != nil {
+		return err
+	}
+	c.n, c.pos, c.vpos = n, 0, 0
+	return nil
+}
+
+// plain decodes n PLAIN encoded values.
+func (c *parquetColumn) plain(data []byte, n int) ([]interface{}, error) {
+	if n < 0 || n > maxParquetPageSize {
+		return nil, fmt.Errorf("Invalid Parquet value count %d", n)
+	}
+	values := make([]interface{}, n)
+	for i := range values {
+		var v interface{}
+		switch c.leaf.typ {
+		case parquetBoolean:
+			if i/8 >= len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = data[i/8]>>(uint(i)%8)&1 == 1
+		case parquetInt32, parquetFloat:
+			if len(data) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			bits := binary.LittleEndian.Uint32(data)
+			data = data[4:]
+			if c.leaf.typ == parquetFloat {
+				v = math.Float32frombits(bits)
+			} else {
+				v = int32(bits)
+			}
+		case parquetInt64, parquetDouble:
+			if len(data) < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			bits := binary.LittleEndian.Uint64(data)
+			data = data[8:]
+			if c.leaf.typ == parquetDouble {
+				v = math.Float64frombits(bits)
+			} else {
+				v = int64(bits)
+			}
+		case parquetInt96:
+			// Nanoseconds of the day, then the Julian day.
+			if len(data) < 12 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			nanos := int64(binary.LittleEndian.Uint64(data))
+			day := int64(int32(binary.LittleEndian.Uint32(data[8:])))
+			data = data[12:]
+			v = time.Unix((day-julianDayOfUnixEpoch)*86400, nanos).UTC()
+		case parquetByteArray:
+			if len(data) < 4 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length := int(binary.LittleEndian.Uint32(data))
+			if length < 0 || 4+length > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = data[4 : 4+length : 4+length]
+			data = data[4+length:]
+		case parquetFixed:
+			if c.leaf.length < 0 || len(data) < c.leaf.length {
+				return nil, io.ErrUnexpectedEOF
+			}
+			v = data[:c.leaf.length:c.leaf.length]
+			data = data[c.leaf.length:]
+		default:
+			return nil, fmt.Errorf("Parquet type %d is not supported", c.leaf.typ)
+		}
+		values[i] = c.leaf.convert(v)
+	}
+	return values, nil
+}
+
+func (c *parquetColumn) decompress(data []byte) ([]byte, error) {
+	switch c.codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		return snappyDecode(data)
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		out, err := ioutil.ReadAll(io.LimitReader(r, maxParquetPageSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxParquetPageSize {
+			return nil, fmt.Errorf("Parquet page exceeds %d bytes once decompressed", maxParquetPageSize)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("Parquet codec %d is not supported", c.codec)
+}
+
+// bitWidth returns the number of bits needed to store max.
+func bitWidth(max int) int {
+	n := 0
+	for ; max > 0; max >>= 1 {
+		n++
+	}
+	return n
+}
+
+// readHybrid decodes n values of the RLE/bit-packing hybrid encoding.
+func readHybrid(data []byte, width, n int) ([]int, error) {
+	if width > 32 {
+		return nil, fmt.Errorf("Invalid Parquet bit width %d", width)
+	}
+	values := make([]int, 0, n)
+	r := bytes.NewReader(data)
+	for len(values) < n {
+		header, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+
+		if header&1 == 0 {
+			// A run of one value, stored in whole bytes.
+			count := int(header >> 1)
+			b := make([]byte, 4)
+			if _, err := io.ReadFull(r, b[:(width+7)/8]); err != nil {
+				return nil, unexpected(err)
+			}
+			v := int(binary.LittleEndian.Uint32(b))
+			for i := 0; i < count && len(values) < n; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// Groups of 8 values, packed least significant bit first.
+		groups := int(header >> 1)
+		if groups*width > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		packed := make([]byte, groups*width)
+		io.ReadFull(r, packed)
+		for i := 0; i < groups*8 && len(values) < n; i++ {
+			v := 0
+			for bit := 0; bit < width; bit++ {
+				pos := i*width + bit
+				v |= int(packed[pos/8]>>(uint(pos)%8)&1) << uint(bit)
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// snappyDecode decodes a snappy block, as used by Parquet pages.
+func snappyDecode(src []byte) ([]byte, error) {
+	corrupt := errors.New("Parquet page is not valid snappy")
+	size, k := binary.Uvarint(src)
+	if k <= 0 || size > maxParquetPageSize {
+		return nil, corrupt
+	}
+	dst := make([]byte, 0, size)
+	for s := k; s < len(src); {
+		tag := src[s]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			s++
+			if length >= 60 {
+				nb := length - 59
+				if s+nb > len(src) {
+					return nil, corrupt
+				}
+				length = 0
+				for i := nb - 1; i >= 0; i-- {
+					length = length<<8 | int(src[s+i])
+				}
+				s += nb
+			}
+			length++
+			if length <= 0 || s+length > len(src) || len(dst)+length > int(size) {
+				return nil, corrupt
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, corrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case 2:
+			if s+3 > len(src) {
+				return nil, corrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 3:
+			if s+5 > len(src) {
+				return nil, corrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, corrupt
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, corrupt
+	}
+	return dst, nil
+}
+
+// thriftStruct is a struct decoded with the Thrift compact protocol, used by
+// Parquet metadata, by field ID. Integers are int64, binaries []byte, lists
+// []interface{} and structs thriftStruct.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) int(id int16) int64 {
+	n, _ := s[id].(int64)
+	return n
+}
+
+func (s thriftStruct) str(id int16) string {
+	b, _ := s[id].([]byte)
+	return string(b)
+}
+
+func (s thriftStruct) st(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func readThriftStruct(r avroByteReader) (thriftStruct, error) {
+	s := thriftStruct{}
+	var id int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if b == 0 {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			n, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			id = int16(n)
+		}
+		if s[id], err = readThriftValue(r, b&0x0f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readThriftValue(r avroByteReader, typ byte) (interface{}, error) {
+	switch typ {
+	case 1:
+		return true, nil
+	case 2:
+		return false, nil
+	case 3:
+		b, err := r.ReadByte()
+		return int64(int8(b)), unexpected(err)
+	case 4, 5, 6:
+		n, err := binary.ReadVarint(r)
+		return n, unexpected(err)
+	case 7:
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, unexpected(err)
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case 8:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if n > maxParquetPageSize {
+			return nil, fmt.Errorf("Thrift length %d exceeds %d bytes", n, maxParquetPageSize)
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, unexpected(err)
+	case 9, 10:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		size, elem := uint64(b>>4), b&0x0f
+		if size == 15 {
+			if size, err = binary.ReadUvarint(r); err != nil {
+				return nil, unexpected(err)
+			}
+		}
+		if size > maxParquetPageSize {
+			return nil, fmt.Errorf("Thrift list of %d elements is too long", size)
+		}
+		list := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			v, err := readThriftElem(r, elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case 11:
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		if size > maxParquetPageSize {
+			return nil, fmt.Errorf("Thrift map of %d entries is too long", size)
+		}
+		kv, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		// Maps are not used by the fields read, so they are skipped.
+		for i := uint64(0); i < 2*size; i++ {
+			typ := kv >> 4
+			if i%2 == 1 {
+				typ = kv & 0x0f
+			}
+			if _, err := readThriftElem(r, typ); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case 12:
+		return readThriftStruct(r)
+	}
+	return nil, fmt.Errorf("Unsupported Thrift type %d", typ)
+}
+
+// readThriftElem reads an element of a list or map. Unlike fields, booleans
+// take a byte each.
+func readThriftElem(r avroByteReader, typ byte) (interface{}, error) {
+	if typ == 1 || typ == 2 {
+		b, err := r.ReadByte()
+		return b == 1, unexpected(err)
+	}
+	return readThriftValue(r, typ)
+}
@@ -0,0 +1,362 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestParquet(t *testing.T) {
+	suite.Run(t, &ParquetTestSuite{})
+}
+
+type ParquetTestSuite struct {
+	suite.Suite
+}
+
+// tstruct is a Thrift struct written with the compact protocol, its fields
+// in increasing ID order.
+type tstruct []tfield
+
+type tfield struct {
+	id int16
+	v  interface{}
+}
+
+func (s tstruct) write(w *bytes.Buffer) {
+	var last int16
+	for _, f := range s {
+		var typ byte
+		switch v := f.v.(type) {
+		case bool:
+			typ = 2
+			if v {
+				typ = 1
+			}
+		case int32:
+			typ = 5
+		case int64:
+			typ = 6
+		case string:
+			typ = 8
+		case []string, []tstruct:
+			typ = 9
+		case tstruct:
+			typ = 12
+		}
+		w.WriteByte(byte(f.id-last)<<4 | typ)
+		last = f.id
+		writeThrift(w, f.v)
+	}
+	w.WriteByte(0)
+}
+
+func writeThrift(w *bytes.Buffer, v interface{}) {
+	b := make([]byte, binary.MaxVarintLen64)
+	switch v := v.(type) {
+	case int32:
+		w.Write(b[:binary.PutVarint(b, int64(v))])
+	case int64:
+		w.Write(b[:binary.PutVarint(b, v)])
+	case string:
+		w.Write(b[:binary.PutUvarint(b, uint64(len(v)))])
+		w.WriteString(v)
+	case []string:
+		w.WriteByte(byte(len(v))<<4 | 8)
+		for _, s := range v {
+			writeThrift(w, s)
+		}
+	case []tstruct:
+		w.WriteByte(byte(len(v))<<4 | 12)
+		for _, s := range v {
+			s.write(w)
+		}
+	case tstruct:
+		v.write(w)
+	}
+}
+
+// hybrid encodes values with the RLE/bit-packing hybrid encoding, as runs of
+// one value.
+func hybrid(width int, values ...int) []byte {
+	w := &bytes.Buffer{}
+	for _, v := range values {
+		w.WriteByte(2)
+		for i := 0; i < (width+7)/8; i++ {
+			w.WriteByte(byte(v >> uint(8*i)))
+		}
+	}
+	return w.Bytes()
+}
+
+func plain(values ...interface{}) []byte {
+	w := &bytes.Buffer{}
+	var bits byte
+	for i, v := range values {
+		switch v := v.(type) {
+		case bool:
+			if v {
+				bits |= 1 << uint(i)
+			}
+			if i == len(values)-1 {
+				w.WriteByte(bits)
+			}
+		case string:
+			binary.Write(w, binary.LittleEndian, uint32(len(v)))
+			w.WriteString(v)
+		default:
+			binary.Write(w, binary.LittleEndian, v)
+		}
+	}
+	return w.Bytes()
+}
+
+// compressPage compresses data with codec, writing snappy as literals only.
+func compressPage(codec int32, data []byte) []byte {
+	w := &bytes.Buffer{}
+	switch codec {
+	case codecGzip:
+		gw := gzip.NewWriter(w)
+		gw.Write(data)
+		gw.Close()
+	case codecSnappy:
+		b := make([]byte, binary.MaxVarintLen64)
+		w.Write(b[:binary.PutUvarint(b, uint64(len(data)))])
+		for len(data) > 0 {
+			n := len(data)
+			if n > 256 {
+				n = 256
+			}
+			w.WriteByte(60 << 2)
+			w.WriteByte(byte(n - 1))
+			w.Write(data[:n])
+			data = data[n:]
+		}
+	default:
+		w.Write(data)
+	}
+	return w.Bytes()
+}
+
+type parquetPage struct {
+	typ      int32
+	n        int32
+	levels   []int
+	maxDef   int
+	encoding int32
+	values   []byte
+}
+
+func (p parquetPage) write(w *bytes.Buffer, codec int32) {
+	var levels []byte
+	if p.levels != nil {
+		levels = hybrid(bitWidth(p.maxDef), p.levels...)
+	}
+
+	var header tstruct
+	var body []byte
+	switch p.typ {
+	case pageDictionary:
+		body = compressPage(codec, p.values)
+		header = tstruct{{1, p.typ}, {2, int32(len(p.values))}, {3, int32(len(body))},
+			{7, tstruct{{1, p.n}, {2, int32(encodingPlain)}}}}
+	case pageData:
+		raw := &bytes.Buffer{}
+		if levels != nil {
+			binary.Write(raw, binary.LittleEndian, uint32(len(levels)))
+			raw.Write(levels)
+		}
+		raw.Write(p.values)
+		body = compressPage(codec, raw.Bytes())
+		header = tstruct{{1, p.typ}, {2, int32(raw.Len())}, {3, int32(len(body))},
+			{5, tstruct{{1, p.n}, {2, p.encoding}, {3, int32(encodingRLE)}, {4, int32(encodingRLE)}}}}
+	case pageDataV2:
+		body = append(levels, compressPage(codec, p.values)...)
+		header = tstruct{{1, p.typ}, {2, int32(len(levels) + len(p.values))}, {3, int32(len(body))},
+			{8, tstruct{{1, p.n}, {2, int32(0)}, {3, p.n}, {4, p.encoding}, {5, int32(len(levels))}, {6, int32(0)}}}}
+	}
+	header.write(w)
+	w.Write(body)
+}
+
+type parquetChunk struct {
+	path  []string
+	pages []parquetPage
+}
+
+func parquetFile(codec int32, rows int64, schema []tstruct, chunks ...parquetChunk) []byte {
+	w := &bytes.Buffer{}
+	w.Write(parquetMagic)
+
+	var columns []tstruct
+	for _, c := range chunks {
+		offset := int64(w.Len())
+		for _, p := range c.pages {
+			p.write(w, codec)
+		}
+		columns = append(columns, tstruct{{2, offset}, {3, tstruct{
+			{1, int32(0)},
+			{2, []string{}},
+			{3, c.path},
+			{4, codec},
+			{5, rows},
+			{6, int64(w.Len()) - offset},
+			{7, int64(w.Len()) - offset},
+			{9, offset},
+		}}})
+	}
+
+	footer := &bytes.Buffer{}
+	tstruct{
+		{1, int32(1)},
+		{2, schema},
+		{3, rows},
+		{4, []tstruct{{{1, columns}, {2, int64(0)}, {3, rows}}}},
+	}.write(footer)
+	w.Write(footer.Bytes())
+	binary.Write(w, binary.LittleEndian, uint32(footer.Len()))
+	w.Write(parquetMagic)
+	return w.Bytes()
+}
+
+// int96 encodes t as nanoseconds of the day and Julian day.
+func int96(t time.Time) []byte {
+	b := make([]byte, 12)
+	day := t.Unix() / 86400
+	binary.LittleEndian.PutUint64(b, uint64(t.Sub(time.Unix(day*86400, 0))))
+	binary.LittleEndian.PutUint32(b[8:], uint32(day+julianDayOfUnixEpoch))
+	return b
+}
+
+var parquetSchema = []tstruct{
+	{{4, "schema"}, {5, int32(7)}},
+	{{1, int32(parquetInt64)}, {3, int32(0)}, {4, "id"}},
+	{{1, int32(parquetByteArray)}, {3, int32(1)}, {4, "name"}, {6, int32(convertedUTF8)}},
+	{{1, int32(parquetDouble)}, {3, int32(1)}, {4, "rating"}},
+	{{1, int32(parquetInt64)}, {3, int32(0)}, {4, "listed_at"},
+		{10, tstruct{{8, tstruct{{1, true}, {2, tstruct{{1, tstruct{}}}}}}}}},
+	{{1, int32(parquetInt32)}, {3, int32(0)}, {4, "price"}, {6, int32(convertedDecimal)}, {7, int32(2)}, {8, int32(6)}},
+	{{3, int32(1)}, {4, "owner"}, {5, int32(2)}},
+	{{1, int32(parquetByteArray)}, {3, int32(0)}, {4, "name"}, {10, tstruct{{1, tstruct{}}}}},
+	{{1, int32(parquetBoolean)}, {3, int32(0)}, {4, "verified"}},
+	{{1, int32(parquetInt96)}, {3, int32(0)}, {4, "created"}},
+}
+
+var listedAt = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func roomsParquet(codec int32) []byte {
+	created := listedAt.Add(90 * time.Minute)
+	return parquetFile(codec, 3, parquetSchema,
+		// Two pages.
+		parquetChunk{[]string{"id"}, []parquetPage{
+			{typ: pageData, n: 2, values: plain(int64(1), int64(2))},
+			{typ: pageData, n: 1, values: plain(int64(3))},
+		}},
+		// Dictionary encoded.
+		parquetChunk{[]string{"name"}, []parquetPage{
+			{typ: pageDictionary, n: 1, values: plain("a")},
+			{typ: pageData, n: 3, levels: []int{1, 0, 1}, maxDef: 1, encoding: encodingRLEDictionary,
+				values: append([]byte{1}, hybrid(1, 0, 0)...)},
+		}},
+		parquetChunk{[]string{"rating"}, []parquetPage{
+			{typ: pageDataV2, n: 3, levels: []int{1, 0, 1}, maxDef: 1, values: plain(4.5, 1.0)},
+		}},
+		parquetChunk{[]string{"listed_at"}, []parquetPage{
+			{typ: pageData, n: 3, values: plain(listedAt.UnixNano()/1e6, int64(0), int64(0))},
+		}},
+		parquetChunk{[]string{"price"}, []parquetPage{
+			{typ: pageData, n: 3, values: plain(int32(-500), int32(0), int32(1999))},
+		}},
+		parquetChunk{[]string{"owner", "name"}, []parquetPage{
+			{typ: pageData, n: 3, levels: []int{1, 0, 1}, maxDef: 1, values: plain("calvin", "hobbes")},
+		}},
+		parquetChunk{[]string{"owner", "verified"}, []parquetPage{
+			{typ: pageData, n: 3, levels: []int{1, 0, 1}, maxDef: 1, values: plain(true, false)},
+		}},
+		parquetChunk{[]string{"created"}, []parquetPage{
+			{typ: pageData, n: 3, values: append(append(int96(created), int96(created)...), int96(created)...)},
+		}},
+	)
+}
+
+func (p *ParquetTestSuite) read(file []byte) []map[string]interface{} {
+	r, err := NewParquetReader(bytes.NewReader(file), int64(len(file)))
+	p.Require().NoError(err)
+
+	rows := []map[string]interface{}{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		p.Require().NoError(err)
+		rows = append(rows, row)
+	}
+}
+
+func (p *ParquetTestSuite) TestRead() {
+	for _, codec := range []int32{codecUncompressed, codecSnappy, codecGzip} {
+		rows := p.read(roomsParquet(codec))
+		p.Require().Len(rows, 3)
+
+		row := rows[0]
+		p.Equal(int64(1), row["id"])
+		p.Equal("a", row["name"])
+		p.Equal(4.5, row["rating"])
+		p.Equal(listedAt, row["listed_at"])
+		p.Equal(json.Number("-5.00"), row["price"])
+		p.Equal(map[string]interface{}{"name": "calvin", "verified": true}, row["owner"])
+		p.Equal(listedAt.Add(90*time.Minute), row["created"])
+
+		p.Equal(map[string]interface{}{
+			"id":        int64(2),
+			"name":      nil,
+			"rating":    nil,
+			"listed_at": time.Unix(0, 0).UTC(),
+			"price":     json.Number("0.00"),
+			"owner":     nil,
+			"created":   listedAt.Add(90 * time.Minute),
+		}, rows[1])
+
+		p.Equal(int64(3), rows[2]["id"])
+		p.Equal("a", rows[2]["name"])
+		p.Equal(1.0, rows[2]["rating"])
+		p.Equal(json.Number("19.99"), rows[2]["price"])
+		p.Equal(map[string]interface{}{"name": "hobbes", "verified": false}, rows[2]["owner"])
+	}
+}
+
+func (p *ParquetTestSuite) TestSnappy() {
+	// A literal followed by a copy of it.
+	data, err := snappyDecode([]byte{9, 2 << 2, 'a', 'b', 'c', (6-4)<<2 | 1, 3})
+	p.NoError(err)
+	p.Equal("abcabcabc", string(data))
+
+	_, err = snappyDecode([]byte{9, 2 << 2, 'a', 'b', 'c', (6-4)<<2 | 1, 4})
+	p.Error(err)
+}
+
+func (p *ParquetTestSuite) TestErrors() {
+	file := avroFile("null")
+	_, err := NewParquetReader(bytes.NewReader(file), int64(len(file)))
+	p.Equal(ErrNotParquet, err)
+
+	// A corrupt footer length fails instead of being allocated.
+	file = roomsParquet(codecUncompressed)
+	binary.LittleEndian.PutUint32(file[len(file)-8:], math.MaxUint32)
+	_, err = NewParquetReader(bytes.NewReader(file), int64(len(file)))
+	p.Error(err)
+
+	repeated := append([]tstruct{}, parquetSchema...)
+	repeated[1] = tstruct{{1, int32(parquetInt64)}, {3, int32(repetitionRepeated)}, {4, "id"}}
+	file = parquetFile(codecUncompressed, 0, repeated)
+	_, err = NewParquetReader(bytes.NewReader(file), int64(len(file)))
+	p.EqualError(err, "Parquet field `id` is repeated, which is not supported")
+}
@@ -0,0 +1,136 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/objects-go"
+)
+
+// Progress is reported after every batch and once the import completes.
+type Progress struct {
+	// RowsRead includes rows skipped when resuming from a checkpoint.
+	RowsRead  int
+	Delivered int
+	Failed    int
+	BytesSent int64
+	Elapsed   time.Duration
+
+	// ETA is the estimated remaining time, zero when Importer.TotalRows is
+	// unknown. It is estimated from the rate of the rows read since the
+	// import started, not counting those skipped when resuming.
+	ETA time.Duration
+
+	Done bool
+}
+
+// Percent returns the completion ratio in [0, 1], or -1 when total is unknown.
+func (p Progress) Percent(total int) float64 {
+	if total <= 0 {
+		return -1
+	}
+	if p.Done {
+		return 1
+	}
+	return float64(p.RowsRead) / float64(total)
+}
+
+// tracker aggregates the progress of every partition of an import.
+type tracker struct {
+	importer *Importer
+	start    time.Time
+
+	mu sync.Mutex
+	p  Progress
+
+	// skipped are the rows skipped when resuming, read before start.
+	skipped int
+}
+
+func (t *tracker) skip(rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.RowsRead += rows
+	t.skipped += rows
+}
+
+func (t *tracker) fail() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.RowsRead++
+	t.p.Failed++
+}
+
+func (t *tracker) batch(b *objects.Batch, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.RowsRead += len(b.Objects)
+	if err != nil {
+		t.p.Failed += len(b.Objects)
+	} else {
+		t.p.Delivered += len(b.Objects)
+		t.p.BytesSent += int64(b.Bytes)
+	}
+	t.report()
+}
+
+func (t *tracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done = true
+	t.report()
+}
+
+func (t *tracker) delivered() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.p.Delivered
+}
+
+func (t *tracker) report() {
+	i := t.importer
+	if i.Progress == nil {
+		return
+	}
+
+	p := &t.p
+	p.Elapsed = time.Since(t.start)
+	p.ETA = 0
+	if read := p.RowsRead - t.skipped; i.TotalRows > 0 && read > 0 && p.RowsRead < i.TotalRows {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(i.TotalRows-p.RowsRead) / float64(read))
+	}
+	i.Progress(*p)
+}
+
+// Bar returns a Progress callback drawing a single-line progress bar on w,
+// typically os.Stderr. total may be zero when unknown.
+func Bar(w io.Writer, total int) func(Progress) {
+	const width = 30
+	return func(p Progress) {
+		bar := ""
+		if pct := p.Percent(total); pct >= 0 {
+			n := int(pct * width)
+			bar = fmt.Sprintf("[%s%s] %3.0f%% ", strings.Repeat("=", n), strings.Repeat(" ", width-n), pct*100)
+		}
+		fmt.Fprintf(w, "\r%s%d rows, %d delivered, %d failed, %s sent, eta %s",
+			bar, p.RowsRead, p.Delivered, p.Failed, bytesize(p.BytesSent), p.ETA.Round(time.Second))
+		if p.Done {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func bytesize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,50 @@
+package lambda_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/lambda"
+)
+
+func ExampleWrap() {
+	// A stand-in for the Objects API.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Collection string            `json:"collection"`
+			Objects    []*objects.Object `json:"objects"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Printf("delivered %d %s\n", len(req.Objects), req.Collection)
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer api.Close()
+
+	// Created once per execution environment, outside of the handler.
+	client := objects.New("writeKey")
+	client.BaseEndpoint = api.URL
+
+	handler := lambda.Wrap(client, lambda.HandlerFunc(func(ctx context.Context, payload []byte) ([]byte, error) {
+		var user struct{ ID, Name string }
+		if err := json.Unmarshal(payload, &user); err != nil {
+			return nil, err
+		}
+		err := client.Set(&objects.Object{
+			ID:         user.ID,
+			Collection: "users",
+			Properties: map[string]interface{}{"name": user.Name},
+		})
+		return []byte(`"ok"`), err
+	}))
+
+	// In a Lambda function: lambda.StartHandler(handler), from aws-lambda-go.
+	resp, err := handler.Invoke(context.Background(), []byte(`{"ID": "1", "Name": "Ada"}`))
+	fmt.Println(string(resp), err)
+	// Output:
+	// delivered 1 users
+	// "ok" <nil>
+}
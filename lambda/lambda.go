@@ -0,0 +1,40 @@
+// Package lambda delivers the objects set during an AWS Lambda invocation
+// before it returns, since the execution environment may be frozen, or
+// never resumed, once the handler returns. It does not depend on the AWS
+// SDK: Handler matches aws-lambda-go's lambda.Handler, so wrapped handlers
+// are started with lambda.StartHandler.
+package lambda
+
+import (
+	"context"
+	"log"
+
+	"github.com/segmentio/objects-go"
+)
+
+// Handler is implemented by aws-lambda-go's handlers, e.g. the result of
+// lambda.NewHandler(fn).
+type Handler interface {
+	Invoke(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, payload []byte) ([]byte, error)
+
+func (f HandlerFunc) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	return f(ctx, payload)
+}
+
+// Wrap returns a Handler invoking h, then flushing client before returning,
+// within the invocation's deadline. A flush that doesn't complete in time
+// is logged and doesn't fail the invocation; batches whose delivery failed
+// are kept in the client's Spool, if any, for a later Replay.
+func Wrap(client *objects.Client, h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, payload []byte) ([]byte, error) {
+		resp, err := h.Invoke(ctx, payload)
+		if ferr := client.Flush(ctx); ferr != nil {
+			log.Printf("[Error] Objects not delivered before the end of the invocation: %v", ferr)
+		}
+		return resp, err
+	})
+}
@@ -0,0 +1,113 @@
+package objects
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the Histogram buckets.
+var LatencyBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// Histogram counts latencies in LatencyBuckets.
+type Histogram struct {
+	// Counts holds the number of latencies up to each of LatencyBuckets,
+	// non-cumulatively, followed by the number above the last bucket.
+	Counts []int64
+
+	Count int64
+	Sum   time.Duration
+	Max   time.Duration
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{Counts: make([]int64, len(LatencyBuckets)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile, e.g.
+// 0.99, or Max when it falls above the last bucket.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(h.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= rank && i < len(LatencyBuckets) {
+			return LatencyBuckets[i]
+		}
+	}
+	return h.Max
+}
+
+// Mean returns the average latency.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+type latencies struct {
+	sync.Mutex
+	collections map[string]*Histogram
+}
+
+func (l *latencies) observe(collection string, d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.collections == nil {
+		l.collections = map[string]*Histogram{}
+	}
+	h, ok := l.collections[collection]
+	if !ok {
+		h = newHistogram()
+		l.collections[collection] = h
+	}
+	h.observe(d)
+}
+
+// Latencies returns, per collection, the histogram of the time from an object
+// being queued by Set to its batch being acknowledged by the Objects API. Only
+// the oldest object of every batch is measured, so it tracks how stale
+// objects get before they are delivered.
+func (c *Client) Latencies() map[string]Histogram {
+	c.latencies.Lock()
+	defer c.latencies.Unlock()
+
+	ret := make(map[string]Histogram, len(c.latencies.collections))
+	for k, h := range c.latencies.collections {
+		cp := *h
+		cp.Counts = append([]int64(nil), h.Counts...)
+		ret[k] = cp
+	}
+	return ret
+}
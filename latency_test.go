@@ -0,0 +1,63 @@
+package objects
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLatency(t *testing.T) {
+	suite.Run(t, &LatencyTestSuite{})
+}
+
+type LatencyTestSuite struct {
+	suite.Suite
+}
+
+func (l *LatencyTestSuite) TestHistogram() {
+	h := newHistogram()
+	l.Equal(time.Duration(0), h.Quantile(0.5))
+
+	for i := 0; i < 98; i++ {
+		h.observe(50 * time.Millisecond)
+	}
+	h.observe(3 * time.Second)
+	h.observe(2 * time.Minute)
+
+	l.Equal(int64(100), h.Count)
+	l.Equal(int64(98), h.Counts[0])
+	l.Equal(int64(1), h.Counts[5])
+	l.Equal(int64(1), h.Counts[len(LatencyBuckets)])
+	l.Equal(100*time.Millisecond, h.Quantile(0.5))
+	l.Equal(5*time.Second, h.Quantile(0.99))
+	l.Equal(2*time.Minute, h.Quantile(1))
+	l.Equal(2*time.Minute, h.Max)
+}
+
+func (l *LatencyTestSuite) TestClientLatencies() {
+	client := New("writeKey")
+	client.MaxBatchInterval = 50 * time.Millisecond
+
+	l.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	l.NoError(client.Close())
+
+	h := client.Latencies()["c"]
+	l.Equal(int64(1), h.Count)
+	l.True(h.Max > 0 && h.Max < time.Second, "%v", h.Max)
+}
+
+func (l *LatencyTestSuite) TestFromEnqueue() {
+	client := New("writeKey")
+	b := newBuffer("c", 10)
+	l.True(b.Queue.push(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+
+	// Latency is measured from the push, not from when the object is
+	// buffered.
+	queued := time.Now().Add(-time.Minute)
+	b.Queue.slots[0].at = queued
+	client.dequeue(b)
+	l.Equal(1, b.count())
+	l.True(queued.Equal(b.first))
+	l.NoError(client.Close())
+}
@@ -0,0 +1,289 @@
+package objects
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoRouter = errors.New("Manager has no Router")
+)
+
+// Limiter bounds a rate of objects, e.g. importer.NewLimiter or a
+// golang.org/x/time/rate.Limiter.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// Manager owns a client per write key, e.g. one per customer workspace in a
+// multi-tenant application. Clients are created on first use, share an HTTP
+// client, a rate limit and metrics, and are closed once idle or when too
+// many are open.
+type Manager struct {
+	// Configure optionally configures every client after New.
+	Configure func(*Client)
+
+	// Client is the HTTP client shared by every managed client, so they
+	// share a connection pool.
+	Client *http.Client
+
+	// Limiter optionally bounds the rate of objects accepted across all
+	// write keys. Set and SendBatch block until it allows their objects.
+	Limiter Limiter
+
+	// Metrics optionally receives the metrics of every managed client.
+	Metrics Metrics
+
+	// MaxClients is the number of clients kept open; the least recently used
+	// one is closed past it. Zero is unlimited.
+	MaxClients int
+
+	// IdleTimeout closes clients not used for longer, checked in the
+	// background and whenever a client is used. Zero keeps them open.
+	IdleTimeout time.Duration
+
+	// Router picks the write key and endpoint of the objects passed to
+	// Route, e.g. to send them to a workspace or region based on their
+	// contents. An empty endpoint keeps the client's BaseEndpoint. Objects of
+	// each route are batched separately.
+	Router func(*Object) (writeKey, endpoint string)
+
+	mu      sync.Mutex
+	clients map[route]*list.Element
+	lru     *list.List
+	closed  bool
+	wg      sync.WaitGroup
+
+	// closing are the evicted clients being closed, and evicted the sum of
+	// the stats of those closed.
+	closing map[*managed]struct{}
+	evicted Stats
+
+	// reaping is closed to stop the reaper, started on first use.
+	reaping chan struct{}
+}
+
+// route identifies a managed client.
+type route struct {
+	writeKey string
+	endpoint string
+}
+
+type managed struct {
+	route  route
+	client *Client
+	used   time.Time
+
+	// users counts the calls in progress, which must return before the
+	// client is closed.
+	users sync.WaitGroup
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		Client:  http.DefaultClient,
+		clients: map[route]*list.Element{},
+		closing: map[*managed]struct{}{},
+		lru:     list.New(),
+	}
+}
+
+// Set queues v on the client of writeKey, see Client.Set.
+func (m *Manager) Set(writeKey string, v *Object) error {
+	return m.set(route{writeKey: writeKey}, v)
+}
+
+// Route queues v on the client of the write key and endpoint picked by
+// Router. It returns ErrNoRouter if Router is nil.
+func (m *Manager) Route(v *Object) error {
+	if m.Router == nil {
+		return ErrNoRouter
+	}
+	writeKey, endpoint := m.Router(v)
+	return m.set(route{writeKey: writeKey, endpoint: endpoint}, v)
+}
+
+func (m *Manager) set(r route, v *Object) error {
+	if m.Limiter != nil {
+		if err := m.Limiter.WaitN(context.Background(), 1); err != nil {
+			return err
+		}
+	}
+
+	e, err := m.acquire(r)
+	if err != nil {
+		return err
+	}
+	defer e.users.Done()
+	return e.client.Set(v)
+}
+
+// SendBatch delivers b with the client of writeKey, see Client.SendBatch.
+func (m *Manager) SendBatch(ctx context.Context, writeKey string, b *Batch) error {
+	if m.Limiter != nil {
+		if err := m.Limiter.WaitN(ctx, len(b.Objects)); err != nil {
+			return err
+		}
+	}
+
+	e, err := m.acquire(route{writeKey: writeKey})
+	if err != nil {
+		return err
+	}
+	defer e.users.Done()
+	return e.client.SendBatch(ctx, b)
+}
+
+// acquire returns the client of r, creating it if needed, and evicts idle
+// and least recently used clients. The caller must call users.Done.
+func (m *Manager) acquire(r route) (*managed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClientClosed
+	}
+	if m.IdleTimeout > 0 && m.reaping == nil {
+		m.reaping = make(chan struct{})
+		m.wg.Add(1)
+		go m.reaper(m.IdleTimeout, m.reaping)
+	}
+
+	now := time.Now()
+	var e *managed
+	if el, ok := m.clients[r]; ok {
+		e = el.Value.(*managed)
+		m.lru.MoveToFront(el)
+	} else {
+		c := New(r.writeKey)
+		c.Client = m.Client
+		c.Metrics = m.Metrics
+		if m.Configure != nil {
+			m.Configure(c)
+		}
+		if r.endpoint != "" {
+			c.BaseEndpoint = r.endpoint
+		}
+		e = &managed{route: r, client: c}
+		m.clients[r] = m.lru.PushFront(e)
+	}
+	e.used = now
+	e.users.Add(1)
+
+	for el := m.lru.Back(); el != nil && el.Value != e; el = m.lru.Back() {
+		old := el.Value.(*managed)
+		if !(m.MaxClients > 0 && m.lru.Len() > m.MaxClients) &&
+			!(m.IdleTimeout > 0 && now.Sub(old.used) > m.IdleTimeout) {
+			break
+		}
+		m.evict(el)
+	}
+	return e, nil
+}
+
+// reaper evicts the clients idle for longer than timeout until stop is
+// closed, so they are closed even once no more objects are sent.
+func (m *Manager) reaper(timeout time.Duration, stop chan struct{}) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.reap(now)
+		}
+	}
+}
+
+// reap evicts the clients not used since IdleTimeout before now.
+func (m *Manager) reap(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for el := m.lru.Back(); el != nil; el = m.lru.Back() {
+		if now.Sub(el.Value.(*managed).used) <= m.IdleTimeout {
+			break
+		}
+		m.evict(el)
+	}
+}
+
+// evict removes el and closes its client in the background once the calls
+// using it return. m.mu must be held.
+func (m *Manager) evict(el *list.Element) {
+	e := m.lru.Remove(el).(*managed)
+	delete(m.clients, e.route)
+	m.closing[e] = struct{}{}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		e.users.Wait()
+		if err := e.client.Close(); err != nil {
+			e.client.Logger.Printf("[Warn] Evicted client failed to close: %v", err)
+		}
+
+		m.mu.Lock()
+		delete(m.closing, e)
+		m.evicted = addStats(m.evicted, e.client.Stats())
+		m.mu.Unlock()
+	}()
+}
+
+// Len returns the number of open clients.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// Stats returns the sum of the counters of every client the manager created,
+// including closed ones.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.evicted
+	for el := m.lru.Front(); el != nil; el = el.Next() {
+		stats = addStats(stats, el.Value.(*managed).client.Stats())
+	}
+	for e := range m.closing {
+		stats = addStats(stats, e.client.Stats())
+	}
+	return stats
+}
+
+// Close closes every client, waiting for their objects to be delivered.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClientClosed
+	}
+	m.closed = true
+	if m.reaping != nil {
+		close(m.reaping)
+	}
+	for el := m.lru.Back(); el != nil; el = m.lru.Back() {
+		m.evict(el)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	return nil
+}
+
+func addStats(a, b Stats) Stats {
+	return Stats{
+		Queued:   a.Queued + b.Queued,
+		Sent:     a.Sent + b.Sent,
+		Failed:   a.Failed + b.Failed,
+		Dropped:  a.Dropped + b.Dropped,
+		Inflight: a.Inflight + b.Inflight,
+	}
+}
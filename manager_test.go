@@ -0,0 +1,121 @@
+package objects
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestManager(t *testing.T) {
+	suite.Run(t, &ManagerTestSuite{})
+}
+
+type ManagerTestSuite struct {
+	suite.Suite
+}
+
+func (m *ManagerTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://eu.manager.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+	httpmock.RegisterResponder("POST", "https://manager.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+type countingLimiter struct {
+	n int64
+}
+
+func (l *countingLimiter) WaitN(ctx context.Context, n int) error {
+	atomic.AddInt64(&l.n, int64(n))
+	return nil
+}
+
+func (m *ManagerTestSuite) manager() *Manager {
+	mgr := NewManager()
+	mgr.Configure = func(c *Client) {
+		c.BaseEndpoint = "https://manager.segment.com"
+	}
+	return mgr
+}
+
+func (m *ManagerTestSuite) TestMaxClients() {
+	limiter := &countingLimiter{}
+	mgr := m.manager()
+	mgr.MaxClients = 2
+	mgr.Limiter = limiter
+
+	for _, key := range []string{"a", "b", "a", "c", "a"} {
+		m.NoError(mgr.Set(key, &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	}
+	m.Equal(2, mgr.Len())
+	m.Equal(int64(5), limiter.n)
+
+	m.NoError(mgr.Close())
+	m.Equal(0, mgr.Len())
+	m.Equal(Stats{Queued: 5, Sent: 5}, mgr.Stats())
+	m.Equal(ErrClientClosed, mgr.Set("a", &Object{ID: "1", Collection: "c"}))
+	m.Equal(ErrClientClosed, mgr.Close())
+}
+
+func (m *ManagerTestSuite) TestIdleTimeout() {
+	mgr := m.manager()
+	mgr.IdleTimeout = 20 * time.Millisecond
+	defer mgr.Close()
+
+	b := NewBatch("c").Add(&Object{ID: "1", Properties: map[string]interface{}{"p": "1"}})
+	m.NoError(mgr.SendBatch(context.Background(), "a", b))
+	m.NoError(mgr.SendBatch(context.Background(), "b", b))
+	m.Equal(2, mgr.Len())
+
+	time.Sleep(30 * time.Millisecond)
+	m.NoError(mgr.SendBatch(context.Background(), "b", b))
+	m.Equal(1, mgr.Len())
+}
+
+func (m *ManagerTestSuite) TestReap() {
+	mgr := m.manager()
+	mgr.IdleTimeout = time.Hour
+	defer mgr.Close()
+
+	b := NewBatch("c").Add(&Object{ID: "1", Properties: map[string]interface{}{"p": "1"}})
+	m.NoError(mgr.SendBatch(context.Background(), "a", b))
+	m.NoError(mgr.SendBatch(context.Background(), "b", b))
+
+	// Clients are evicted without being used again, and counted while they
+	// close.
+	mgr.reap(time.Now().Add(2 * time.Hour))
+	m.Equal(0, mgr.Len())
+	m.Equal(int64(2), mgr.Stats().Sent)
+}
+
+func (m *ManagerTestSuite) TestRoute() {
+	mgr := m.manager()
+	m.Equal(ErrNoRouter, mgr.Route(&Object{ID: "1", Collection: "c"}))
+
+	mgr.Router = func(v *Object) (string, string) {
+		if v.Properties["region"] == "eu" {
+			return "eu", "https://eu.manager.segment.com"
+		}
+		return "us", ""
+	}
+	for _, region := range []string{"eu", "us", "eu"} {
+		m.NoError(mgr.Route(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"region": region}}))
+	}
+	m.Equal(2, mgr.Len())
+
+	eu, err := mgr.acquire(route{writeKey: "eu", endpoint: "https://eu.manager.segment.com"})
+	m.NoError(err)
+	m.Equal("https://eu.manager.segment.com", eu.client.BaseEndpoint)
+	eu.users.Done()
+
+	m.NoError(mgr.Close())
+	m.Equal(Stats{Queued: 3, Sent: 3}, mgr.Stats())
+}
@@ -0,0 +1,56 @@
+package objects
+
+import "time"
+
+// Metrics receives the client's delivery metrics, e.g. to forward them to
+// statsd. Every metric is tagged with its collection and a status of "ok" or
+// "error". Implementations must be safe for concurrent use.
+//
+// The client reports:
+//
+//	objects.batches        count of batches delivered
+//	objects.objects        count of objects delivered
+//	objects.bytes          count of object bytes delivered
+//	objects.delivery_time  timing of deliveries, including retries
+//	objects.latency        timing from the oldest object of a batch being
+//	                       buffered to its delivery, on success only
+//	objects.dropped        count of objects dropped, tagged with a reason
+//	                       instead of a status
+//	objects.retries        count of failed requests retried, or not because
+//	                       the RetryBudget is exhausted, tagged with a
+//	                       budget of "ok" or "exhausted" only
+//	objects.dns_errors     count of requests failed to resolve their
+//	                       endpoint, without tags
+//	objects.slow_responses count of responses slower than the Breaker's
+//	                       SlowResponse, without tags
+//	objects.duplicates     count of objects detected by the DuplicateFilter,
+//	                       tagged with their collection only
+//	objects.restarts       count of buffer goroutines restarted after a
+//	                       panic, tagged with their collection only
+//	objects.stalls         count of buffer goroutines found stalled, tagged
+//	                       with their collection only
+type Metrics interface {
+	Count(name string, value int64, tags map[string]string)
+	Timing(name string, value time.Duration, tags map[string]string)
+}
+
+// measure reports a delivery of request that started at start.
+func (c *Client) measure(request *batch, start time.Time, err error) {
+	if c.Metrics == nil {
+		return
+	}
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	tags := map[string]string{"collection": request.Collection, "status": status}
+
+	c.Metrics.Count("objects.batches", 1, tags)
+	c.Metrics.Count("objects.objects", int64(request.count), tags)
+	c.Metrics.Count("objects.bytes", int64(request.size()), tags)
+	c.Metrics.Timing("objects.delivery_time", time.Since(start), tags)
+	if err == nil {
+		c.Metrics.Timing("objects.latency", time.Since(request.enqueued), tags)
+	}
+}
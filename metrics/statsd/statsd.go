@@ -0,0 +1,95 @@
+// Package statsd implements objects.Metrics for statsd and DogStatsD.
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Metrics struct {
+	// Prefix is prepended to every metric name, e.g. "myapp.".
+	Prefix string
+
+	// Tags are added to every metric.
+	Tags map[string]string
+
+	// Plain disables DogStatsD tags for servers that do not support them.
+	// Tag values are appended to the metric name instead, sorted by tag
+	// name, e.g. objects.objects.rooms.ok.
+	Plain bool
+
+	conn net.Conn
+}
+
+// New returns Metrics sending to the statsd server at addr over UDP, e.g.
+// "127.0.0.1:8125".
+func New(addr string) (*Metrics, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Metrics{conn: conn}, nil
+}
+
+// Count implements objects.Metrics.
+func (m *Metrics) Count(name string, value int64, tags map[string]string) {
+	m.send(name, fmt.Sprintf("%d|c", value), tags)
+}
+
+// Timing implements objects.Metrics.
+func (m *Metrics) Timing(name string, value time.Duration, tags map[string]string) {
+	m.send(name, fmt.Sprintf("%d|ms", value/time.Millisecond), tags)
+}
+
+// Close closes the connection to the statsd server.
+func (m *Metrics) Close() error {
+	return m.conn.Close()
+}
+
+func (m *Metrics) send(name, value string, tags map[string]string) {
+	all := make(map[string]string, len(m.Tags)+len(tags))
+	for k, v := range m.Tags {
+		all[k] = v
+	}
+	for k, v := range tags {
+		all[k] = v
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(m.Prefix)
+	buf.WriteString(name)
+	if m.Plain {
+		for _, k := range keys {
+			buf.WriteByte('.')
+			buf.WriteString(sanitize(all[k]))
+		}
+	}
+	buf.WriteByte(':')
+	buf.WriteString(value)
+	if !m.Plain && len(keys) > 0 {
+		buf.WriteString("|#")
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(sanitize(k))
+			buf.WriteByte(':')
+			buf.WriteString(sanitize(all[k]))
+		}
+	}
+
+	// Metrics are best effort: UDP write errors are ignored.
+	m.conn.Write(buf.Bytes())
+}
+
+// sanitize replaces the characters reserved by the statsd protocol.
+var sanitize = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "@", "_", " ", "_", "\n", "_").Replace
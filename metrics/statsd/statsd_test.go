@@ -0,0 +1,59 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStatsd(t *testing.T) {
+	suite.Run(t, &StatsdTestSuite{})
+}
+
+type StatsdTestSuite struct {
+	suite.Suite
+	server  net.PacketConn
+	metrics *Metrics
+}
+
+func (s *StatsdTestSuite) SetupTest() {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.server = server
+
+	s.metrics, err = New(server.LocalAddr().String())
+	s.Require().NoError(err)
+}
+
+func (s *StatsdTestSuite) TearDownTest() {
+	s.metrics.Close()
+	s.server.Close()
+}
+
+func (s *StatsdTestSuite) read() string {
+	buf := make([]byte, 1024)
+	s.server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := s.server.ReadFrom(buf)
+	s.Require().NoError(err)
+	return string(buf[:n])
+}
+
+func (s *StatsdTestSuite) TestDogStatsD() {
+	s.metrics.Prefix = "app."
+	s.metrics.Tags = map[string]string{"env": "prod"}
+
+	s.metrics.Count("objects.objects", 3, map[string]string{"collection": "rooms", "status": "ok"})
+	s.Equal("app.objects.objects:3|c|#collection:rooms,env:prod,status:ok", s.read())
+
+	s.metrics.Timing("objects.delivery_time", 1500*time.Millisecond, map[string]string{"collection": "a|b"})
+	s.Equal("app.objects.delivery_time:1500|ms|#collection:a_b,env:prod", s.read())
+}
+
+func (s *StatsdTestSuite) TestPlain() {
+	s.metrics.Plain = true
+
+	s.metrics.Count("objects.batches", 1, map[string]string{"collection": "rooms", "status": "error"})
+	s.Equal("objects.batches.rooms.error:1|c", s.read())
+}
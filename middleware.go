@@ -0,0 +1,64 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// BatchRequest is a batch on its way to the Objects API, see Middleware.
+type BatchRequest struct {
+	Collection string
+
+	// Objects is the JSON array of the batch's encoded objects.
+	Objects json.RawMessage
+
+	// Count is the number of objects in the batch. It is zero for batches
+	// replayed from a Spool.
+	Count int
+
+	// Header holds the extra headers of the request, e.g. RequestIDHeader.
+	Header http.Header
+}
+
+// BatchSender sends a batch to the Objects API.
+type BatchSender interface {
+	Send(ctx context.Context, r *BatchRequest) error
+}
+
+// BatchSenderFunc adapts a function to a BatchSender.
+type BatchSenderFunc func(ctx context.Context, r *BatchRequest) error
+
+func (f BatchSenderFunc) Send(ctx context.Context, r *BatchRequest) error {
+	return f(ctx, r)
+}
+
+// Middleware wraps the delivery of every batch, including replayed ones,
+// like http middleware wraps handlers: it may inspect or modify the request,
+// log, measure or fail it, and calls next to send it. Errors it returns fail
+// the delivery like any other.
+type Middleware func(next BatchSender) BatchSender
+
+// sendBatch sends r through the client's Middleware.
+func (c *Client) sendBatch(ctx context.Context, r *BatchRequest) error {
+	var s BatchSender = BatchSenderFunc(c.postBatch)
+	for i := len(c.Middleware) - 1; i >= 0; i-- {
+		s = c.Middleware[i](s)
+	}
+	return s.Send(ctx, r)
+}
+
+// postBatch encodes r and posts it to BaseEndpoint.
+func (c *Client) postBatch(ctx context.Context, r *BatchRequest) error {
+	payload, err := c.encodeBatch(&batch{
+		Collection: r.Collection,
+		WriteKey:   c.writeKey,
+		Objects:    r.Objects,
+	}, r.Header)
+	if err != nil {
+		return c.redactError(fmt.Errorf("Request failed to marshal: %v", err))
+	}
+
+	return c.post(ctx, "/v1/set", payload, r.Header)
+}
@@ -1,7 +1,59 @@
 package objects
 
+import (
+	"time"
+
+	"gopkg.in/validator.v2"
+)
+
 type Object struct {
 	Collection string                 `json:"-" validate:"nonzero"`
 	ID         string                 `json:"id" validate:"nonzero"`
 	Properties map[string]interface{} `json:"properties" validate:"min=1"`
+
+	// Keys optionally holds the columns of a composite primary key. When ID
+	// is empty, the client joins them into the ID, see Client.KeyEncoding.
+	Keys map[string]string `json:"-"`
+
+	// ExpiresAt optionally schedules a Delete of the object once the time is
+	// reached and its Set is delivered, so the Delete never overtakes it.
+	// Objects that are dropped are not deleted. Expiries are tracked in
+	// memory and discarded by Close unless the client has a Spool: they are
+	// persisted to it, and rescheduled by the Replay of a later process.
+	// A later Set with a new ExpiresAt reschedules the Delete.
+	ExpiresAt time.Time `json:"-"`
+
+	// Deadline optionally bounds when a Set object may be delivered, for
+	// freshness-sensitive data. An object still buffered at its deadline is
+	// dropped with DropDeadline instead of being sent stale, and objects
+	// with a deadline are flushed without waiting for MaxBatchInterval. A
+	// request whose objects all have a deadline is canceled at the latest.
+	// Objects with a deadline are never spooled: if their batch fails, they
+	// are dropped with DropDeadline and the others are spooled.
+	Deadline time.Time `json:"-"`
+
+	// Meta is never sent. It is passed back in Delivery.Meta and Drop.Object
+	// so applications can correlate outcomes with upstream messages, e.g. to
+	// ack them. It is not kept for spooled batches.
+	Meta map[string]interface{} `json:"-"`
+}
+
+// validate is equivalent to validator.Validate(v) for the tags above, without
+// reflection, so valid objects are checked without allocating.
+func (v *Object) validate() error {
+	if v.Collection != "" && v.ID != "" && len(v.Properties) >= 1 {
+		return nil
+	}
+
+	errs := validator.ErrorMap{}
+	if v.Collection == "" {
+		errs["Collection"] = validator.ErrorArray{validator.ErrZeroValue}
+	}
+	if v.ID == "" {
+		errs["ID"] = validator.ErrorArray{validator.ErrZeroValue}
+	}
+	if len(v.Properties) < 1 {
+		errs["Properties"] = validator.ErrorArray{validator.ErrMin}
+	}
+	return errs
 }
@@ -0,0 +1,144 @@
+package objectstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/objects-go"
+)
+
+var (
+	ErrInjected = errors.New("objectstest: injected fault")
+)
+
+// Faults injects latency and failures into requests, for verifying error
+// handling and back-pressure under failure. Use it as the transport of the
+// client's http.Client, or wrap a sink with Sink. Probabilities are in
+// [0, 1] and evaluated independently for every request.
+//
+//	faults := &objectstest.Faults{ErrorProbability: 0.2, Latency: time.Second, LatencyProbability: 0.1}
+//	client.Client = &http.Client{Transport: faults}
+type Faults struct {
+	// Latency is added before the request with LatencyProbability.
+	Latency            time.Duration
+	LatencyProbability float64
+
+	// ErrorProbability fails the request with ErrInjected before it is sent.
+	ErrorProbability float64
+
+	// StatusProbability fails the request with Status (default 503) before
+	// it is sent.
+	StatusProbability float64
+	Status            int
+
+	// PartialProbability sends the request, then reports a 500 to the
+	// caller, as when a server fails after processing a batch. Retries then
+	// deliver duplicates.
+	PartialProbability float64
+
+	// Transport sends requests that are let through; defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Seed makes the injected faults reproducible.
+	Seed int64
+
+	once sync.Once
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (f *Faults) roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.once.Do(func() { f.rand = rand.New(rand.NewSource(f.Seed)) })
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < p
+}
+
+// delay sleeps for Latency with LatencyProbability, or until ctx is done.
+func (f *Faults) delay(ctx context.Context) error {
+	if !f.roll(f.LatencyProbability) {
+		return nil
+	}
+	select {
+	case <-time.After(f.Latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (f *Faults) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := f.delay(req.Context()); err != nil {
+		return nil, err
+	}
+	if f.roll(f.ErrorProbability) {
+		return nil, ErrInjected
+	}
+	if f.roll(f.StatusProbability) {
+		status := f.Status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		return response(req, status), nil
+	}
+
+	transport := f.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil || !f.roll(f.PartialProbability) {
+		return resp, err
+	}
+	resp.Body.Close()
+	return response(req, http.StatusInternalServerError), nil
+}
+
+func response(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"error": "injected"}`)),
+		Request:    req,
+	}
+}
+
+// Sink wraps s so its sends are subject to f's latency and error
+// probabilities. Partial failures forward the batch and then fail.
+func (f *Faults) Sink(s objects.Sink) objects.Sink {
+	return &faultySink{faults: f, sink: s}
+}
+
+type faultySink struct {
+	faults *Faults
+	sink   objects.Sink
+}
+
+func (s *faultySink) Send(ctx context.Context, collection string, raw json.RawMessage) error {
+	if err := s.faults.delay(ctx); err != nil {
+		return err
+	}
+	if s.faults.roll(s.faults.ErrorProbability) || s.faults.roll(s.faults.StatusProbability) {
+		return ErrInjected
+	}
+	if err := s.sink.Send(ctx, collection, raw); err != nil {
+		return err
+	}
+	if s.faults.roll(s.faults.PartialProbability) {
+		return ErrInjected
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package objectstest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultsPartial(t *testing.T) {
+	rec := NewRecorder()
+	defer rec.Close()
+
+	f := &Faults{PartialProbability: 1}
+	req, _ := http.NewRequest("POST", rec.URL+"/v1/set", strings.NewReader(`{"collection": "rooms", "objects": []}`))
+	resp, err := f.RoundTrip(req)
+
+	// The request reached the server but is reported as failed.
+	assert.NoError(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Len(t, rec.Batches(), 1)
+}
+
+func TestFaultsError(t *testing.T) {
+	f := &Faults{ErrorProbability: 1}
+	req, _ := http.NewRequest("POST", "http://localhost/v1/set", nil)
+	_, err := f.RoundTrip(req)
+	assert.Equal(t, ErrInjected, err)
+
+	f = &Faults{StatusProbability: 1, Status: 429}
+	resp, err := f.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 429, resp.StatusCode)
+}
+
+func TestFaultsLatency(t *testing.T) {
+	f := &Faults{Latency: 50 * time.Millisecond, LatencyProbability: 1, ErrorProbability: 1}
+	req, _ := http.NewRequest("POST", "http://localhost/v1/set", nil)
+
+	start := time.Now()
+	f.RoundTrip(req)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestFaultsSeed(t *testing.T) {
+	rolls := func() []bool {
+		f := &Faults{Seed: 42}
+		r := []bool{}
+		for i := 0; i < 20; i++ {
+			r = append(r, f.roll(0.5))
+		}
+		return r
+	}
+	assert.Equal(t, rolls(), rolls())
+}
+
+type countingSink struct {
+	sent int
+}
+
+func (s *countingSink) Send(ctx context.Context, collection string, raw json.RawMessage) error {
+	s.sent++
+	return nil
+}
+
+func TestFaultsSink(t *testing.T) {
+	s := &countingSink{}
+	assert.Equal(t, ErrInjected, (&Faults{ErrorProbability: 1}).Sink(s).Send(context.Background(), "rooms", nil))
+	assert.Equal(t, 0, s.sent)
+
+	assert.Equal(t, ErrInjected, (&Faults{PartialProbability: 1}).Sink(s).Send(context.Background(), "rooms", nil))
+	assert.Equal(t, 1, s.sent)
+
+	assert.NoError(t, (&Faults{}).Sink(s).Send(context.Background(), "rooms", nil))
+	assert.Equal(t, 2, s.sent)
+}
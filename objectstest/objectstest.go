@@ -0,0 +1,143 @@
+// Package objectstest provides helpers for testing code that sends objects:
+// a Recorder capturing the exact batches a client would deliver, and golden
+// file assertions so schema changes show up as diffs in CI.
+//
+//	func TestSync(t *testing.T) {
+//		client, rec := objectstest.NewClient()
+//		defer rec.Close()
+//
+//		syncRooms(client)
+//		client.Close()
+//
+//		objectstest.AssertGolden(t, "testdata/rooms.golden", rec.Snapshot())
+//	}
+//
+// Run `go test -objectstest.update` to rewrite golden files.
+package objectstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/objects-go"
+)
+
+var update = flag.Bool("objectstest.update", false, "rewrite objectstest golden files")
+
+// Recorder is a fake Objects API recording every batch it receives.
+type Recorder struct {
+	URL string
+
+	server  *httptest.Server
+	mu      sync.Mutex
+	batches []*Batch
+}
+
+// Batch is a batch received by a Recorder.
+type Batch struct {
+	Collection string            `json:"collection"`
+	Objects    []json.RawMessage `json:"objects"`
+}
+
+func NewRecorder() *Recorder {
+	r := &Recorder{}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	r.URL = r.server.URL
+	return r
+}
+
+// NewClient returns a client sending to a new Recorder.
+func NewClient() (*objects.Client, *Recorder) {
+	r := NewRecorder()
+	client := objects.New("objectstest")
+	client.BaseEndpoint = r.URL
+	return client, r
+}
+
+func (r *Recorder) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/set" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	b := &Batch{}
+	if err := json.NewDecoder(req.Body).Decode(b); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	r.batches = append(r.batches, b)
+	r.mu.Unlock()
+	w.Write([]byte(`{"success": true}`))
+}
+
+// Close shuts down the fake API.
+func (r *Recorder) Close() {
+	r.server.Close()
+}
+
+// Batches returns the batches received so far, in arrival order.
+func (r *Recorder) Batches() []*Batch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Batch{}, r.batches...)
+}
+
+// Snapshot returns an indented JSON document of every object received,
+// grouped by collection and ordered by ID, so it does not depend on batch
+// boundaries or delivery order. Close the client before taking a snapshot.
+func (r *Recorder) Snapshot() []byte {
+	type object struct {
+		ID  string `json:"id"`
+		raw json.RawMessage
+	}
+
+	grouped := map[string][]object{}
+	for _, b := range r.Batches() {
+		for _, raw := range b.Objects {
+			o := object{raw: raw}
+			json.Unmarshal(raw, &o)
+			grouped[b.Collection] = append(grouped[b.Collection], o)
+		}
+	}
+
+	snapshot := map[string][]json.RawMessage{}
+	for collection, objs := range grouped {
+		sort.SliceStable(objs, func(i, j int) bool { return objs[i].ID < objs[j].ID })
+		for _, o := range objs {
+			snapshot[collection] = append(snapshot[collection], o.raw)
+		}
+	}
+
+	b, _ := json.MarshalIndent(snapshot, "", "  ")
+	return append(b, '\n')
+}
+
+// AssertGolden fails t if got differs from the content of the golden file at
+// path. With -objectstest.update, the file is rewritten instead.
+func AssertGolden(t testing.TB, path string, got []byte) {
+	t.Helper()
+
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("objectstest: %v", err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("objectstest: %v (run with -objectstest.update to create it)", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("objectstest: %s differs from golden file\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
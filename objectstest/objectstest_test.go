@@ -0,0 +1,47 @@
+package objectstest
+
+import (
+	"testing"
+
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	client, rec := NewClient()
+	defer rec.Close()
+
+	client.MaxBatchCount = 2
+	assert.NoError(t, client.Set(&objects.Object{ID: "r2", Collection: "rooms", Properties: map[string]interface{}{
+		"Name": "Beach Room", "location": map[string]interface{}{"city": "Lihue"},
+	}}))
+	assert.NoError(t, client.Set(&objects.Object{ID: "r1", Collection: "rooms", Properties: map[string]interface{}{
+		"name": "Mountain Cabin", "reviewCount": 47,
+	}}))
+	assert.NoError(t, client.Set(&objects.Object{ID: "u1", Collection: "users", Properties: map[string]interface{}{
+		"name": "calvin",
+	}}))
+	assert.NoError(t, client.Close())
+
+	assert.True(t, len(rec.Batches()) >= 2)
+	AssertGolden(t, "testdata/snapshot.golden", rec.Snapshot())
+}
+
+type fakeT struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeT) Helper()                                   {}
+func (f *fakeT) Errorf(format string, args ...interface{}) { f.failed = true }
+func (f *fakeT) Fatalf(format string, args ...interface{}) { f.failed = true }
+
+func TestGoldenMismatch(t *testing.T) {
+	if *update {
+		t.Skip("golden files are being rewritten")
+	}
+
+	ft := &fakeT{}
+	AssertGolden(ft, "testdata/snapshot.golden", []byte("{}\n"))
+	assert.True(t, ft.failed)
+}
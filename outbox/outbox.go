@@ -0,0 +1,169 @@
+// Package outbox delivers objects written to a SQL outbox table, typically
+// in the same transaction as the application's own changes, and marks rows
+// as sent only once the Objects API has accepted them. A row is delivered at
+// least once; since Set is idempotent, end-to-end it behaves as exactly once.
+//
+// The package does not depend on a database driver. The default table is
+//
+//	CREATE TABLE objects_outbox (
+//		id         BIGINT PRIMARY KEY,  -- increasing, e.g. auto incremented
+//		collection TEXT NOT NULL,
+//		object_id  TEXT NOT NULL,
+//		properties TEXT NOT NULL,       -- JSON object
+//		sent_at    TIMESTAMP NULL
+//	)
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/source"
+)
+
+// Question is the `?` placeholder style, used by MySQL and SQLite.
+func Question(n int) string {
+	return "?"
+}
+
+// Dollar is the `$1` placeholder style, used by Postgres.
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+type Outbox struct {
+	DB     *sql.DB
+	Client *objects.Client
+
+	// Table and its columns, see the package documentation.
+	Table            string
+	IDColumn         string
+	CollectionColumn string
+	ObjectIDColumn   string
+	PropertiesColumn string
+	SentColumn       string
+
+	// Placeholder returns the n-th query placeholder, starting at 1.
+	Placeholder func(n int) string
+
+	// BatchSize is the maximum number of rows read per poll.
+	BatchSize int
+
+	// Interval is how long Run waits after a poll that found fewer than
+	// BatchSize rows.
+	Interval time.Duration
+}
+
+func New(db *sql.DB, client *objects.Client) *Outbox {
+	return &Outbox{
+		DB:               db,
+		Client:           client,
+		Table:            "objects_outbox",
+		IDColumn:         "id",
+		CollectionColumn: "collection",
+		ObjectIDColumn:   "object_id",
+		PropertiesColumn: "properties",
+		SentColumn:       "sent_at",
+		Placeholder:      Question,
+		BatchSize:        100,
+		Interval:         time.Second,
+	}
+}
+
+// Run polls the outbox until ctx is done or an error occurs. Rows are
+// delivered in the order of IDColumn.
+func (o *Outbox) Run(ctx context.Context) error {
+	for {
+		n, err := o.Poll(ctx)
+		if err != nil {
+			return err
+		}
+		if n >= o.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(o.Interval):
+		}
+	}
+}
+
+// Poll delivers up to BatchSize unsent rows with SendBatch and marks them
+// as sent. It returns the number of rows read. Invalid rows, with an empty
+// collection or object ID, or whose properties are not a non-empty JSON
+// object, are logged and marked as sent, so they don't block the outbox.
+func (o *Outbox) Poll(ctx context.Context) (int, error) {
+	rows, err := o.DB.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %s, %s, %s, %s FROM %s WHERE %s IS NULL ORDER BY %s LIMIT %d",
+		o.IDColumn, o.CollectionColumn, o.ObjectIDColumn, o.PropertiesColumn,
+		o.Table, o.SentColumn, o.IDColumn, o.BatchSize,
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []interface{}
+	var objs []*objects.Object
+	for rows.Next() {
+		var key interface{}
+		var collection, id string
+		var props []byte
+		if err := rows.Scan(&key, &collection, &id, &props); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+		if collection == "" || id == "" {
+			log.Printf("[Error] Outbox row `%v` skipped: empty collection or object ID", key)
+			continue
+		}
+
+		v := &objects.Object{ID: id, Collection: collection}
+		dec := json.NewDecoder(bytes.NewReader(props))
+		dec.UseNumber()
+		if err := dec.Decode(&v.Properties); err != nil || len(v.Properties) == 0 {
+			log.Printf("[Error] Outbox row `%v` skipped: invalid properties", key)
+			continue
+		}
+		objs = append(objs, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if err := source.Send(ctx, o.Client, objs); err != nil {
+		return 0, err
+	}
+	return len(keys), o.markSent(ctx, keys)
+}
+
+func (o *Outbox) markSent(ctx context.Context, keys []interface{}) error {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, time.Now().UTC())
+
+	in := make([]string, len(keys))
+	for i, key := range keys {
+		in[i] = o.Placeholder(i + 2)
+		args = append(args, key)
+	}
+
+	_, err := o.DB.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET %s = %s WHERE %s IN (%s)",
+		o.Table, o.SentColumn, o.Placeholder(1), o.IDColumn, strings.Join(in, ", "),
+	), args...)
+	return err
+}
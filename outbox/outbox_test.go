@@ -0,0 +1,229 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestOutbox(t *testing.T) {
+	suite.Run(t, &OutboxTestSuite{})
+}
+
+type OutboxTestSuite struct {
+	suite.Suite
+
+	mu   sync.Mutex
+	sets []string
+
+	table *testTable
+	db    *sql.DB
+}
+
+func (o *OutboxTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := struct {
+			Collection string            `json:"collection"`
+			Objects    []*objects.Object `json:"objects"`
+		}{}
+		json.NewDecoder(req.Body).Decode(&v)
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for _, x := range v.Objects {
+			b, _ := json.Marshal(x.Properties)
+			o.sets = append(o.sets, v.Collection+"/"+x.ID+" "+string(b))
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(400, ""))
+
+	o.table = &testTable{}
+	sql.Register("outboxtest", &testDriver{o.table})
+	db, err := sql.Open("outboxtest", "")
+	o.NoError(err)
+	o.db = db
+}
+
+func (o *OutboxTestSuite) TearDownSuite() {
+	httpmock.DeactivateAndReset()
+}
+
+func (o *OutboxTestSuite) SetupTest() {
+	o.mu.Lock()
+	o.sets = nil
+	o.mu.Unlock()
+	o.table.rows = nil
+}
+
+func (o *OutboxTestSuite) TestPoll() {
+	o.table.insert("users", "1", `{"name": "a"}`)
+	o.table.insert("users", "2", `not json`)
+	o.table.insert("orders", "3", `{"total": 12.5}`)
+
+	client := objects.New("writeKey")
+	box := New(o.db, client)
+	box.BatchSize = 2
+
+	n, err := box.Poll(context.Background())
+	o.NoError(err)
+	o.Equal(2, n)
+	o.Equal([]string{`users/1 {"name":"a"}`}, o.sets)
+	o.Equal([]bool{true, true, false}, o.table.sent())
+
+	n, err = box.Poll(context.Background())
+	o.NoError(err)
+	o.Equal(1, n)
+	o.Equal([]string{`users/1 {"name":"a"}`, `orders/3 {"total":12.5}`}, o.sets)
+	o.Equal([]bool{true, true, true}, o.table.sent())
+
+	n, err = box.Poll(context.Background())
+	o.NoError(err)
+	o.Equal(0, n)
+	o.NoError(client.Close())
+}
+
+func (o *OutboxTestSuite) TestPollInvalid() {
+	o.table.insert("", "1", `{"name": "a"}`)
+	o.table.insert("users", "", `{"name": "a"}`)
+	o.table.insert("users", "3", `{}`)
+	o.table.insert("users", "4", `{"name": "d"}`)
+
+	client := objects.New("writeKey")
+	n, err := New(o.db, client).Poll(context.Background())
+	o.NoError(err)
+	o.Equal(4, n)
+	o.Equal([]string{`users/4 {"name":"d"}`}, o.sets)
+	o.Equal([]bool{true, true, true, true}, o.table.sent())
+	o.NoError(client.Close())
+}
+
+func (o *OutboxTestSuite) TestPollFailed() {
+	o.table.insert("users", "1", `{"name": "a"}`)
+
+	client := objects.New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := New(o.db, client).Poll(ctx)
+	o.Error(err)
+	o.Equal([]bool{false}, o.table.sent())
+}
+
+type testRow struct {
+	id                          int64
+	collection, objectID, props string
+	sent                        bool
+}
+
+// testTable is an outbox table understanding the queries of Outbox.
+type testTable struct {
+	mu   sync.Mutex
+	rows []*testRow
+}
+
+func (t *testTable) insert(collection, id, props string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows = append(t.rows, &testRow{id: int64(len(t.rows) + 1), collection: collection, objectID: id, props: props})
+}
+
+func (t *testTable) sent() []bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]bool, len(t.rows))
+	for i, r := range t.rows {
+		ret[i] = r.sent
+	}
+	return ret
+}
+
+type testDriver struct {
+	table *testTable
+}
+
+func (d *testDriver) Open(name string) (driver.Conn, error) {
+	return &testConn{d.table}, nil
+}
+
+type testConn struct {
+	table *testTable
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return &testStmt{table: c.table, query: query}, nil
+}
+
+func (c *testConn) Close() error              { return nil }
+func (c *testConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+type testStmt struct {
+	table *testTable
+	query string
+}
+
+func (s *testStmt) Close() error  { return nil }
+func (s *testStmt) NumInput() int { return -1 }
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	for _, arg := range args[1:] {
+		for _, r := range s.table.rows {
+			if r.id == arg.(int64) {
+				r.sent = true
+			}
+		}
+	}
+	return driver.RowsAffected(len(args) - 1), nil
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var limit int
+	if i := strings.LastIndex(s.query, "LIMIT "); i >= 0 {
+		limit, _ = strconv.Atoi(s.query[i+6:])
+	}
+
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	rows := &testRows{}
+	for _, r := range s.table.rows {
+		if !r.sent && len(rows.values) < limit {
+			rows.values = append(rows.values, []driver.Value{r.id, r.collection, r.objectID, []byte(r.props)})
+		}
+	}
+	return rows, nil
+}
+
+type testRows struct {
+	values [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	return []string{"id", "collection", "object_id", "properties"}
+}
+
+func (r *testRows) Close() error { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
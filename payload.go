@@ -0,0 +1,84 @@
+package objects
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// PayloadEncoder encodes the body of the batch requests sent to
+// BaseEndpoint, for gateways accepting formats more compact than JSON, e.g.
+// msgpack.Encoder. Batches are JSON by default.
+type PayloadEncoder interface {
+	// ContentType is sent as the Content-Type of every batch request.
+	ContentType() string
+
+	// Encode returns the body of a request delivering objects, the JSON
+	// array of the batch's encoded objects, to collection.
+	Encode(collection, writeKey string, objects json.RawMessage) ([]byte, error)
+}
+
+// encodeBatch encodes b with the client's PayloadEncoder, setting the
+// Content-Type of the request in header.
+func (c *Client) encodeBatch(b *batch, header http.Header) ([]byte, error) {
+	if c.PayloadEncoder == nil {
+		return encodeRequest(b)
+	}
+	header.Set("Content-Type", c.PayloadEncoder.ContentType())
+	return c.PayloadEncoder.Encode(b.Collection, b.WriteKey, b.Objects)
+}
+
+// Envelope is a JSON PayloadEncoder renaming the fields of the batch
+// payload, for services expecting a slightly different schema than the
+// Objects API, e.g. `Envelope{Collection: "table", Objects: "rows"}`. Empty
+// names default to those of the Objects API; set a name to "-" to omit its
+// field.
+type Envelope struct {
+	Collection string
+	WriteKey   string
+	Objects    string
+}
+
+// ContentType implements PayloadEncoder.
+func (e *Envelope) ContentType() string {
+	return "application/json"
+}
+
+// Encode implements PayloadEncoder.
+func (e *Envelope) Encode(collection, writeKey string, objects json.RawMessage) ([]byte, error) {
+	if len(objects) == 0 {
+		objects = json.RawMessage("[]")
+	}
+
+	payload := make([]byte, 0, len(collection)+len(writeKey)+len(objects)+64)
+	payload = append(payload, '{')
+	for _, f := range []struct {
+		name, fallback string
+		value          interface{}
+	}{
+		{e.Collection, "collection", collection},
+		{e.WriteKey, "write_key", writeKey},
+		{e.Objects, "objects", objects},
+	} {
+		if f.name == "-" {
+			continue
+		}
+		if f.name == "" {
+			f.name = f.fallback
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) > 1 {
+			payload = append(payload, ',')
+		}
+		payload = append(payload, name...)
+		payload = append(payload, ':')
+		payload = append(payload, value...)
+	}
+	return append(payload, '}'), nil
+}
@@ -0,0 +1,157 @@
+// Package msgpack implements an objects.PayloadEncoder sending batches as
+// MessagePack, for gateways accepting it.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ContentType is the Content-Type of encoded batches.
+const ContentType = "application/msgpack"
+
+// Encoder encodes batches as a MessagePack map with the same keys as the
+// JSON payload: collection, write_key and objects. Integers are encoded as
+// integers and other numbers as float64.
+type Encoder struct{}
+
+// ContentType implements objects.PayloadEncoder.
+func (Encoder) ContentType() string {
+	return ContentType
+}
+
+// Encode implements objects.PayloadEncoder.
+func (Encoder) Encode(collection, writeKey string, objects json.RawMessage) ([]byte, error) {
+	var v []interface{}
+	dec := json.NewDecoder(bytes.NewReader(objects))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	w := &writer{}
+	w.mapHeader(3)
+	w.string("collection")
+	w.string(collection)
+	w.string("write_key")
+	w.string(writeKey)
+	w.string("objects")
+	if err := w.value(v); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) value(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+	case bool:
+		if v {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case string:
+		w.string(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			w.int(n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		w.WriteByte(0xcb)
+		w.uint64(math.Float64bits(f))
+	case []interface{}:
+		w.header(len(v), 0x90, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := w.value(e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		w.mapHeader(len(v))
+		for _, k := range keys {
+			w.string(k)
+			if err := w.value(v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported value %T", v)
+	}
+	return nil
+}
+
+func (w *writer) int(n int64) {
+	switch {
+	case n >= 0 && n < 128, n < 0 && n >= -32:
+		w.WriteByte(byte(n))
+	default:
+		w.WriteByte(0xd3)
+		w.uint64(uint64(n))
+	}
+}
+
+func (w *writer) string(s string) {
+	if len(s) < 32 {
+		w.WriteByte(0xa0 | byte(len(s)))
+	} else if len(s) <= math.MaxUint8 {
+		w.WriteByte(0xd9)
+		w.WriteByte(byte(len(s)))
+	} else {
+		w.length(len(s), 0xda, 0xdb)
+	}
+	w.WriteString(s)
+}
+
+func (w *writer) mapHeader(n int) {
+	w.header(n, 0x80, 0xde, 0xdf)
+}
+
+// header writes the length of an array or map using its fix, 16 and 32 bit
+// formats.
+func (w *writer) header(n int, fix, b16, b32 byte) {
+	if n < 16 {
+		w.WriteByte(fix | byte(n))
+		return
+	}
+	w.length(n, b16, b32)
+}
+
+func (w *writer) length(n int, b16, b32 byte) {
+	if n <= math.MaxUint16 {
+		w.WriteByte(b16)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		w.Write(b[:])
+		return
+	}
+	w.WriteByte(b32)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	w.Write(b[:])
+}
+
+func (w *writer) uint64(n uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	w.Write(b[:])
+}
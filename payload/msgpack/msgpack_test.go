@@ -0,0 +1,49 @@
+package msgpack
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMsgpack(t *testing.T) {
+	suite.Run(t, &MsgpackTestSuite{})
+}
+
+type MsgpackTestSuite struct {
+	suite.Suite
+}
+
+func (m *MsgpackTestSuite) TestEncode() {
+	b, err := Encoder{}.Encode("c", "k", json.RawMessage(`[{"id":"1","properties":{"n":-1,"f":1.5,"ok":true,"x":null,"big":1000}}]`))
+	m.NoError(err)
+	m.Equal([]byte{
+		0x83,
+		0xaa, 'c', 'o', 'l', 'l', 'e', 'c', 't', 'i', 'o', 'n', 0xa1, 'c',
+		0xa9, 'w', 'r', 'i', 't', 'e', '_', 'k', 'e', 'y', 0xa1, 'k',
+		0xa7, 'o', 'b', 'j', 'e', 'c', 't', 's', 0x91,
+		0x82,
+		0xa2, 'i', 'd', 0xa1, '1',
+		0xaa, 'p', 'r', 'o', 'p', 'e', 'r', 't', 'i', 'e', 's', 0x85,
+		0xa3, 'b', 'i', 'g', 0xd3, 0, 0, 0, 0, 0, 0, 0x03, 0xe8,
+		0xa1, 'f', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa1, 'n', 0xff,
+		0xa2, 'o', 'k', 0xc3,
+		0xa1, 'x', 0xc0,
+	}, b)
+}
+
+func (m *MsgpackTestSuite) TestLongString() {
+	s := strings.Repeat("a", 300)
+	b, err := Encoder{}.Encode(s, "k", json.RawMessage(`[]`))
+	m.NoError(err)
+	m.Equal([]byte{0x83, 0xaa}, b[:2])
+	m.Equal([]byte{0xda, 0x01, 0x2c}, b[12:15])
+}
+
+func (m *MsgpackTestSuite) TestInvalid() {
+	_, err := Encoder{}.Encode("c", "k", json.RawMessage(`[`))
+	m.Error(err)
+}
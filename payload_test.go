@@ -0,0 +1,37 @@
+package objects
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPayload(t *testing.T) {
+	suite.Run(t, &PayloadTestSuite{})
+}
+
+type PayloadTestSuite struct {
+	suite.Suite
+}
+
+func (p *PayloadTestSuite) TestEnvelopeDefaults() {
+	objects := json.RawMessage(`[{"id":"1","properties":{"a":"b"}}]`)
+	b, err := (&Envelope{}).Encode("c", "key", objects)
+	p.NoError(err)
+
+	expected, err := encodeRequest(&batch{Collection: "c", WriteKey: "key", Objects: objects})
+	p.NoError(err)
+	p.JSONEq(string(expected), string(b))
+}
+
+func (p *PayloadTestSuite) TestEnvelope() {
+	e := &Envelope{Collection: "table", WriteKey: "-", Objects: "rows"}
+	b, err := e.Encode("c", "key", json.RawMessage(`[{"id":"1"}]`))
+	p.NoError(err)
+	p.Equal(`{"table":"c","rows":[{"id":"1"}]}`, string(b))
+
+	b, err = e.Encode("c", "key", nil)
+	p.NoError(err)
+	p.Equal(`{"table":"c","rows":[]}`, string(b))
+}
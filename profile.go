@@ -0,0 +1,32 @@
+package objects
+
+import (
+	"context"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// labels returns the pprof labels of the goroutines buffering and sending
+// batches: "objects.collection" and "objects.role", "buffer" or "send". CPU
+// profiles can be broken down by collection, e.g. with
+// `go tool pprof -tagfocus objects.collection=users`.
+func labels(collection, role string) pprof.LabelSet {
+	return pprof.Labels("objects.collection", collection, "objects.role", role)
+}
+
+type region interface {
+	End()
+}
+
+type noRegion struct{}
+
+func (noRegion) End() {}
+
+// region starts a runtime/trace region named name when TraceRegions is set.
+// The caller ends it.
+func (c *Client) region(ctx context.Context, name string) region {
+	if !c.TraceRegions {
+		return noRegion{}
+	}
+	return trace.StartRegion(ctx, name)
+}
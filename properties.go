@@ -0,0 +1,214 @@
+package objects
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Properties converts a struct, or a pointer to one, to a property map.
+// Fields are named and skipped following their json tags, including
+// omitempty, and embedded structs are promoted like with encoding/json.
+// Nested structs become nested maps, except those implementing
+// json.Marshaler or encoding.TextMarshaler, such as time.Time, which are
+// encoded by the client. It returns nil for other values.
+//
+// The fields of every type are looked up once and cached.
+func Properties(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	return structProperties(rv)
+}
+
+type fieldPlan struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	nested    bool
+	tagged    bool
+}
+
+var plans sync.Map // reflect.Type => []fieldPlan
+
+func structProperties(rv reflect.Value) map[string]interface{} {
+	fields := planFor(rv.Type())
+	m := make(map[string]interface{}, len(fields))
+
+	for _, f := range fields {
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok || (f.omitEmpty && isEmpty(fv)) {
+			continue
+		}
+
+		if f.nested {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				m[f.name] = structProperties(fv)
+				continue
+			}
+		}
+		if fv.Kind() == reflect.Ptr && fv.IsNil() {
+			m[f.name] = nil
+			continue
+		}
+		m[f.name] = fv.Interface()
+	}
+	return m
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but reports false instead
+// of panicking on a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func planFor(t reflect.Type) []fieldPlan {
+	if p, ok := plans.Load(t); ok {
+		return p.([]fieldPlan)
+	}
+	p := dominantFields(buildPlan(t, nil, map[reflect.Type]bool{}))
+	plans.Store(t, p)
+	return p
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func buildPlan(t reflect.Type, index []int, seen map[reflect.Type]bool) []fieldPlan {
+	seen[t] = true
+	defer delete(seen, t)
+
+	var fields []fieldPlan
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+		idx := append(append([]int{}, index...), i)
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && !seen[ft] {
+			fields = append(fields, buildPlan(ft, idx, seen)...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+
+		nested := ft.Kind() == reflect.Struct &&
+			!sf.Type.Implements(jsonMarshaler) && !sf.Type.Implements(textMarshaler) &&
+			!reflect.PtrTo(ft).Implements(jsonMarshaler) && !reflect.PtrTo(ft).Implements(textMarshaler)
+
+		fields = append(fields, fieldPlan{
+			name:      name,
+			index:     idx,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+			nested:    nested,
+			tagged:    tagged,
+		})
+	}
+	return fields
+}
+
+// dominantFields resolves fields sharing a name like encoding/json: the
+// shallowest one wins, then the only tagged one among the shallowest, and
+// the name is dropped if that is still ambiguous.
+func dominantFields(fields []fieldPlan) []fieldPlan {
+	byName := map[string][]fieldPlan{}
+	for _, f := range fields {
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	var out []fieldPlan
+	for _, f := range fields {
+		candidates := byName[f.name]
+		if candidates == nil {
+			continue // resolved already
+		}
+		delete(byName, f.name)
+
+		depth := len(candidates[0].index)
+		for _, c := range candidates {
+			if len(c.index) < depth {
+				depth = len(c.index)
+			}
+		}
+		var shallowest, tagged []fieldPlan
+		for _, c := range candidates {
+			if len(c.index) == depth {
+				shallowest = append(shallowest, c)
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+		}
+		switch {
+		case len(shallowest) == 1:
+			out = append(out, shallowest[0])
+		case len(tagged) == 1:
+			out = append(out, tagged[0])
+		}
+	}
+	return out
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package objects
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestProperties(t *testing.T) {
+	suite.Run(t, &PropertiesTestSuite{})
+}
+
+type PropertiesTestSuite struct {
+	suite.Suite
+}
+
+type address struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type audit struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type room struct {
+	*audit
+	Name     string   `json:"name"`
+	Reviews  int      `json:"review_count"`
+	Secret   string   `json:"-"`
+	Address  address  `json:"address"`
+	Previous *address `json:"previous"`
+	Tags     []string `json:"tags,omitempty"`
+	Plain    bool
+	private  string
+}
+
+func (p *PropertiesTestSuite) TestStruct() {
+	created := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	v := &room{
+		audit:   &audit{CreatedAt: created},
+		Name:    "Beach Room",
+		Reviews: 47,
+		Secret:  "s",
+		Address: address{City: "SF"},
+		Plain:   true,
+		private: "p",
+	}
+
+	p.Equal(map[string]interface{}{
+		"created_at":   created,
+		"name":         "Beach Room",
+		"review_count": 47,
+		"address":      map[string]interface{}{"city": "SF"},
+		"previous":     nil,
+		"Plain":        true,
+	}, Properties(v))
+
+	// Cached plans give the same result.
+	p.Equal(Properties(v), Properties(*v))
+}
+
+func (p *PropertiesTestSuite) TestNilEmbedded() {
+	props := Properties(room{Name: "a"})
+	p.NotContains(props, "created_at")
+	p.Equal("a", props["name"])
+}
+
+type named struct {
+	Name string `json:"Name"`
+	Kind string
+}
+
+type labeled struct {
+	Name  string
+	Kind  string
+	Label string `json:"label"`
+}
+
+type conflicts struct {
+	named
+	labeled
+	Label string `json:"label"`
+}
+
+func (p *PropertiesTestSuite) TestEmbeddedConflicts() {
+	v := conflicts{
+		named:   named{Name: "tagged", Kind: "a"},
+		labeled: labeled{Name: "untagged", Kind: "b", Label: "deep"},
+		Label:   "shallow",
+	}
+
+	// The tagged name wins, the shallowest label wins, and the ambiguous
+	// kind is dropped, as with encoding/json.
+	p.Equal(map[string]interface{}{
+		"Name":  "tagged",
+		"label": "shallow",
+	}, Properties(v))
+}
+
+func (p *PropertiesTestSuite) TestOther() {
+	m := map[string]interface{}{"a": 1}
+	p.Equal(m, Properties(m))
+	p.Nil(Properties(1))
+	p.Nil(Properties((*room)(nil)))
+}
+
+func BenchmarkProperties(b *testing.B) {
+	v := &room{Name: "Beach Room", Reviews: 47, Address: address{City: "SF"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Properties(v)
+	}
+}
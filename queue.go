@@ -0,0 +1,191 @@
+package objects
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// queue is a bounded multi-producer single-consumer queue of objects. It
+// replaces a buffered channel so producers calling Set concurrently on the
+// same collection only contend on an atomic counter, and the consumer takes
+// every queued object at once instead of one per receive.
+//
+// It is a ring of slots whose sequence numbers tell producers and the
+// consumer whose turn it is, as in Dmitry Vyukov's bounded MPMC queue.
+// Producers only block, on space, when the queue is full.
+type queue struct {
+	slots []slot
+
+	// head is the position of the next push, shared by producers. tail is
+	// the position of the next pop, only updated by the consumer. They are
+	// kept on separate cache lines.
+	head uint64
+	_    [56]byte
+	tail uint64
+	_    [56]byte
+
+	// ready is signaled after pushes, space after pops. Both hold at most one
+	// pending signal.
+	ready chan struct{}
+	space chan struct{}
+
+	// pushing counts the pushes in progress, which close waits for: once it
+	// returns, every object pushed is in the queue.
+	closed  int32
+	pushing int32
+	done    chan struct{}
+}
+
+type slot struct {
+	seq uint64
+	v   *Object
+
+	// at is when v was pushed.
+	at time.Time
+}
+
+// newQueue returns a queue holding up to capacity objects, at least two:
+// with a single slot, a pushed object's sequence number would be the one of
+// the free slot of the next lap.
+func newQueue(capacity int) *queue {
+	if capacity < 2 {
+		capacity = 2
+	}
+	q := &queue{
+		slots: make([]slot, capacity),
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	for i := range q.slots {
+		q.slots[i].seq = uint64(i)
+	}
+	return q
+}
+
+// push adds v to the queue, blocking while it is full. It returns false if
+// the queue was closed.
+func (q *queue) push(v *Object) bool {
+	// Counted before closed is checked, see close.
+	atomic.AddInt32(&q.pushing, 1)
+	defer atomic.AddInt32(&q.pushing, -1)
+
+	woken := false
+	for {
+		if atomic.LoadInt32(&q.closed) == 1 {
+			return false
+		}
+		if q.offer(v) {
+			notify(q.ready)
+			if woken && q.len() < q.cap() {
+				// Let the next blocked producer push too.
+				notify(q.space)
+			}
+			return true
+		}
+		select {
+		case <-q.space:
+			woken = true
+		case <-q.done:
+		}
+	}
+}
+
+// offer adds v to the queue unless it is full.
+func (q *queue) offer(v *Object) bool {
+	n := uint64(len(q.slots))
+	pos := atomic.LoadUint64(&q.head)
+	for {
+		s := &q.slots[pos%n]
+		seq := atomic.LoadUint64(&s.seq)
+		switch {
+		case seq == pos:
+			if atomic.CompareAndSwapUint64(&q.head, pos, pos+1) {
+				s.v, s.at = v, time.Now()
+				atomic.StoreUint64(&s.seq, pos+1)
+				return true
+			}
+			pos = atomic.LoadUint64(&q.head)
+		case seq < pos:
+			// The slot still holds the object pushed a lap earlier.
+			return false
+		default:
+			pos = atomic.LoadUint64(&q.head)
+			runtime.Gosched()
+		}
+	}
+}
+
+// pop removes the oldest object. It must only be called by the consumer.
+func (q *queue) pop() (*Object, bool) {
+	v, _, ok := q.take()
+	return v, ok
+}
+
+// take removes the oldest object and returns when it was pushed. It must
+// only be called by the consumer.
+func (q *queue) take() (*Object, time.Time, bool) {
+	n := uint64(len(q.slots))
+	s := &q.slots[q.tail%n]
+	if atomic.LoadUint64(&s.seq) != q.tail+1 {
+		return nil, time.Time{}, false
+	}
+	v, at := s.v, s.at
+	s.v = nil
+	atomic.StoreUint64(&s.seq, q.tail+n)
+	atomic.StoreUint64(&q.tail, q.tail+1)
+	notify(q.space)
+	return v, at, true
+}
+
+// drain appends the queued objects to dst, and when they were pushed to at,
+// at most one queue length so a steady stream of pushes can't keep the
+// consumer draining forever.
+func (q *queue) drain(dst []*Object, at []time.Time) ([]*Object, []time.Time) {
+	for i := 0; i < len(q.slots); i++ {
+		v, t, ok := q.take()
+		if !ok {
+			return dst, at
+		}
+		dst, at = append(dst, v), append(at, t)
+	}
+	// Objects may be left, wake the consumer again.
+	notify(q.ready)
+	return dst, at
+}
+
+// len returns the number of queued objects, including pushes in progress.
+func (q *queue) len() int {
+	tail := atomic.LoadUint64(&q.tail)
+	head := atomic.LoadUint64(&q.head)
+	if head < tail {
+		return 0
+	}
+	return int(head - tail)
+}
+
+func (q *queue) cap() int {
+	return len(q.slots)
+}
+
+// close rejects further pushes, wakes blocked producers and waits for the
+// pushes in progress, so that a push either fails or completes before close
+// returns. Queued objects can still be drained.
+func (q *queue) close() {
+	if atomic.CompareAndSwapInt32(&q.closed, 0, 1) {
+		close(q.done)
+	}
+	// A push counted after this sees closed set.
+	for atomic.LoadInt32(&q.pushing) > 0 {
+		runtime.Gosched()
+	}
+}
+
+// notify sends on a channel of capacity one unless a signal is pending.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
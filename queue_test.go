@@ -0,0 +1,150 @@
+package objects
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestQueue(t *testing.T) {
+	suite.Run(t, &QueueTestSuite{})
+}
+
+type QueueTestSuite struct {
+	suite.Suite
+}
+
+func (s *QueueTestSuite) TestOrder() {
+	q := newQueue(4)
+	for i := 0; i < 3; i++ {
+		s.True(q.push(&Object{ID: strconv.Itoa(i)}))
+	}
+	s.Equal(3, q.len())
+	s.Equal(4, q.cap())
+
+	objs, at := q.drain(nil, nil)
+	s.Len(objs, 3)
+	s.Len(at, 3)
+	for i, v := range objs {
+		s.Equal(strconv.Itoa(i), v.ID)
+		s.False(at[i].IsZero())
+	}
+	s.False(at[1].Before(at[0]))
+	_, ok := q.pop()
+	s.False(ok)
+	s.Equal(0, q.len())
+}
+
+func (s *QueueTestSuite) TestProducers() {
+	q := newQueue(8)
+	wg := sync.WaitGroup{}
+	for p := 0; p < 16; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				q.push(&Object{Collection: strconv.Itoa(p), ID: strconv.Itoa(i)})
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		q.close()
+	}()
+
+	// Every producer's objects are received in the order they were pushed.
+	next := map[string]int{}
+	var objs []*Object
+	var at []time.Time
+	for q.len() > 0 || !s.closed(q) {
+		select {
+		case <-q.ready:
+		case <-q.done:
+		}
+		objs, at = q.drain(objs[:0], at[:0])
+		for _, v := range objs {
+			s.Equal(strconv.Itoa(next[v.Collection]), v.ID)
+			next[v.Collection]++
+		}
+	}
+	for p := 0; p < 16; p++ {
+		s.Equal(1000, next[strconv.Itoa(p)])
+	}
+}
+
+func (s *QueueTestSuite) TestBlockWhenFull() {
+	q := newQueue(1)
+	s.Equal(2, q.cap())
+	s.True(q.push(&Object{ID: "0"}))
+	s.True(q.push(&Object{ID: "1"}))
+
+	pushed := make(chan bool)
+	go func() { pushed <- q.push(&Object{ID: "2"}) }()
+	select {
+	case <-pushed:
+		s.Fail("push didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	v, _ := q.pop()
+	s.Equal("0", v.ID)
+	s.True(<-pushed)
+}
+
+func (s *QueueTestSuite) TestClose() {
+	q := newQueue(2)
+	s.True(q.push(&Object{ID: "1"}))
+	s.True(q.push(&Object{ID: "2"}))
+
+	pushed := make(chan bool)
+	go func() { pushed <- q.push(&Object{ID: "3"}) }()
+	q.close()
+	s.False(<-pushed)
+	s.False(q.push(&Object{ID: "4"}))
+
+	v, ok := q.pop()
+	s.True(ok)
+	s.Equal("1", v.ID)
+}
+
+func (s *QueueTestSuite) TestCloseWhilePushing() {
+	q := newQueue(1024)
+	wg := sync.WaitGroup{}
+	pushed := make([]int, 8)
+	for i := range pushed {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for q.push(&Object{ID: strconv.Itoa(i)}) {
+				pushed[i]++
+				if pushed[i] == 100 {
+					return
+				}
+			}
+		}(i)
+	}
+	q.close()
+
+	// Every successful push is drained after close returns.
+	objs, _ := q.drain(nil, nil)
+	n := len(objs)
+	wg.Wait()
+	total := 0
+	for _, p := range pushed {
+		total += p
+	}
+	s.Equal(total, n)
+	s.Equal(0, q.len())
+}
+
+func (s *QueueTestSuite) closed(q *queue) bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
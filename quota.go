@@ -0,0 +1,149 @@
+package objects
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("Quota exceeded")
+)
+
+// QuotaMode is what happens to objects set once a quota is exceeded.
+type QuotaMode int
+
+const (
+	// QuotaWarn logs a warning once per period and sends objects anyway.
+	QuotaWarn QuotaMode = iota
+
+	// QuotaDrop drops objects with DropQuota.
+	QuotaDrop
+
+	// QuotaBlock blocks Set until the quota resets.
+	QuotaBlock
+)
+
+// Quota caps the objects of a collection passed to Set, SendBatch or
+// SimpleClient.Send per period, to protect against runaway producers. Usage is tracked in memory and counts
+// encoded objects, so the objects already queued when a QuotaBlock quota is
+// exceeded are still sent.
+type Quota struct {
+	// MaxObjects and MaxBytes are the limits per period. Zero is unlimited.
+	MaxObjects int64
+	MaxBytes   int64
+
+	// Period is how often usage resets, at multiples of Period since the
+	// zero time in UTC, e.g. at midnight UTC for 24h. Zero means 24h.
+	Period time.Duration
+
+	Mode QuotaMode
+}
+
+func (q *Quota) window(now time.Time) time.Time {
+	period := q.Period
+	if period <= 0 {
+		period = 24 * time.Hour
+	}
+	return now.UTC().Truncate(period)
+}
+
+func (q *Quota) next(now time.Time) time.Time {
+	period := q.Period
+	if period <= 0 {
+		period = 24 * time.Hour
+	}
+	return q.window(now).Add(period)
+}
+
+type quotaUsage struct {
+	window  time.Time
+	objects int64
+	bytes   int64
+	warned  bool
+}
+
+type quotas struct {
+	sync.Mutex
+	usage map[string]*quotaUsage
+}
+
+// current returns the usage of collection in the window of quota q.
+func (qs *quotas) current(collection string, q *Quota, now time.Time) *quotaUsage {
+	if qs.usage == nil {
+		qs.usage = map[string]*quotaUsage{}
+	}
+	u, ok := qs.usage[collection]
+	if w := q.window(now); !ok || !u.window.Equal(w) {
+		u = &quotaUsage{window: w}
+		qs.usage[collection] = u
+	}
+	return u
+}
+
+func (u *quotaUsage) exceeded(q *Quota) bool {
+	return (q.MaxObjects > 0 && u.objects >= q.MaxObjects) ||
+		(q.MaxBytes > 0 && u.bytes >= q.MaxBytes)
+}
+
+// admit counts an encoded object of size bytes against its collection's
+// quota. It reports whether the object may be sent, dropping it otherwise.
+func (c *Client) admit(v *Object, size int) bool {
+	q, ok := c.Quotas[v.Collection]
+	if !ok {
+		return true
+	}
+
+	c.quotas.Lock()
+	u := c.quotas.current(v.Collection, q, time.Now())
+	exceeded := u.exceeded(q)
+	warn := exceeded && q.Mode == QuotaWarn && !u.warned
+	if exceeded && q.Mode == QuotaWarn {
+		u.warned = true
+	}
+	if !exceeded || q.Mode != QuotaDrop {
+		u.objects++
+		u.bytes += int64(size)
+	}
+	c.quotas.Unlock()
+
+	if warn {
+		c.Logger.Printf("[Warn] Quota exceeded for collection `%s`, objects are still sent", v.Collection)
+	}
+	if exceeded && q.Mode == QuotaDrop {
+		c.drop(v, DropQuota, ErrQuotaExceeded)
+		return false
+	}
+	return true
+}
+
+// waitQuota blocks while the QuotaBlock quota of collection is exceeded, or
+// until the client is closed or ctx is done.
+func (c *Client) waitQuota(ctx context.Context, collection string) error {
+	q, ok := c.Quotas[collection]
+	if !ok || q.Mode != QuotaBlock {
+		return nil
+	}
+
+	for {
+		now := time.Now()
+		c.quotas.Lock()
+		exceeded := c.quotas.current(collection, q, now).exceeded(q)
+		c.quotas.Unlock()
+		if !exceeded {
+			return nil
+		}
+
+		timer := time.NewTimer(q.next(now).Sub(now))
+		select {
+		case <-timer.C:
+		case <-c.closing:
+			timer.Stop()
+			return ErrClientClosed
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package objects
+
+// raceEnabled is whether the race detector is on. It instruments memory
+// accesses, which allocates.
+const raceEnabled = true
@@ -0,0 +1,186 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var (
+	ErrNotFound     = errors.New("Object not found")
+	ErrNotSupported = errors.New("Operation not supported by the API")
+	ErrUnauthorized = errors.New("Write key rejected by the API")
+)
+
+// Get fetches a single object from the Objects store.
+// It returns ErrNotFound if the object does not exist.
+func (c *Client) Get(ctx context.Context, collection, id string) (*Object, error) {
+	v := &Object{}
+	err := c.call(ctx, "GET", "/v1/get", url.Values{
+		"collection": {collection},
+		"id":         {id},
+	}, v)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Collection = collection
+	return v, nil
+}
+
+// call makes a single authenticated API request and decodes the response
+// into v, unless v is nil.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, v interface{}) error {
+	resp, err := c.request(ctx, method, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return ErrNotSupported
+	default:
+		return fmt.Errorf("HTTP %s Request Failed, Status Code %d", method, resp.StatusCode)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// request sends a single authenticated API request without a body.
+func (c *Client) request(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.endpoint()+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.writeKey, "")
+	req.Header.Set("User-Agent", userAgent)
+	if c.Signer != nil {
+		if err := c.Signer.Sign(req, nil); err != nil {
+			return nil, err
+		}
+	}
+	return c.do(req.WithContext(ctx), nil)
+}
+
+// ListOptions configures a List call.
+type ListOptions struct {
+	// Limit is the page size requested from the API; zero uses the API default.
+	Limit int
+
+	// Cursor resumes listing from a cursor returned by Iterator.Cursor.
+	Cursor string
+}
+
+// Iterator walks the objects of a collection, fetching pages lazily.
+//
+//	it, err := client.List(ctx, "rooms", objects.ListOptions{})
+//	for it.Next() {
+//		v := it.Object()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	ctx        context.Context
+	client     *Client
+	collection string
+	limit      int
+
+	page    []*Object
+	pos     int
+	current *Object
+	cursor  string
+	err     error
+}
+
+type listResponse struct {
+	Objects    []*Object `json:"objects"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// List returns an iterator over the objects of collection. The first page is
+// fetched immediately so configuration errors are reported here.
+func (c *Client) List(ctx context.Context, collection string, opts ListOptions) (*Iterator, error) {
+	it := &Iterator{
+		ctx:        ctx,
+		client:     c,
+		collection: collection,
+		limit:      opts.Limit,
+		cursor:     opts.Cursor,
+	}
+	if err := it.fetch(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func (it *Iterator) fetch() error {
+	query := url.Values{"collection": {it.collection}}
+	if it.limit > 0 {
+		query.Set("limit", strconv.Itoa(it.limit))
+	}
+	if it.cursor != "" {
+		query.Set("cursor", it.cursor)
+	}
+
+	resp := &listResponse{}
+	if err := it.client.call(it.ctx, "GET", "/v1/list", query, resp); err != nil {
+		return err
+	}
+
+	for _, v := range resp.Objects {
+		v.Collection = it.collection
+	}
+	it.page = resp.Objects
+	it.pos = 0
+	it.cursor = resp.NextCursor
+	return nil
+}
+
+// Next advances to the next object, fetching the next page when needed.
+// It returns false when the collection is exhausted or an error occurred.
+func (it *Iterator) Next() bool {
+	for it.pos >= len(it.page) {
+		if it.err != nil || it.cursor == "" {
+			it.current = nil
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil {
+			it.current = nil
+			return false
+		}
+	}
+
+	it.current = it.page[it.pos]
+	it.pos++
+	return true
+}
+
+// Object returns the object at the current position.
+func (it *Iterator) Object() *Object {
+	return it.current
+}
+
+// Cursor returns the cursor of the next page, which can be passed in
+// ListOptions to resume listing later. It is empty after the last page.
+func (it *Iterator) Cursor() string {
+	return it.cursor
+}
+
+// Err returns the first error encountered while fetching pages.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,176 @@
+package objects
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRead(t *testing.T) {
+	suite.Run(t, &ReadTestSuite{})
+}
+
+type ReadTestSuite struct {
+	suite.Suite
+}
+
+func (r *ReadTestSuite) SetupSuite() {
+	httpmock.Activate()
+
+	httpmock.RegisterResponder("GET", "https://objects.segment.com/v1/get", func(req *http.Request) (*http.Response, error) {
+		if key, _, _ := req.BasicAuth(); key != "writeKey" {
+			return httpmock.NewStringResponse(401, ""), nil
+		}
+
+		q := req.URL.Query()
+		if q.Get("collection") != "rooms" || q.Get("id") != "room1000" {
+			return httpmock.NewStringResponse(404, ""), nil
+		}
+
+		return httpmock.NewStringResponse(200, `{"id": "room1000", "properties": {"name": "Beach Room", "review_count": 47}}`), nil
+	})
+
+	pages := map[string]string{
+		"":   `{"objects": [{"id": "1", "properties": {"p": 1}}, {"id": "2", "properties": {"p": 2}}], "next_cursor": "c2"}`,
+		"c2": `{"objects": [{"id": "3", "properties": {"p": 3}}], "next_cursor": "c3"}`,
+		"c3": `{"objects": [], "next_cursor": ""}`,
+	}
+	httpmock.RegisterResponder("GET", "https://objects.segment.com/v1/list", func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		page, ok := pages[q.Get("cursor")]
+		if q.Get("collection") != "rooms" || q.Get("limit") != "2" || !ok {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		return httpmock.NewStringResponse(200, page), nil
+	})
+
+	httpmock.RegisterResponder("GET", "https://objects.segment.com/v1/collections", func(req *http.Request) (*http.Response, error) {
+		if key, _, _ := req.BasicAuth(); key != "writeKey" {
+			return httpmock.NewStringResponse(401, ""), nil
+		}
+		return httpmock.NewStringResponse(200, `{"collections": ["rooms", "users"]}`), nil
+	})
+
+	httpmock.RegisterResponder("GET", "https://nocollections.segment.com/v1/collections", httpmock.NewStringResponder(404, ""))
+
+	httpmock.RegisterResponder("DELETE", "https://objects.segment.com/v1/collections", func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Query().Get("collection") {
+		case "rooms":
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		case "users":
+			return httpmock.NewStringResponse(405, ""), nil
+		}
+		return httpmock.NewStringResponse(404, ""), nil
+	})
+}
+
+func (r *ReadTestSuite) TestGet() {
+	client := New("writeKey")
+
+	v, err := client.Get(context.Background(), "rooms", "room1000")
+	r.NoError(err)
+	r.Equal("room1000", v.ID)
+	r.Equal("rooms", v.Collection)
+	r.Equal("Beach Room", v.Properties["name"])
+	r.Equal(float64(47), v.Properties["review_count"])
+}
+
+func (r *ReadTestSuite) TestGetErrors() {
+	client := New("writeKey")
+
+	_, err := client.Get(context.Background(), "rooms", "missing")
+	r.Equal(ErrNotFound, err)
+
+	_, err = New("badKey").Get(context.Background(), "rooms", "room1000")
+	r.Error(err)
+	r.NotEqual(ErrNotFound, err)
+}
+
+func (r *ReadTestSuite) TestList() {
+	client := New("writeKey")
+
+	it, err := client.List(context.Background(), "rooms", ListOptions{Limit: 2})
+	r.NoError(err)
+
+	ids := []string{}
+	for it.Next() {
+		r.Equal("rooms", it.Object().Collection)
+		ids = append(ids, it.Object().ID)
+	}
+	r.NoError(it.Err())
+	r.Equal([]string{"1", "2", "3"}, ids)
+	r.Equal("", it.Cursor())
+	r.Nil(it.Object())
+}
+
+func (r *ReadTestSuite) TestListResume() {
+	client := New("writeKey")
+
+	it, err := client.List(context.Background(), "rooms", ListOptions{Limit: 2, Cursor: "c2"})
+	r.NoError(err)
+	r.True(it.Next())
+	r.Equal("3", it.Object().ID)
+	r.False(it.Next())
+	r.NoError(it.Err())
+}
+
+func (r *ReadTestSuite) TestListErrors() {
+	client := New("writeKey")
+
+	_, err := client.List(context.Background(), "rooms", ListOptions{Limit: 2, Cursor: "unknown"})
+	r.Error(err)
+}
+
+func (r *ReadTestSuite) TestCollections() {
+	client := New("writeKey")
+
+	names, err := client.Collections(context.Background())
+	r.NoError(err)
+	r.Equal([]string{"rooms", "users"}, names)
+}
+
+func (r *ReadTestSuite) TestPing() {
+	r.NoError(New("writeKey").Ping(context.Background()))
+	r.Equal(ErrUnauthorized, New("badKey").Ping(context.Background()))
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://unknown.segment.com"
+	r.Error(client.Ping(context.Background()))
+
+	// An API without the collections endpoint is reachable.
+	client.BaseEndpoint = "https://nocollections.segment.com"
+	r.NoError(client.Ping(context.Background()))
+}
+
+func (r *ReadTestSuite) TestDeleteCollection() {
+	client := New("writeKey")
+
+	r.NoError(client.DeleteCollection(context.Background(), "rooms"))
+	r.Equal(ErrNotSupported, client.DeleteCollection(context.Background(), "users"))
+	r.Equal(ErrNotFound, client.DeleteCollection(context.Background(), "missing"))
+}
+
+func (r *ReadTestSuite) TestDebug() {
+	httpmock.RegisterResponder("GET", "https://debug.segment.com/v1/get", httpmock.NewStringResponder(500, `{"error":"boom"}`))
+
+	out := &bytes.Buffer{}
+	client := New("writeKey")
+	client.BaseEndpoint = "https://debug.segment.com"
+	client.Logger = log.New(out, "", 0)
+	client.Debug = true
+	client.DebugBodies = true
+
+	_, err := client.Get(context.Background(), "rooms", "room1000")
+	r.Error(err)
+
+	logged := out.String()
+	r.Contains(logged, "[Debug] GET https://debug.segment.com/v1/get?collection=rooms&id=room1000 500")
+	r.Contains(logged, "Authorization: [redacted]")
+	r.Contains(logged, "\"error\": \"boom\"")
+	r.NotContains(logged, "Basic ")
+}
@@ -0,0 +1,50 @@
+package objects
+
+import (
+	"context"
+	"time"
+)
+
+// Receipt confirms that an object was accepted by the Objects API.
+type Receipt struct {
+	Collection string
+	ID         string
+	Time       time.Time
+
+	// BatchID is the RequestID of the batch that delivered the object.
+	BatchID string
+}
+
+// ReceiptStore persists delivery receipts, e.g. to mark outbox rows as synced
+// in the same transaction. Store is called once per delivered batch, from
+// the goroutine delivering it, so it delays the next deliveries while it
+// runs. Its errors are logged and never fail the delivery.
+type ReceiptStore interface {
+	Store(ctx context.Context, receipts []Receipt) error
+}
+
+// ReceiptStoreFunc adapts a function to a ReceiptStore.
+type ReceiptStoreFunc func(ctx context.Context, receipts []Receipt) error
+
+func (f ReceiptStoreFunc) Store(ctx context.Context, receipts []Receipt) error {
+	return f(ctx, receipts)
+}
+
+// storeReceipts passes a receipt for every object of request, delivered in
+// the batch batchID, to the client's ReceiptStore.
+func (c *Client) storeReceipts(ctx context.Context, request *batch, batchID string) {
+	now := time.Now().UTC()
+	receipts := make([]Receipt, len(request.ids))
+	for i, id := range request.ids {
+		receipts[i] = Receipt{
+			Collection: request.Collection,
+			ID:         id,
+			Time:       now,
+			BatchID:    batchID,
+		}
+	}
+
+	if err := c.Receipts.Store(ctx, receipts); err != nil {
+		c.Logger.Printf("[Error] Receipts failed to store for collection `%s`: %v", request.Collection, err)
+	}
+}
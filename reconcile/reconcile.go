@@ -0,0 +1,170 @@
+// Package reconcile converges a remote Objects collection towards a local
+// snapshot, emitting the Sets and Deletes needed to make them match.
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/segmentio/objects-go"
+)
+
+// Iterator is a stream of objects. *objects.Iterator satisfies it.
+type Iterator interface {
+	Next() bool
+	Object() *objects.Object
+	Err() error
+}
+
+// Slice returns an Iterator over objs.
+func Slice(objs []*objects.Object) Iterator {
+	return &sliceIterator{objs: objs, pos: -1}
+}
+
+type sliceIterator struct {
+	objs []*objects.Object
+	pos  int
+}
+
+func (s *sliceIterator) Next() bool {
+	s.pos++
+	return s.pos < len(s.objs)
+}
+
+func (s *sliceIterator) Object() *objects.Object {
+	if s.pos < 0 || s.pos >= len(s.objs) {
+		return nil
+	}
+	return s.objs[s.pos]
+}
+
+func (s *sliceIterator) Err() error {
+	return nil
+}
+
+// Report describes the changes needed to converge a collection.
+type Report struct {
+	Collection string
+
+	// Sets are local objects missing from, or different in, the remote collection.
+	Sets []*objects.Object
+
+	// Deletes are IDs of remote objects missing from the local snapshot.
+	Deletes []string
+
+	// Unchanged counts local objects already matching the remote collection.
+	Unchanged int
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%s: %d to set, %d to delete, %d unchanged", r.Collection, len(r.Sets), len(r.Deletes), r.Unchanged)
+}
+
+// Reconciler converges remote collections read and written through Client.
+type Reconciler struct {
+	Client *objects.Client
+
+	// DryRun computes the report without sending anything.
+	DryRun bool
+
+	// Delete is called for every remote object missing from the local
+	// snapshot. When nil, deletions are only reported.
+	Delete func(ctx context.Context, collection, id string) error
+}
+
+// New returns a Reconciler applying differences with client.
+func New(client *objects.Client) *Reconciler {
+	return &Reconciler{Client: client}
+}
+
+// Run compares local against the remote collection and, unless DryRun is
+// set, applies the differences. Sets are enqueued with Client.Set, so the
+// client must still be flushed or closed for them to be delivered.
+func (r *Reconciler) Run(ctx context.Context, collection string, local Iterator) (*Report, error) {
+	remote, err := r.fetch(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Collection: collection}
+	for local.Next() {
+		v := local.Object()
+		if v.Collection == "" {
+			v.Collection = collection
+		}
+
+		props, err := roundtrip(r.Client.Flatten(v))
+		if err != nil {
+			return nil, fmt.Errorf("Object `%s` cannot be compared: %v", v.ID, err)
+		}
+
+		existing, ok := remote[v.ID]
+		delete(remote, v.ID)
+		if ok && reflect.DeepEqual(existing, props) {
+			report.Unchanged++
+			continue
+		}
+		report.Sets = append(report.Sets, v)
+	}
+	if err := local.Err(); err != nil {
+		return nil, err
+	}
+
+	for id := range remote {
+		report.Deletes = append(report.Deletes, id)
+	}
+	sort.Strings(report.Deletes)
+
+	if r.DryRun {
+		return report, nil
+	}
+
+	for _, v := range report.Sets {
+		if err := r.Client.Set(v); err != nil {
+			return report, err
+		}
+	}
+
+	if r.Delete != nil {
+		for _, id := range report.Deletes {
+			if err := r.Delete(ctx, collection, id); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// fetch loads the remote collection as a map of ID to properties.
+func (r *Reconciler) fetch(ctx context.Context, collection string) (map[string]map[string]interface{}, error) {
+	it, err := r.Client.List(ctx, collection, objects.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	remote := map[string]map[string]interface{}{}
+	for it.Next() {
+		v := it.Object()
+		props, err := roundtrip(v.Properties)
+		if err != nil {
+			return nil, err
+		}
+		remote[v.ID] = props
+	}
+	return remote, it.Err()
+}
+
+// roundtrip encodes and decodes m so local and remote values share the same
+// representation (e.g. float64 numbers) before comparison.
+func roundtrip(m map[string]interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{}
+	return ret, json.Unmarshal(b, &ret)
+}
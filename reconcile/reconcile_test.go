@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestReconcile(t *testing.T) {
+	suite.Run(t, &ReconcileTestSuite{})
+}
+
+type ReconcileTestSuite struct {
+	suite.Suite
+
+	mu  sync.Mutex
+	set []string
+}
+
+func (r *ReconcileTestSuite) SetupSuite() {
+	httpmock.Activate()
+
+	httpmock.RegisterResponder("GET", "https://objects.segment.com/v1/list", func(req *http.Request) (*http.Response, error) {
+		return httpmock.NewStringResponse(200, `{"objects": [
+			{"id": "1", "properties": {"name": "same", "review_count": 1}},
+			{"id": "2", "properties": {"name": "old"}},
+			{"id": "3", "properties": {"name": "gone"}}
+		]}`), nil
+	})
+
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := struct {
+			Objects []*objects.Object `json:"objects"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&v); err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		r.mu.Lock()
+		for _, o := range v.Objects {
+			r.set = append(r.set, o.ID)
+		}
+		r.mu.Unlock()
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (r *ReconcileTestSuite) SetupTest() {
+	r.mu.Lock()
+	r.set = nil
+	r.mu.Unlock()
+}
+
+func (r *ReconcileTestSuite) local() Iterator {
+	return Slice([]*objects.Object{
+		{ID: "1", Properties: map[string]interface{}{"Name": "same", "reviewCount": 1}},
+		{ID: "2", Properties: map[string]interface{}{"name": "new"}},
+		{ID: "4", Properties: map[string]interface{}{"name": "added"}},
+	})
+}
+
+func (r *ReconcileTestSuite) TestDryRun() {
+	client := objects.New("writeKey")
+	rec := New(client)
+	rec.DryRun = true
+	rec.Delete = func(ctx context.Context, collection, id string) error {
+		r.Fail("Delete called in dry-run mode")
+		return nil
+	}
+
+	report, err := rec.Run(context.Background(), "rooms", r.local())
+	r.NoError(err)
+	r.Equal(1, report.Unchanged)
+	r.Len(report.Sets, 2)
+	r.Equal("2", report.Sets[0].ID)
+	r.Equal("4", report.Sets[1].ID)
+	r.Equal([]string{"3"}, report.Deletes)
+	r.Equal("rooms: 2 to set, 1 to delete, 1 unchanged", report.String())
+
+	r.NoError(client.Close())
+	r.Len(r.set, 0)
+}
+
+func (r *ReconcileTestSuite) TestApply() {
+	client := objects.New("writeKey")
+	rec := New(client)
+	deleted := []string{}
+	rec.Delete = func(ctx context.Context, collection, id string) error {
+		r.Equal("rooms", collection)
+		deleted = append(deleted, id)
+		return nil
+	}
+
+	_, err := rec.Run(context.Background(), "rooms", r.local())
+	r.NoError(err)
+	r.NoError(client.Close())
+
+	r.Equal([]string{"2", "4"}, r.set)
+	r.Equal([]string{"3"}, deleted)
+}
@@ -0,0 +1,32 @@
+package objects
+
+import "strings"
+
+const redacted = "[redacted]"
+
+// redact replaces the write key in s so it never ends up in logs or errors.
+func (c *Client) redact(s string) string {
+	if c.writeKey == "" {
+		return s
+	}
+	return strings.Replace(s, c.writeKey, redacted, -1)
+}
+
+// redactError returns err with the write key redacted from its message. The
+// result wraps err, so errors.Is and errors.As still see through it.
+func (c *Client) redactError(err error) error {
+	if err == nil || c.writeKey == "" || !strings.Contains(err.Error(), c.writeKey) {
+		return err
+	}
+	return &redactedError{msg: c.redact(err.Error()), err: err}
+}
+
+// redactedError is an error whose message had the write key redacted.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
@@ -0,0 +1,206 @@
+package objects
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// retry calls fn until it succeeds, b stops or ctx is done. Unlike
+// backoff.Retry, it stops sleeping as soon as ctx is done. It returns the
+// last error of fn. Attempts are recorded in the retryState of ctx, if any.
+func retry(ctx context.Context, b backoff.BackOff, fn func() error) error {
+	st := retryStateFrom(ctx)
+	b.Reset()
+	var last time.Duration
+	for {
+		if st != nil {
+			st.status = 0
+		}
+		err := fn()
+		if st != nil {
+			st.attempts++
+			st.retryAt = time.Time{}
+		}
+		if p, ok := err.(*permanentError); ok {
+			return p.err
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		next := b.NextBackOff()
+		if next == backoff.Stop {
+			if st != nil && last > 0 {
+				st.retryAt = time.Now().Add(last)
+			}
+			return err
+		}
+		last = next
+		if st != nil {
+			st.retryAt = time.Now().Add(next)
+		}
+
+		t := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// permanentError is returned by the function passed to retry to fail
+// without retrying.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// responseError returns err, the error of a request that got a response,
+// made permanent when only network errors are retried.
+func (c *Client) responseError(err error) error {
+	if c.RetryNetworkErrorsOnly {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// retryState records the attempts made to deliver a batch and when the next
+// one is due, so a batch spooled after failing, e.g. aborted by Close,
+// resumes its backoff schedule when replayed instead of being retried at
+// once.
+type retryState struct {
+	attempts int
+	retryAt  time.Time
+
+	// status is the status code of the last response, if any.
+	status int
+}
+
+// rejected reports whether the last attempt was rejected by the API, e.g.
+// as invalid, so that resending the same request cannot succeed. Rate
+// limited and timed out requests are not rejected.
+func (st *retryState) rejected() bool {
+	switch {
+	case st.status == http.StatusRequestTimeout, st.status == http.StatusTooManyRequests:
+		return false
+	default:
+		return st.status >= 400 && st.status < 500
+	}
+}
+
+type retryStateKey struct{}
+
+func withRetryState(ctx context.Context, st *retryState) context.Context {
+	return context.WithValue(ctx, retryStateKey{}, st)
+}
+
+func retryStateFrom(ctx context.Context) *retryState {
+	st, _ := ctx.Value(retryStateKey{}).(*retryState)
+	return st
+}
+
+// RetryBudget caps retries to a fraction of requests across all collections,
+// so retries do not amplify the load on an API already failing. Every
+// request earns Ratio tokens, up to Burst, and every retry spends one; once
+// tokens run out, failed requests are not retried.
+type RetryBudget struct {
+	// Ratio is the number of retries earned per request, e.g. 0.1 allows
+	// one retry per ten requests.
+	Ratio float64
+
+	// Burst is the most tokens saved, and the tokens available at first.
+	Burst int
+
+	// OnExhausted is optionally called when a retry is denied after the
+	// budget ran out. It is called again only once the budget recovered.
+	OnExhausted func()
+
+	mu        sync.Mutex
+	tokens    float64
+	started   bool
+	exhausted bool
+}
+
+func NewRetryBudget(ratio float64, burst int) *RetryBudget {
+	return &RetryBudget{Ratio: ratio, Burst: burst}
+}
+
+// Remaining returns the number of retries currently allowed.
+func (b *RetryBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start()
+	return int(b.tokens)
+}
+
+func (b *RetryBudget) start() {
+	if !b.started {
+		b.tokens = float64(b.Burst)
+		b.started = true
+	}
+}
+
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start()
+	b.tokens += b.Ratio
+	if b.tokens > float64(b.Burst) {
+		b.tokens = float64(b.Burst)
+	}
+	if b.tokens >= 1 {
+		b.exhausted = false
+	}
+}
+
+// withdraw spends a token for a retry and reports whether it is allowed.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	b.start()
+	if b.tokens >= 1 {
+		b.tokens--
+		b.mu.Unlock()
+		return true
+	}
+	notify := !b.exhausted
+	b.exhausted = true
+	b.mu.Unlock()
+
+	if notify && b.OnExhausted != nil {
+		b.OnExhausted()
+	}
+	return false
+}
+
+// budgetBackOff stops retrying once the client's RetryBudget is spent, and
+// reports every retry to the client's Metrics.
+type budgetBackOff struct {
+	backoff.BackOff
+	client *Client
+}
+
+func (b *budgetBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop {
+		return next
+	}
+
+	budget := "ok"
+	if b.client.RetryBudget != nil && !b.client.RetryBudget.withdraw() {
+		budget = "exhausted"
+		next = backoff.Stop
+	}
+	if b.client.Metrics != nil {
+		b.client.Metrics.Count("objects.retries", 1, map[string]string{"budget": budget})
+	}
+	return next
+}
@@ -0,0 +1,151 @@
+package objects
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// scheduler runs functions at a future time. Each function is registered
+// under a key, and scheduling an existing key replaces the previous entry.
+type scheduler struct {
+	mu    sync.Mutex
+	items scheduleHeap
+	index map[string]*scheduled
+
+	once sync.Once
+	wake chan struct{}
+	exit chan struct{}
+	done chan struct{}
+}
+
+type scheduled struct {
+	key string
+	at  time.Time
+	fn  func()
+	pos int
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		index: map[string]*scheduled{},
+		wake:  make(chan struct{}, 1),
+		exit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// schedule registers fn to run at t under key. The background goroutine is
+// started on first use.
+func (s *scheduler) schedule(key string, t time.Time, fn func()) {
+	s.once.Do(func() { go s.run() })
+
+	s.mu.Lock()
+	if item, ok := s.index[key]; ok {
+		item.at = t
+		item.fn = fn
+		heap.Fix(&s.items, item.pos)
+	} else {
+		item := &scheduled{key: key, at: t, fn: fn}
+		s.index[key] = item
+		heap.Push(&s.items, item)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel removes the entry registered under key, if any.
+func (s *scheduler) cancel(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.index[key]; ok {
+		heap.Remove(&s.items, item.pos)
+		delete(s.index, key)
+	}
+}
+
+// pending returns the number of scheduled entries.
+func (s *scheduler) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// stop terminates the background goroutine and returns the number of
+// pending entries, which are discarded.
+func (s *scheduler) stop() int {
+	s.once.Do(func() { close(s.done) })
+	close(s.exit)
+	<-s.done
+	return s.pending()
+}
+
+func (s *scheduler) run() {
+	defer close(s.done)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		due := []func(){}
+		for len(s.items) > 0 && !s.items[0].at.After(now) {
+			item := heap.Pop(&s.items).(*scheduled)
+			delete(s.index, item.key)
+			due = append(due, item.fn)
+		}
+		wait := time.Hour
+		if len(s.items) > 0 {
+			wait = s.items[0].at.Sub(now)
+		}
+		s.mu.Unlock()
+
+		for _, fn := range due {
+			fn()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.exit:
+			return
+		}
+	}
+}
+
+type scheduleHeap []*scheduled
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *scheduleHeap) Push(x interface{}) {
+	item := x.(*scheduled)
+	item.pos = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *scheduleHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
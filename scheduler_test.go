@@ -0,0 +1,65 @@
+package objects
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestScheduler(t *testing.T) {
+	suite.Run(t, &SchedulerTestSuite{})
+}
+
+type SchedulerTestSuite struct {
+	suite.Suite
+}
+
+func (s *SchedulerTestSuite) TestOrder() {
+	sched := newScheduler()
+	defer sched.stop()
+
+	mu := sync.Mutex{}
+	ran := []string{}
+	record := func(key string) func() {
+		return func() {
+			mu.Lock()
+			ran = append(ran, key)
+			mu.Unlock()
+		}
+	}
+
+	now := time.Now()
+	sched.schedule("c", now.Add(150*time.Millisecond), record("c"))
+	sched.schedule("a", now.Add(50*time.Millisecond), record("a"))
+	sched.schedule("b", now.Add(100*time.Millisecond), record("b"))
+	s.Equal(3, sched.pending())
+
+	time.Sleep(300 * time.Millisecond)
+	mu.Lock()
+	s.Equal([]string{"a", "b", "c"}, ran)
+	mu.Unlock()
+	s.Equal(0, sched.pending())
+}
+
+func (s *SchedulerTestSuite) TestReplaceAndCancel() {
+	sched := newScheduler()
+	defer sched.stop()
+
+	ran := make(chan string, 3)
+	sched.schedule("a", time.Now().Add(time.Hour), func() { ran <- "a1" })
+	sched.schedule("a", time.Now(), func() { ran <- "a2" })
+	sched.schedule("b", time.Now().Add(50*time.Millisecond), func() { ran <- "b" })
+	sched.cancel("b")
+
+	s.Equal("a2", <-ran)
+	time.Sleep(100 * time.Millisecond)
+	s.Len(ran, 0)
+	s.Equal(0, sched.pending())
+}
+
+func (s *SchedulerTestSuite) TestStopUnused() {
+	sched := newScheduler()
+	sched.stop()
+}
@@ -0,0 +1,73 @@
+package objects
+
+import "sync/atomic"
+
+// ShutdownPhase is a step of Close, passed to Client.OnShutdown as it starts.
+// Phases run in order, each for every collection at once.
+type ShutdownPhase string
+
+const (
+	// ShutdownIntake rejects new objects: Set returns ErrClientClosed for
+	// every collection from then on.
+	ShutdownIntake ShutdownPhase = "intake"
+
+	// ShutdownDrain adds the objects queued in every collection to their
+	// batches, and flushes them.
+	ShutdownDrain ShutdownPhase = "drain"
+
+	// ShutdownFlush sends the batches held by DeliveryWindows or waiting to
+	// be coalesced.
+	ShutdownFlush ShutdownPhase = "flush"
+
+	// ShutdownSenders waits for every delivery in progress, including
+	// retries.
+	ShutdownSenders ShutdownPhase = "senders"
+
+	// ShutdownDone means the client is closed.
+	ShutdownDone ShutdownPhase = "done"
+)
+
+// shutdown runs the shutdown phases of Close.
+func (c *Client) shutdown() {
+	if c.spoolingOnClose() {
+		// Abort deliveries in progress, their batches are spooled.
+		c.cancel()
+	}
+
+	c.phase(ShutdownIntake)
+	var buffers []*buffer
+	for t := range c.cmap.Iter() {
+		buffers = append(buffers, t.Val.all()...)
+	}
+	for _, b := range buffers {
+		b.Queue.close()
+	}
+
+	c.phase(ShutdownDrain)
+	for _, b := range buffers {
+		close(b.Exit)
+	}
+	c.wg.Wait()
+
+	c.phase(ShutdownFlush)
+	c.release()
+	c.flushCoalesced()
+
+	c.phase(ShutdownSenders)
+	c.semaphore.Wait()
+	c.cancel()
+
+	c.phase(ShutdownDone)
+}
+
+func (c *Client) phase(p ShutdownPhase) {
+	if c.OnShutdown != nil {
+		c.OnShutdown(p)
+	}
+}
+
+// spoolingOnClose reports whether batches go to Spool rather than to the
+// network because the client is closing, see SpoolOnClose.
+func (c *Client) spoolingOnClose() bool {
+	return c.SpoolOnClose && c.Spool != nil && atomic.LoadInt64(&c.closed) == 1
+}
@@ -0,0 +1,50 @@
+package objects
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// HandleSignals drains c when the process receives one of sigs, e.g.
+// syscall.SIGTERM and syscall.SIGINT, waiting up to c.ShutdownGrace for
+// buffered objects to be delivered, see Drain. The signal is then raised again so the
+// process terminates as it would have without the handler.
+//
+// Applications handling these signals themselves should call Close from
+// their own handler instead, as they would receive the signal twice. The
+// returned function stops handling the signals.
+func HandleSignals(c *Client, sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-ch:
+		case <-done:
+			return
+		}
+		signal.Stop(ch)
+
+		c.Logger.Printf("Received %v, delivering buffered objects", sig)
+		<-c.Drain()
+
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package objects
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSignal(t *testing.T) {
+	suite.Run(t, &SignalTestSuite{})
+}
+
+type SignalTestSuite struct {
+	suite.Suite
+}
+
+func (s *SignalTestSuite) TestHandleSignals() {
+	// Catch the re-raised signal, which would otherwise kill the test.
+	raised := make(chan os.Signal, 2)
+	signal.Notify(raised, syscall.SIGUSR1)
+	defer signal.Stop(raised)
+
+	client := New("writeKey")
+	HandleSignals(client, syscall.SIGUSR1)
+
+	s.NoError(syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	<-raised
+
+	// The signal is raised again once the client is closed.
+	select {
+	case <-raised:
+	case <-time.After(time.Second):
+		s.Fail("signal not raised again")
+	}
+	s.Equal(ErrClientClosed, client.Close())
+}
+
+func (s *SignalTestSuite) TestStop() {
+	client := New("writeKey")
+	stop := HandleSignals(client, syscall.SIGUSR2)
+	stop()
+	stop()
+
+	raised := make(chan os.Signal, 1)
+	signal.Notify(raised, syscall.SIGUSR2)
+	defer signal.Stop(raised)
+	s.NoError(syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+	<-raised
+
+	s.NoError(client.Close())
+}
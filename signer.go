@@ -0,0 +1,42 @@
+package objects
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// DefaultSignatureHeader carries the HMACSigner signature.
+	DefaultSignatureHeader = "X-Signature"
+)
+
+// Signer signs outgoing requests, e.g. for gateways that require signed
+// payloads. body is the exact request body, empty for requests without one.
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// HMACSigner sets Header to `sha256=<hex>`, the HMAC-SHA256 of the request
+// body keyed with Key.
+type HMACSigner struct {
+	Key    []byte
+	Header string
+}
+
+func NewHMACSigner(key []byte) *HMACSigner {
+	return &HMACSigner{Key: key, Header: DefaultSignatureHeader}
+}
+
+func (s *HMACSigner) Sign(req *http.Request, body []byte) error {
+	req.Header.Set(s.Header, s.Signature(body))
+	return nil
+}
+
+// Signature returns the `sha256=<hex>` signature of body.
+func (s *HMACSigner) Signature(body []byte) string {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
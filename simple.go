@@ -0,0 +1,47 @@
+package objects
+
+import "context"
+
+// SimpleClient sends objects synchronously, with no buffering and no
+// background goroutines, for environments such as AWS Lambda or Cloud
+// Functions that freeze the process between invocations, losing anything
+// buffered.
+type SimpleClient struct {
+	// Client configures the endpoint, encoding, authentication, retries and
+	// delivery hooks used by Send. Its buffering and scheduled operations,
+	// such as Set, ExpiresAt, Coalesce and Endpoints probes, must not be
+	// used.
+	Client *Client
+}
+
+func NewSimpleClient(writeKey string) *SimpleClient {
+	return &SimpleClient{Client: New(writeKey)}
+}
+
+// Send delivers objs, grouped by collection and split in batches of at most
+// Client.MaxBatchCount objects and Client.MaxBatchBytes, and returns once
+// every batch is delivered or retries are exhausted. Nothing is sent if an
+// object is invalid. On a delivery error, the batches sent before it are
+// delivered and the ones after it are not sent.
+func (s *SimpleClient) Send(ctx context.Context, objs []*Object) error {
+	c := s.Client
+	order := []string{}
+	groups := map[string][]*Object{}
+	for _, v := range objs {
+		c.identify(v)
+		if err := v.validate(); err != nil {
+			return err
+		}
+		if _, ok := groups[v.Collection]; !ok {
+			order = append(order, v.Collection)
+		}
+		groups[v.Collection] = append(groups[v.Collection], v)
+	}
+
+	for _, collection := range order {
+		if _, err := c.sendObjects(ctx, collection, groups[collection], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
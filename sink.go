@@ -0,0 +1,145 @@
+package objects
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Sink receives a copy of every batch delivered by the client, so object
+// updates can be teed into other systems alongside Segment. Batches the
+// Objects API did not accept are not sent to sinks. Objects is the JSON
+// array of flattened objects exactly as sent to the Objects API. Its memory
+// is reused once Send returns, so sinks must copy it to retain it.
+type Sink interface {
+	Send(ctx context.Context, collection string, objects json.RawMessage) error
+}
+
+// Delivery describes a batch delivery attempt, passed to Client.OnDeliver.
+type Delivery struct {
+	Collection string
+	IDs        []string
+	Objects    int
+
+	// Seq numbers the client's batches from 1 in the order they were
+	// created, at Created. Batches delivered in one request with Coalesce
+	// keep their own.
+	Seq     uint64
+	Created time.Time
+
+	// Meta holds the Object.Meta of every object, in the order of IDs.
+	Meta []map[string]interface{}
+
+	// Bytes is the size of the JSON array of objects.
+	Bytes int
+
+	// RequestID is sent in the RequestIDHeader to correlate the batch with
+	// server and proxy logs.
+	RequestID string
+
+	// Checksum is the hex SHA-256 of the request body as sent, set when
+	// Client.Checksum is enabled.
+	Checksum string
+
+	Duration time.Duration
+
+	// Err is nil if the batch was delivered.
+	Err error
+}
+
+// tracksIDs reports whether object IDs are kept for Delivery, or for OnDrop
+// should the batch be dropped.
+func (c *Client) tracksIDs() bool {
+	return c.OnDeliver != nil || c.AuditLog != nil || c.Receipts != nil || c.OnDrop != nil
+}
+
+// deliver sends request to the Objects API, then, if it was delivered, to
+// every sink. Sink errors are logged and never fail the delivery.
+func (c *Client) deliver(ctx context.Context, request *batch) error {
+	start := time.Now()
+	c.begin(request)
+
+	header := http.Header{}
+	header.Set(RequestIDHeader, newRequestID())
+	err := c.sendBatch(ctx, &BatchRequest{
+		Collection: request.Collection,
+		Objects:    request.Objects,
+		Count:      request.count,
+		Header:     header,
+	})
+
+	c.end(request)
+	c.complete(ctx, request, start, header, err)
+	return err
+}
+
+// report passes d to AuditLog and OnDeliver.
+func (c *Client) report(d Delivery) {
+	if c.AuditLog != nil {
+		if err := c.AuditLog.Record(d); err != nil {
+			c.Logger.Printf("[Error] Audit log failed for collection `%s`: %v", d.Collection, err)
+		}
+	}
+	if c.OnDeliver != nil {
+		c.OnDeliver(d)
+	}
+}
+
+// begin counts request as in flight.
+func (c *Client) begin(request *batch) {
+	if b, ok := c.cmap.Get(request.Collection); ok {
+		atomic.AddInt64(&b.inflight, int64(request.count))
+	}
+	atomic.AddInt64(&c.counters.inflight, 1)
+}
+
+// end counts request as no longer in flight.
+func (c *Client) end(request *batch) {
+	atomic.AddInt64(&c.counters.inflight, -1)
+	if b, ok := c.cmap.Get(request.Collection); ok {
+		atomic.AddInt64(&b.inflight, -int64(request.count))
+	}
+}
+
+// complete records the outcome of the delivery of request, sent with header
+// since start, and passes it to sinks if it was delivered.
+func (c *Client) complete(ctx context.Context, request *batch, start time.Time, header http.Header, err error) {
+	c.health.record(request.Collection, err)
+	c.measure(request, start, err)
+	if err == nil {
+		atomic.AddInt64(&c.counters.sent, int64(request.count))
+		c.latencies.observe(request.Collection, time.Since(request.enqueued))
+		for _, e := range request.expires {
+			c.expire(e)
+		}
+		if c.Duplicates != nil {
+			c.Duplicates.record(request.dedupe, time.Now())
+		}
+	}
+
+	if c.tracksIDs() {
+		d := Delivery{
+			Collection: request.Collection,
+			IDs:        request.ids,
+			Meta:       request.meta,
+			Objects:    request.count,
+			Seq:        request.seq,
+			Created:    request.created,
+			Bytes:      request.size(),
+			RequestID:  header.Get(RequestIDHeader),
+			Checksum:   header.Get(ChecksumHeader),
+			Duration:   time.Since(start),
+			Err:        err,
+		}
+		c.report(d)
+	}
+	if c.Receipts != nil && err == nil {
+		c.storeReceipts(ctx, request, header.Get(RequestIDHeader))
+	}
+
+	if request.spilled == 0 && err == nil {
+		c.sendSinks(ctx, request)
+	}
+}
@@ -0,0 +1,122 @@
+// Package webhook implements an objects.Sink that POSTs every delivered batch
+// to a user URL, optionally transformed by a template and signed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/segmentio/objects-go"
+)
+
+type Sink struct {
+	URL    string
+	Client *http.Client
+
+	// Signer optionally signs request bodies, e.g. objects.NewHMACSigner(secret).
+	Signer objects.Signer
+
+	// Template optionally renders the request body. It is executed with a
+	// TemplateData. When nil, the body is `{"collection": ..., "objects": [...]}`.
+	Template *template.Template
+}
+
+// TemplateData is passed to Sink.Template.
+type TemplateData struct {
+	Collection string
+	Objects    []map[string]interface{}
+
+	// JSON is the raw JSON array of objects.
+	JSON string
+}
+
+type payload struct {
+	Collection string          `json:"collection"`
+	Objects    json.RawMessage `json:"objects"`
+}
+
+func New(url string) *Sink {
+	return &Sink{
+		URL:    url,
+		Client: http.DefaultClient,
+	}
+}
+
+// Send implements objects.Sink.
+func (s *Sink) Send(ctx context.Context, collection string, raw json.RawMessage) error {
+	body, err := s.render(collection, raw)
+	if err != nil {
+		return err
+	}
+	return s.post(ctx, body)
+}
+
+// Columnar returns a sink sending batches whose objects all share the same
+// properties as `{"collection": ..., "columns": [...], "rows": [[...]]}`,
+// which is much smaller for table syncs. Other batches are sent by s.
+func (s *Sink) Columnar() objects.ColumnarSink {
+	return &columnarSink{s}
+}
+
+type columnarSink struct {
+	*Sink
+}
+
+type columnarPayload struct {
+	Collection string `json:"collection"`
+	*objects.Columns
+}
+
+// SendColumns implements objects.ColumnarSink.
+func (s *columnarSink) SendColumns(ctx context.Context, collection string, columns *objects.Columns) error {
+	body, err := json.Marshal(&columnarPayload{Collection: collection, Columns: columns})
+	if err != nil {
+		return err
+	}
+	return s.post(ctx, body)
+}
+
+func (s *Sink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Signer != nil {
+		if err := s.Signer.Sign(req, body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook Request Failed, Status Code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Sink) render(collection string, raw json.RawMessage) ([]byte, error) {
+	if s.Template == nil {
+		return json.Marshal(&payload{Collection: collection, Objects: raw})
+	}
+
+	data := &TemplateData{Collection: collection, JSON: string(raw)}
+	if err := json.Unmarshal(raw, &data.Objects); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.Template.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
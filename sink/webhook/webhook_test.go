@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWebhook(t *testing.T) {
+	suite.Run(t, &WebhookTestSuite{})
+}
+
+type WebhookTestSuite struct {
+	suite.Suite
+
+	server    *httptest.Server
+	body      []byte
+	signature string
+}
+
+func (w *WebhookTestSuite) SetupTest() {
+	w.body = nil
+	w.signature = ""
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		w.body, _ = ioutil.ReadAll(req.Body)
+		w.signature = req.Header.Get(objects.DefaultSignatureHeader)
+		if req.URL.Path == "/fail" {
+			rw.WriteHeader(500)
+		}
+	}))
+}
+
+func (w *WebhookTestSuite) TearDownTest() {
+	w.server.Close()
+}
+
+var batch = json.RawMessage(`[{"id":"1","properties":{"name":"a"}}]`)
+
+func (w *WebhookTestSuite) TestSend() {
+	s := New(w.server.URL)
+	w.NoError(s.Send(context.Background(), "rooms", batch))
+	w.JSONEq(`{"collection":"rooms","objects":[{"id":"1","properties":{"name":"a"}}]}`, string(w.body))
+	w.Equal("", w.signature)
+}
+
+func (w *WebhookTestSuite) TestSigned() {
+	s := New(w.server.URL)
+	s.Signer = objects.NewHMACSigner([]byte("secret"))
+	w.NoError(s.Send(context.Background(), "rooms", batch))
+	w.Equal(objects.NewHMACSigner([]byte("secret")).Signature(w.body), w.signature)
+	w.NotEqual(objects.NewHMACSigner([]byte("other")).Signature(w.body), w.signature)
+}
+
+func (w *WebhookTestSuite) TestTemplate() {
+	s := New(w.server.URL)
+	s.Template = template.Must(template.New("").Parse(
+		`{"table":"{{.Collection}}","rows":{{.JSON}},"first":"{{(index .Objects 0).id}}"}`))
+	w.NoError(s.Send(context.Background(), "rooms", batch))
+	w.JSONEq(`{"table":"rooms","rows":[{"id":"1","properties":{"name":"a"}}],"first":"1"}`, string(w.body))
+}
+
+func (w *WebhookTestSuite) TestColumnar() {
+	s := New(w.server.URL).Columnar()
+	cols, err := objects.NewColumns(json.RawMessage(`[{"id":"1","properties":{"name":"a","n":1}},{"id":"2","properties":{"name":"b","n":2}}]`))
+	w.NoError(err)
+	w.NoError(s.SendColumns(context.Background(), "rooms", cols))
+	w.JSONEq(`{"collection":"rooms","columns":["id","n","name"],"rows":[["1",1,"a"],["2",2,"b"]]}`, string(w.body))
+
+	w.NoError(s.Send(context.Background(), "rooms", batch))
+	w.JSONEq(`{"collection":"rooms","objects":[{"id":"1","properties":{"name":"a"}}]}`, string(w.body))
+}
+
+func (w *WebhookTestSuite) TestError() {
+	s := New(w.server.URL + "/fail")
+	w.Error(s.Send(context.Background(), "rooms", batch))
+}
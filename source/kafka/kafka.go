@@ -0,0 +1,155 @@
+// Package kafka feeds Kafka records into an objects.Client with at-least-once
+// delivery: offsets are only committed once the batches containing them have
+// been acknowledged by the Objects API.
+//
+// The package does not depend on a Kafka library. Wrap the consumer group
+// client of your choice (sarama, kafka-go, confluent-kafka-go) in a Consumer,
+// and call Source.Revoked from its rebalance handler.
+package kafka
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/source"
+)
+
+// Message is a Kafka record.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+}
+
+// Consumer is the subset of a Kafka consumer group client used by Source.
+type Consumer interface {
+	// Fetch blocks until the next message is available or ctx is done.
+	Fetch(ctx context.Context) (*Message, error)
+
+	// Commit marks msgs, and every earlier offset of their partitions, as
+	// processed.
+	Commit(ctx context.Context, msgs ...*Message) error
+}
+
+// Decoder maps a message to an object. Returning a nil object skips the
+// message; returning an error logs and skips it.
+type Decoder func(*Message) (*objects.Object, error)
+
+type Source struct {
+	Client   *objects.Client
+	Consumer Consumer
+	Decoder  Decoder
+
+	// MaxBatchCount and MaxBatchInterval bound how many messages are held
+	// before their batches are sent and their offsets committed.
+	MaxBatchCount    int
+	MaxBatchInterval time.Duration
+
+	mu      sync.Mutex
+	pending []*Message
+	objects []*objects.Object
+}
+
+func New(client *objects.Client, consumer Consumer, decoder Decoder) *Source {
+	return &Source{
+		Client:           client,
+		Consumer:         consumer,
+		Decoder:          decoder,
+		MaxBatchCount:    100,
+		MaxBatchInterval: 10 * time.Second,
+	}
+}
+
+// Run consumes messages until ctx is done or delivery fails. Messages that
+// were not delivered are not committed and will be consumed again.
+func (s *Source) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		tick := time.NewTicker(s.MaxBatchInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if err := s.Flush(ctx); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, err := s.Consumer.Fetch(ctx)
+		if err != nil {
+			select {
+			case err := <-errs:
+				return err
+			default:
+			}
+			return err
+		}
+
+		if err := s.add(ctx, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Source) add(ctx context.Context, msg *Message) error {
+	v, err := s.Decoder(msg)
+	if err != nil {
+		log.Printf("[Error] Message %s/%d/%d skipped: %v", msg.Topic, msg.Partition, msg.Offset, err)
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, msg)
+	if v != nil && err == nil {
+		s.objects = append(s.objects, v)
+	}
+	full := len(s.pending) >= s.MaxBatchCount
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush sends every held batch and commits the offsets of their messages.
+func (s *Source) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	if err := source.Send(ctx, s.Client, s.objects); err != nil {
+		return err
+	}
+	s.objects = nil
+
+	if err := s.Consumer.Commit(ctx, s.pending...); err != nil {
+		return err
+	}
+	s.pending = nil
+	return nil
+}
+
+// Revoked must be called by the consumer's rebalance handler before
+// partitions are released. It delivers and commits every held message so
+// the next owner of the partitions does not consume them again.
+func (s *Source) Revoked(ctx context.Context) error {
+	return s.Flush(ctx)
+}
@@ -0,0 +1,116 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestKafka(t *testing.T) {
+	suite.Run(t, &KafkaTestSuite{})
+}
+
+type KafkaTestSuite struct {
+	suite.Suite
+
+	mu   sync.Mutex
+	sent []string
+}
+
+type consumer struct {
+	mu        sync.Mutex
+	messages  chan *Message
+	committed []int64
+}
+
+func (c *consumer) Fetch(ctx context.Context) (*Message, error) {
+	select {
+	case m := <-c.messages:
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *consumer) Commit(ctx context.Context, msgs ...*Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range msgs {
+		c.committed = append(c.committed, m.Offset)
+	}
+	return nil
+}
+
+func (c *consumer) commits() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64{}, c.committed...)
+}
+
+func decode(m *Message) (*objects.Object, error) {
+	if string(m.Value) == "bad" {
+		return nil, errors.New("bad message")
+	}
+	return &objects.Object{
+		ID:         string(m.Key),
+		Collection: m.Topic,
+		Properties: map[string]interface{}{"value": string(m.Value)},
+	}, nil
+}
+
+func (k *KafkaTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := struct {
+			Objects []*objects.Object `json:"objects"`
+		}{}
+		json.NewDecoder(req.Body).Decode(&v)
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		for _, o := range v.Objects {
+			k.sent = append(k.sent, o.ID)
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (k *KafkaTestSuite) SetupTest() {
+	k.mu.Lock()
+	k.sent = nil
+	k.mu.Unlock()
+}
+
+func (k *KafkaTestSuite) TestCommitAfterDelivery() {
+	c := &consumer{messages: make(chan *Message, 10)}
+	s := New(objects.New("writeKey"), c, decode)
+	s.MaxBatchCount = 3
+
+	c.messages <- &Message{Topic: "rooms", Offset: 1, Key: []byte("a"), Value: []byte("1")}
+	c.messages <- &Message{Topic: "users", Offset: 2, Key: []byte("b"), Value: []byte("2")}
+	c.messages <- &Message{Topic: "rooms", Offset: 3, Key: []byte("c"), Value: []byte("bad")}
+	c.messages <- &Message{Topic: "rooms", Offset: 4, Key: []byte("d"), Value: []byte("4")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	k.Equal(context.DeadlineExceeded, s.Run(ctx))
+
+	// The first three messages filled a batch; the fourth is still held.
+	k.Equal([]int64{1, 2, 3}, c.commits())
+	k.mu.Lock()
+	sort.Strings(k.sent)
+	k.Equal([]string{"a", "b"}, k.sent)
+	k.mu.Unlock()
+
+	k.NoError(s.Revoked(context.Background()))
+	k.Equal([]int64{1, 2, 3, 4}, c.commits())
+}
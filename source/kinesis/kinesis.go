@@ -0,0 +1,96 @@
+// Package kinesis feeds Amazon Kinesis records into an objects.Client,
+// checkpointing a shard only once the records read from it have been
+// delivered, giving at-least-once delivery across restarts.
+//
+// The package does not depend on the AWS SDK; wrap GetRecords and your
+// checkpoint store (e.g. a DynamoDB lease table) in a Shard, and run one
+// Source per shard.
+package kinesis
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/source"
+)
+
+// Record is a Kinesis data record.
+type Record struct {
+	ShardID        string
+	SequenceNumber string
+	PartitionKey   string
+	Data           []byte
+}
+
+// Shard is a reader over a single Kinesis shard.
+type Shard interface {
+	// GetRecords returns the next records of the shard, resuming after the
+	// last checkpoint. It blocks or returns no records when caught up.
+	GetRecords(ctx context.Context) ([]*Record, error)
+
+	// Checkpoint persists r as the last processed record of the shard.
+	Checkpoint(ctx context.Context, r *Record) error
+}
+
+// Decoder maps a record to an object. Returning a nil object skips the
+// record; returning an error logs and skips it.
+type Decoder func(*Record) (*objects.Object, error)
+
+type Source struct {
+	Client  *objects.Client
+	Shard   Shard
+	Decoder Decoder
+
+	// PollInterval is the pause after GetRecords returns no records.
+	PollInterval time.Duration
+}
+
+func New(client *objects.Client, shard Shard, decoder Decoder) *Source {
+	return &Source{
+		Client:       client,
+		Shard:        shard,
+		Decoder:      decoder,
+		PollInterval: time.Second,
+	}
+}
+
+// Run reads the shard until ctx is done or a read, delivery or checkpoint
+// fails. Records after the last checkpoint are read again on the next run.
+func (s *Source) Run(ctx context.Context) error {
+	for {
+		records, err := s.Shard.GetRecords(ctx)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			select {
+			case <-time.After(s.PollInterval):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		objs := make([]*objects.Object, 0, len(records))
+		for _, r := range records {
+			v, err := s.Decoder(r)
+			if err != nil {
+				log.Printf("[Error] Record `%s/%s` skipped: %v", r.ShardID, r.SequenceNumber, err)
+				continue
+			}
+			if v != nil {
+				objs = append(objs, v)
+			}
+		}
+
+		if err := source.Send(ctx, s.Client, objs); err != nil {
+			return err
+		}
+
+		if err := s.Shard.Checkpoint(ctx, records[len(records)-1]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package kinesis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestKinesis(t *testing.T) {
+	suite.Run(t, &KinesisTestSuite{})
+}
+
+type KinesisTestSuite struct {
+	suite.Suite
+
+	mu   sync.Mutex
+	sent []string
+}
+
+type shard struct {
+	batches    [][]*Record
+	checkpoint string
+}
+
+func (s *shard) GetRecords(ctx context.Context) ([]*Record, error) {
+	if len(s.batches) == 0 {
+		return nil, nil
+	}
+	records := s.batches[0]
+	s.batches = s.batches[1:]
+	return records, nil
+}
+
+func (s *shard) Checkpoint(ctx context.Context, r *Record) error {
+	s.checkpoint = r.SequenceNumber
+	return nil
+}
+
+func decode(r *Record) (*objects.Object, error) {
+	if string(r.Data) == "bad" {
+		return nil, errors.New("bad record")
+	}
+	return &objects.Object{
+		ID:         r.PartitionKey,
+		Collection: "rooms",
+		Properties: map[string]interface{}{"value": string(r.Data)},
+	}, nil
+}
+
+func (k *KinesisTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := struct {
+			Objects []*objects.Object `json:"objects"`
+		}{}
+		json.NewDecoder(req.Body).Decode(&v)
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		for _, o := range v.Objects {
+			k.sent = append(k.sent, o.ID)
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (k *KinesisTestSuite) TestCheckpointAfterDelivery() {
+	sh := &shard{batches: [][]*Record{
+		{{SequenceNumber: "1", PartitionKey: "a", Data: []byte("1")}, {SequenceNumber: "2", PartitionKey: "b", Data: []byte("bad")}},
+		{{SequenceNumber: "3", PartitionKey: "c", Data: []byte("3")}},
+	}}
+	s := New(objects.New("writeKey"), sh, decode)
+	s.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	k.Equal(context.DeadlineExceeded, s.Run(ctx))
+
+	k.Equal("3", sh.checkpoint)
+	k.mu.Lock()
+	k.Equal([]string{"a", "c"}, k.sent)
+	k.mu.Unlock()
+}
@@ -0,0 +1,212 @@
+// Package mongo feeds MongoDB change stream events into an objects.Client,
+// persisting the stream's resume token only after the events before it have
+// been delivered, so a restarted process resumes where it left off.
+//
+// The package does not depend on the MongoDB driver; wrap a
+// *mongo.ChangeStream in a ChangeStream and open it with ResumeAfter set to
+// the token passed to Open.
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/source"
+)
+
+// Event is a change stream event.
+type Event struct {
+	OperationType string // insert, update, replace or delete
+	Collection    string
+	DocumentKey   map[string]interface{}
+
+	// FullDocument is set for inserts and replaces, and for updates when the
+	// stream was opened with the updateLookup option.
+	FullDocument map[string]interface{}
+
+	// UpdatedFields is set for updates.
+	UpdatedFields map[string]interface{}
+
+	ResumeToken []byte
+}
+
+type ChangeStream interface {
+	// Next blocks until the next event is available.
+	Next(ctx context.Context) (*Event, error)
+
+	// TryNext returns the next event if one is immediately available, or nil.
+	TryNext(ctx context.Context) (*Event, error)
+}
+
+// TokenStore persists the resume token between restarts.
+type TokenStore interface {
+	// Load returns the last saved token, or nil to start from now.
+	Load(ctx context.Context) ([]byte, error)
+	Save(ctx context.Context, token []byte) error
+}
+
+type Source struct {
+	Client *objects.Client
+	Store  TokenStore
+
+	// Open starts a change stream after resumeToken, or from now when nil.
+	Open func(ctx context.Context, resumeToken []byte) (ChangeStream, error)
+
+	// Collections maps MongoDB collections to object collections. Unmapped
+	// collections keep their name.
+	Collections map[string]string
+
+	// IDPath is the dotted path of the object ID in documents.
+	IDPath string
+
+	// Fields optionally restricts properties to these dotted paths, which are
+	// flattened into `a_b` style properties. All fields are sent when empty.
+	Fields []string
+
+	// MaxBatchCount bounds how many events are held before delivery.
+	MaxBatchCount int
+}
+
+func New(client *objects.Client, store TokenStore, open func(context.Context, []byte) (ChangeStream, error)) *Source {
+	return &Source{
+		Client:        client,
+		Store:         store,
+		Open:          open,
+		Collections:   map[string]string{},
+		IDPath:        "_id",
+		MaxBatchCount: 100,
+	}
+}
+
+// Run resumes the change stream from the stored token and delivers events
+// until ctx is done or an error occurs. Events are delivered as soon as the
+// stream has no more immediately available events, or MaxBatchCount is
+// reached.
+func (s *Source) Run(ctx context.Context) error {
+	token, err := s.Store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	stream, err := s.Open(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	pending := []*objects.Object{}
+	var last []byte
+	flush := func() error {
+		if last == nil {
+			return nil
+		}
+		if err := source.Send(ctx, s.Client, pending); err != nil {
+			return err
+		}
+		if err := s.Store.Save(ctx, last); err != nil {
+			return err
+		}
+		pending, last = pending[:0], nil
+		return nil
+	}
+
+	for {
+		ev, err := stream.TryNext(ctx)
+		if err == nil && ev == nil {
+			if err := flush(); err != nil {
+				return err
+			}
+			ev, err = stream.Next(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		collection := ev.Collection
+		if name, ok := s.Collections[collection]; ok {
+			collection = name
+		}
+
+		switch ev.OperationType {
+		case "insert", "replace", "update":
+			doc := ev.FullDocument
+			if doc == nil {
+				doc = ev.UpdatedFields
+			}
+			if v := s.object(collection, ev.DocumentKey, doc); v != nil {
+				pending = append(pending, v)
+			}
+		case "delete":
+			// Deliver earlier events first to preserve ordering.
+			if err := source.Send(ctx, s.Client, pending); err != nil {
+				return err
+			}
+			pending = pending[:0]
+			id, ok := lookup(ev.DocumentKey, s.IDPath)
+			if ok {
+				if err := s.Client.Delete(ctx, collection, id); err != nil {
+					return err
+				}
+			}
+		}
+
+		last = ev.ResumeToken
+		if len(pending) >= s.MaxBatchCount {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Source) object(collection string, key, doc map[string]interface{}) *objects.Object {
+	id, ok := lookup(doc, s.IDPath)
+	if !ok {
+		if id, ok = lookup(key, s.IDPath); !ok {
+			return nil
+		}
+	}
+
+	props := doc
+	if len(s.Fields) > 0 {
+		props = make(map[string]interface{}, len(s.Fields))
+		for _, path := range s.Fields {
+			if v, ok := get(doc, path); ok {
+				props[strings.Replace(path, ".", "_", -1)] = v
+			}
+		}
+	}
+	if len(props) == 0 {
+		return nil
+	}
+
+	return &objects.Object{ID: id, Collection: collection, Properties: props}
+}
+
+// lookup returns the value at path in doc as an ID string.
+func lookup(doc map[string]interface{}, path string) (string, bool) {
+	v, ok := get(doc, path)
+	if !ok || v == nil {
+		return "", false
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String(), true
+	}
+	return fmt.Sprint(v), true
+}
+
+// get returns the value at the dotted path in doc.
+func get(doc map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
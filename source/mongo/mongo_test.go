@@ -0,0 +1,121 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestMongo(t *testing.T) {
+	suite.Run(t, &MongoTestSuite{})
+}
+
+type MongoTestSuite struct {
+	suite.Suite
+
+	mu  sync.Mutex
+	ops []string
+}
+
+var errDone = errors.New("done")
+
+type stream struct {
+	events []*Event
+	ready  int
+}
+
+func (s *stream) TryNext(ctx context.Context) (*Event, error) {
+	if s.ready == 0 {
+		return nil, nil
+	}
+	s.ready--
+	return s.Next(ctx)
+}
+
+func (s *stream) Next(ctx context.Context) (*Event, error) {
+	if len(s.events) == 0 {
+		return nil, errDone
+	}
+	ev := s.events[0]
+	s.events = s.events[1:]
+	return ev, nil
+}
+
+type store struct {
+	token []byte
+}
+
+func (s *store) Load(ctx context.Context) ([]byte, error) { return s.token, nil }
+
+func (s *store) Save(ctx context.Context, token []byte) error {
+	s.token = token
+	return nil
+}
+
+func (m *MongoTestSuite) SetupSuite() {
+	httpmock.Activate()
+
+	record := func(op string) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			v := struct {
+				Collection string            `json:"collection"`
+				Objects    []*objects.Object `json:"objects"`
+				IDs        []string          `json:"ids"`
+			}{}
+			json.NewDecoder(req.Body).Decode(&v)
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for _, o := range v.Objects {
+				b, _ := json.Marshal(o.Properties)
+				m.ops = append(m.ops, op+" "+v.Collection+"/"+o.ID+" "+string(b))
+			}
+			for _, id := range v.IDs {
+				m.ops = append(m.ops, op+" "+v.Collection+"/"+id)
+			}
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		}
+	}
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", record("set"))
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/delete", record("delete"))
+}
+
+func (m *MongoTestSuite) TestRun() {
+	st := &store{token: []byte("t0")}
+	str := &stream{ready: 1, events: []*Event{
+		{OperationType: "insert", Collection: "users", ResumeToken: []byte("t1"),
+			FullDocument: map[string]interface{}{"_id": "u1", "name": "alice", "address": map[string]interface{}{"city": "Lihue"}}},
+		{OperationType: "update", Collection: "users", ResumeToken: []byte("t2"),
+			DocumentKey:   map[string]interface{}{"_id": "u2"},
+			UpdatedFields: map[string]interface{}{"name": "bob"}},
+		{OperationType: "delete", Collection: "users", ResumeToken: []byte("t3"),
+			DocumentKey: map[string]interface{}{"_id": "u3"}},
+	}}
+
+	opened := []byte{}
+	s := New(objects.New("writeKey"), st, func(ctx context.Context, token []byte) (ChangeStream, error) {
+		opened = token
+		return str, nil
+	})
+	s.Collections["users"] = "people"
+	s.Fields = []string{"name", "address.city"}
+
+	m.Equal(errDone, s.Run(context.Background()))
+	m.Equal("t0", string(opened))
+
+	// Events are delivered whenever the stream has nothing ready, and the
+	// token of the last delivered event is saved.
+	m.Equal([]string{
+		`set people/u1 {"address_city":"Lihue","name":"alice"}`,
+		`set people/u2 {"name":"bob"}`,
+		`delete people/u3`,
+	}, m.ops)
+	m.Equal("t3", string(st.token))
+}
@@ -0,0 +1,176 @@
+// Package postgres converts Postgres logical replication events, in the
+// wal2json format, into Set and Delete calls on an objects.Client, enabling
+// near-real-time sync of tables into warehouse objects.
+//
+// The package does not depend on a Postgres driver. Either call Apply with
+// wal2json payloads you already receive, or wrap a replication connection
+// (e.g. pglogrepl) in a Slot and call Run, which only acknowledges an LSN
+// once its changes have been delivered.
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/source"
+)
+
+// Slot is a logical replication slot using the wal2json output plugin.
+type Slot interface {
+	// Next blocks until the next transaction is available and returns its
+	// wal2json payload and LSN.
+	Next(ctx context.Context) (data []byte, lsn uint64, err error)
+
+	// Ack confirms that everything up to lsn has been processed.
+	Ack(ctx context.Context, lsn uint64) error
+}
+
+// Table maps a Postgres table to a collection.
+type Table struct {
+	Collection string
+
+	// IDColumns are joined with `:` to form the object ID.
+	IDColumns []string
+}
+
+type Replicator struct {
+	Client *objects.Client
+
+	// Tables maps `schema.table` to its collection. Tables missing from the
+	// map are synced to a collection named after the table, keyed by `id`,
+	// unless Strict is set, in which case their changes are ignored.
+	Tables map[string]Table
+	Strict bool
+}
+
+func New(client *objects.Client) *Replicator {
+	return &Replicator{
+		Client: client,
+		Tables: map[string]Table{},
+	}
+}
+
+type transaction struct {
+	Change []*change `json:"change"`
+}
+
+type change struct {
+	Kind         string        `json:"kind"`
+	Schema       string        `json:"schema"`
+	Table        string        `json:"table"`
+	ColumnNames  []string      `json:"columnnames"`
+	ColumnValues []interface{} `json:"columnvalues"`
+	OldKeys      struct {
+		KeyNames  []string      `json:"keynames"`
+		KeyValues []interface{} `json:"keyvalues"`
+	} `json:"oldkeys"`
+}
+
+// Run applies every transaction of slot until ctx is done or an error occurs.
+func (r *Replicator) Run(ctx context.Context, slot Slot) error {
+	for {
+		data, lsn, err := slot.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if err := r.Apply(ctx, data); err != nil {
+			return err
+		}
+		if err := slot.Ack(ctx, lsn); err != nil {
+			return err
+		}
+	}
+}
+
+// Apply delivers the changes of a single wal2json transaction, in order.
+// It returns once every change has been acknowledged by the Objects API.
+func (r *Replicator) Apply(ctx context.Context, data []byte) error {
+	tx := &transaction{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(tx); err != nil {
+		return err
+	}
+
+	sets := []*objects.Object{}
+	for _, c := range tx.Change {
+		table, ok := r.table(c.Schema, c.Table)
+		if !ok {
+			continue
+		}
+
+		switch c.Kind {
+		case "insert", "update":
+			v, err := table.object(c.ColumnNames, c.ColumnValues)
+			if err != nil {
+				return err
+			}
+			sets = append(sets, v)
+		case "delete":
+			id, err := table.id(c.OldKeys.KeyNames, c.OldKeys.KeyValues)
+			if err != nil {
+				return err
+			}
+			// Deliver earlier changes first to preserve ordering.
+			if err := source.Send(ctx, r.Client, sets); err != nil {
+				return err
+			}
+			sets = sets[:0]
+			if err := r.Client.Delete(ctx, table.Collection, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return source.Send(ctx, r.Client, sets)
+}
+
+func (r *Replicator) table(schema, name string) (Table, bool) {
+	if t, ok := r.Tables[schema+"."+name]; ok {
+		if len(t.IDColumns) == 0 {
+			t.IDColumns = []string{"id"}
+		}
+		return t, true
+	}
+	if r.Strict {
+		return Table{}, false
+	}
+	return Table{Collection: name, IDColumns: []string{"id"}}, true
+}
+
+func (t Table) object(names []string, values []interface{}) (*objects.Object, error) {
+	id, err := t.id(names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	props := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if i < len(values) && values[i] != nil {
+			props[name] = values[i]
+		}
+	}
+	return &objects.Object{ID: id, Collection: t.Collection, Properties: props}, nil
+}
+
+func (t Table) id(names []string, values []interface{}) (string, error) {
+	parts := make([]string, 0, len(t.IDColumns))
+	for _, col := range t.IDColumns {
+		found := false
+		for i, name := range names {
+			if name == col && i < len(values) && values[i] != nil {
+				parts = append(parts, fmt.Sprint(values[i]))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("Column `%s` missing from change on collection `%s`", col, t.Collection)
+		}
+	}
+	return strings.Join(parts, ":"), nil
+}
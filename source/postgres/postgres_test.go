@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPostgres(t *testing.T) {
+	suite.Run(t, &PostgresTestSuite{})
+}
+
+type PostgresTestSuite struct {
+	suite.Suite
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func (p *PostgresTestSuite) SetupSuite() {
+	httpmock.Activate()
+
+	record := func(op string) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			v := struct {
+				Collection string            `json:"collection"`
+				Objects    []*objects.Object `json:"objects"`
+				IDs        []string          `json:"ids"`
+			}{}
+			json.NewDecoder(req.Body).Decode(&v)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, o := range v.Objects {
+				b, _ := json.Marshal(o.Properties)
+				p.ops = append(p.ops, op+" "+v.Collection+"/"+o.ID+" "+string(b))
+			}
+			for _, id := range v.IDs {
+				p.ops = append(p.ops, op+" "+v.Collection+"/"+id)
+			}
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		}
+	}
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", record("set"))
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/delete", record("delete"))
+}
+
+func (p *PostgresTestSuite) SetupTest() {
+	p.mu.Lock()
+	p.ops = nil
+	p.mu.Unlock()
+}
+
+const tx = `{"change": [
+	{"kind": "insert", "schema": "public", "table": "users",
+	 "columnnames": ["id", "name", "deleted_at"], "columnvalues": [1000000, "alice", null]},
+	{"kind": "update", "schema": "public", "table": "room_bookings",
+	 "columnnames": ["room_id", "day", "guest"], "columnvalues": [7, "2016-06-01", "bob"]},
+	{"kind": "delete", "schema": "public", "table": "users",
+	 "oldkeys": {"keynames": ["id"], "keyvalues": [2]}},
+	{"kind": "insert", "schema": "audit", "table": "events",
+	 "columnnames": ["id"], "columnvalues": [1]}
+]}`
+
+func (p *PostgresTestSuite) TestApply() {
+	r := New(objects.New("writeKey"))
+	r.Tables["public.room_bookings"] = Table{Collection: "bookings", IDColumns: []string{"room_id", "day"}}
+
+	p.NoError(r.Apply(context.Background(), []byte(tx)))
+	p.Equal([]string{
+		`set users/1000000 {"id":1000000,"name":"alice"}`,
+		`set bookings/7:2016-06-01 {"day":"2016-06-01","guest":"bob","room_id":7}`,
+		`delete users/2`,
+		`set events/1 {"id":1}`,
+	}, p.ops)
+}
+
+func (p *PostgresTestSuite) TestStrict() {
+	r := New(objects.New("writeKey"))
+	r.Strict = true
+	r.Tables["public.users"] = Table{Collection: "users"}
+
+	p.NoError(r.Apply(context.Background(), []byte(tx)))
+	p.Equal([]string{
+		`set users/1000000 {"id":1000000,"name":"alice"}`,
+		`delete users/2`,
+	}, p.ops)
+}
+
+func (p *PostgresTestSuite) TestMissingKey() {
+	r := New(objects.New("writeKey"))
+	r.Tables["public.users"] = Table{Collection: "users", IDColumns: []string{"uuid"}}
+
+	p.Error(r.Apply(context.Background(), []byte(tx)))
+}
@@ -0,0 +1,33 @@
+// Package source holds helpers shared by the source adapters, which feed
+// objects from external systems into an objects.Client and acknowledge them
+// upstream only after delivery.
+package source
+
+import (
+	"context"
+
+	"github.com/segmentio/objects-go"
+)
+
+// Send groups objs by collection and sends each group with SendBatch,
+// returning the first delivery error.
+func Send(ctx context.Context, client *objects.Client, objs []*objects.Object) error {
+	order := []string{}
+	batches := map[string]*objects.Batch{}
+	for _, v := range objs {
+		b, ok := batches[v.Collection]
+		if !ok {
+			b = objects.NewBatch(v.Collection)
+			batches[v.Collection] = b
+			order = append(order, v.Collection)
+		}
+		b.Add(v)
+	}
+
+	for _, collection := range order {
+		if err := client.SendBatch(ctx, batches[collection]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
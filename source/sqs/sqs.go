@@ -0,0 +1,92 @@
+// Package sqs feeds Amazon SQS messages into an objects.Client, deleting
+// messages from the queue only once their batch has been delivered. Messages
+// whose delivery fails become visible again after the queue's visibility
+// timeout, giving at-least-once delivery.
+//
+// The package does not depend on the AWS SDK; wrap ReceiveMessage and
+// DeleteMessageBatch of your SQS client in a Queue.
+package sqs
+
+import (
+	"context"
+	"log"
+
+	"github.com/segmentio/objects-go"
+	"github.com/segmentio/objects-go/source"
+)
+
+// Message is an SQS message.
+type Message struct {
+	ID            string
+	ReceiptHandle string
+	Body          []byte
+	Attributes    map[string]string
+}
+
+// Queue is the subset of an SQS client used by Source.
+type Queue interface {
+	// Receive long-polls the queue for the next messages.
+	Receive(ctx context.Context) ([]*Message, error)
+
+	// Delete removes msgs from the queue.
+	Delete(ctx context.Context, msgs ...*Message) error
+}
+
+// Decoder maps a message to an object. Returning a nil object skips the
+// message; returning an error logs it and leaves it on the queue, so it can
+// be routed to a dead-letter queue by the queue's redrive policy.
+type Decoder func(*Message) (*objects.Object, error)
+
+type Source struct {
+	Client  *objects.Client
+	Queue   Queue
+	Decoder Decoder
+}
+
+func New(client *objects.Client, queue Queue, decoder Decoder) *Source {
+	return &Source{
+		Client:  client,
+		Queue:   queue,
+		Decoder: decoder,
+	}
+}
+
+// Run polls the queue until ctx is done or Receive fails. Delivery errors
+// are logged and the affected messages are left for redelivery.
+func (s *Source) Run(ctx context.Context) error {
+	for {
+		msgs, err := s.Queue.Receive(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := s.process(ctx, msgs); err != nil {
+			log.Printf("[Error] %d messages left for redelivery: %v", len(msgs), err)
+		}
+	}
+}
+
+func (s *Source) process(ctx context.Context, msgs []*Message) error {
+	done := make([]*Message, 0, len(msgs))
+	objs := make([]*objects.Object, 0, len(msgs))
+	for _, m := range msgs {
+		v, err := s.Decoder(m)
+		if err != nil {
+			log.Printf("[Error] Message `%s` not decoded: %v", m.ID, err)
+			continue
+		}
+		done = append(done, m)
+		if v != nil {
+			objs = append(objs, v)
+		}
+	}
+
+	if err := source.Send(ctx, s.Client, objs); err != nil {
+		return err
+	}
+
+	if len(done) == 0 {
+		return nil
+	}
+	return s.Queue.Delete(ctx, done...)
+}
@@ -0,0 +1,92 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/segmentio/objects-go"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSQS(t *testing.T) {
+	suite.Run(t, &SQSTestSuite{})
+}
+
+type SQSTestSuite struct {
+	suite.Suite
+
+	mu   sync.Mutex
+	sent []string
+}
+
+type queue struct {
+	batches [][]*Message
+	deleted []string
+}
+
+func (q *queue) Receive(ctx context.Context) ([]*Message, error) {
+	if len(q.batches) == 0 {
+		return nil, context.Canceled
+	}
+	msgs := q.batches[0]
+	q.batches = q.batches[1:]
+	return msgs, nil
+}
+
+func (q *queue) Delete(ctx context.Context, msgs ...*Message) error {
+	for _, m := range msgs {
+		q.deleted = append(q.deleted, m.ID)
+	}
+	return nil
+}
+
+func decode(m *Message) (*objects.Object, error) {
+	if string(m.Body) == "bad" {
+		return nil, errors.New("bad message")
+	}
+	if string(m.Body) == "skip" {
+		return nil, nil
+	}
+	return &objects.Object{
+		ID:         m.ID,
+		Collection: "rooms",
+		Properties: map[string]interface{}{"value": string(m.Body)},
+	}, nil
+}
+
+func (s *SQSTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://objects.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := struct {
+			Objects []*objects.Object `json:"objects"`
+		}{}
+		json.NewDecoder(req.Body).Decode(&v)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, o := range v.Objects {
+			s.sent = append(s.sent, o.ID)
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (s *SQSTestSuite) TestDeleteAfterDelivery() {
+	q := &queue{batches: [][]*Message{
+		{{ID: "a", Body: []byte("1")}, {ID: "b", Body: []byte("bad")}, {ID: "c", Body: []byte("skip")}},
+		{{ID: "d", Body: []byte("4")}},
+	}}
+	src := New(objects.New("writeKey"), q, decode)
+	s.Equal(context.Canceled, src.Run(context.Background()))
+
+	// Undecodable messages stay on the queue for its redrive policy.
+	s.Equal([]string{"a", "c", "d"}, q.deleted)
+	s.mu.Lock()
+	s.Equal([]string{"a", "d"}, s.sent)
+	s.mu.Unlock()
+}
@@ -0,0 +1,323 @@
+package objects
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	snakecase "github.com/segmentio/go-snakecase"
+)
+
+// spillable reports whether v is large enough to be encoded to disk, see
+// Client.SpillThreshold. Its size is estimated from its top-level string and
+// byte values, without encoding it.
+func (c *Client) spillable(v *Object) bool {
+	if c.SpillThreshold <= 0 || c.spoolingOnClose() || c.Signer != nil || c.Checksum || c.PayloadEncoder != nil ||
+		len(c.Middleware) > 0 || c.Serializers[v.Collection] != nil || !c.windowOpen(time.Now()) {
+		return false
+	}
+
+	n := 0
+	for _, val := range v.Properties {
+		switch x := val.(type) {
+		case string:
+			n += len(x)
+		case json.RawMessage:
+			n += len(x)
+		case []byte:
+			n += len(x) * 4 / 3
+		}
+	}
+	return n >= c.SpillThreshold
+}
+
+// spill encodes v into a temporary file in SpillDir and delivers it in its
+// own request, streamed from the file. It reports whether v was handled,
+// which it is not if the file can't be created.
+func (c *Client) spill(v *Object) bool {
+	f, err := ioutil.TempFile(c.SpillDir, "objects-spill-")
+	if err != nil {
+		c.Logger.Printf("[Warn] Object `%s` not spilled: %v", v.ID, err)
+		return false
+	}
+	discard := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	w := bufio.NewWriter(f)
+	err = c.encodeSpilled(w, v)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		discard()
+		c.drop(v, DropMarshal, err)
+		return true
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		discard()
+		c.drop(v, DropMarshal, err)
+		return true
+	}
+	if !c.admit(v, int(size)) {
+		discard()
+		return true
+	}
+
+	request := &batch{
+		Collection: v.Collection,
+		WriteKey:   c.writeKey,
+		seq:        c.nextSeq(),
+		created:    time.Now(),
+		count:      1,
+		enqueued:   time.Now(),
+		spilled:    int(size),
+	}
+	if c.tracksIDs() {
+		request.ids = []string{v.ID}
+		request.meta = []map[string]interface{}{v.Meta}
+	}
+	if !v.Deadline.IsZero() {
+		request.expiring, request.expiringPos = []*Object{v}, []int{0}
+	}
+	if !v.ExpiresAt.IsZero() {
+		request.expires = []expiry{{Collection: v.Collection, ID: v.ID, At: v.ExpiresAt}}
+		request.expiresPos = []int{0}
+	}
+
+	c.semaphore.Run(func() {
+		defer discard()
+		if !c.dropExpired(request) {
+			return
+		}
+
+		ctx := c.ctx
+		if !v.Deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, v.Deadline)
+			defer cancel()
+		}
+
+		start := time.Now()
+		c.begin(request)
+		header := http.Header{}
+		header.Set(RequestIDHeader, newRequestID())
+		st := &retryState{}
+		err := c.postFile(withRetryState(ctx, st), request, f, header)
+		c.end(request)
+		c.complete(ctx, request, start, header, err)
+		if err != nil {
+			c.Logger.Printf("[Error] %v", err)
+			request.attempts, request.retryAt = st.attempts, st.retryAt
+			if !v.Deadline.IsZero() {
+				// It would be stale once replayed, see sendAndSpool.
+				c.drop(v, DropDeadline, err)
+			} else if st.rejected() {
+				c.dropBatch(request, DropRejected, err)
+			} else if !c.spoolFile(request, f) {
+				c.dropBatch(request, DropRetriesExhausted, err)
+			}
+		}
+	})
+	return true
+}
+
+// spillChunk bounds the memory used to escape a string property streamed to
+// a spill file.
+const spillChunk = 32 << 10
+
+// encodeSpilled encodes v to w like marshal. Its string and bytes properties
+// are streamed to w rather than flattened in memory with the others.
+func (c *Client) encodeSpilled(w *bufio.Writer, v *Object) error {
+	streamed := map[string]interface{}{}
+	rest := make(map[string]interface{}, len(v.Properties))
+	for key, val := range v.Properties {
+		switch val.(type) {
+		case string, []byte, json.RawMessage:
+			if _, ok := c.encoders[reflect.TypeOf(val)]; !ok {
+				if !c.Nested[v.Collection] {
+					key = snakecase.Snakecase(key)
+				}
+				streamed[key] = val
+				continue
+			}
+		}
+		rest[key] = val
+	}
+
+	id, err := json.Marshal(v.ID)
+	if err != nil {
+		return err
+	}
+	w.WriteString(`{"id":`)
+	w.Write(id)
+	w.WriteString(`,"properties":{`)
+
+	first := true
+	writeKey := func(key string) error {
+		k, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.WriteByte(',')
+		}
+		first = false
+		w.Write(k)
+		return w.WriteByte(':')
+	}
+	for key, val := range c.Flatten(&Object{ID: v.ID, Collection: v.Collection, Properties: rest}) {
+		if _, ok := streamed[key]; ok {
+			continue
+		}
+		x, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		if err := writeKey(key); err != nil {
+			return err
+		}
+		w.Write(x)
+	}
+	for key, val := range streamed {
+		if err := writeKey(key); err != nil {
+			return err
+		}
+		switch x := val.(type) {
+		case string:
+			err = writeString(w, x)
+		case []byte:
+			// Like encoding/json, which encodes bytes as base64.
+			w.WriteByte('"')
+			enc := base64.NewEncoder(base64.StdEncoding, w)
+			enc.Write(x)
+			enc.Close()
+			err = w.WriteByte('"')
+		case json.RawMessage:
+			if !json.Valid(x) {
+				return fmt.Errorf("Object `%s` property `%s` is invalid JSON", v.ID, key)
+			}
+			_, err = w.Write(x)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	w.WriteString(`}}`)
+	return nil
+}
+
+// writeString writes s to w as a JSON string, escaping it in chunks of
+// spillChunk bytes.
+func writeString(w *bufio.Writer, s string) error {
+	w.WriteByte('"')
+	for len(s) > 0 {
+		n := len(s)
+		if n > spillChunk {
+			// Chunks are cut between runes, which are escaped whole.
+			n = spillChunk
+			for n > spillChunk-utf8.UTFMax && !utf8.RuneStart(s[n]) {
+				n--
+			}
+		}
+		x, err := json.Marshal(s[:n])
+		if err != nil {
+			return err
+		}
+		w.Write(x[1 : len(x)-1])
+		s = s[n:]
+	}
+	return w.WriteByte('"')
+}
+
+// spoolFile writes the spilled object of request, stored in f, to Spool, if
+// any, and reports whether it did.
+func (c *Client) spoolFile(request *batch, f *os.File) bool {
+	if c.Spool == nil {
+		return false
+	}
+	objects := make([]byte, request.spilled+2)
+	objects[0], objects[len(objects)-1] = '[', ']'
+	if _, err := f.ReadAt(objects[1:len(objects)-1], 0); err != nil {
+		c.Logger.Printf("[Error] Batch for collection `%s` failed to spool: %v", request.Collection, err)
+		return false
+	}
+	spooled := *request
+	spooled.Objects, spooled.spilled = objects, 0
+	return c.spool(&spooled)
+}
+
+// postFile sends the spilled object of request, stored in f, to /v1/set,
+// retrying failures.
+func (c *Client) postFile(ctx context.Context, request *batch, f *os.File, header http.Header) error {
+	collection, err := json.Marshal(request.Collection)
+	if err != nil {
+		return err
+	}
+	writeKey, err := json.Marshal(request.WriteKey)
+	if err != nil {
+		return err
+	}
+	prefix := fmt.Sprintf(`{"collection":%s,"write_key":%s,"objects":[`, collection, writeKey)
+	const suffix = `]}`
+
+	open := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.MultiReader(
+			strings.NewReader(prefix),
+			io.NewSectionReader(f, 0, int64(request.spilled)),
+			strings.NewReader(suffix),
+		)), nil
+	}
+
+	err = retry(ctx, c.backOff(ctx), func() error {
+		endpoint := c.endpoint()
+		body, _ := open()
+		req, err := http.NewRequest("POST", endpoint+"/v1/set", body)
+		if err != nil {
+			return err
+		}
+		req.GetBody = open
+		req.ContentLength = int64(len(prefix) + request.spilled + len(suffix))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		probe, err := c.gate(ctx)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		resp, err := c.do(req.WithContext(ctx), nil)
+		c.observe(ctx, endpoint, start, probe, resp, err)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		ioutil.ReadAll(resp.Body)
+		if st := retryStateFrom(ctx); st != nil {
+			st.status = resp.StatusCode
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return c.responseError(fmt.Errorf("HTTP Post Request Failed, Status Code %d, for a spilled object of %d bytes of collection `%s`",
+				resp.StatusCode, request.spilled, request.Collection))
+		}
+		return nil
+	})
+	return c.redactError(err)
+}
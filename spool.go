@@ -0,0 +1,973 @@
+package objects
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSpoolCorrupt = errors.New("Spooled batch is corrupt or was encrypted with another key")
+	ErrSpoolFull    = errors.New("Spool is full")
+	ErrSpoolBusy    = errors.New("Spool is being replayed by another process")
+)
+
+const (
+	segmentExt = ".seg"
+
+	// dedupeFile lists the IDs of batches delivered by a Replay whose
+	// segment has not been removed or rewritten yet.
+	dedupeFile = "delivered"
+
+	// lockFile is locked by the process replaying or compacting the spool.
+	lockFile = "lock"
+
+	// quarantineFile holds the records Replay failed to decode, e.g. those
+	// encrypted with another key, in the segment format.
+	quarantineFile = "quarantine"
+
+	// maxRecordSize bounds the length read from a record header, so a
+	// corrupt one cannot allocate gigabytes.
+	maxRecordSize = 64 << 20
+)
+
+// EvictionPolicy decides what happens when a Spool reaches MaxSize.
+type EvictionPolicy int
+
+const (
+	// EvictOldest deletes the oldest segments to make room for new batches.
+	// Their objects are dropped with DropEvicted.
+	EvictOldest EvictionPolicy = iota
+
+	// RejectNew keeps the spooled batches and drops new ones.
+	RejectNew
+)
+
+// Spool persists batches whose delivery failed to a directory, so they can
+// be replayed with Client.Replay once the Objects API is reachable again.
+// Batches are appended to segment files of up to SegmentSize bytes.
+// Spooled batches may contain PII: set Key or KeyFunc to encrypt them with
+// AES-GCM. The write key is never spooled.
+//
+// Several processes may share a spool directory, e.g. during blue/green
+// deploys. Every process writes to its own segments, which it keeps locked
+// while they are active, and only one process at a time replays or compacts
+// the spool. Locking relies on flock and is not available on Windows.
+type Spool struct {
+	Dir string
+
+	// Key is a 16, 24 or 32 byte AES key.
+	Key []byte
+
+	// KeyFunc returns the AES key, e.g. by decrypting a data key with a KMS.
+	// It takes precedence over Key and is called for every read and write,
+	// so it should cache the key.
+	KeyFunc func() ([]byte, error)
+
+	// SegmentSize is the size at which a new segment file is started.
+	SegmentSize int64
+
+	// MaxSize optionally bounds the total size of the spool. Eviction decides
+	// what is dropped when a new batch would exceed it.
+	MaxSize  int64
+	Eviction EvictionPolicy
+
+	// CompactInterval optionally merges small segments, e.g. those left by a
+	// partial Replay, in the background. Close stops it.
+	CompactInterval time.Duration
+
+	// Logger logs the errors of the spool's own work, e.g. compaction and
+	// eviction. OpenSpool sets it to log to stderr like Client.Logger; nil
+	// disables logging.
+	Logger *log.Logger
+
+	mu         sync.Mutex
+	owner      string
+	ownerOnce  sync.Once
+	seq        uint64
+	active     *os.File
+	activePath string
+	activeSize int64
+
+	// expiries is the expiry log of this process, see expiryLog.
+	expiries expiryLog
+
+	once sync.Once
+	exit chan struct{}
+}
+
+type spooledBatch struct {
+	// ID identifies the batch in the dedupe index and is sent as its
+	// request ID when replayed.
+	ID         string          `json:"id"`
+	Collection string          `json:"collection"`
+	Objects    json.RawMessage `json:"objects"`
+
+	// Seq and Created are the batch's, see Delivery.
+	Seq     uint64    `json:"seq,omitempty"`
+	Created time.Time `json:"created"`
+
+	// Attempts is the number of failed delivery attempts, and RetryAt when
+	// the next one is due. Replay resumes the backoff schedule from there.
+	Attempts int       `json:"attempts,omitempty"`
+	RetryAt  time.Time `json:"retry_at"`
+
+	// Expires are the expiries of the batch's objects, scheduled once it is
+	// delivered.
+	Expires []expiry `json:"expires,omitempty"`
+}
+
+// OpenSpool returns a Spool writing to dir, creating it if needed. Segments
+// already in dir are kept and replayed.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Spool{
+		Dir:         dir,
+		SegmentSize: 4 << 20,
+		Logger:      log.New(os.Stderr, "segment ", log.LstdFlags),
+		exit:        make(chan struct{}),
+	}, nil
+}
+
+// Close stops background compaction and closes the active segment.
+func (s *Spool) Close() error {
+	if s.exit != nil {
+		select {
+		case <-s.exit:
+		default:
+			close(s.exit)
+		}
+	}
+
+	s.expiries.close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seal()
+}
+
+func (s *Spool) aead() (cipher.AEAD, error) {
+	key := s.Key
+	if s.KeyFunc != nil {
+		var err error
+		if key, err = s.KeyFunc(); err != nil {
+			return nil, err
+		}
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encode returns the record for v, see frame.
+func (s *Spool) encode(v *spooledBatch) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return s.frame(data)
+}
+
+func (s *Spool) decode(record []byte) (*spooledBatch, error) {
+	data, err := s.unframe(record)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &spooledBatch{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, ErrSpoolCorrupt
+	}
+	return v, nil
+}
+
+// frame returns the record for data: a 4 byte big-endian length followed by
+// data, sealed when a key is set.
+func (s *Spool) frame(data []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	if aead != nil {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		data = aead.Seal(nonce, nonce, data, nil)
+	}
+
+	if len(data) > maxRecordSize {
+		return nil, ErrSpoolFull
+	}
+	record := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	return append(record, data...), nil
+}
+
+// unframe returns the data of record, opened when a key is set.
+func (s *Spool) unframe(record []byte) ([]byte, error) {
+	data := record[4:]
+
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	if aead != nil {
+		if len(data) < aead.NonceSize() {
+			return nil, ErrSpoolCorrupt
+		}
+		nonce := data[:aead.NonceSize()]
+		if data, err = aead.Open(nil, nonce, data[aead.NonceSize():], nil); err != nil {
+			return nil, ErrSpoolCorrupt
+		}
+	}
+	return data, nil
+}
+
+// write appends request to the active segment.
+func (s *Spool) write(request *batch) error {
+	_, err := s.put(request)
+	return err
+}
+
+// put appends request to the active segment and returns the batches evicted
+// to make room for it.
+func (s *Spool) put(request *batch) ([]*spooledBatch, error) {
+	if s.CompactInterval > 0 {
+		s.once.Do(func() { go s.compactLoop() })
+	}
+
+	record, err := s.encode(&spooledBatch{
+		ID:         newRequestID(),
+		Collection: request.Collection,
+		Objects:    request.Objects,
+		Seq:        request.seq,
+		Created:    request.created.UTC(),
+		Attempts:   request.attempts,
+		RetryAt:    request.retryAt.UTC(),
+		Expires:    request.expires,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	evicted, err := s.reserve(int64(len(record)))
+	if err != nil {
+		return evicted, err
+	}
+
+	if s.active == nil || (s.activeSize > 0 && s.activeSize+int64(len(record)) > s.SegmentSize) {
+		if err := s.roll(); err != nil {
+			return evicted, err
+		}
+	}
+
+	n, err := s.active.Write(record)
+	if err == nil {
+		err = s.active.Sync()
+	}
+	if err != nil {
+		// Cut a partial record off, so the next ones can be read back.
+		if n > 0 && s.active.Truncate(s.activeSize) != nil {
+			s.seal()
+		}
+		return evicted, err
+	}
+	s.activeSize += int64(n)
+	return evicted, nil
+}
+
+// reserve makes room for n more bytes according to MaxSize and Eviction,
+// and returns the batches evicted. Records that fail to decode are not
+// returned.
+func (s *Spool) reserve(n int64) ([]*spooledBatch, error) {
+	if s.MaxSize <= 0 {
+		return nil, nil
+	}
+	if n > s.MaxSize {
+		return nil, ErrSpoolFull
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int64, len(segments))
+	var total int64
+	for i, path := range segments {
+		if fi, err := os.Stat(path); err == nil {
+			sizes[i] = fi.Size()
+			total += sizes[i]
+		}
+	}
+
+	var evicted []*spooledBatch
+	for i := 0; total+n > s.MaxSize; i++ {
+		if s.Eviction == RejectNew || i == len(segments) {
+			return evicted, ErrSpoolFull
+		}
+		if segments[i] == s.activePath {
+			if err := s.seal(); err != nil {
+				return evicted, err
+			}
+		}
+
+		// Segments locked by other processes are skipped.
+		f, err := claim(segments[i])
+		if err != nil {
+			return evicted, err
+		}
+		if f == nil {
+			continue
+		}
+		records, err := s.readRecords(segments[i])
+		if err == nil {
+			err = os.Remove(segments[i])
+		}
+		f.Close()
+		if err != nil {
+			return evicted, err
+		}
+		for _, record := range records {
+			if v, err := s.decode(record); err == nil {
+				evicted = append(evicted, v)
+			} else {
+				s.logf("[Error] Spooled batch evicted from %s failed to decode: %v", segments[i], err)
+			}
+		}
+		total -= sizes[i]
+	}
+	return evicted, nil
+}
+
+// roll seals the active segment and starts a new one.
+func (s *Spool) roll() error {
+	if err := s.seal(); err != nil {
+		return err
+	}
+
+	// Names sort by creation time and are unique across processes.
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d-%s%s", time.Now().UnixNano(), s.seq, s.ownerID(), segmentExt)
+	path := filepath.Join(s.Dir, name)
+	f, err := createLocked(path)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.activePath = path
+	s.activeSize = 0
+	return nil
+}
+
+// ownerID returns the name identifying the files of this process.
+func (s *Spool) ownerID() string {
+	s.ownerOnce.Do(func() { s.owner = newRequestID()[:8] })
+	return s.owner
+}
+
+// createLocked creates the file at path, opened for appending and locked.
+// The file is locked under a temporary name before it is visible, so another
+// process's Replay or Compact cannot claim it while it is empty.
+func createLocked(path string) (*os.File, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return f, nil
+}
+
+// seal closes the active segment, if any, which releases its lock.
+func (s *Spool) seal() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	s.activePath = ""
+	s.activeSize = 0
+	return err
+}
+
+// segments returns the segment files, oldest first.
+func (s *Spool) segments() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), segmentExt) {
+			segments = append(segments, filepath.Join(s.Dir, e.Name()))
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// readRecords reads the raw records of the segment at path. A segment ending
+// with a partial record, e.g. left by a crash mid-write, or with a corrupt
+// length is truncated after its last complete record.
+func (s *Spool) readRecords(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records [][]byte
+	r := bufio.NewReader(f)
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return records, nil
+		} else if err == io.ErrUnexpectedEOF {
+			s.logTruncated(path, len(records))
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size > maxRecordSize {
+			s.logTruncated(path, len(records))
+			return records, nil
+		}
+		record := make([]byte, 4+size)
+		copy(record, header)
+		if _, err := io.ReadFull(r, record[4:]); err == io.ErrUnexpectedEOF || err == io.EOF {
+			s.logTruncated(path, len(records))
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// logTruncated logs that the segment at path is cut after n records. The
+// tail is dropped when the segment is next rewritten or removed.
+func (s *Spool) logTruncated(path string, n int) {
+	s.logf("[Error] Spool segment %s truncated after %d records: %v", path, n, ErrSpoolCorrupt)
+}
+
+// logf logs to the spool's Logger, if any.
+func (s *Spool) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// rewrite atomically replaces the segment at path with records: they are
+// written and synced to a temporary file first, so a crash leaves either the
+// old segment or the new one.
+func rewrite(path string, records [][]byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, record := range records {
+		w.Write(record)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Compact merges adjacent segments that together fit in SegmentSize, keeping
+// the batches in order.
+func (s *Spool) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err == ErrSpoolBusy {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var run []string
+	var claimed []*os.File
+	var size int64
+	merge := func() error {
+		defer func() {
+			for _, f := range claimed {
+				f.Close()
+			}
+			run, claimed, size = nil, nil, 0
+		}()
+		if len(run) < 2 {
+			return nil
+		}
+
+		var records [][]byte
+		for _, path := range run {
+			r, err := s.readRecords(path)
+			if err != nil {
+				return err
+			}
+			records = append(records, r...)
+		}
+		if err := rewrite(run[0], records); err != nil {
+			return err
+		}
+		for _, path := range run[1:] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, path := range segments {
+		if path == s.activePath {
+			break
+		}
+
+		// A segment active in another process ends the run.
+		f, err := claim(path)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			if err := merge(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if size+fi.Size() > s.SegmentSize {
+			if err := merge(); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		run = append(run, path)
+		claimed = append(claimed, f)
+		size += fi.Size()
+	}
+	return merge()
+}
+
+func (s *Spool) compactLoop() {
+	tick := time.NewTicker(s.CompactInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := s.Compact(); err != nil {
+				s.logf("[Error] Spool compaction failed: %v", err)
+			}
+		case <-s.exit:
+			return
+		}
+	}
+}
+
+// loadDelivered returns the IDs in the dedupe index.
+func (s *Spool) loadDelivered() (map[string]bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, dedupeFile))
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	for _, id := range strings.Split(string(data), "\n") {
+		if id != "" {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// markDelivered durably appends id to the dedupe index.
+func (s *Spool) markDelivered(id string) error {
+	path := filepath.Join(s.Dir, dedupeFile)
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(id + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if created {
+		return syncDir(s.Dir)
+	}
+	return nil
+}
+
+// clearDelivered empties the dedupe index once the delivered batches are
+// gone from the segments.
+func (s *Spool) clearDelivered() error {
+	err := os.Remove(filepath.Join(s.Dir, dedupeFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Replay delivers every batch in Spool, oldest first, deleting segments once
+// they are delivered. It stops at the first failure, keeping the batches not
+// yet delivered, and returns the number of batches delivered. Batches the
+// API rejects, e.g. as invalid, are dropped with DropRejected instead, and
+// batches that fail to decode are moved to a quarantine file in Dir.
+//
+// Delivered batch IDs are recorded in a dedupe index until their segment is
+// removed, so batches acknowledged just before a crash are not resent by the
+// next Replay.
+//
+// Batches spooled mid-retry, e.g. by Close, keep their attempts: Replay skips
+// them until their next attempt is due, leaving them for a later Replay, and
+// continues their backoff schedule.
+//
+// Replay also reschedules the expiries, see Object.ExpiresAt, and the
+// objects held by SetAt, left pending by processes sharing the spool that
+// exited.
+func (c *Client) Replay(ctx context.Context) (int, error) {
+	if c.Spool == nil {
+		return 0, nil
+	}
+
+	// The spool-wide lock keeps other replays and compactions out, s.mu is
+	// only held to change files, so batches are spooled while replaying.
+	s := c.Spool
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	s.mu.Lock()
+	err = s.seal()
+	var segments []string
+	if err == nil {
+		segments, err = s.segments()
+	}
+	var delivered map[string]bool
+	if err == nil {
+		delivered, err = s.loadDelivered()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if err := c.restoreExpiries(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, path := range segments {
+		sent, err := c.replaySegment(ctx, path, delivered)
+		n += sent
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// replaySegment delivers the batches of the segment at path, unless another
+// process is still writing to it. Batches spooled mid-retry whose next
+// attempt is not due yet are left in the segment for a later Replay, rather
+// than waited for while holding the spool-wide lock. Batches are sent
+// without holding s.mu.
+func (c *Client) replaySegment(ctx context.Context, path string, delivered map[string]bool) (int, error) {
+	s := c.Spool
+	s.mu.Lock()
+	f, err := claim(path)
+	if err != nil || f == nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	defer f.Close()
+	records, err := s.readRecords(path)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// kept are the records left in the segment, and changed reports
+	// whether they differ from records.
+	var kept [][]byte
+	changed := false
+	n := 0
+	for i, record := range records {
+		v, err := s.decode(record)
+		if err != nil {
+			// Moved aside rather than blocking the batches behind it.
+			c.Logger.Printf("[Error] Spooled batch in %s quarantined: %v", path, err)
+			s.mu.Lock()
+			err := s.quarantine(record)
+			s.mu.Unlock()
+			if err != nil {
+				kept = append(kept, records[i:]...)
+				return n, s.keep(path, kept, changed, err)
+			}
+			changed = true
+			continue
+		}
+		if delivered[v.ID] {
+			changed = true
+			continue
+		}
+		if time.Now().Before(v.RetryAt) {
+			kept = append(kept, record)
+			continue
+		}
+
+		rejected, err := c.replay(ctx, v)
+		if rejected {
+			// Resending it cannot succeed: it is dropped, and recorded
+			// like a delivered batch so it is not dropped twice.
+			c.dropSpooled(v, DropRejected, err)
+		} else if err == nil {
+			n++
+		}
+		if err == nil || rejected {
+			// The batch is not kept, even if recording it fails.
+			changed = true
+			s.mu.Lock()
+			err = s.markDelivered(v.ID)
+			s.mu.Unlock()
+		} else {
+			if record, encErr := s.encode(v); encErr == nil {
+				// Keep the attempts made for the next Replay.
+				records[i] = record
+				changed = true
+			}
+			kept = append(kept, records[i])
+		}
+		if err != nil {
+			kept = append(kept, records[i+1:]...)
+			return n, s.keep(path, kept, changed, err)
+		}
+	}
+	return n, s.keep(path, kept, changed, nil)
+}
+
+// keep leaves records in the segment at path, removing it if there are none,
+// once replaying it stopped with err. The dedupe index is cleared once the
+// delivered batches are out of the segment.
+func (s *Spool) keep(path string, records [][]byte, changed bool, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(records) == 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if changed {
+		if err := rewrite(path, records); err != nil {
+			return err
+		}
+	}
+	if changed {
+		if err := s.clearDelivered(); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// quarantine durably appends record to the quarantine file.
+func (s *Spool) quarantine(record []byte) error {
+	f, err := os.OpenFile(filepath.Join(s.Dir, quarantineFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(record); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lock takes the spool-wide lock held while replaying or compacting. It
+// returns ErrSpoolBusy if another process holds it.
+func (s *Spool) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.Dir, lockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err == errLocked {
+		f.Close()
+		return nil, ErrSpoolBusy
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// claim locks the segment at path. It returns a nil file if the segment is
+// locked by another writer or no longer exists. Closing the file releases
+// the lock.
+func claim(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err == errLocked {
+		f.Close()
+		return nil, nil
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// replay sends a spooled batch with its ID as request ID. If that fails, v
+// is updated with the attempts made, and rejected reports whether the API
+// rejected the batch.
+func (c *Client) replay(ctx context.Context, v *spooledBatch) (rejected bool, err error) {
+	start := time.Now()
+	header := http.Header{}
+	header.Set(RequestIDHeader, v.ID)
+	st := &retryState{attempts: v.Attempts}
+	err = c.sendBatch(withRetryState(ctx, st), &BatchRequest{
+		Collection: v.Collection,
+		Objects:    v.Objects,
+		Header:     header,
+	})
+	if c.tracksIDs() {
+		c.completeReplay(ctx, v, start, header, err)
+	}
+	if err == nil {
+		for _, e := range v.Expires {
+			c.expire(e)
+		}
+	}
+	if err != nil {
+		v.Attempts, v.RetryAt = st.attempts, st.retryAt
+	}
+	return err != nil && st.rejected(), err
+}
+
+// completeReplay reports the delivery of a replayed batch, whose object IDs
+// are not spooled separately, with the Seq and Created it was spooled with.
+func (c *Client) completeReplay(ctx context.Context, v *spooledBatch, start time.Time, header http.Header, err error) {
+	ids, idsErr := v.ids()
+	if idsErr != nil {
+		c.Logger.Printf("[Error] Replayed batch of collection `%s` failed to decode: %v", v.Collection, idsErr)
+		return
+	}
+	c.report(Delivery{
+		Collection: v.Collection,
+		IDs:        ids,
+		Objects:    len(ids),
+		Seq:        v.Seq,
+		Created:    v.Created,
+		Bytes:      len(v.Objects),
+		RequestID:  header.Get(RequestIDHeader),
+		Checksum:   header.Get(ChecksumHeader),
+		Duration:   time.Since(start),
+		Err:        err,
+	})
+	if c.Receipts != nil && err == nil {
+		c.storeReceipts(ctx, &batch{Collection: v.Collection, ids: ids}, v.ID)
+	}
+}
+
+// ids returns the IDs of the objects of v.
+func (v *spooledBatch) ids() ([]string, error) {
+	var objects []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(v.Objects, &objects); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(objects))
+	for i, o := range objects {
+		ids[i] = o.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,681 @@
+package objects
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSpool(t *testing.T) {
+	suite.Run(t, &SpoolTestSuite{})
+}
+
+type SpoolTestSuite struct {
+	suite.Suite
+	dir      string
+	received chan string
+}
+
+func (s *SpoolTestSuite) SetupSuite() {
+	s.received = make(chan string, 100)
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+	httpmock.RegisterResponder("POST", "https://reject.segment.com/v1/set", httpmock.NewStringResponder(400, ""))
+	httpmock.RegisterResponder("POST", "https://spool.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := &batch{}
+		if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		s.received <- v.Collection + " " + string(v.Objects)
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+	httpmock.RegisterResponder("POST", "https://spool.segment.com/v1/delete", func(req *http.Request) (*http.Response, error) {
+		v := &deleteRequest{}
+		if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		s.received <- "delete " + v.Collection + " " + strings.Join(v.IDs, ",")
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+}
+
+func (s *SpoolTestSuite) SetupTest() {
+	var err error
+	s.dir, err = ioutil.TempDir("", "spool")
+	s.Require().NoError(err)
+}
+
+func (s *SpoolTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *SpoolTestSuite) segments() []string {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentExt))
+	s.NoError(err)
+	return files
+}
+
+func (s *SpoolTestSuite) batch(i int) *batch {
+	return &batch{Collection: "c", Objects: json.RawMessage(`[{"id":"` + strconv.Itoa(i) + `"}]`)}
+}
+
+func (s *SpoolTestSuite) TestSpoolFailedBatches() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Backoff = BackoffFunc(func() backoff.BackOff { return &backoff.StopBackOff{} })
+	client.Spool = spool
+	s.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.CloseContext(ctx)
+	s.NoError(spool.Close())
+
+	segments := s.segments()
+	s.Len(segments, 1)
+	data, err := ioutil.ReadFile(segments[0])
+	s.NoError(err)
+	s.NotContains(string(data), "writeKey")
+
+	// Reopening keeps the segments.
+	spool, err = OpenSpool(s.dir)
+	s.NoError(err)
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.Spool = spool
+	n, err := replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"1","properties":{"p":"1"}}]`, <-s.received)
+	s.Len(s.segments(), 0)
+}
+
+func (s *SpoolTestSuite) TestResumeBackoff() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	// A batch aborted by Close keeps its attempts.
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Spool = spool
+	s.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.CloseContext(ctx)
+
+	segments := s.segments()
+	records, err := spool.readRecords(segments[0])
+	s.NoError(err)
+	v, err := spool.decode(records[0])
+	s.NoError(err)
+	s.True(v.Attempts > 0)
+	s.False(v.RetryAt.IsZero())
+	s.NoError(spool.Close())
+	s.NoError(os.Remove(segments[0]))
+	spool, err = OpenSpool(s.dir)
+	s.NoError(err)
+
+	// Replay skips batches until their next attempt is due.
+	request := s.batch(2)
+	request.attempts, request.retryAt = 3, time.Now().Add(time.Hour)
+	s.NoError(spool.write(request))
+	request = s.batch(3)
+	request.attempts, request.retryAt = 3, time.Now().Add(-time.Second)
+	s.NoError(spool.write(request))
+
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.Spool = spool
+	n, err := replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"3"}]`, <-s.received)
+
+	segments = s.segments()
+	s.Len(segments, 1)
+	records, err = spool.readRecords(segments[0])
+	s.NoError(err)
+	s.Len(records, 1)
+	v, err = spool.decode(records[0])
+	s.NoError(err)
+	s.Equal(3, v.Attempts)
+	s.Equal(`[{"id":"2"}]`, string(v.Objects))
+}
+
+func (s *SpoolTestSuite) TestResumeAttempts() {
+	client := New("writeKey")
+	client.Backoff = &FibonacciBackoff{Initial: time.Second, Max: time.Minute}
+	st := &retryState{attempts: 3}
+	b := client.backOff(withRetryState(context.Background(), st))
+	b.Reset()
+	s.Equal(3*time.Second, b.NextBackOff())
+	s.Equal(5*time.Second, b.NextBackOff())
+
+	calls := 0
+	ctx := withRetryState(context.Background(), st)
+	err := retry(ctx, &backoff.ZeroBackOff{}, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	s.NoError(err)
+	s.Equal(6, st.attempts)
+}
+
+func (s *SpoolTestSuite) TestRejectedBatches() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	// Batches rejected by the API are dropped instead of spooled.
+	var drops []Drop
+	client := New("writeKey")
+	client.BaseEndpoint = "https://reject.segment.com"
+	client.Backoff = BackoffFunc(func() backoff.BackOff { return &backoff.StopBackOff{} })
+	client.Spool = spool
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	s.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	s.NoError(client.Close())
+	s.Len(drops, 1)
+	s.Equal(DropRejected, drops[0].Reason)
+	s.Len(s.segments(), 0)
+
+	// Replay drops rejected batches and quarantines corrupt ones rather than
+	// stopping at them.
+	s.NoError(spool.write(s.batch(0)))
+	s.NoError(spool.Close())
+	f, err := os.OpenFile(s.segments()[0], os.O_WRONLY|os.O_APPEND, 0600)
+	s.NoError(err)
+	_, err = f.Write([]byte{0, 0, 0, 3, 'x', 'y', 'z'})
+	s.NoError(err)
+	s.NoError(f.Close())
+	s.NoError(spool.write(s.batch(1)))
+
+	drops = nil
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://reject.segment.com"
+	replayer.Backoff = client.Backoff
+	replayer.Spool = spool
+	replayer.OnDrop = client.OnDrop
+	n, err := replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(0, n)
+	s.Len(drops, 2)
+	s.Len(s.segments(), 0)
+	records, err := spool.readRecords(filepath.Join(s.dir, quarantineFile))
+	s.NoError(err)
+	s.Len(records, 1)
+}
+
+func (s *SpoolTestSuite) TestDeadlineNotSpooled() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	var drops []Drop
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Backoff = BackoffFunc(func() backoff.BackOff { return &backoff.StopBackOff{} })
+	client.Spool = spool
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	s.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	s.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "2"}, Deadline: time.Now().Add(time.Hour)}))
+	s.NoError(client.Close())
+
+	s.Len(drops, 1)
+	s.Equal("2", drops[0].Object.ID)
+	s.Equal(DropDeadline, drops[0].Reason)
+
+	records, err := spool.readRecords(s.segments()[0])
+	s.NoError(err)
+	s.Len(records, 1)
+	v, err := spool.decode(records[0])
+	s.NoError(err)
+	s.Equal(`[{"id":"1","properties":{"p":"1"}}]`, string(v.Objects))
+}
+
+func (s *SpoolTestSuite) TestSpoolSpilled() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.Backoff = BackoffFunc(func() backoff.BackOff { return &backoff.StopBackOff{} })
+	client.Spool = spool
+	client.SpillThreshold = 1000
+	client.SpillDir = s.dir
+	big := strings.Repeat("x", 2000)
+	s.NoError(client.Set(&Object{ID: "big", Collection: "c", Properties: map[string]interface{}{"Blob": big}}))
+	s.NoError(client.Close())
+
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.Spool = spool
+	n, err := replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"big","properties":{"blob":"`+big+`"}}]`, <-s.received)
+}
+
+func (s *SpoolTestSuite) TestSpoolOnClose() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	client.SpoolOnClose = true
+	s.NoError(client.Validate())
+	s.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	s.NoError(client.Close())
+	s.Len(s.received, 0)
+
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.Spool = spool
+	n, err := replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"1","properties":{"p":"1"}}]`, <-s.received)
+	s.NoError(spool.Close())
+}
+
+func (s *SpoolTestSuite) TestEncryption() {
+	key := bytes.Repeat([]byte{1}, 32)
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	spool.KeyFunc = func() ([]byte, error) { return key, nil }
+
+	s.NoError(spool.write(&batch{Collection: "c", Objects: json.RawMessage(`[{"id":"secret-id"}]`)}))
+	s.NoError(spool.Close())
+	segments := s.segments()
+	s.Len(segments, 1)
+	data, err := ioutil.ReadFile(segments[0])
+	s.NoError(err)
+	s.NotContains(string(data), "secret-id")
+
+	records, err := spool.readRecords(segments[0])
+	s.NoError(err)
+	s.Len(records, 1)
+	v, err := spool.decode(records[0])
+	s.NoError(err)
+	s.Equal(`[{"id":"secret-id"}]`, string(v.Objects))
+
+	other := &Spool{Dir: s.dir, Key: bytes.Repeat([]byte{2}, 32)}
+	_, err = other.decode(records[0])
+	s.Equal(ErrSpoolCorrupt, err)
+
+	plain := &Spool{Dir: s.dir}
+	_, err = plain.decode(records[0])
+	s.Equal(ErrSpoolCorrupt, err)
+}
+
+func (s *SpoolTestSuite) TestSegments() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	record, err := spool.encode(&spooledBatch{ID: newRequestID(), Collection: "c", Objects: s.batch(0).Objects})
+	s.NoError(err)
+	spool.SegmentSize = int64(2 * len(record))
+
+	for i := 0; i < 5; i++ {
+		s.NoError(spool.write(s.batch(i)))
+	}
+	s.Len(s.segments(), 3)
+}
+
+func (s *SpoolTestSuite) TestEvictOldest() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	record, err := spool.encode(&spooledBatch{ID: newRequestID(), Collection: "c", Objects: s.batch(0).Objects})
+	s.NoError(err)
+	spool.SegmentSize = int64(len(record))
+	spool.MaxSize = int64(3 * len(record))
+
+	for i := 0; i < 5; i++ {
+		s.NoError(spool.write(s.batch(i)))
+	}
+	s.Len(s.segments(), 3)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	n, err := client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(3, n)
+	s.Equal(`c [{"id":"2"}]`, <-s.received)
+	s.Equal(`c [{"id":"3"}]`, <-s.received)
+	s.Equal(`c [{"id":"4"}]`, <-s.received)
+}
+
+func (s *SpoolTestSuite) TestEvictedDrops() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	record, err := spool.encode(&spooledBatch{ID: newRequestID(), Collection: "c", Objects: s.batch(0).Objects})
+	s.NoError(err)
+	spool.SegmentSize = int64(len(record))
+	spool.MaxSize = int64(2 * len(record))
+
+	var drops []Drop
+	client := New("writeKey")
+	client.Spool = spool
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	for i := 0; i < 3; i++ {
+		s.True(client.spool(s.batch(i)))
+	}
+	s.Len(drops, 1)
+	s.Equal("0", drops[0].Object.ID)
+	s.Equal(DropEvicted, drops[0].Reason)
+}
+
+func (s *SpoolTestSuite) TestTornTail() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	for i := 0; i < 2; i++ {
+		s.NoError(spool.write(s.batch(i)))
+	}
+	s.NoError(spool.Close())
+
+	// A crash mid-write leaves a partial record, and a corrupt length must
+	// not be allocated.
+	path := s.segments()[0]
+	data, err := ioutil.ReadFile(path)
+	s.NoError(err)
+	s.NoError(ioutil.WriteFile(path, append(data, 0, 0, 1, 0, '{'), 0600))
+	records, err := spool.readRecords(path)
+	s.NoError(err)
+	s.Len(records, 2)
+
+	s.NoError(ioutil.WriteFile(path, append(data, 0xff, 0xff, 0xff, 0xff, '{'), 0600))
+	records, err = spool.readRecords(path)
+	s.NoError(err)
+	s.Len(records, 2)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	n, err := client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(2, n)
+	<-s.received
+	<-s.received
+	s.Len(s.segments(), 0)
+}
+
+func (s *SpoolTestSuite) TestRejectNew() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	record, err := spool.encode(&spooledBatch{ID: newRequestID(), Collection: "c", Objects: s.batch(0).Objects})
+	s.NoError(err)
+	spool.MaxSize = int64(2 * len(record))
+	spool.Eviction = RejectNew
+
+	s.NoError(spool.write(s.batch(0)))
+	s.NoError(spool.write(s.batch(1)))
+	s.Equal(ErrSpoolFull, spool.write(s.batch(2)))
+}
+
+func (s *SpoolTestSuite) TestCompact() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	record, err := spool.encode(&spooledBatch{ID: newRequestID(), Collection: "c", Objects: s.batch(0).Objects})
+	s.NoError(err)
+
+	// One batch per segment, then merge them into segments of two.
+	spool.SegmentSize = int64(len(record))
+	for i := 0; i < 5; i++ {
+		s.NoError(spool.write(s.batch(i)))
+	}
+	s.NoError(spool.Close())
+	s.Len(s.segments(), 5)
+
+	spool.SegmentSize = int64(2 * len(record))
+	s.NoError(spool.Compact())
+	s.Len(s.segments(), 3)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	n, err := client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(5, n)
+	for i := 0; i < 5; i++ {
+		s.Equal(`c [{"id":"`+strconv.Itoa(i)+`"}]`, <-s.received)
+	}
+}
+
+func (s *SpoolTestSuite) TestDedupeAfterCrash() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	for i := 0; i < 3; i++ {
+		s.NoError(spool.write(s.batch(i)))
+	}
+	s.NoError(spool.Close())
+
+	// Simulate a crash after the first two batches were acknowledged, before
+	// their segment was removed.
+	records, err := spool.readRecords(s.segments()[0])
+	s.NoError(err)
+	for _, record := range records[:2] {
+		v, err := spool.decode(record)
+		s.NoError(err)
+		s.NoError(spool.markDelivered(v.ID))
+	}
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	n, err := client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"2"}]`, <-s.received)
+	s.Len(s.received, 0)
+
+	_, err = os.Stat(filepath.Join(s.dir, dedupeFile))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *SpoolTestSuite) TestMarkDeliveredFails() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	for i := 0; i < 3; i++ {
+		s.NoError(spool.write(s.batch(i)))
+	}
+	s.NoError(spool.Close())
+
+	// A batch delivered but not recorded is removed from its segment rather
+	// than resent.
+	s.NoError(os.Symlink(filepath.Join(s.dir, "missing", dedupeFile), filepath.Join(s.dir, dedupeFile)))
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	n, err := client.Replay(context.Background())
+	s.Error(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"0"}]`, <-s.received)
+
+	n, err = client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(2, n)
+	s.Equal(`c [{"id":"1"}]`, <-s.received)
+	s.Equal(`c [{"id":"2"}]`, <-s.received)
+}
+
+func (s *SpoolTestSuite) TestSharedBetweenProcesses() {
+	blue, err := OpenSpool(s.dir)
+	s.NoError(err)
+	green, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	s.NoError(blue.write(s.batch(0)))
+	s.NoError(green.write(s.batch(1)))
+	s.Len(s.segments(), 2)
+
+	// Blue's segment is still active, so green only replays its own.
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = green
+	n, err := client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"1"}]`, <-s.received)
+	s.Len(s.segments(), 1)
+
+	// Only one process replays at a time.
+	unlock, err := blue.lock()
+	s.NoError(err)
+	_, err = client.Replay(context.Background())
+	s.Equal(ErrSpoolBusy, err)
+	unlock()
+
+	// Once blue is done with its segment, green replays it.
+	s.NoError(blue.Close())
+	n, err = client.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal(`c [{"id":"0"}]`, <-s.received)
+	s.Len(s.segments(), 0)
+}
+
+func (s *SpoolTestSuite) TestExpiriesRestored() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	s.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}, ExpiresAt: time.Now().Add(-time.Second)}))
+	s.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "2"}, ExpiresAt: time.Now().Add(time.Hour)}))
+	s.NoError(client.Flush(context.Background()))
+	s.Equal(`c [{"id":"1","properties":{"p":"1"}},{"id":"2","properties":{"p":"2"}}]`, <-s.received)
+	s.Equal("delete c 1", <-s.received)
+	s.NoError(client.Close())
+	s.NoError(spool.Close())
+
+	// The next process only reschedules the expiry left pending.
+	spool, err = OpenSpool(s.dir)
+	s.NoError(err)
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.Spool = spool
+	_, err = replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, replayer.scheduler.pending())
+	logs, err := filepath.Glob(filepath.Join(s.dir, "*"+expiryExt))
+	s.NoError(err)
+	s.Equal([]string{spool.expiries.path}, logs)
+
+	s.NoError(replayer.Close())
+	s.NoError(spool.Close())
+}
+
+func (s *SpoolTestSuite) TestHeldRestored() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+
+	var drops []Drop
+	client := New("writeKey")
+	client.BaseEndpoint = "https://spool.segment.com"
+	client.Spool = spool
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+	s.NoError(client.SetAt(time.Now().Add(100*time.Millisecond), &Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"n": int64(1 << 60)}}))
+	s.NoError(client.SetAt(time.Now().Add(time.Hour), &Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "2"}}))
+	s.NoError(client.Close())
+	s.NoError(spool.Close())
+	s.Empty(drops)
+
+	// The next process holds both objects again, and sets the one due.
+	spool, err = OpenSpool(s.dir)
+	s.NoError(err)
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.MaxBatchInterval = 10 * time.Millisecond
+	replayer.Spool = spool
+	_, err = replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(`c [{"id":"1","properties":{"n":1152921504606846976}}]`, <-s.received)
+	s.NoError(replayer.Close())
+	s.NoError(spool.Close())
+
+	// Only the object not set yet is left.
+	spool, err = OpenSpool(s.dir)
+	s.NoError(err)
+	replayer = New("writeKey")
+	replayer.Spool = spool
+	_, err = replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, replayer.scheduler.pending())
+	s.NoError(replayer.Close())
+	s.NoError(spool.Close())
+}
+
+func (s *SpoolTestSuite) TestSpoolWhileReplaying() {
+	started, release := make(chan struct{}), make(chan struct{})
+	httpmock.RegisterResponder("POST", "https://blocked.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		close(started)
+		<-release
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	defer spool.Close()
+	s.NoError(spool.write(s.batch(1)))
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://blocked.segment.com"
+	client.Spool = spool
+	replayed := make(chan int)
+	go func() {
+		n, err := client.Replay(context.Background())
+		s.NoError(err)
+		replayed <- n
+	}()
+
+	// Batches are spooled while a replayed one is being sent.
+	<-started
+	s.NoError(spool.write(s.batch(2)))
+	close(release)
+	s.Equal(1, <-replayed)
+	s.Len(s.segments(), 1)
+}
+
+func (s *SpoolTestSuite) TestReplayDeliveries() {
+	spool, err := OpenSpool(s.dir)
+	s.NoError(err)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := s.batch(1)
+	b.seq, b.created = 7, created
+	s.NoError(spool.write(b))
+
+	var deliveries []Delivery
+	replayer := New("writeKey")
+	replayer.BaseEndpoint = "https://spool.segment.com"
+	replayer.Spool = spool
+	replayer.OnDeliver = func(d Delivery) { deliveries = append(deliveries, d) }
+	n, err := replayer.Replay(context.Background())
+	s.NoError(err)
+	s.Equal(1, n)
+	<-s.received
+
+	s.Len(deliveries, 1)
+	s.Equal([]string{"1"}, deliveries[0].IDs)
+	s.Equal(uint64(7), deliveries[0].Seq)
+	s.True(created.Equal(deliveries[0].Created))
+	s.NoError(deliveries[0].Err)
+}
@@ -0,0 +1,89 @@
+package objects
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// Stats are counters of the objects handled by a client since it was
+// created.
+type Stats struct {
+	// Queued is the number of objects accepted by Set, or valid and sent
+	// by SendBatch or SimpleClient.Send.
+	Queued int64 `json:"queued"`
+
+	// Sent is the number of objects delivered to the Objects API.
+	Sent int64 `json:"sent"`
+
+	// Failed is the number of objects not delivered but written to Spool,
+	// or whose SendBatch returned an error. What becomes of spooled objects
+	// once replayed is not counted.
+	Failed int64 `json:"failed"`
+
+	// Dropped is the number of objects dropped without being delivered,
+	// see OnDrop, except those of spooled batches, which count as Failed.
+	// Every queued object ends up in exactly one of Sent, Failed and
+	// Dropped.
+	Dropped int64 `json:"dropped"`
+
+	// Inflight is the number of batches being delivered.
+	Inflight int64 `json:"inflight"`
+}
+
+type counters struct {
+	queued   int64
+	sent     int64
+	failed   int64
+	dropped  int64
+	inflight int64
+}
+
+// Stats returns the client's counters.
+func (c *Client) Stats() Stats {
+	return Stats{
+		Queued:   atomic.LoadInt64(&c.counters.queued),
+		Sent:     atomic.LoadInt64(&c.counters.sent),
+		Failed:   atomic.LoadInt64(&c.counters.failed),
+		Dropped:  atomic.LoadInt64(&c.counters.dropped),
+		Inflight: atomic.LoadInt64(&c.counters.inflight),
+	}
+}
+
+// PublishExpvar publishes the client's Stats under name with expvar, so they
+// are served on /debug/vars. Like expvar.Publish, it panics if name is
+// already in use.
+func (c *Client) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+}
+
+// BufferStats describes the queue of a collection.
+type BufferStats struct {
+	// Capacity is the number of objects the collection queues before Set
+	// blocks.
+	Capacity int
+
+	// Length is the number of objects currently queued.
+	Length int
+
+	// HighWatermark is the longest the queue has been. A high watermark
+	// close to Capacity means producers are likely blocking in Set.
+	HighWatermark int
+}
+
+// BufferStats returns the queue stats of every collection used so far. The
+// stats of sharded collections are the sums over their shards.
+func (c *Client) BufferStats() map[string]BufferStats {
+	stats := map[string]BufferStats{}
+	for t := range c.cmap.Iter() {
+		var s BufferStats
+		for _, b := range t.Val.all() {
+			s.Capacity += b.Queue.cap()
+			s.Length += b.Queue.len()
+			s.HighWatermark += int(atomic.LoadInt64(&b.highWatermark))
+		}
+		stats[t.Key] = s
+	}
+	return stats
+}
@@ -0,0 +1,75 @@
+package objects
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Commit is the VCS revision the library was built from. It is read from the
+// build info when available, as the module version when the library is a
+// dependency, and can be set at link time with
+// -ldflags "-X github.com/segmentio/objects-go.Commit=<sha>".
+var Commit = ""
+
+// BuildInfo describes the library build, e.g. for an application's own
+// diagnostics.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	GoVersion string
+}
+
+// VersionInfo returns the version, commit and Go version of the library.
+func VersionInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+	if info.Commit == "" {
+		info.Commit = vcsRevision()
+	}
+	return info
+}
+
+// modulePath is the path of the library's module.
+const modulePath = "github.com/segmentio/objects-go"
+
+// vcsRevision returns the revision of the library from the build info. When
+// the library is a dependency, that is the version its module is required
+// at, e.g. a pseudo-version naming the commit, since the VCS revision in the
+// build info is the application's.
+func vcsRevision() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, m := range bi.Deps {
+		if m.Path != modulePath {
+			continue
+		}
+		if m.Replace != nil && m.Replace.Version != "" {
+			return m.Replace.Version
+		}
+		return m.Version
+	}
+	if bi.Main.Path != modulePath {
+		return ""
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// userAgent is sent with every request to the Objects API.
+var userAgent = func() string {
+	info := VersionInfo()
+	if info.Commit == "" {
+		return fmt.Sprintf("objects-go/%s (%s)", info.Version, info.GoVersion)
+	}
+	return fmt.Sprintf("objects-go/%s (%s; %s)", info.Version, info.Commit, info.GoVersion)
+}()
@@ -0,0 +1,89 @@
+package objects
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StuckReport describes a collection whose objects have not been delivered
+// for longer than Client.StuckAfter.
+type StuckReport struct {
+	Collection string
+
+	// Since is the time of the collection's last successful delivery, or of
+	// its first object if it never had one.
+	Since time.Time
+
+	// Queued is the number of objects waiting in the collection's channel.
+	Queued int
+
+	// Buffered is the number of objects in the batch being built.
+	Buffered int
+
+	// Inflight is the number of objects being delivered.
+	Inflight int
+
+	// LastError is the error of the collection's last failed delivery.
+	LastError string
+}
+
+// watch starts the watchdog if StuckAfter is set.
+func (c *Client) watch() {
+	if c.StuckAfter <= 0 {
+		return
+	}
+	c.watchOnce.Do(func() {
+		c.scheduler.schedule("watchdog", time.Now().Add(c.StuckAfter/2), c.watchdog)
+	})
+}
+
+// watchdog reports collections that became stuck since the last check, then
+// schedules the next check.
+func (c *Client) watchdog() {
+	now := time.Now()
+	for t := range c.cmap.Iter() {
+		b := t.Val
+		queued, buffered := 0, 0
+		for _, s := range b.all() {
+			queued += s.Queue.len()
+			buffered += int(atomic.LoadInt64(&s.buffered))
+		}
+		inflight := int(atomic.LoadInt64(&b.inflight))
+
+		c.health.Lock()
+		since, ok := c.health.lastFlush[t.Key]
+		lastError := c.health.errors[t.Key]
+		c.health.Unlock()
+		if !ok {
+			since = b.created
+		}
+
+		stuck := (queued > 0 || buffered > 0 || inflight > 0 || lastError != "") && now.Sub(since) > c.StuckAfter
+		if !stuck {
+			atomic.StoreInt32(&b.stuck, 0)
+			continue
+		}
+		if !atomic.CompareAndSwapInt32(&b.stuck, 0, 1) {
+			continue
+		}
+
+		report := StuckReport{
+			Collection: t.Key,
+			Since:      since,
+			Queued:     queued,
+			Buffered:   buffered,
+			Inflight:   inflight,
+			LastError:  lastError,
+		}
+		if c.OnStuck != nil {
+			c.OnStuck(report)
+		} else {
+			c.Logger.Printf("[Warn] Collection `%s` has not delivered since %s: %d queued, %d buffered, %d inflight, last error: %s",
+				report.Collection, report.Since.Format(time.RFC3339), report.Queued, report.Buffered, report.Inflight, report.LastError)
+		}
+	}
+
+	if atomic.LoadInt64(&c.closed) == 0 {
+		c.scheduler.schedule("watchdog", now.Add(c.StuckAfter/2), c.watchdog)
+	}
+}
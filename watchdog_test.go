@@ -0,0 +1,164 @@
+package objects
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWatchdog(t *testing.T) {
+	suite.Run(t, &WatchdogTestSuite{})
+}
+
+type WatchdogTestSuite struct {
+	suite.Suite
+}
+
+func (w *WatchdogTestSuite) SetupSuite() {
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://ok.segment.com/v1/set", httpmock.NewStringResponder(200, `{"success": true}`))
+	httpmock.RegisterResponder("POST", "https://fail.segment.com/v1/set", httpmock.NewStringResponder(500, ""))
+}
+
+func (w *WatchdogTestSuite) TestStuck() {
+	reports := make(chan StuckReport, 10)
+	client := New("writeKey")
+	client.BaseEndpoint = "https://fail.segment.com"
+	client.MaxBatchInterval = 10 * time.Millisecond
+	client.StuckAfter = 100 * time.Millisecond
+	client.OnStuck = func(r StuckReport) { reports <- r }
+
+	w.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+
+	select {
+	case r := <-reports:
+		w.Equal("c", r.Collection)
+		w.Equal(1, r.Inflight)
+	case <-time.After(time.Second):
+		w.Fail("no stuck report")
+	}
+
+	// Reported once until it recovers.
+	time.Sleep(150 * time.Millisecond)
+	w.Len(reports, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.Equal(context.Canceled, client.CloseContext(ctx))
+}
+
+func (w *WatchdogTestSuite) TestHealthy() {
+	reports := make(chan StuckReport, 10)
+	client := New("writeKey")
+	client.BaseEndpoint = "https://ok.segment.com"
+	client.MaxBatchInterval = 10 * time.Millisecond
+	client.StuckAfter = 100 * time.Millisecond
+	client.OnStuck = func(r StuckReport) { reports <- r }
+
+	for i := 0; i < 10; i++ {
+		w.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+		time.Sleep(30 * time.Millisecond)
+	}
+	w.NoError(client.Close())
+	w.Len(reports, 0)
+}
+
+type panicky struct{}
+
+func (w *WatchdogTestSuite) TestRestart() {
+	events := make(chan WorkerEvent, 10)
+	drops := make(chan Drop, 10)
+	client := New("writeKey")
+	client.BaseEndpoint = "https://ok.segment.com"
+	client.OnWorkerEvent = func(e WorkerEvent) { events <- e }
+	client.OnDrop = func(d Drop) { drops <- d }
+	client.RegisterEncoder(panicky{}, func(interface{}) (interface{}, error) { panic("boom") })
+
+	w.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	w.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": panicky{}}}))
+	w.NoError(client.Set(&Object{ID: "3", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	w.NoError(client.Close())
+
+	e := <-events
+	w.Equal("c", e.Collection)
+	w.True(e.Restarted)
+	w.Contains(e.Err.Error(), "boom")
+	d := <-drops
+	w.Equal("2", d.Object.ID)
+	w.Equal(DropPanic, d.Reason)
+	w.Equal(int64(2), client.Stats().Sent)
+}
+
+func (w *WatchdogTestSuite) TestRestartCallbackPanics() {
+	events := make(chan WorkerEvent, 10)
+	client := New("writeKey")
+	client.BaseEndpoint = "https://ok.segment.com"
+	client.OnWorkerEvent = func(e WorkerEvent) { events <- e }
+	client.OnDrop = func(Drop) { panic("drop") }
+	client.RegisterEncoder(panicky{}, func(interface{}) (interface{}, error) { panic("boom") })
+
+	// OnDrop panics again while restart drops the object.
+	w.NoError(client.Set(&Object{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": panicky{}}}))
+	w.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+	w.NoError(client.Close())
+
+	e := <-events
+	w.True(e.Restarted)
+	w.Contains(e.Err.Error(), "boom")
+	w.Equal(int64(1), client.Stats().Sent)
+	w.Equal(int64(1), client.Stats().Dropped)
+}
+
+func (w *WatchdogTestSuite) TestRestartDropsBatch() {
+	drops := make(chan Drop, 10)
+	client := New("writeKey")
+	client.OnDrop = func(d Drop) { drops <- d }
+	client.OnWorkerEvent = func(WorkerEvent) {}
+
+	// A panic outside encoding drops the buffered batch, which would
+	// panic again.
+	b := newBuffer("c", 10)
+	b.pending = []*Object{
+		{ID: "1", Collection: "c", Properties: map[string]interface{}{"p": "1"}},
+		{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}},
+	}
+	b.pendingAt = make([]time.Time, len(b.pending))
+	client.addPending(b)
+	client.restart(b, "boom")
+	w.Equal(0, b.count())
+	w.Len(drops, 2)
+	for _, id := range []string{"1", "2"} {
+		d := <-drops
+		w.Equal(id, d.Object.ID)
+		w.Equal(DropPanic, d.Reason)
+	}
+}
+
+func (w *WatchdogTestSuite) TestStall() {
+	events := make(chan WorkerEvent, 10)
+	unblock := make(chan struct{})
+	client := New("writeKey")
+	client.BaseEndpoint = "https://ok.segment.com"
+	client.StallAfter = 50 * time.Millisecond
+	client.OnWorkerEvent = func(e WorkerEvent) { events <- e }
+	client.OnDrop = func(Drop) { <-unblock }
+
+	// The buffer goroutine blocks dropping the expired object.
+	w.NoError(client.Set(&Object{ID: "1", Collection: "c", Deadline: time.Now(), Properties: map[string]interface{}{"p": "1"}}))
+	w.NoError(client.Set(&Object{ID: "2", Collection: "c", Properties: map[string]interface{}{"p": "1"}}))
+
+	select {
+	case e := <-events:
+		w.Equal("c", e.Collection)
+		w.False(e.Restarted)
+		w.Equal(ErrStalled, e.Err)
+	case <-time.After(time.Second):
+		w.Fail("no stall reported")
+	}
+
+	close(unblock)
+	w.NoError(client.Close())
+}
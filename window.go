@@ -0,0 +1,124 @@
+package objects
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrHoldFull = errors.New("Batches held by DeliveryWindows exceed MaxHeldBytes")
+)
+
+// Window is a daily time range during which batches are delivered. Start and
+// End are offsets from midnight; an End before Start spans midnight, e.g.
+// 22h to 6h. Location defaults to time.Local.
+type Window struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// Contains reports whether t falls inside the window.
+func (w Window) Contains(t time.Time) bool {
+	offset := t.Sub(w.midnight(t))
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// next returns the first time after t at which the window opens.
+func (w Window) next(t time.Time) time.Time {
+	start := w.midnight(t).Add(w.Start)
+	if !start.After(t) {
+		start = w.midnight(t).AddDate(0, 0, 1).Add(w.Start)
+	}
+	return start
+}
+
+func (w Window) midnight(t time.Time) time.Time {
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// hold queues request until the next delivery window opens if t falls
+// outside all of the client's DeliveryWindows, and reports whether it did.
+// Batches beyond MaxHeldBytes are spooled until then instead, or dropped.
+// Batches are never held once the client is closing.
+func (c *Client) hold(request *batch, t time.Time) bool {
+	if len(c.DeliveryWindows) == 0 || atomic.LoadInt64(&c.closed) == 1 {
+		return false
+	}
+
+	next := time.Time{}
+	for _, w := range c.DeliveryWindows {
+		if w.Contains(t) {
+			return false
+		}
+		if n := w.next(t); next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+
+	c.heldMutex.Lock()
+	full := c.heldBytes+len(request.Objects) > c.MaxHeldBytes
+	if !full {
+		c.held = append(c.held, request)
+		c.heldBytes += len(request.Objects)
+	}
+	c.heldMutex.Unlock()
+
+	if full {
+		// Replay leaves the batch in the spool until the window opens.
+		request.retryAt = next
+		if c.spool(request) {
+			c.heldMutex.Lock()
+			c.heldSpool = true
+			c.heldMutex.Unlock()
+		} else {
+			c.dropBatch(request, DropHoldFull, ErrHoldFull)
+		}
+		putBytes(request.Objects)
+	}
+
+	c.scheduler.schedule("window", next, c.release)
+	return true
+}
+
+// windowOpen reports whether batches may be sent at t, see DeliveryWindows.
+func (c *Client) windowOpen(t time.Time) bool {
+	if len(c.DeliveryWindows) == 0 {
+		return true
+	}
+	for _, w := range c.DeliveryWindows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// release sends every held batch, and replays Spool if batches beyond
+// MaxHeldBytes were written to it.
+func (c *Client) release() {
+	c.heldMutex.Lock()
+	held, spooled := c.held, c.heldSpool
+	c.held, c.heldBytes, c.heldSpool = nil, 0, false
+	c.heldMutex.Unlock()
+
+	for _, request := range held {
+		c.send(request)
+	}
+	if spooled {
+		c.semaphore.Run(func() {
+			if _, err := c.Replay(c.ctx); err != nil {
+				c.Logger.Printf("[Error] Batches held by DeliveryWindows failed to replay: %v", err)
+			}
+		})
+	}
+}
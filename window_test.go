@@ -0,0 +1,115 @@
+package objects
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWindow(t *testing.T) {
+	suite.Run(t, &WindowTestSuite{})
+}
+
+type WindowTestSuite struct {
+	suite.Suite
+}
+
+func (w *WindowTestSuite) at(hour, min int) time.Time {
+	return time.Date(2016, 6, 1, hour, min, 0, 0, time.UTC)
+}
+
+func (w *WindowTestSuite) TestContains() {
+	win := Window{Start: 9 * time.Hour, End: 17 * time.Hour, Location: time.UTC}
+	w.False(win.Contains(w.at(8, 59)))
+	w.True(win.Contains(w.at(9, 0)))
+	w.True(win.Contains(w.at(16, 59)))
+	w.False(win.Contains(w.at(17, 0)))
+}
+
+func (w *WindowTestSuite) TestContainsOvernight() {
+	win := Window{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.UTC}
+	w.True(win.Contains(w.at(23, 0)))
+	w.True(win.Contains(w.at(2, 0)))
+	w.False(win.Contains(w.at(6, 0)))
+	w.False(win.Contains(w.at(12, 0)))
+}
+
+func (w *WindowTestSuite) TestNext() {
+	win := Window{Start: 9 * time.Hour, End: 17 * time.Hour, Location: time.UTC}
+	w.Equal(w.at(9, 0), win.next(w.at(8, 0)))
+	w.Equal(w.at(9, 0).AddDate(0, 0, 1), win.next(w.at(18, 0)))
+}
+
+func (w *WindowTestSuite) TestHold() {
+	client := New("writeKey")
+	client.DeliveryWindows = []Window{
+		{Start: 9 * time.Hour, End: 12 * time.Hour, Location: time.UTC},
+		{Start: 14 * time.Hour, End: 17 * time.Hour, Location: time.UTC},
+	}
+
+	w.False(client.hold(&batch{}, w.at(10, 0)))
+	w.True(client.hold(&batch{}, w.at(13, 0)))
+	w.Len(client.held, 1)
+	w.Equal(1, client.scheduler.pending())
+
+	w.NoError(client.Close())
+	w.Len(client.held, 0)
+}
+
+func (w *WindowTestSuite) TestHoldFull() {
+	var drops []Drop
+	client := New("writeKey")
+	client.DeliveryWindows = []Window{{Start: 14 * time.Hour, End: 17 * time.Hour, Location: time.UTC}}
+	client.MaxHeldBytes = 10
+	client.OnDrop = func(d Drop) { drops = append(drops, d) }
+
+	held := &batch{Collection: "c", Objects: json.RawMessage(`[{"id":"1"}]`[:8]), count: 1, ids: []string{"1"}}
+	w.True(client.hold(held, w.at(13, 0)))
+	w.True(client.hold(&batch{Collection: "c", Objects: json.RawMessage(`[{"id":"2"}]`), count: 1, ids: []string{"2"}, meta: []map[string]interface{}{nil}}, w.at(13, 0)))
+	w.Len(client.held, 1)
+	w.Len(drops, 1)
+	w.Equal("2", drops[0].Object.ID)
+	w.Equal(DropHoldFull, drops[0].Reason)
+
+	client.held = nil
+	w.NoError(client.Close())
+}
+
+func (w *WindowTestSuite) TestHoldSpooled() {
+	received := make(chan string, 1)
+	httpmock.Activate()
+	httpmock.RegisterResponder("POST", "https://window.segment.com/v1/set", func(req *http.Request) (*http.Response, error) {
+		v := &batch{}
+		if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		received <- string(v.Objects)
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	dir, err := ioutil.TempDir("", "window")
+	w.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	spool, err := OpenSpool(dir)
+	w.Require().NoError(err)
+	defer spool.Close()
+
+	client := New("writeKey")
+	client.BaseEndpoint = "https://window.segment.com"
+	client.DeliveryWindows = []Window{{Start: 14 * time.Hour, End: 17 * time.Hour, Location: time.UTC}}
+	client.MaxHeldBytes = 1
+	client.Spool = spool
+
+	// The batch is spooled until the window opens, which is past already.
+	w.True(client.hold(&batch{Collection: "c", Objects: json.RawMessage(`[{"id":"1"}]`), count: 1}, w.at(13, 0)))
+	w.Len(client.held, 0)
+	client.release()
+	w.Equal(`[{"id":"1"}]`, <-received)
+	w.NoError(client.Close())
+}